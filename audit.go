@@ -0,0 +1,379 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgtype"
+	"github.com/synapsewave/remiges-demo/pg"
+	"github.com/synapsewave/remiges-demo/pg/sqlc-gen"
+)
+
+// auditOps maps users_audit operations to the change log op the service
+// would have logged for them
+var auditOps = map[string]string{
+	"INSERT": "Create",
+	"UPDATE": "Update",
+	"DELETE": "Delete",
+	// -ops also accepts the change log names
+	"CREATE": "Create",
+}
+
+// auditIgnoredColumns change on every write and are never change-logged
+var auditIgnoredColumns = map[string]bool{
+	"created_at":        true,
+	"updated_at":        true,
+	"status_changed_at": true,
+}
+
+// recordedChange is one row change, from either the users_audit table or a
+// LogHarbour change log, reduced to what both sources record
+type recordedChange struct {
+	source   string // audit row ID or log ID
+	when     time.Time
+	userID   string
+	op       string
+	values   map[string]string // field -> new value
+	origin   string            // application_name and database user of an audit row
+	matched  bool
+	mismatch []string
+}
+
+func (c *recordedChange) fields() string {
+	names := make([]string, 0, len(c.values))
+	for name := range c.values {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return strings.Join(names, ",")
+}
+
+// runAudit implements `usersvc audit reconcile` and returns the process exit
+// code
+func runAudit(ctx context.Context, dbConfig pg.Config, args []string) int {
+	fs := flag.NewFlagSet("audit", flag.ExitOnError)
+	from := fs.String("from", "", "reconcile changes at or after this time (RFC3339)")
+	to := fs.String("to", "", "reconcile changes before this time (RFC3339); defaults to now")
+	logsearchURL := fs.String("logsearch", getEnv("LOGSEARCH_URL", "http://localhost:8091"), "logsearch service URL")
+	tolerance := fs.Duration("tolerance", time.Minute, "maximum time between a database change and its change log")
+	ops := fs.String("ops", "update,delete", "operations to reconcile (create, update, delete); the service does not change-log creates")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: usersvc audit reconcile -from TIME [flags]\n\nCompares the users_audit table with the LogHarbour change logs and lists\nchanges recorded in only one of them. Exits 1 when there are discrepancies.\n\nFlags:\n")
+		fs.PrintDefaults()
+	}
+	if len(args) == 0 || args[0] != "reconcile" {
+		fs.Usage()
+		return 2
+	}
+	fs.Parse(args[1:])
+
+	start, err := time.Parse(time.RFC3339, *from)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Invalid -from: %v\n", err)
+		return 2
+	}
+	end := time.Now()
+	if *to != "" {
+		if end, err = time.Parse(time.RFC3339, *to); err != nil {
+			fmt.Fprintf(os.Stderr, "Invalid -to: %v\n", err)
+			return 2
+		}
+	}
+	if !start.Before(end) {
+		fmt.Fprintln(os.Stderr, "-from must be before -to")
+		return 2
+	}
+	wanted := make(map[string]bool)
+	for _, op := range strings.Split(*ops, ",") {
+		name, ok := auditOps[strings.ToUpper(strings.TrimSpace(op))]
+		if !ok {
+			fmt.Fprintf(os.Stderr, "Invalid -ops: unknown operation %q\n", op)
+			return 2
+		}
+		wanted[name] = true
+	}
+
+	dbConfig.Replicas = nil
+	provider, err := pg.NewProvider(ctx, dbConfig)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return 1
+	}
+	defer provider.Close()
+
+	rows, err := provider.Queries().ListUsersAudit(ctx, sqlc.ListUsersAuditParams{
+		ChangedFrom: pgtype.Timestamptz{Time: start, Valid: true},
+		ChangedTo:   pgtype.Timestamptz{Time: end, Valid: true},
+	})
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error reading users_audit: %v\n", err)
+		return 1
+	}
+	var audited []*recordedChange
+	for _, row := range rows {
+		change, err := changeFromAudit(row)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error reading users_audit row %d: %v\n", row.ID, err)
+			return 1
+		}
+		if change != nil && wanted[change.op] {
+			audited = append(audited, change)
+		}
+	}
+
+	// Change logs are written after the commit, so look a little past the
+	// window on both sides
+	logs, err := fetchChangeLogs(ctx, *logsearchURL, start.Add(-*tolerance), end.Add(*tolerance))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error reading change logs: %v\n", err)
+		return 1
+	}
+	var logged []*recordedChange
+	for _, change := range logs {
+		if wanted[change.op] {
+			logged = append(logged, change)
+		}
+	}
+
+	reconcileChanges(audited, logged, *tolerance)
+
+	discrepancies := 0
+	for _, change := range audited {
+		switch {
+		case !change.matched:
+			discrepancies++
+			fmt.Printf("NOT LOGGED      %s user %s [%s] at %s by %s (audit row %s)\n",
+				change.op, change.userID, change.fields(), change.when.Format(time.RFC3339), change.origin, change.source)
+		case len(change.mismatch) > 0:
+			discrepancies++
+			fmt.Printf("VALUE MISMATCH  %s user %s at %s (audit row %s): %s\n",
+				change.op, change.userID, change.when.Format(time.RFC3339), change.source, strings.Join(change.mismatch, "; "))
+		}
+	}
+	for _, change := range logged {
+		if !change.matched && !change.when.Before(start) && change.when.Before(end) {
+			discrepancies++
+			fmt.Printf("NOT IN DATABASE %s user %s [%s] at %s (log %s)\n",
+				change.op, change.userID, change.fields(), change.when.Format(time.RFC3339), change.source)
+		}
+	}
+	fmt.Printf("Reconciled %d audited changes with %d change logs: %d discrepancies\n", len(audited), len(logged), discrepancies)
+	if discrepancies > 0 {
+		return 1
+	}
+	return 0
+}
+
+// changeFromAudit reduces an audit row to the fields a change log records.
+// It returns nil for updates that only touched timestamps, which the
+// service does not change-log.
+func changeFromAudit(row sqlc.UsersAudit) (*recordedChange, error) {
+	var oldRow, newRow map[string]any
+	if row.OldRow != nil {
+		if err := json.Unmarshal(row.OldRow, &oldRow); err != nil {
+			return nil, err
+		}
+	}
+	if row.NewRow != nil {
+		if err := json.Unmarshal(row.NewRow, &newRow); err != nil {
+			return nil, err
+		}
+	}
+
+	change := &recordedChange{
+		source: fmt.Sprint(row.ID),
+		when:   row.ChangedAt.Time,
+		userID: row.RowID.String,
+		op:     auditOps[row.Operation],
+		values: make(map[string]string),
+		origin: fmt.Sprintf("%s/%s", row.ApplicationName, row.DbUser),
+	}
+	for column, value := range newRow {
+		if auditIgnoredColumns[column] || column == "id" {
+			continue
+		}
+		if column == "attributes" {
+			// Custom attributes are change-logged per key
+			addAttributeChanges(change.values, oldRow[column], value)
+			continue
+		}
+		if old, ok := oldRow[column]; ok && auditValue(old) == auditValue(value) {
+			continue
+		}
+		change.values[column] = auditValue(value)
+	}
+	if row.Operation == "UPDATE" && len(change.values) == 0 {
+		return nil, nil
+	}
+	return change, nil
+}
+
+// addAttributeChanges records every attribute key that differs between two
+// attributes objects as attributes.<key>, with a removed key as empty
+func addAttributeChanges(values map[string]string, oldValue, newValue any) {
+	oldAttrs, _ := oldValue.(map[string]any)
+	newAttrs, _ := newValue.(map[string]any)
+	for key, value := range newAttrs {
+		if old, ok := oldAttrs[key]; !ok || auditValue(old) != auditValue(value) {
+			values["attributes."+key] = auditValue(value)
+		}
+	}
+	for key := range oldAttrs {
+		if _, ok := newAttrs[key]; !ok {
+			values["attributes."+key] = ""
+		}
+	}
+}
+
+// auditValue renders a JSON value the way change logs record it, with NULL
+// as an empty string
+func auditValue(v any) string {
+	switch v := v.(type) {
+	case nil:
+		return ""
+	case string:
+		return v
+	default:
+		data, _ := json.Marshal(v)
+		return string(data)
+	}
+}
+
+// reconcileChanges pairs every audited change with the closest unmatched
+// change log for the same user, operation and fields, and records values
+// that differ
+func reconcileChanges(audited, logged []*recordedChange, tolerance time.Duration) {
+	for _, a := range audited {
+		var best *recordedChange
+		for _, l := range logged {
+			if l.matched || l.userID != a.userID || l.op != a.op || l.fields() != a.fields() {
+				continue
+			}
+			if d := l.when.Sub(a.when).Abs(); d <= tolerance && (best == nil || d < best.when.Sub(a.when).Abs()) {
+				best = l
+			}
+		}
+		if best == nil {
+			continue
+		}
+		a.matched, best.matched = true, true
+		for field, value := range a.values {
+			if !sameValue(best.values[field], value) {
+				a.mismatch = append(a.mismatch, fmt.Sprintf("%s: database %q, log %q", field, value, best.values[field]))
+			}
+		}
+		sort.Strings(a.mismatch)
+	}
+}
+
+// sameValue compares a logged value with an audited one. Timestamps are
+// compared as instants, since the database and the logs format them
+// differently.
+func sameValue(logged, audited string) bool {
+	if logged == audited {
+		return true
+	}
+	l, err := time.Parse(time.RFC3339Nano, logged)
+	if err != nil {
+		return false
+	}
+	a, err := time.Parse(time.RFC3339Nano, audited)
+	return err == nil && l.Equal(a)
+}
+
+// fetchChangeLogs pages through the User change logs in [from, to) using
+// the logsearch API
+func fetchChangeLogs(ctx context.Context, baseURL string, from, to time.Time) ([]*recordedChange, error) {
+	var changes []*recordedChange
+	cursor := ""
+	for {
+		query := url.Values{
+			"type":  {"C"},
+			"from":  {from.UTC().Format(time.RFC3339)},
+			"to":    {to.UTC().Format(time.RFC3339)},
+			"order": {"asc"},
+			"size":  {"500"},
+		}
+		if cursor != "" {
+			query.Set("cursor", cursor)
+		}
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, strings.TrimRight(baseURL, "/")+"/api/v1/logs?"+query.Encode(), nil)
+		if err != nil {
+			return nil, err
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			return nil, err
+		}
+		var page struct {
+			Status string `json:"status"`
+			Data   struct {
+				Logs []struct {
+					ID       string `json:"id"`
+					When     string `json:"when"`
+					Instance string `json:"instance"`
+					Data     struct {
+						ChangeData struct {
+							Entity  string `json:"entity"`
+							Op      string `json:"op"`
+							Changes []struct {
+								Field    string `json:"field"`
+								NewValue any    `json:"new_value"`
+							} `json:"changes"`
+						} `json:"change_data"`
+					} `json:"data"`
+				} `json:"logs"`
+				NextCursor string `json:"next_cursor"`
+			} `json:"data"`
+		}
+		err = json.NewDecoder(resp.Body).Decode(&page)
+		resp.Body.Close()
+		if err != nil {
+			return nil, fmt.Errorf("decoding logsearch response: %w", err)
+		}
+		if resp.StatusCode != http.StatusOK || page.Status != "success" {
+			return nil, fmt.Errorf("logsearch returned %s", resp.Status)
+		}
+
+		for _, entry := range page.Data.Logs {
+			changeData := entry.Data.ChangeData
+			if changeData.Entity != "User" {
+				continue
+			}
+			when, err := time.Parse(time.RFC3339Nano, entry.When)
+			if err != nil {
+				continue
+			}
+			change := &recordedChange{
+				source: entry.ID,
+				when:   when,
+				userID: entry.Instance,
+				op:     changeData.Op,
+				values: make(map[string]string),
+			}
+			for _, c := range changeData.Changes {
+				change.values[c.Field] = auditValue(c.NewValue)
+			}
+			changes = append(changes, change)
+		}
+		if page.Data.NextCursor == "" {
+			return changes, nil
+		}
+		cursor = page.Data.NextCursor
+	}
+}
+
+func getEnv(key, def string) string {
+	if v := os.Getenv(key); v != "" {
+		return v
+	}
+	return def
+}
@@ -0,0 +1,432 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"regexp"
+	"sync"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// AlertRules is the YAML file named by ALERT_RULES
+type AlertRules struct {
+	Rules     []AlertRule      `yaml:"rules"`
+	Notifiers []NotifierConfig `yaml:"notifiers"`
+}
+
+// AlertRule fires when at least Threshold entries matching Match are
+// processed within Window. After firing it stays quiet for Cooldown.
+type AlertRule struct {
+	Name        string        `yaml:"name"`
+	Description string        `yaml:"description"`
+	Severity    string        `yaml:"severity"`
+	Match       AlertMatch    `yaml:"match"`
+	Window      time.Duration `yaml:"window"`
+	Threshold   int           `yaml:"threshold"`
+	Cooldown    time.Duration `yaml:"cooldown"`
+	Notify      []string      `yaml:"notify"`
+}
+
+// AlertMatch selects entries. Every condition that is set must hold; list
+// conditions match if the field equals any listed value. Msg is a regular
+// expression. Data maps paths below data (in the redaction path syntax) to
+// the value expected at that path.
+type AlertMatch struct {
+	App    []string          `yaml:"app"`
+	Module []string          `yaml:"module"`
+	Type   []string          `yaml:"type"`
+	Pri    []string          `yaml:"pri"`
+	Msg    string            `yaml:"msg"`
+	Data   map[string]string `yaml:"data"`
+}
+
+// Alert is sent to notifiers when a rule fires
+type Alert struct {
+	Rule        string    `json:"rule"`
+	Description string    `json:"description,omitempty"`
+	Severity    string    `json:"severity,omitempty"`
+	Count       int       `json:"count"`
+	Threshold   int       `json:"threshold"`
+	Window      string    `json:"window"`
+	FiredAt     time.Time `json:"fired_at"`
+	Sample      LogEntry  `json:"sample"`
+}
+
+type dataCondition struct {
+	path  string
+	segs  []pathSegment
+	value string
+}
+
+type compiledRule struct {
+	AlertRule
+	app, module, typ, pri map[string]bool
+	msg                   *regexp.Regexp
+	data                  []dataCondition
+	notifiers             []Notifier
+
+	// hits holds the times of the most recent matches, at most Threshold of them
+	hits      []time.Time
+	lastFired time.Time
+}
+
+// notification is a fired alert waiting to be delivered
+type notification struct {
+	alert     Alert
+	notifiers []Notifier
+}
+
+// AlertEngine evaluates alert rules against processed entries and delivers
+// fired alerts in the background, so slow notifiers never hold up indexing
+type AlertEngine struct {
+	path string
+
+	mu      sync.Mutex
+	rules   []*compiledRule
+	modTime time.Time
+	closed  bool
+
+	queue chan notification
+	done  chan struct{}
+}
+
+// NewAlertEngine loads rules from path and starts the notification dispatcher
+func NewAlertEngine(path string) (*AlertEngine, error) {
+	e := &AlertEngine{
+		path:  path,
+		queue: make(chan notification, 100),
+		done:  make(chan struct{}),
+	}
+	if err := e.Reload(); err != nil {
+		return nil, err
+	}
+	go e.dispatch()
+	return e, nil
+}
+
+// Reload re-reads the rules file. If it is invalid the current rules are
+// kept. Window state carries over for rules whose name is unchanged.
+func (e *AlertEngine) Reload() error {
+	info, err := os.Stat(e.path)
+	if err != nil {
+		alertRuleReloads.WithLabelValues("error").Inc()
+		return fmt.Errorf("error reading alert rules: %w", err)
+	}
+	rules, err := loadAlertRules(e.path)
+	if err != nil {
+		alertRuleReloads.WithLabelValues("error").Inc()
+		return err
+	}
+
+	e.mu.Lock()
+	previous := make(map[string]*compiledRule, len(e.rules))
+	for _, rule := range e.rules {
+		previous[rule.Name] = rule
+	}
+	for _, rule := range rules {
+		if old, ok := previous[rule.Name]; ok {
+			rule.hits = old.hits
+			rule.lastFired = old.lastFired
+			if len(rule.hits) > rule.Threshold {
+				rule.hits = rule.hits[len(rule.hits)-rule.Threshold:]
+			}
+		}
+	}
+	e.rules = rules
+	e.modTime = info.ModTime()
+	e.mu.Unlock()
+
+	alertRuleReloads.WithLabelValues("ok").Inc()
+	log.Printf("Loaded %d alert rules from %s", len(rules), e.path)
+	return nil
+}
+
+// WatchRules reloads the rules file whenever its modification time changes,
+// checking every interval until ctx is cancelled
+func (e *AlertEngine) WatchRules(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			info, err := os.Stat(e.path)
+			if err != nil {
+				continue
+			}
+			e.mu.Lock()
+			changed := !info.ModTime().Equal(e.modTime)
+			e.mu.Unlock()
+			if changed {
+				if err := e.Reload(); err != nil {
+					log.Printf("Error reloading alert rules, keeping current rules: %v", err)
+				}
+			}
+		}
+	}
+}
+
+// Evaluate counts the entries matching each rule and queues an alert for
+// every rule that crosses its threshold
+func (e *AlertEngine) Evaluate(entries []LogEntry) {
+	now := time.Now()
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.closed {
+		return
+	}
+	for _, rule := range e.rules {
+		var sample *LogEntry
+		for i := range entries {
+			if !rule.matches(&entries[i]) {
+				continue
+			}
+			sample = &entries[i]
+			rule.hits = append(rule.hits, now)
+			if len(rule.hits) > rule.Threshold {
+				rule.hits = rule.hits[1:]
+			}
+		}
+		if sample == nil {
+			continue
+		}
+
+		// Forget matches that have left the window
+		cutoff := now.Add(-rule.Window)
+		for len(rule.hits) > 0 && rule.hits[0].Before(cutoff) {
+			rule.hits = rule.hits[1:]
+		}
+		if len(rule.hits) < rule.Threshold || now.Sub(rule.lastFired) < rule.Cooldown {
+			continue
+		}
+
+		rule.lastFired = now
+		alertsFired.WithLabelValues(rule.Name).Inc()
+		alert := Alert{
+			Rule:        rule.Name,
+			Description: rule.Description,
+			Severity:    rule.Severity,
+			Count:       len(rule.hits),
+			Threshold:   rule.Threshold,
+			Window:      rule.Window.String(),
+			FiredAt:     now.UTC(),
+			Sample:      *sample,
+		}
+		log.Printf("Alert %s fired: %d matching entries within %s", rule.Name, alert.Count, rule.Window)
+
+		select {
+		case e.queue <- notification{alert: alert, notifiers: rule.notifiers}:
+		default:
+			log.Printf("Alert queue full, dropping alert %s", rule.Name)
+			alertNotifications.WithLabelValues("", "dropped").Inc()
+		}
+	}
+}
+
+// dispatch delivers queued alerts until the queue is closed
+func (e *AlertEngine) dispatch() {
+	defer close(e.done)
+	for n := range e.queue {
+		for _, notifier := range n.notifiers {
+			ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+			err := notifier.Notify(ctx, n.alert)
+			cancel()
+			if err != nil {
+				log.Printf("Error sending alert %s to %s: %v", n.alert.Rule, notifier.Name(), err)
+				alertNotifications.WithLabelValues(notifier.Name(), "error").Inc()
+				continue
+			}
+			alertNotifications.WithLabelValues(notifier.Name(), "ok").Inc()
+		}
+	}
+}
+
+// Close delivers alerts that are already queued and stops the dispatcher.
+// Entries evaluated afterwards are ignored.
+func (e *AlertEngine) Close() error {
+	e.mu.Lock()
+	e.closed = true
+	close(e.queue)
+	e.mu.Unlock()
+	<-e.done
+	return nil
+}
+
+func (rule *compiledRule) matches(logEntry *LogEntry) bool {
+	if !inSet(rule.app, logEntry.App) || !inSet(rule.module, logEntry.Module) ||
+		!inSet(rule.typ, logEntry.Type) || !inSet(rule.pri, logEntry.Priority) {
+		return false
+	}
+	if rule.msg != nil && !rule.msg.MatchString(logEntry.Msg) {
+		return false
+	}
+	for _, cond := range rule.data {
+		if !anyEqual(lookupPath(logEntry.Data, cond.segs), cond.value) {
+			return false
+		}
+	}
+	return true
+}
+
+// inSet reports whether v is in set; an empty set matches everything
+func inSet(set map[string]bool, v string) bool {
+	return len(set) == 0 || set[v]
+}
+
+func anyEqual(values []any, want string) bool {
+	for _, v := range values {
+		if fmt.Sprint(v) == want {
+			return true
+		}
+	}
+	return false
+}
+
+// lookupPath returns every value at segs below node, traversing arrays and
+// applying filters the same way redaction field rules do
+func lookupPath(node any, segs []pathSegment) []any {
+	obj, ok := node.(map[string]any)
+	if !ok {
+		return nil
+	}
+	seg := segs[0]
+
+	var children []any
+	if seg.key == "*" {
+		for _, v := range obj {
+			children = append(children, v)
+		}
+	} else if v, ok := obj[seg.key]; ok {
+		children = []any{v}
+	}
+
+	var values []any
+	for _, child := range children {
+		if arr, ok := child.([]any); ok && (seg.filterKey != "" || len(segs) > 1) {
+			for _, elem := range arr {
+				if !matchesFilter(elem, seg) {
+					continue
+				}
+				if len(segs) > 1 {
+					values = append(values, lookupPath(elem, segs[1:])...)
+				} else {
+					values = append(values, elem)
+				}
+			}
+			continue
+		}
+		if len(segs) > 1 {
+			values = append(values, lookupPath(child, segs[1:])...)
+			continue
+		}
+		values = append(values, child)
+	}
+	return values
+}
+
+// loadAlertRules reads and compiles the rules file. Environment variables
+// in the file are expanded, so webhook secrets need not be stored in it.
+func loadAlertRules(path string) ([]*compiledRule, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("error reading alert rules: %w", err)
+	}
+	var cfg AlertRules
+	if err := yaml.Unmarshal([]byte(os.ExpandEnv(string(data))), &cfg); err != nil {
+		return nil, fmt.Errorf("error parsing alert rules: %w", err)
+	}
+
+	notifiers := make(map[string]Notifier, len(cfg.Notifiers))
+	for _, nc := range cfg.Notifiers {
+		if _, ok := notifiers[nc.Name]; ok {
+			return nil, fmt.Errorf("duplicate notifier %q", nc.Name)
+		}
+		notifier, err := newNotifier(nc)
+		if err != nil {
+			return nil, err
+		}
+		notifiers[nc.Name] = notifier
+	}
+
+	seen := make(map[string]bool, len(cfg.Rules))
+	rules := make([]*compiledRule, 0, len(cfg.Rules))
+	for _, rule := range cfg.Rules {
+		if rule.Name == "" {
+			return nil, fmt.Errorf("alert rule without a name")
+		}
+		if seen[rule.Name] {
+			return nil, fmt.Errorf("duplicate alert rule %q", rule.Name)
+		}
+		seen[rule.Name] = true
+
+		compiled, err := compileRule(rule, notifiers)
+		if err != nil {
+			return nil, fmt.Errorf("alert rule %q: %w", rule.Name, err)
+		}
+		rules = append(rules, compiled)
+	}
+	return rules, nil
+}
+
+func compileRule(rule AlertRule, notifiers map[string]Notifier) (*compiledRule, error) {
+	if rule.Threshold <= 0 {
+		rule.Threshold = 1
+	}
+	if rule.Window <= 0 {
+		rule.Window = time.Minute
+	}
+	if rule.Cooldown <= 0 {
+		rule.Cooldown = rule.Window
+	}
+
+	c := &compiledRule{
+		AlertRule: rule,
+		app:       toSet(rule.Match.App),
+		module:    toSet(rule.Match.Module),
+		typ:       toSet(rule.Match.Type),
+		pri:       toSet(rule.Match.Pri),
+	}
+	if rule.Match.Msg != "" {
+		re, err := regexp.Compile(rule.Match.Msg)
+		if err != nil {
+			return nil, fmt.Errorf("invalid msg pattern: %w", err)
+		}
+		c.msg = re
+	}
+	for path, value := range rule.Match.Data {
+		segs, err := parsePath(path)
+		if err != nil {
+			return nil, err
+		}
+		c.data = append(c.data, dataCondition{path: path, segs: segs, value: value})
+	}
+
+	if len(rule.Notify) == 0 {
+		return nil, fmt.Errorf("no notifiers")
+	}
+	for _, name := range rule.Notify {
+		notifier, ok := notifiers[name]
+		if !ok {
+			return nil, fmt.Errorf("unknown notifier %q", name)
+		}
+		c.notifiers = append(c.notifiers, notifier)
+	}
+	return c, nil
+}
+
+func toSet(values []string) map[string]bool {
+	if len(values) == 0 {
+		return nil
+	}
+	set := make(map[string]bool, len(values))
+	for _, v := range values {
+		set[v] = true
+	}
+	return set
+}
@@ -0,0 +1,383 @@
+package main
+
+import (
+	"bufio"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// archiveCloseTimeout bounds the final uploads on shutdown. Files that are
+// not uploaded in time stay in the spool and are uploaded on the next start.
+const archiveCloseTimeout = 30 * time.Second
+
+// ArchiveSink keeps raw logs in an object store for long-term retention.
+// Entries are partitioned by app, type and the hour of their timestamp:
+//
+//	app=usersvc/type=A/date=2024-06-22/hour=10/<instance>-<opened>-<random>-<seq>.ndjson.gz
+//
+// Each partition is written to a gzip-compressed NDJSON file in a local
+// spool directory, flushed after every batch. Files are completed after
+// maxBytes of uncompressed data or rotateEvery and then uploaded in the
+// background, so a slow store never holds up indexing and a crash loses
+// nothing that was acknowledged.
+type ArchiveSink struct {
+	store       ObjectStore
+	spoolDir    string
+	maxBytes    int64
+	rotateEvery time.Duration
+	instance    string
+
+	mu    sync.Mutex
+	files map[string]*spoolFile // partition -> open file
+	seq   int
+
+	stop chan struct{}
+	done chan struct{}
+}
+
+// spoolFile is an archive file being written. It carries a .part suffix
+// until it is complete.
+type spoolFile struct {
+	path    string // final spool path, without .part
+	file    *os.File
+	gz      *gzip.Writer
+	written int64
+	opened  time.Time
+}
+
+// NewArchiveSink recovers files left in spoolDir by an earlier run and
+// starts uploading completed files to store
+func NewArchiveSink(store ObjectStore, spoolDir string, maxBytes int64, rotateEvery time.Duration) (*ArchiveSink, error) {
+	if err := os.MkdirAll(spoolDir, 0o755); err != nil {
+		return nil, fmt.Errorf("error creating archive spool directory: %w", err)
+	}
+	instance, _ := os.Hostname()
+	s := &ArchiveSink{
+		store:       store,
+		spoolDir:    spoolDir,
+		maxBytes:    maxBytes,
+		rotateEvery: rotateEvery,
+		instance:    archiveSegment(instance),
+		files:       make(map[string]*spoolFile),
+		stop:        make(chan struct{}),
+		done:        make(chan struct{}),
+	}
+	if err := s.recoverParts(); err != nil {
+		return nil, err
+	}
+	go s.run()
+	return s, nil
+}
+
+func (s *ArchiveSink) Name() string { return "archive" }
+
+func (s *ArchiveSink) Write(ctx context.Context, entries []LogEntry) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	touched := make(map[*spoolFile]bool)
+	for _, logEntry := range entries {
+		data, err := json.Marshal(logEntry)
+		if err != nil {
+			return fmt.Errorf("error marshaling log entry: %w", err)
+		}
+		partition := archivePartition(logEntry)
+		f, ok := s.files[partition]
+		if !ok {
+			if f, err = s.openLocked(partition); err != nil {
+				return err
+			}
+		}
+		n, err := f.gz.Write(append(data, '\n'))
+		f.written += int64(n)
+		if err != nil {
+			return fmt.Errorf("error writing %s: %w", f.path, err)
+		}
+		touched[f] = true
+	}
+	// Flush so a crash loses at most the batch being written
+	for f := range touched {
+		if err := f.gz.Flush(); err != nil {
+			return fmt.Errorf("error flushing %s: %w", f.path, err)
+		}
+	}
+	return s.rotateLocked(false)
+}
+
+func (s *ArchiveSink) openLocked(partition string) (*spoolFile, error) {
+	now := time.Now().UTC()
+	s.seq++
+	// The random part keeps names unique across restarts of the same host
+	key := fmt.Sprintf("%s/%s-%s-%s-%04d.ndjson.gz", partition, s.instance, now.Format("20060102T150405Z"), newReportID()[:8], s.seq)
+	// The object key is kept in the file name so uploads survive restarts
+	f := &spoolFile{path: filepath.Join(s.spoolDir, url.PathEscape(key)), opened: now}
+
+	file, err := os.OpenFile(f.path+".part", os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0o644)
+	if err != nil {
+		return nil, fmt.Errorf("error creating %s: %w", f.path, err)
+	}
+	f.file = file
+	f.gz = gzip.NewWriter(file)
+	s.files[partition] = f
+	return f, nil
+}
+
+// rotateLocked completes files that are due, or all files if force is set
+func (s *ArchiveSink) rotateLocked(force bool) error {
+	var errs []error
+	for partition, f := range s.files {
+		if !force && f.written < s.maxBytes && time.Since(f.opened) < s.rotateEvery {
+			continue
+		}
+		delete(s.files, partition)
+		err := f.gz.Close()
+		if cerr := f.file.Close(); err == nil {
+			err = cerr
+		}
+		if err == nil {
+			err = os.Rename(f.path+".part", f.path)
+		}
+		if err != nil {
+			errs = append(errs, fmt.Errorf("error completing %s: %w", f.path, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// run rotates and uploads files until Close
+func (s *ArchiveSink) run() {
+	defer close(s.done)
+	interval := min(s.rotateEvery, time.Minute)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		<-s.stop
+		cancel()
+	}()
+
+	for {
+		s.uploadCompleted(ctx)
+		select {
+		case <-s.stop:
+			return
+		case <-ticker.C:
+			s.mu.Lock()
+			if err := s.rotateLocked(false); err != nil {
+				log.Printf("Error rotating archive files: %v", err)
+			}
+			s.mu.Unlock()
+		}
+	}
+}
+
+// uploadCompleted uploads every completed spool file and removes it. Files
+// that fail are kept and retried on the next pass.
+func (s *ArchiveSink) uploadCompleted(ctx context.Context) {
+	names, err := os.ReadDir(s.spoolDir)
+	if err != nil {
+		log.Printf("Error reading archive spool: %v", err)
+		return
+	}
+	for _, entry := range names {
+		name := entry.Name()
+		if entry.IsDir() || !strings.HasSuffix(name, ".ndjson.gz") || ctx.Err() != nil {
+			continue
+		}
+		key, err := url.PathUnescape(name)
+		if err != nil {
+			log.Printf("Skipping unexpected archive spool file %s", name)
+			continue
+		}
+		if err := s.upload(ctx, filepath.Join(s.spoolDir, name), key); err != nil {
+			log.Printf("Error uploading %s: %v", key, err)
+			archiveUploads.WithLabelValues("error").Inc()
+			continue
+		}
+		archiveUploads.WithLabelValues("ok").Inc()
+	}
+}
+
+func (s *ArchiveSink) upload(ctx context.Context, spoolPath, key string) error {
+	f, err := os.Open(spoolPath)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	if err := s.store.Put(ctx, key, f); err != nil {
+		return err
+	}
+	if info, err := f.Stat(); err == nil {
+		archiveUploadedBytes.Add(float64(info.Size()))
+	}
+	return os.Remove(spoolPath)
+}
+
+// recoverParts completes files left open by a crash. Everything up to the
+// last flush is readable, so it is recompressed into a complete file.
+func (s *ArchiveSink) recoverParts() error {
+	parts, err := filepath.Glob(filepath.Join(s.spoolDir, "*.part"))
+	if err != nil {
+		return err
+	}
+	for _, part := range parts {
+		target := strings.TrimSuffix(part, ".part")
+		n, err := recompress(part, target)
+		if err != nil {
+			return fmt.Errorf("error recovering %s: %w", part, err)
+		}
+		log.Printf("Recovered %d archived log entries from %s", n, filepath.Base(target))
+		if err := os.Remove(part); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// recompress copies the complete lines of a possibly truncated gzip file
+// into a new gzip file
+func recompress(src, dst string) (int, error) {
+	in, err := os.Open(src)
+	if err != nil {
+		return 0, err
+	}
+	defer in.Close()
+	out, err := os.Create(dst)
+	if err != nil {
+		return 0, err
+	}
+	gzOut := gzip.NewWriter(out)
+
+	n := 0
+	if gzIn, err := gzip.NewReader(in); err == nil {
+		reader := bufio.NewReader(gzIn)
+		for {
+			line, err := reader.ReadBytes('\n')
+			if err != nil {
+				// A trailing partial line was never acknowledged
+				break
+			}
+			if _, err := gzOut.Write(line); err != nil {
+				out.Close()
+				return n, err
+			}
+			n++
+		}
+	}
+	err = gzOut.Close()
+	if cerr := out.Close(); err == nil {
+		err = cerr
+	}
+	return n, err
+}
+
+// Close completes all open files and makes a last attempt to upload them
+func (s *ArchiveSink) Close() error {
+	close(s.stop)
+	<-s.done
+
+	s.mu.Lock()
+	err := s.rotateLocked(true)
+	s.mu.Unlock()
+
+	ctx, cancel := context.WithTimeout(context.Background(), archiveCloseTimeout)
+	defer cancel()
+	s.uploadCompleted(ctx)
+	return err
+}
+
+// archivePartition is the key prefix for logEntry. Entries without a
+// parseable timestamp are filed under the current hour.
+func archivePartition(logEntry LogEntry) string {
+	when, err := time.Parse(time.RFC3339Nano, logEntry.When)
+	if err != nil {
+		when = time.Now()
+	}
+	when = when.UTC()
+	return fmt.Sprintf("app=%s/type=%s/date=%s/hour=%02d",
+		archiveSegment(logEntry.App), archiveSegment(strings.ToUpper(logEntry.Type)), when.Format("2006-01-02"), when.Hour())
+}
+
+// archiveSegment makes s safe to use as one key segment
+func archiveSegment(s string) string {
+	if s == "" {
+		return "unknown"
+	}
+	return strings.Map(func(r rune) rune {
+		switch {
+		case 'a' <= r && r <= 'z', 'A' <= r && r <= 'Z', '0' <= r && r <= '9', r == '-', r == '_', r == '.':
+			return r
+		}
+		return '_'
+	}, s)
+}
+
+// archiveKey is a parsed object key
+type archiveKey struct {
+	app  string
+	typ  string
+	hour time.Time
+}
+
+// parseArchiveKey reads the partition of an object key written by ArchiveSink
+func parseArchiveKey(key string) (archiveKey, bool) {
+	parts := strings.Split(path.Dir(key), "/")
+	if len(parts) != 4 {
+		return archiveKey{}, false
+	}
+	values := make([]string, 4)
+	for i, name := range []string{"app", "type", "date", "hour"} {
+		v, ok := strings.CutPrefix(parts[i], name+"=")
+		if !ok {
+			return archiveKey{}, false
+		}
+		values[i] = v
+	}
+	hour, err := time.Parse("2006-01-02 15", values[2]+" "+values[3])
+	if err != nil {
+		return archiveKey{}, false
+	}
+	return archiveKey{app: values[0], typ: values[1], hour: hour}, true
+}
+
+// readArchive calls fn for every entry in an archived object
+func readArchive(ctx context.Context, store ObjectStore, key string, fn func(LogEntry) error) error {
+	body, err := store.Get(ctx, key)
+	if err != nil {
+		return err
+	}
+	defer body.Close()
+	gz, err := gzip.NewReader(body)
+	if err != nil {
+		return fmt.Errorf("error reading %s: %w", key, err)
+	}
+	scanner := bufio.NewScanner(gz)
+	scanner.Buffer(make([]byte, 64*1024), 16<<20)
+	for scanner.Scan() {
+		var logEntry LogEntry
+		if err := json.Unmarshal(scanner.Bytes(), &logEntry); err != nil {
+			return fmt.Errorf("error decoding %s: %w", key, err)
+		}
+		if err := fn(logEntry); err != nil {
+			return err
+		}
+	}
+	if err := scanner.Err(); err != nil && !errors.Is(err, io.ErrUnexpectedEOF) {
+		return fmt.Errorf("error reading %s: %w", key, err)
+	}
+	return nil
+}
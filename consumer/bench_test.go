@@ -0,0 +1,100 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/IBM/sarama"
+)
+
+// BenchmarkConsumeClaim measures end-to-end throughput from a claim to the
+// fake Elasticsearch, including validation, enrichment and bulk encoding.
+// The slow-es cases add Elasticsearch latency to show how many workers are
+// needed to keep a partition busy.
+// Run with: go test -run '^$' -bench ConsumeClaim -benchtime 50000x
+func BenchmarkConsumeClaim(b *testing.B) {
+	for _, bc := range []struct {
+		name  string
+		setup func(b *testing.B, consumer *Consumer, es *fakeElasticsearch)
+	}{
+		{"plain", func(b *testing.B, consumer *Consumer, es *fakeElasticsearch) {}},
+		{"dedup", func(b *testing.B, consumer *Consumer, es *fakeElasticsearch) {
+			consumer.dedup = NewDeduplicator(100000, consumer.cfg.DedupWindow, nil)
+		}},
+		{"sampled", func(b *testing.B, consumer *Consumer, es *fakeElasticsearch) {
+			sampler, err := NewSampler("Debug2=0.1,Debug1=0.1,Debug0=0.5", 0, consumer.cfg.DedupWindow)
+			if err != nil {
+				b.Fatal(err)
+			}
+			consumer.sampler = sampler
+		}},
+		{"slow-es/workers=1", func(b *testing.B, consumer *Consumer, es *fakeElasticsearch) {
+			consumer.cfg.Workers = 1
+			es.set(func(f *fakeElasticsearch) { f.delay = 20 * time.Millisecond })
+		}},
+		{"slow-es/workers=8", func(b *testing.B, consumer *Consumer, es *fakeElasticsearch) {
+			consumer.cfg.Workers = 8
+			es.set(func(f *fakeElasticsearch) { f.delay = 20 * time.Millisecond })
+		}},
+	} {
+		b.Run(bc.name, func(b *testing.B) {
+			es := newFakeElasticsearch(b)
+			cfg := testConfig()
+			cfg.BatchSize = 500
+			cfg.UserAgentPaths = "user_agent"
+			consumer := newTestConsumer(b, cfg, es)
+			enricher, err := NewEnricher(cfg)
+			if err != nil {
+				b.Fatal(err)
+			}
+			consumer.enricher = enricher
+			bc.setup(b, consumer, es)
+
+			messages := newEntryGenerator(1).messages(b, b.N)
+			session := newFakeSession(context.Background())
+			claim := &fakeClaim{topic: "logharbour-logs", messages: make(chan *sarama.ConsumerMessage, len(messages))}
+			for _, msg := range messages {
+				claim.messages <- msg
+			}
+			close(claim.messages)
+
+			b.ReportAllocs()
+			b.ResetTimer()
+			if err := consumer.ConsumeClaim(session, claim); err != nil {
+				b.Fatal(err)
+			}
+			b.StopTimer()
+
+			if got := session.markedOffset(0); got != int64(b.N) {
+				b.Fatalf("marked offset = %d, want %d", got, b.N)
+			}
+			b.ReportMetric(float64(b.N)/b.Elapsed().Seconds(), "msgs/s")
+		})
+	}
+}
+
+// BenchmarkProcess measures per-message decoding, validation and enrichment
+// without any sink
+func BenchmarkProcess(b *testing.B) {
+	cfg := testConfig()
+	cfg.UserAgentPaths = "user_agent"
+	consumer := &Consumer{cfg: cfg}
+	var err error
+	if consumer.validator, err = NewValidator(); err != nil {
+		b.Fatal(err)
+	}
+	if consumer.enricher, err = NewEnricher(cfg); err != nil {
+		b.Fatal(err)
+	}
+	messages := newEntryGenerator(1).messages(b, 1000)
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, ok := consumer.process(messages[i%len(messages)]); !ok {
+			b.Fatal("message rejected")
+		}
+	}
+	b.ReportMetric(float64(b.N)/b.Elapsed().Seconds(), "msgs/s")
+}
@@ -0,0 +1,196 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// changeLogSchema creates the audit table on startup. Rows are keyed on the
+// log ID and field so redelivered messages overwrite rather than duplicate.
+const changeLogSchema = `
+CREATE TABLE IF NOT EXISTS entity_change_log (
+    log_id     VARCHAR(255) NOT NULL,
+    field      VARCHAR(255) NOT NULL,
+    entity     VARCHAR(255) NOT NULL,
+    instance   VARCHAR(255),
+    op         VARCHAR(64),
+    old_value  JSONB,
+    new_value  JSONB,
+    who        VARCHAR(255),
+    app        VARCHAR(255),
+    changed_at TIMESTAMP WITH TIME ZONE NOT NULL,
+    PRIMARY KEY (log_id, field)
+);
+CREATE INDEX IF NOT EXISTS entity_change_log_entity_idx
+    ON entity_change_log (entity, instance, changed_at);
+`
+
+const upsertChange = `
+INSERT INTO entity_change_log (
+    log_id, field, entity, instance, op, old_value, new_value, who, app, changed_at
+) VALUES (
+    $1, $2, $3, $4, $5, $6, $7, $8, $9, $10
+)
+ON CONFLICT (log_id, field) DO UPDATE SET
+    entity = EXCLUDED.entity,
+    instance = EXCLUDED.instance,
+    op = EXCLUDED.op,
+    old_value = EXCLUDED.old_value,
+    new_value = EXCLUDED.new_value,
+    who = EXCLUDED.who,
+    app = EXCLUDED.app,
+    changed_at = EXCLUDED.changed_at`
+
+// ChangeRow is one field change extracted from a LogHarbour change log
+type ChangeRow struct {
+	LogID     string
+	Field     string
+	Entity    string
+	Instance  string
+	Op        string
+	OldValue  any
+	NewValue  any
+	Who       string
+	App       string
+	ChangedAt time.Time
+}
+
+// changeData mirrors LogHarbour's ChangeInfo as it appears under data.change_data
+type changeData struct {
+	Entity  string `json:"entity"`
+	Op      string `json:"op"`
+	Changes []struct {
+		Field    string `json:"field"`
+		OldValue any    `json:"old_value"`
+		NewValue any    `json:"new_value"`
+	} `json:"changes"`
+}
+
+// changeRows extracts per-field rows from a change log entry. It returns
+// nil for entries that are not change logs.
+func changeRows(logEntry LogEntry) ([]ChangeRow, error) {
+	if logEntry.Type != "C" || logEntry.Data == nil {
+		return nil, nil
+	}
+	raw, ok := logEntry.Data["change_data"]
+	if !ok {
+		return nil, nil
+	}
+
+	// Round-trip through JSON to get a typed view of the generic map
+	data, err := json.Marshal(raw)
+	if err != nil {
+		return nil, fmt.Errorf("error marshaling change data: %w", err)
+	}
+	var change changeData
+	if err := json.Unmarshal(data, &change); err != nil {
+		return nil, fmt.Errorf("error decoding change data: %w", err)
+	}
+	if change.Entity == "" {
+		return nil, fmt.Errorf("change data without entity")
+	}
+
+	changedAt, err := time.Parse(time.RFC3339Nano, logEntry.When)
+	if err != nil {
+		return nil, fmt.Errorf("invalid timestamp %q: %w", logEntry.When, err)
+	}
+
+	rows := make([]ChangeRow, 0, len(change.Changes))
+	for _, c := range change.Changes {
+		rows = append(rows, ChangeRow{
+			LogID:     logEntry.ID,
+			Field:     c.Field,
+			Entity:    change.Entity,
+			Instance:  logEntry.Instance,
+			Op:        change.Op,
+			OldValue:  c.OldValue,
+			NewValue:  c.NewValue,
+			Who:       logEntry.Who,
+			App:       logEntry.App,
+			ChangedAt: changedAt,
+		})
+	}
+	return rows, nil
+}
+
+// ChangeLogSink materializes change logs into Postgres so entity history
+// outlives Elasticsearch retention. Entries that are not change logs are ignored.
+type ChangeLogSink struct {
+	pool *pgxpool.Pool
+}
+
+// NewChangeLogSink connects to Postgres and ensures the audit table exists
+func NewChangeLogSink(ctx context.Context, databaseURL string) (*ChangeLogSink, error) {
+	pool, err := pgxpool.New(ctx, databaseURL)
+	if err != nil {
+		return nil, fmt.Errorf("error creating change log pool: %w", err)
+	}
+	if err := pool.Ping(ctx); err != nil {
+		pool.Close()
+		return nil, fmt.Errorf("error connecting to change log database: %w", err)
+	}
+	if _, err := pool.Exec(ctx, changeLogSchema); err != nil {
+		pool.Close()
+		return nil, fmt.Errorf("error creating change log table: %w", err)
+	}
+	return &ChangeLogSink{pool: pool}, nil
+}
+
+func (s *ChangeLogSink) Name() string { return "changelog" }
+
+// Write upserts the change rows found in entries in a single batch
+func (s *ChangeLogSink) Write(ctx context.Context, entries []LogEntry) error {
+	batch := &pgx.Batch{}
+	for _, logEntry := range entries {
+		rows, err := changeRows(logEntry)
+		if err != nil {
+			log.Printf("Skipping change log %s: %v", logEntry.ID, err)
+			changeLogErrors.Inc()
+			continue
+		}
+		for _, row := range rows {
+			oldValue, _ := json.Marshal(row.OldValue)
+			newValue, _ := json.Marshal(row.NewValue)
+			batch.Queue(upsertChange,
+				row.LogID, row.Field, row.Entity, nullable(row.Instance), nullable(row.Op),
+				oldValue, newValue, nullable(row.Who), nullable(row.App), row.ChangedAt)
+		}
+	}
+	if batch.Len() == 0 {
+		return nil
+	}
+
+	if err := s.pool.SendBatch(ctx, batch).Close(); err != nil {
+		changeLogErrors.Add(float64(batch.Len()))
+		return fmt.Errorf("error writing change log rows: %w", err)
+	}
+	changeLogRows.Add(float64(batch.Len()))
+	return nil
+}
+
+// CheckHealth pings the database
+func (s *ChangeLogSink) CheckHealth(ctx context.Context) (string, bool, error) {
+	if err := s.pool.Ping(ctx); err != nil {
+		return "unreachable", false, err
+	}
+	return "up", true, nil
+}
+
+// Close releases the connection pool
+func (s *ChangeLogSink) Close() error {
+	s.pool.Close()
+	return nil
+}
+
+func nullable(s string) *string {
+	if s == "" {
+		return nil
+	}
+	return &s
+}
@@ -0,0 +1,214 @@
+package main
+
+import (
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Config holds consumer settings read from the environment
+type Config struct {
+	KafkaBrokers []string
+	Topic        string
+	Group        string
+
+	// Sinks lists the outputs every batch is written to
+	Sinks []string
+
+	ElasticsearchURL string
+
+	OpenSearchURL      string
+	OpenSearchUsername string
+	OpenSearchPassword string
+
+	FileSinkDir            string
+	FileSinkMaxBytes       int64
+	FileSinkRotateInterval time.Duration
+
+	// Postgres database for the changelog sink
+	ChangeLogDatabaseURL string
+
+	// Long-term archive for the archive sink and the restore and compact
+	// subcommands: s3://bucket/prefix or a directory
+	ArchiveURL            string
+	ArchiveS3             S3Config
+	ArchiveSpoolDir       string
+	ArchiveMaxBytes       int64
+	ArchiveRotateInterval time.Duration
+
+	// Bulk indexing. Up to Workers batches per partition are processed and
+	// written concurrently.
+	BatchSize     int
+	FlushInterval time.Duration
+	Workers       int
+
+	// Schema validation. Rejected messages go to InvalidTopic unless it is empty.
+	SchemaValidation bool
+	InvalidTopic     string
+
+	// PII redaction. Disabled when RedactionConfig is empty.
+	RedactionConfig  string
+	RedactionHashKey string
+
+	// Enrichment. Tags are comma-separated key=value pairs. User agents are
+	// read from the first of UserAgentPaths (below data) that is set.
+	// Geolocation is disabled when GeoIPDatabase is empty.
+	EnrichEnvironment string
+	EnrichCluster     string
+	EnrichTags        string
+	UserAgentPaths    string
+	GeoIPDatabase     string
+
+	// Sampling of low-priority entries. Disabled when SamplingRates is empty.
+	SamplingRates          string
+	SamplingModuleLimit    float64
+	SamplingTraceWindow    time.Duration
+	SamplingReportInterval time.Duration
+
+	// Deduplication of redelivered messages by log ID. DedupDatabaseURL adds a
+	// Postgres store shared across instances to the in-memory cache.
+	DedupEnabled     bool
+	DedupWindow      time.Duration
+	DedupCacheSize   int
+	DedupDatabaseURL string
+
+	// Alerting. Disabled when AlertRules is empty. The rules file is reloaded
+	// on SIGHUP and when it changes, checked every AlertReloadInterval.
+	AlertRules          string
+	AlertReloadInterval time.Duration
+
+	// Observability
+	MetricsAddr         string
+	HealthCheckInterval time.Duration
+
+	// ShutdownTimeout bounds how long in-flight batches may take to drain
+	ShutdownTimeout time.Duration
+}
+
+func loadConfig() Config {
+	return Config{
+		KafkaBrokers: strings.Split(getEnv("KAFKA_BROKERS", "localhost:9092"), ","),
+		Topic:        getEnv("KAFKA_TOPIC", "logharbour-logs"),
+		Group:        getEnv("KAFKA_CONSUMER_GROUP", "logharbour-consumer"),
+
+		Sinks: strings.Split(getEnv("SINKS", "elasticsearch"), ","),
+
+		ElasticsearchURL: getEnv("ELASTICSEARCH_URL", "http://localhost:9200"),
+
+		OpenSearchURL:      os.Getenv("OPENSEARCH_URL"),
+		OpenSearchUsername: os.Getenv("OPENSEARCH_USERNAME"),
+		OpenSearchPassword: os.Getenv("OPENSEARCH_PASSWORD"),
+
+		FileSinkDir:            getEnv("FILE_SINK_DIR", "archive"),
+		FileSinkMaxBytes:       int64(getEnvInt("FILE_SINK_MAX_BYTES", 100<<20)),
+		FileSinkRotateInterval: getEnvDuration("FILE_SINK_ROTATE_INTERVAL", time.Hour),
+
+		ChangeLogDatabaseURL: os.Getenv("CHANGELOG_DATABASE_URL"),
+
+		ArchiveURL: os.Getenv("ARCHIVE_URL"),
+		ArchiveS3: S3Config{
+			Endpoint:        os.Getenv("ARCHIVE_S3_ENDPOINT"),
+			Region:          getEnv("ARCHIVE_S3_REGION", "us-east-1"),
+			AccessKeyID:     os.Getenv("AWS_ACCESS_KEY_ID"),
+			SecretAccessKey: os.Getenv("AWS_SECRET_ACCESS_KEY"),
+		},
+		ArchiveSpoolDir:       getEnv("ARCHIVE_SPOOL_DIR", "archive-spool"),
+		ArchiveMaxBytes:       int64(getEnvInt("ARCHIVE_MAX_BYTES", 256<<20)),
+		ArchiveRotateInterval: getEnvDuration("ARCHIVE_ROTATE_INTERVAL", 15*time.Minute),
+
+		BatchSize:     getEnvInt("BATCH_SIZE", 100),
+		FlushInterval: getEnvDuration("FLUSH_INTERVAL", time.Second),
+		Workers:       getEnvInt("WORKERS", 4),
+
+		SchemaValidation: getEnvBool("SCHEMA_VALIDATION", true),
+		InvalidTopic:     os.Getenv("INVALID_TOPIC"),
+
+		RedactionConfig:  os.Getenv("REDACTION_CONFIG"),
+		RedactionHashKey: os.Getenv("REDACTION_HASH_KEY"),
+
+		EnrichEnvironment: os.Getenv("ENRICH_ENVIRONMENT"),
+		EnrichCluster:     os.Getenv("ENRICH_CLUSTER"),
+		EnrichTags:        os.Getenv("ENRICH_TAGS"),
+		UserAgentPaths:    getEnv("USER_AGENT_PATHS", "user_agent,activity_data.user_agent"),
+		GeoIPDatabase:     os.Getenv("GEOIP_DATABASE"),
+
+		SamplingRates:          os.Getenv("SAMPLING_RATES"),
+		SamplingModuleLimit:    getEnvFloat("SAMPLING_MODULE_LIMIT", 0),
+		SamplingTraceWindow:    getEnvDuration("SAMPLING_TRACE_WINDOW", 5*time.Minute),
+		SamplingReportInterval: getEnvDuration("SAMPLING_REPORT_INTERVAL", time.Minute),
+
+		DedupEnabled:     getEnvBool("DEDUP_ENABLED", true),
+		DedupWindow:      getEnvDuration("DEDUP_WINDOW", 10*time.Minute),
+		DedupCacheSize:   getEnvInt("DEDUP_CACHE_SIZE", 100000),
+		DedupDatabaseURL: os.Getenv("DEDUP_DATABASE_URL"),
+
+		AlertRules:          os.Getenv("ALERT_RULES"),
+		AlertReloadInterval: getEnvDuration("ALERT_RELOAD_INTERVAL", 30*time.Second),
+
+		MetricsAddr:         getEnv("METRICS_ADDR", ":2112"),
+		HealthCheckInterval: getEnvDuration("HEALTH_CHECK_INTERVAL", 10*time.Second),
+
+		ShutdownTimeout: getEnvDuration("SHUTDOWN_TIMEOUT", 30*time.Second),
+	}
+}
+
+func getEnv(key, def string) string {
+	if v := os.Getenv(key); v != "" {
+		return v
+	}
+	return def
+}
+
+func getEnvInt(key string, def int) int {
+	v := os.Getenv(key)
+	if v == "" {
+		return def
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil {
+		log.Printf("Invalid value for %s (%q), using default %d", key, v, def)
+		return def
+	}
+	return n
+}
+
+func getEnvFloat(key string, def float64) float64 {
+	v := os.Getenv(key)
+	if v == "" {
+		return def
+	}
+	f, err := strconv.ParseFloat(v, 64)
+	if err != nil {
+		log.Printf("Invalid value for %s (%q), using default %g", key, v, def)
+		return def
+	}
+	return f
+}
+
+func getEnvBool(key string, def bool) bool {
+	v := os.Getenv(key)
+	if v == "" {
+		return def
+	}
+	b, err := strconv.ParseBool(v)
+	if err != nil {
+		log.Printf("Invalid value for %s (%q), using default %t", key, v, def)
+		return def
+	}
+	return b
+}
+
+func getEnvDuration(key string, def time.Duration) time.Duration {
+	v := os.Getenv(key)
+	if v == "" {
+		return def
+	}
+	d, err := time.ParseDuration(v)
+	if err != nil {
+		log.Printf("Invalid value for %s (%q), using default %s", key, v, def)
+		return def
+	}
+	return d
+}
@@ -0,0 +1,243 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/IBM/sarama"
+)
+
+// Consumer represents a Sarama consumer group consumer
+type Consumer struct {
+	sinks      []Sink
+	cfg        Config
+	health     *Health
+	redactor   *Redactor     // nil when redaction is disabled
+	enricher   *Enricher     // nil when enrichment is disabled
+	sampler    *Sampler      // nil when sampling is disabled
+	validator  *Validator    // nil when schema validation is disabled
+	deadLetter *DeadLetter   // nil when rejected messages are not routed
+	dedup      *Deduplicator // nil when deduplication is disabled
+	alerts     *AlertEngine  // nil when alerting is disabled
+
+	// indexCtx is used for sink writes. It outlives the Kafka session so
+	// pending batches can be flushed during shutdown, and is cancelled only
+	// when the shutdown deadline expires.
+	indexCtx context.Context
+}
+
+// Setup is run at the beginning of a new session, before ConsumeClaim
+func (consumer *Consumer) Setup(sarama.ConsumerGroupSession) error {
+	consumer.health.SetKafkaSession(true)
+	return nil
+}
+
+// Cleanup is run at the end of a session, once all ConsumeClaim goroutines have exited.
+// Offsets marked while draining are committed before the session is released.
+func (consumer *Consumer) Cleanup(session sarama.ConsumerGroupSession) error {
+	session.Commit()
+	consumer.health.SetKafkaSession(false)
+	return nil
+}
+
+// batch is a run of consecutive messages from one partition. Batches are
+// numbered in the order they were read so their offsets can be marked in
+// that order, whichever worker finishes first.
+type batch struct {
+	seq      uint64
+	messages []*sarama.ConsumerMessage
+}
+
+// ConsumeClaim must start a consumer loop of ConsumerGroupClaim's Messages().
+// Messages are read in order and handed out in batches to a pool of
+// cfg.Workers goroutines, which process and write them concurrently. When
+// every worker is busy, reading waits for one to finish.
+func (consumer *Consumer) ConsumeClaim(session sarama.ConsumerGroupSession, claim sarama.ConsumerGroupClaim) error {
+	topic := claim.Topic()
+	partition := strconv.Itoa(int(claim.Partition()))
+	tracker := newOffsetTracker(session, topic, claim.Partition())
+	inFlight := batchesInFlight.WithLabelValues(topic, partition)
+
+	jobs := make(chan batch)
+	var workers sync.WaitGroup
+	for i := 0; i < max(1, consumer.cfg.Workers); i++ {
+		workers.Add(1)
+		go func() {
+			defer workers.Done()
+			for b := range jobs {
+				consumer.flush(b.messages)
+				tracker.complete(b.seq, b.messages[len(b.messages)-1].Offset+1)
+				inFlight.Dec()
+			}
+		}()
+	}
+
+	ticker := time.NewTicker(consumer.cfg.FlushInterval)
+	defer ticker.Stop()
+
+	var pending batch
+	dispatch := func() {
+		if len(pending.messages) == 0 {
+			return
+		}
+		inFlight.Inc()
+		jobs <- pending
+		pending = batch{seq: pending.seq + 1}
+	}
+	// Batches already read are written even when the session ends, before
+	// Cleanup commits their offsets
+	finish := func() {
+		dispatch()
+		close(jobs)
+		workers.Wait()
+	}
+
+	for {
+		select {
+		case message, ok := <-claim.Messages():
+			if !ok {
+				finish()
+				return nil
+			}
+			messagesConsumed.WithLabelValues(topic, partition).Inc()
+			consumerLag.WithLabelValues(topic, partition).Set(float64(claim.HighWaterMarkOffset() - message.Offset - 1))
+
+			pending.messages = append(pending.messages, message)
+			if len(pending.messages) >= consumer.cfg.BatchSize {
+				dispatch()
+			}
+
+		case <-ticker.C:
+			dispatch()
+
+		case <-session.Context().Done():
+			// Stop fetching; index what has already been read
+			finish()
+			return nil
+		}
+	}
+}
+
+// process turns a Kafka message into a log entry ready for the sinks.
+// ok is false if the message must not be written.
+func (consumer *Consumer) process(message *sarama.ConsumerMessage) (logEntry LogEntry, ok bool) {
+	// Parse and validate log entry
+	if consumer.validator != nil {
+		var violation *Violation
+		logEntry, violation = consumer.validator.Decode(message.Value)
+		if violation != nil {
+			consumer.reject(message, violation)
+			return logEntry, false
+		}
+	} else if err := json.Unmarshal(message.Value, &logEntry); err != nil {
+		consumer.reject(message, &Violation{Reason: ViolationMalformed, Detail: err.Error()})
+		return logEntry, false
+	}
+
+	if consumer.sampler != nil && !consumer.sampler.Keep(&logEntry) {
+		return logEntry, false
+	}
+
+	if !consumer.redact(&logEntry) {
+		return logEntry, false
+	}
+	// Enrich after redaction so nothing is derived from redacted values
+	if consumer.enricher != nil {
+		consumer.enricher.Enrich(&logEntry)
+	}
+	return logEntry, true
+}
+
+// reject counts an invalid message and routes it to the dead letter topic
+func (consumer *Consumer) reject(message *sarama.ConsumerMessage, violation *Violation) {
+	log.Printf("Rejected message at %s/%d/%d: %v", message.Topic, message.Partition, message.Offset, violation)
+	if violation.Reason == ViolationMalformed {
+		parseFailures.WithLabelValues(message.Topic).Inc()
+	}
+	schemaViolations.WithLabelValues(violation.Reason, violation.Version).Inc()
+
+	if consumer.deadLetter == nil {
+		return
+	}
+	if err := consumer.deadLetter.Send(message, violation); err != nil {
+		log.Printf("Error routing rejected message: %v", err)
+		deadLetterErrors.Inc()
+		return
+	}
+	deadLettered.Inc()
+}
+
+// redact strips personal data from logEntry before it is indexed. Entries
+// that cannot be redacted are dropped rather than indexed unredacted.
+func (consumer *Consumer) redact(logEntry *LogEntry) bool {
+	if consumer.redactor == nil {
+		return true
+	}
+	if err := consumer.redactor.Redact(logEntry); err != nil {
+		log.Printf("Error redacting log %s, dropping it: %v", logEntry.ID, err)
+		redactionFailures.Inc()
+		return false
+	}
+	return true
+}
+
+// flush processes a batch of messages and writes the resulting entries to
+// every sink. Redelivered entries are dropped first and alert rules are
+// evaluated on the rest. Sink failures are logged and counted; the batch is
+// still marked afterwards so that one bad document or sink cannot stall the
+// partition. Entries are only recorded as processed once every sink has
+// written them, so that a redelivery of a batch that was not written is not
+// dropped as a duplicate.
+func (consumer *Consumer) flush(messages []*sarama.ConsumerMessage) {
+	entries := make([]LogEntry, 0, len(messages))
+	for _, message := range messages {
+		if logEntry, ok := consumer.process(message); ok {
+			entries = append(entries, logEntry)
+		}
+	}
+
+	if consumer.dedup != nil && len(entries) > 0 {
+		entries = consumer.dedup.Check(consumer.indexCtx, entries)
+	}
+	if consumer.alerts != nil && len(entries) > 0 {
+		consumer.alerts.Evaluate(entries)
+	}
+	if len(entries) > 0 {
+		bulkSize.Observe(float64(len(entries)))
+		written := true
+		for _, sink := range consumer.sinks {
+			written = writeToSink(consumer.indexCtx, sink, entries) && written
+		}
+		if consumer.dedup != nil && written {
+			consumer.dedup.Record(consumer.indexCtx, entries)
+		}
+	}
+}
+
+// writeToSink writes one batch to sink and records the outcome. It reports
+// whether the sink accepted every entry.
+func writeToSink(ctx context.Context, sink Sink, entries []LogEntry) bool {
+	name := sink.Name()
+	start := time.Now()
+	err := sink.Write(ctx, entries)
+	sinkWriteDuration.WithLabelValues(name).Observe(time.Since(start).Seconds())
+
+	var partial *PartialWriteError
+	switch {
+	case err == nil:
+		sinkDocuments.WithLabelValues(name, "ok").Add(float64(len(entries)))
+	case errors.As(err, &partial):
+		log.Printf("Error writing logs to %s: %v", name, err)
+		sinkDocuments.WithLabelValues(name, "ok").Add(float64(partial.Total - partial.Failed))
+		sinkDocuments.WithLabelValues(name, "error").Add(float64(partial.Failed))
+	default:
+		log.Printf("Error writing logs to %s: %v", name, err)
+		sinkDocuments.WithLabelValues(name, "error").Add(float64(len(entries)))
+	}
+	return err == nil
+}
@@ -0,0 +1,365 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/IBM/sarama"
+	"github.com/IBM/sarama/mocks"
+)
+
+func TestConsumeClaimIndexesInBatches(t *testing.T) {
+	es := newFakeElasticsearch(t)
+	consumer := newTestConsumer(t, testConfig(), es)
+	session := newFakeSession(context.Background())
+	claim := newFakeClaim("logharbour-logs", 0, 0)
+
+	done := runClaim(t, consumer, session, claim)
+	claim.sendEntries(t, newEntryGenerator(1).entries(25))
+
+	// Two full batches are written without waiting for the flush interval
+	waitFor(t, "two full batches", func() bool { return es.docCount() == 20 && session.markedOffset(0) == 20 })
+
+	// The partial batch is written when the claim ends
+	close(claim.messages)
+	waitDone(t, done)
+	if got := es.docCount(); got != 25 {
+		t.Errorf("indexed %d documents, want 25", got)
+	}
+	if got := session.markedOffset(0); got != 25 {
+		t.Errorf("marked offset = %d, want 25", got)
+	}
+	if got := es.bulkCallCount(); got != 3 {
+		t.Errorf("bulk calls = %d, want 3", got)
+	}
+}
+
+func TestConsumeClaimFlushesOnInterval(t *testing.T) {
+	es := newFakeElasticsearch(t)
+	cfg := testConfig()
+	cfg.FlushInterval = 20 * time.Millisecond
+	consumer := newTestConsumer(t, cfg, es)
+	session := newFakeSession(context.Background())
+	claim := newFakeClaim("logharbour-logs", 0, 0)
+
+	done := runClaim(t, consumer, session, claim)
+	claim.sendEntries(t, newEntryGenerator(2).entries(3))
+
+	waitFor(t, "partial batch flush", func() bool { return es.docCount() == 3 && session.markedOffset(0) == 3 })
+	close(claim.messages)
+	waitDone(t, done)
+}
+
+func TestSlowBatchHoldsBackLaterOffsets(t *testing.T) {
+	es := newFakeElasticsearch(t)
+	release := make(chan struct{})
+	var fastDone atomic.Int64
+	es.set(func(f *fakeElasticsearch) {
+		f.onBulk = func(ids []string) {
+			if ids[0] == "log-00000001" {
+				<-release
+				return
+			}
+			fastDone.Add(1)
+		}
+	})
+	consumer := newTestConsumer(t, testConfig(), es)
+	session := newFakeSession(context.Background())
+	claim := newFakeClaim("logharbour-logs", 0, 0)
+
+	done := runClaim(t, consumer, session, claim)
+	claim.sendEntries(t, newEntryGenerator(10).entries(40))
+
+	// Later batches finish while the first is still being written, but their
+	// offsets must not be marked ahead of it
+	waitFor(t, "later batches", func() bool { return fastDone.Load() == 3 })
+	time.Sleep(20 * time.Millisecond)
+	if got := session.markedOffset(0); got != 0 {
+		t.Fatalf("marked offset = %d while the first batch is unfinished, want 0", got)
+	}
+
+	close(release)
+	waitFor(t, "first batch", func() bool { return session.markedOffset(0) == 40 })
+	close(claim.messages)
+	waitDone(t, done)
+	if got := es.docCount(); got != 40 {
+		t.Errorf("indexed %d documents, want 40", got)
+	}
+}
+
+func TestParseFailuresAreDeadLettered(t *testing.T) {
+	es := newFakeElasticsearch(t)
+	consumer := newTestConsumer(t, testConfig(), es)
+
+	producer := mocks.NewSyncProducer(t, nil)
+	for _, reason := range []string{ViolationMalformed, ViolationSchema, ViolationUnknownVersion} {
+		reason := reason
+		producer.ExpectSendMessageWithMessageCheckerFunctionAndSucceed(func(msg *sarama.ProducerMessage) error {
+			if msg.Topic != "logharbour-logs-invalid" {
+				t.Errorf("dead letter topic = %s", msg.Topic)
+			}
+			if got := string(msg.Headers[0].Value); got != reason {
+				t.Errorf("x-violation-reason = %s, want %s", got, reason)
+			}
+			return nil
+		})
+	}
+	consumer.deadLetter = &DeadLetter{producer: producer, topic: "logharbour-logs-invalid"}
+
+	session := newFakeSession(context.Background())
+	claim := newFakeClaim("logharbour-logs", 0, 0)
+	done := runClaim(t, consumer, session, claim)
+
+	gen := newEntryGenerator(3)
+	claim.sendEntries(t, gen.entries(1))
+	claim.send([]byte(`{"id": "broken"`))
+	claim.send([]byte(`{"id": "x1", "app": "usersvc", "type": "Z", "pri": "Info", "when": "2024-06-22T10:00:00Z"}`))
+	claim.send([]byte(`{"id": "x2", "app": "usersvc", "type": "A", "pri": "Info", "when": "2024-06-22T10:00:00Z", "schema_version": "9"}`))
+	claim.sendEntries(t, gen.entries(1))
+
+	close(claim.messages)
+	waitDone(t, done)
+	if err := producer.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	if got := es.docCount(); got != 2 {
+		t.Errorf("indexed %d documents, want 2", got)
+	}
+	// Rejected messages must not hold back the committed offset
+	if got := session.markedOffset(0); got != 5 {
+		t.Errorf("marked offset = %d, want 5", got)
+	}
+}
+
+func TestDeadLetterFailuresDoNotStallPartition(t *testing.T) {
+	es := newFakeElasticsearch(t)
+	consumer := newTestConsumer(t, testConfig(), es)
+
+	producer := mocks.NewSyncProducer(t, nil)
+	producer.ExpectSendMessageAndFail(sarama.ErrNotEnoughReplicas)
+	consumer.deadLetter = &DeadLetter{producer: producer, topic: "logharbour-logs-invalid"}
+
+	session := newFakeSession(context.Background())
+	claim := newFakeClaim("logharbour-logs", 0, 0)
+	done := runClaim(t, consumer, session, claim)
+	claim.send([]byte(`{"id": "broken"`))
+	claim.sendEntries(t, newEntryGenerator(7).entries(2))
+	close(claim.messages)
+	waitDone(t, done)
+	if err := producer.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	if got := es.docCount(); got != 2 {
+		t.Errorf("indexed %d documents, want 2", got)
+	}
+	if got := session.markedOffset(0); got != 3 {
+		t.Errorf("marked offset = %d, want 3", got)
+	}
+}
+
+func TestElasticsearchErrorsDoNotStallPartition(t *testing.T) {
+	es := newFakeElasticsearch(t)
+	consumer := newTestConsumer(t, testConfig(), es)
+	session := newFakeSession(context.Background())
+	claim := newFakeClaim("logharbour-logs", 0, 0)
+	gen := newEntryGenerator(4)
+
+	es.set(func(f *fakeElasticsearch) { f.bulkStatus = http.StatusServiceUnavailable })
+	done := runClaim(t, consumer, session, claim)
+	claim.sendEntries(t, gen.entries(10))
+	waitFor(t, "failed batch to be marked", func() bool { return session.markedOffset(0) == 10 })
+	if got := es.docCount(); got != 0 {
+		t.Fatalf("indexed %d documents during outage", got)
+	}
+
+	es.set(func(f *fakeElasticsearch) { f.bulkStatus = 0 })
+	claim.sendEntries(t, gen.entries(10))
+	waitFor(t, "recovery", func() bool { return es.docCount() == 10 && session.markedOffset(0) == 20 })
+
+	close(claim.messages)
+	waitDone(t, done)
+}
+
+func TestRejectedDocumentsArePartialFailures(t *testing.T) {
+	es := newFakeElasticsearch(t)
+	es.set(func(f *fakeElasticsearch) {
+		f.rejectDoc = func(id string) bool { return id == "log-00000003" }
+	})
+	consumer := newTestConsumer(t, testConfig(), es)
+	session := newFakeSession(context.Background())
+	claim := newFakeClaim("logharbour-logs", 0, 0)
+
+	done := runClaim(t, consumer, session, claim)
+	claim.sendEntries(t, newEntryGenerator(5).entries(10))
+	close(claim.messages)
+	waitDone(t, done)
+
+	if got := es.docCount(); got != 9 {
+		t.Errorf("indexed %d documents, want 9", got)
+	}
+	if _, ok := es.doc("log-00000003"); ok {
+		t.Error("rejected document was indexed")
+	}
+	if got := session.markedOffset(0); got != 10 {
+		t.Errorf("marked offset = %d, want 10", got)
+	}
+}
+
+func TestRebalanceRedeliveryIsDeduplicated(t *testing.T) {
+	es := newFakeElasticsearch(t)
+	consumer := newTestConsumer(t, testConfig(), es)
+	consumer.dedup = NewDeduplicator(1000, time.Minute, nil)
+
+	var written atomic.Int64
+	es.set(func(f *fakeElasticsearch) {
+		f.onBulk = func(ids []string) { written.Add(int64(len(ids))) }
+	})
+
+	entries := newEntryGenerator(6).entries(20)
+
+	// First session reads 15 messages, then the group rebalances
+	ctx, revoke := context.WithCancel(context.Background())
+	session := newFakeSession(ctx)
+	claim := newFakeClaim("logharbour-logs", 0, 0)
+	done := runClaim(t, consumer, session, claim)
+	claim.sendEntries(t, entries[:15])
+	waitFor(t, "first batch", func() bool { return es.docCount() == 10 })
+	revoke()
+	waitDone(t, done)
+
+	// Pending entries are flushed when the session ends
+	if got := session.markedOffset(0); got != 15 {
+		t.Fatalf("marked offset = %d, want 15", got)
+	}
+
+	// The offset commit was lost, so the next owner resumes from offset 10
+	session = newFakeSession(context.Background())
+	claim = newFakeClaim("logharbour-logs", 0, 10)
+	done = runClaim(t, consumer, session, claim)
+	claim.sendEntries(t, entries[10:])
+	close(claim.messages)
+	waitDone(t, done)
+
+	if got := es.docCount(); got != 20 {
+		t.Errorf("indexed %d documents, want 20", got)
+	}
+	if got := written.Load(); got != 20 {
+		t.Errorf("wrote %d documents, want 20 (redelivered entries were written again)", got)
+	}
+}
+
+func TestShutdownFlushesPendingBatch(t *testing.T) {
+	es := newFakeElasticsearch(t)
+	consumer := newTestConsumer(t, testConfig(), es)
+	ctx, cancel := context.WithCancel(context.Background())
+	session := newFakeSession(ctx)
+	claim := newFakeClaim("logharbour-logs", 0, 0)
+
+	if err := consumer.Setup(session); err != nil {
+		t.Fatal(err)
+	}
+	done := runClaim(t, consumer, session, claim)
+	claim.sendEntries(t, newEntryGenerator(7).entries(4))
+	waitFor(t, "messages to be read", func() bool { return len(claim.messages) == 0 })
+
+	cancel()
+	waitDone(t, done)
+	if err := consumer.Cleanup(session); err != nil {
+		t.Fatal(err)
+	}
+
+	if got := es.docCount(); got != 4 {
+		t.Errorf("indexed %d documents, want 4", got)
+	}
+	if got := session.markedOffset(0); got != 4 {
+		t.Errorf("marked offset = %d, want 4", got)
+	}
+	if session.commits != 1 {
+		t.Errorf("commits = %d, want 1", session.commits)
+	}
+	if consumer.health.kafkaSession.Load() {
+		t.Error("Kafka session still reported active after Cleanup")
+	}
+}
+
+func TestShutdownDeadlineAbortsIndexing(t *testing.T) {
+	es := newFakeElasticsearch(t)
+	es.set(func(f *fakeElasticsearch) { f.delay = time.Minute })
+	consumer := newTestConsumer(t, testConfig(), es)
+	indexCtx, abortIndexing := context.WithCancel(context.Background())
+	consumer.indexCtx = indexCtx
+
+	ctx, cancel := context.WithCancel(context.Background())
+	session := newFakeSession(ctx)
+	claim := newFakeClaim("logharbour-logs", 0, 0)
+	done := runClaim(t, consumer, session, claim)
+	claim.sendEntries(t, newEntryGenerator(8).entries(10))
+	waitFor(t, "bulk request to start", func() bool { return es.bulkCallCount() == 1 })
+
+	start := time.Now()
+	cancel()
+	abortIndexing()
+	waitDone(t, done)
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Errorf("shutdown took %s after the deadline", elapsed)
+	}
+	if got := es.docCount(); got != 0 {
+		t.Errorf("indexed %d documents, want 0", got)
+	}
+}
+
+func TestSinkOutageOnlyFailsReadiness(t *testing.T) {
+	health := NewHealth()
+	health.SetKafkaSession(true)
+	health.setSink("elasticsearch", "red", false, nil)
+
+	for _, tc := range []struct {
+		path    string
+		handler http.HandlerFunc
+		want    int
+	}{
+		{"/healthz", health.handleHealthz, http.StatusOK},
+		{"/readyz", health.handleReadyz, http.StatusServiceUnavailable},
+	} {
+		rec := httptest.NewRecorder()
+		tc.handler(rec, httptest.NewRequest(http.MethodGet, tc.path, nil))
+		if rec.Code != tc.want {
+			t.Errorf("%s = %d, want %d", tc.path, rec.Code, tc.want)
+		}
+	}
+}
+
+func TestUnwrittenBatchIsNotRecordedAsSeen(t *testing.T) {
+	es := newFakeElasticsearch(t)
+	consumer := newTestConsumer(t, testConfig(), es)
+	consumer.dedup = NewDeduplicator(1000, time.Minute, nil)
+	entries := newEntryGenerator(8).entries(10)
+
+	// The batch is read but Elasticsearch is down, so nothing is written
+	es.set(func(f *fakeElasticsearch) { f.bulkStatus = http.StatusServiceUnavailable })
+	session := newFakeSession(context.Background())
+	claim := newFakeClaim("logharbour-logs", 0, 0)
+	done := runClaim(t, consumer, session, claim)
+	claim.sendEntries(t, entries)
+	close(claim.messages)
+	waitDone(t, done)
+
+	// Its redelivery is written rather than dropped as a duplicate
+	es.set(func(f *fakeElasticsearch) { f.bulkStatus = 0 })
+	session = newFakeSession(context.Background())
+	claim = newFakeClaim("logharbour-logs", 0, 0)
+	done = runClaim(t, consumer, session, claim)
+	claim.sendEntries(t, entries)
+	close(claim.messages)
+	waitDone(t, done)
+
+	if got := es.docCount(); got != 10 {
+		t.Errorf("indexed %d documents, want 10", got)
+	}
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+
+	"github.com/IBM/sarama"
+)
+
+// DeadLetter routes rejected messages to a separate Kafka topic, unchanged,
+// with the reason in headers so they can be inspected and replayed
+type DeadLetter struct {
+	producer sarama.SyncProducer
+	topic    string
+}
+
+// NewDeadLetter creates a producer for topic
+func NewDeadLetter(brokers []string, topic string) (*DeadLetter, error) {
+	config := sarama.NewConfig()
+	config.Version = sarama.V2_6_0_0
+	config.Producer.Return.Successes = true
+	config.Producer.RequiredAcks = sarama.WaitForAll
+
+	producer, err := sarama.NewSyncProducer(brokers, config)
+	if err != nil {
+		return nil, fmt.Errorf("error creating dead letter producer: %w", err)
+	}
+	return &DeadLetter{producer: producer, topic: topic}, nil
+}
+
+// Send publishes the original message with the violation attached
+func (d *DeadLetter) Send(message *sarama.ConsumerMessage, violation *Violation) error {
+	_, _, err := d.producer.SendMessage(&sarama.ProducerMessage{
+		Topic: d.topic,
+		Key:   sarama.ByteEncoder(message.Key),
+		Value: sarama.ByteEncoder(message.Value),
+		Headers: []sarama.RecordHeader{
+			{Key: []byte("x-violation-reason"), Value: []byte(violation.Reason)},
+			{Key: []byte("x-violation-detail"), Value: []byte(violation.Detail)},
+			{Key: []byte("x-schema-version"), Value: []byte(violation.Version)},
+			{Key: []byte("x-source-topic"), Value: []byte(message.Topic)},
+			{Key: []byte("x-source-partition"), Value: []byte(strconv.Itoa(int(message.Partition)))},
+			{Key: []byte("x-source-offset"), Value: []byte(strconv.FormatInt(message.Offset, 10))},
+		},
+	})
+	if err != nil {
+		return fmt.Errorf("error sending to %s: %w", d.topic, err)
+	}
+	return nil
+}
+
+func (d *DeadLetter) Close() error {
+	return d.producer.Close()
+}
@@ -0,0 +1,217 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/hashicorp/golang-lru/v2/expirable"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// SeenStore is a persistent record of processed log IDs shared by all
+// consumer instances, so duplicates are caught even when a rebalance moves a
+// partition to another instance
+type SeenStore interface {
+	// Seen returns those of ids that were recorded within the window
+	Seen(ctx context.Context, ids []string) (map[string]bool, error)
+	// Record marks ids as seen now
+	Record(ctx context.Context, ids []string) error
+	Close() error
+}
+
+// Deduplicator drops log entries whose ID was already processed within a
+// time window. An in-memory LRU answers most lookups; the optional store
+// catches redeliveries that the local cache has not seen. Checking and
+// recording are separate steps: IDs are only recorded once the sinks have
+// written their entries, so a batch lost before then is not mistaken for a
+// duplicate when it is redelivered.
+type Deduplicator struct {
+	cache *expirable.LRU[string, struct{}]
+	store SeenStore // nil for memory-only deduplication
+}
+
+// NewDeduplicator creates a deduplicator remembering up to cacheSize IDs for window
+func NewDeduplicator(cacheSize int, window time.Duration, store SeenStore) *Deduplicator {
+	return &Deduplicator{
+		cache: expirable.NewLRU[string, struct{}](cacheSize, nil, window),
+		store: store,
+	}
+}
+
+// Check returns the entries of batch that have not been recorded before, in
+// order, without recording them. Entries without an ID are always kept. If
+// the store is unavailable, entries are kept: a duplicate is preferable to a
+// lost log.
+func (d *Deduplicator) Check(ctx context.Context, batch []LogEntry) []LogEntry {
+	inBatch := make(map[string]bool, len(batch))
+	keep := make([]bool, len(batch))
+	var candidates []string
+
+	for i, logEntry := range batch {
+		dedupChecked.Inc()
+		switch {
+		case logEntry.ID == "":
+			keep[i] = true
+		case inBatch[logEntry.ID]:
+			duplicates.WithLabelValues("batch").Inc()
+		case d.cache.Contains(logEntry.ID):
+			duplicates.WithLabelValues("memory").Inc()
+		default:
+			keep[i] = true
+			candidates = append(candidates, logEntry.ID)
+		}
+		if logEntry.ID != "" {
+			inBatch[logEntry.ID] = true
+		}
+	}
+
+	if d.store != nil && len(candidates) > 0 {
+		seen, err := d.store.Seen(ctx, candidates)
+		if err != nil {
+			log.Printf("Error checking dedup store, keeping batch: %v", err)
+			dedupStoreErrors.Inc()
+		} else {
+			for i, logEntry := range batch {
+				if keep[i] && logEntry.ID != "" && seen[logEntry.ID] {
+					keep[i] = false
+					duplicates.WithLabelValues("store").Inc()
+				}
+			}
+		}
+	}
+
+	out := make([]LogEntry, 0, len(batch))
+	for i, logEntry := range batch {
+		if keep[i] {
+			out = append(out, logEntry)
+		}
+	}
+	return out
+}
+
+// Record marks the IDs of entries as processed. Call it once the sinks have
+// accepted the entries. A store failure is logged: the entries may then be
+// written again if they are redelivered.
+func (d *Deduplicator) Record(ctx context.Context, entries []LogEntry) {
+	ids := make([]string, 0, len(entries))
+	for _, logEntry := range entries {
+		if logEntry.ID != "" {
+			d.cache.Add(logEntry.ID, struct{}{})
+			ids = append(ids, logEntry.ID)
+		}
+	}
+	if d.store == nil || len(ids) == 0 {
+		return
+	}
+	if err := d.store.Record(ctx, ids); err != nil {
+		log.Printf("Error recording seen logs: %v", err)
+		dedupStoreErrors.Inc()
+	}
+}
+
+// Close releases the persistent store
+func (d *Deduplicator) Close() error {
+	if d.store == nil {
+		return nil
+	}
+	return d.store.Close()
+}
+
+const seenLogsSchema = `
+CREATE TABLE IF NOT EXISTS consumer_seen_logs (
+    log_id  VARCHAR(255) PRIMARY KEY,
+    seen_at TIMESTAMP WITH TIME ZONE NOT NULL
+);
+CREATE INDEX IF NOT EXISTS consumer_seen_logs_seen_at_idx ON consumer_seen_logs (seen_at);
+`
+
+// selectSeen returns the IDs last seen within the window
+const selectSeen = `
+SELECT log_id FROM consumer_seen_logs
+WHERE log_id = ANY($1::text[]) AND seen_at >= now() - $2::interval`
+
+// recordSeen inserts new IDs and refreshes the sighting of known ones
+const recordSeen = `
+INSERT INTO consumer_seen_logs (log_id, seen_at)
+SELECT unnest($1::text[]), now()
+ON CONFLICT (log_id) DO UPDATE SET seen_at = EXCLUDED.seen_at`
+
+const pruneSeen = `DELETE FROM consumer_seen_logs WHERE seen_at < now() - $1::interval`
+
+// PostgresSeenStore keeps seen IDs in a Postgres table pruned to the window
+type PostgresSeenStore struct {
+	pool   *pgxpool.Pool
+	window time.Duration
+
+	mu         sync.Mutex
+	lastPruned time.Time
+}
+
+// NewPostgresSeenStore connects to Postgres and ensures the table exists
+func NewPostgresSeenStore(ctx context.Context, databaseURL string, window time.Duration) (*PostgresSeenStore, error) {
+	pool, err := pgxpool.New(ctx, databaseURL)
+	if err != nil {
+		return nil, fmt.Errorf("error creating dedup pool: %w", err)
+	}
+	if err := pool.Ping(ctx); err != nil {
+		pool.Close()
+		return nil, fmt.Errorf("error connecting to dedup database: %w", err)
+	}
+	if _, err := pool.Exec(ctx, seenLogsSchema); err != nil {
+		pool.Close()
+		return nil, fmt.Errorf("error creating dedup table: %w", err)
+	}
+	return &PostgresSeenStore{pool: pool, window: window, lastPruned: time.Now()}, nil
+}
+
+func (s *PostgresSeenStore) Seen(ctx context.Context, ids []string) (map[string]bool, error) {
+	rows, err := s.pool.Query(ctx, selectSeen, ids, s.window.String())
+	if err != nil {
+		return nil, fmt.Errorf("error checking seen logs: %w", err)
+	}
+	defer rows.Close()
+
+	seen := make(map[string]bool, len(ids))
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			return nil, fmt.Errorf("error reading seen logs: %w", err)
+		}
+		seen[id] = true
+	}
+	return seen, rows.Err()
+}
+
+func (s *PostgresSeenStore) Record(ctx context.Context, ids []string) error {
+	s.pruneIfDue(ctx)
+
+	if _, err := s.pool.Exec(ctx, recordSeen, ids); err != nil {
+		return fmt.Errorf("error recording seen logs: %w", err)
+	}
+	return nil
+}
+
+// pruneIfDue deletes expired IDs at most once per window
+func (s *PostgresSeenStore) pruneIfDue(ctx context.Context) {
+	s.mu.Lock()
+	due := time.Since(s.lastPruned) >= s.window
+	if due {
+		s.lastPruned = time.Now()
+	}
+	s.mu.Unlock()
+	if !due {
+		return
+	}
+
+	if _, err := s.pool.Exec(ctx, pruneSeen, s.window.String()); err != nil {
+		log.Printf("Error pruning dedup table: %v", err)
+	}
+}
+
+func (s *PostgresSeenStore) Close() error {
+	s.pool.Close()
+	return nil
+}
@@ -0,0 +1,276 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/elastic/go-elasticsearch/v8"
+	"github.com/elastic/go-elasticsearch/v8/esapi"
+)
+
+// indexName determines the index for a log entry based on log type and the
+// UTC date it was logged, so that replayed and restored entries go back to
+// the index that holds their originals. Entries without a parseable
+// timestamp go to today's index.
+func indexName(logEntry LogEntry) string {
+	when, err := time.Parse(time.RFC3339Nano, logEntry.When)
+	if err != nil {
+		when = time.Now()
+	}
+	return fmt.Sprintf("logharbour-%s-%s",
+		strings.ToLower(logEntry.Type),
+		when.UTC().Format("2006.01.02"))
+}
+
+// indexTemplate is shared by the Elasticsearch and OpenSearch sinks
+const indexTemplate = `{
+	"index_patterns": ["logharbour-*"],
+	"template": {
+		"settings": {
+			"number_of_shards": 1,
+			"number_of_replicas": 0
+		},
+		"mappings": {
+			"properties": {
+				"id": { "type": "keyword" },
+				"app": { "type": "keyword" },
+				"tenant": { "type": "keyword" },
+				"system": { "type": "keyword" },
+				"module": { "type": "keyword" },
+				"type": { "type": "keyword" },
+				"pri": { "type": "keyword" },
+				"when": { "type": "date" },
+				"who": { "type": "keyword" },
+				"instance": { "type": "keyword" },
+				"remote_ip": { "type": "ip" },
+				"trace_id": { "type": "keyword" },
+				"msg": { "type": "text" },
+				"enrich": {
+					"properties": {
+						"environment": { "type": "keyword" },
+						"cluster": { "type": "keyword" },
+						"user_agent": {
+							"properties": {
+								"original": { "type": "keyword", "ignore_above": 1024 },
+								"browser": { "type": "keyword" },
+								"browser_version": { "type": "keyword" },
+								"os": { "type": "keyword" },
+								"os_version": { "type": "keyword" },
+								"device": { "type": "keyword" }
+							}
+						},
+						"geo": {
+							"properties": {
+								"country_iso_code": { "type": "keyword" },
+								"country_name": { "type": "keyword" },
+								"region": { "type": "keyword" },
+								"city": { "type": "keyword" },
+								"location": { "type": "geo_point" }
+							}
+						}
+					}
+				},
+				"data": {
+					"type": "object",
+					"properties": {
+						"change_data": {
+							"properties": {
+								"entity": { "type": "keyword" },
+								"op": { "type": "keyword" },
+								"changes": {
+									"properties": {
+										"field": { "type": "keyword" },
+										"old_value": { "type": "keyword" },
+										"new_value": { "type": "keyword" }
+									}
+								}
+							}
+						}
+					}
+				}
+			}
+		}
+	}
+}`
+
+// bulkBody encodes entries as an NDJSON _bulk request body. Entries go to
+// index if it is set, otherwise to their daily index.
+func bulkBody(entries []LogEntry, index string) (*bytes.Buffer, error) {
+	var buf bytes.Buffer
+	for _, logEntry := range entries {
+		target := index
+		if target == "" {
+			target = indexName(logEntry)
+		}
+		meta, err := json.Marshal(map[string]any{
+			"index": map[string]string{"_index": target, "_id": logEntry.ID},
+		})
+		if err != nil {
+			return nil, fmt.Errorf("error marshaling bulk metadata: %w", err)
+		}
+		data, err := json.Marshal(logEntry)
+		if err != nil {
+			return nil, fmt.Errorf("error marshaling log entry: %w", err)
+		}
+		buf.Write(meta)
+		buf.WriteByte('\n')
+		buf.Write(data)
+		buf.WriteByte('\n')
+	}
+	return &buf, nil
+}
+
+type bulkResponse struct {
+	Errors bool `json:"errors"`
+	Items  []map[string]struct {
+		Status int `json:"status"`
+		Error  struct {
+			Type   string `json:"type"`
+			Reason string `json:"reason"`
+		} `json:"error"`
+	} `json:"items"`
+}
+
+// checkBulkResponse decodes a _bulk response and reports rejected documents
+// as a *PartialWriteError
+func checkBulkResponse(body io.Reader, total int) error {
+	var res bulkResponse
+	if err := json.NewDecoder(body).Decode(&res); err != nil {
+		return fmt.Errorf("error decoding bulk response: %w", err)
+	}
+	if !res.Errors {
+		return nil
+	}
+
+	failed := 0
+	for _, item := range res.Items {
+		for _, result := range item {
+			if result.Status >= 300 {
+				failed++
+				log.Printf("Error indexing document: %s: %s", result.Error.Type, result.Error.Reason)
+			}
+		}
+	}
+	if failed == 0 {
+		return nil
+	}
+	return &PartialWriteError{Failed: failed, Total: total}
+}
+
+// clusterHealthy maps a _cluster/health status to sink health
+func clusterHealthy(status string) bool {
+	return status == "green" || status == "yellow"
+}
+
+// ElasticsearchSink bulk-indexes entries into daily logharbour-* indices,
+// or into a single fixed index when one is given
+type ElasticsearchSink struct {
+	es        *elasticsearch.Client
+	transport *http.Transport
+	index     string
+}
+
+// NewElasticsearchSink connects to Elasticsearch and installs the index
+// template. index may be empty to use the daily indices.
+func NewElasticsearchSink(url, index string) (*ElasticsearchSink, error) {
+	// The transport is kept so its idle connections can be closed on shutdown
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	es, err := elasticsearch.NewClient(elasticsearch.Config{
+		Addresses: []string{url},
+		Transport: transport,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error creating Elasticsearch client: %w", err)
+	}
+
+	// Test Elasticsearch connection
+	res, err := es.Info()
+	if err != nil {
+		return nil, fmt.Errorf("error getting Elasticsearch info: %w", err)
+	}
+	res.Body.Close()
+	log.Println("Elasticsearch connected successfully")
+
+	// Create index template for logs
+	createIndexTemplate(es)
+
+	return &ElasticsearchSink{es: es, transport: transport, index: index}, nil
+}
+
+func (s *ElasticsearchSink) Name() string { return "elasticsearch" }
+
+// Write sends entries in a single _bulk request
+func (s *ElasticsearchSink) Write(ctx context.Context, entries []LogEntry) error {
+	body, err := bulkBody(entries, s.index)
+	if err != nil {
+		return err
+	}
+
+	req := esapi.BulkRequest{
+		Body:    body,
+		Refresh: "false",
+	}
+	res, err := req.Do(ctx, s.es)
+	if err != nil {
+		return fmt.Errorf("error indexing documents: %w", err)
+	}
+	defer res.Body.Close()
+
+	if res.IsError() {
+		return fmt.Errorf("error indexing documents: %s", res.String())
+	}
+	return checkBulkResponse(res.Body, len(entries))
+}
+
+// CheckHealth reports the cluster health status
+func (s *ElasticsearchSink) CheckHealth(ctx context.Context) (string, bool, error) {
+	res, err := s.es.Cluster.Health(s.es.Cluster.Health.WithContext(ctx))
+	if err != nil {
+		return "unreachable", false, err
+	}
+	defer res.Body.Close()
+	if res.IsError() {
+		return "unreachable", false, fmt.Errorf("cluster health: %s", res.Status())
+	}
+
+	var body struct {
+		Status string `json:"status"`
+	}
+	if err := json.NewDecoder(res.Body).Decode(&body); err != nil {
+		return "unreachable", false, fmt.Errorf("error decoding cluster health: %w", err)
+	}
+	return body.Status, clusterHealthy(body.Status), nil
+}
+
+func (s *ElasticsearchSink) Close() error {
+	s.transport.CloseIdleConnections()
+	return nil
+}
+
+func createIndexTemplate(es *elasticsearch.Client) {
+	// Create an index template for LogHarbour logs
+	req := esapi.IndicesPutIndexTemplateRequest{
+		Name: "logharbour-template",
+		Body: strings.NewReader(indexTemplate),
+	}
+
+	res, err := req.Do(context.Background(), es)
+	if err != nil {
+		log.Printf("Error creating index template: %s", err)
+		return
+	}
+	defer res.Body.Close()
+
+	if res.IsError() {
+		log.Printf("Error creating index template: %s", res.String())
+	} else {
+		log.Println("Index template created successfully")
+	}
+}
@@ -0,0 +1,207 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"strings"
+
+	"github.com/mssola/useragent"
+	"github.com/oschwald/maxminddb-golang"
+)
+
+// Enrichment holds fields derived by the consumer. It is stored under
+// "enrich" so derived data never collides with what the producer sent.
+type Enrichment struct {
+	Environment string            `json:"environment,omitempty"`
+	Cluster     string            `json:"cluster,omitempty"`
+	Tags        map[string]string `json:"tags,omitempty"`
+	UserAgent   *UserAgentInfo    `json:"user_agent,omitempty"`
+	Geo         *GeoInfo          `json:"geo,omitempty"`
+}
+
+// UserAgentInfo is a parsed User-Agent header
+type UserAgentInfo struct {
+	Original       string `json:"original"`
+	Browser        string `json:"browser,omitempty"`
+	BrowserVersion string `json:"browser_version,omitempty"`
+	OS             string `json:"os,omitempty"`
+	OSVersion      string `json:"os_version,omitempty"`
+	Device         string `json:"device"` // desktop, mobile or bot
+}
+
+// GeoInfo is the location of remote_ip
+type GeoInfo struct {
+	CountryISOCode string       `json:"country_iso_code,omitempty"`
+	CountryName    string       `json:"country_name,omitempty"`
+	Region         string       `json:"region,omitempty"`
+	City           string       `json:"city,omitempty"`
+	Location       *GeoLocation `json:"location,omitempty"`
+}
+
+// GeoLocation is encoded as an Elasticsearch geo_point
+type GeoLocation struct {
+	Lat float64 `json:"lat"`
+	Lon float64 `json:"lon"`
+}
+
+// geoRecord is the subset of a MaxMind City (or Country) record we use
+type geoRecord struct {
+	Country struct {
+		ISOCode string            `maxminddb:"iso_code"`
+		Names   map[string]string `maxminddb:"names"`
+	} `maxminddb:"country"`
+	Subdivisions []struct {
+		Names map[string]string `maxminddb:"names"`
+	} `maxminddb:"subdivisions"`
+	City struct {
+		Names map[string]string `maxminddb:"names"`
+	} `maxminddb:"city"`
+	Location struct {
+		Latitude  *float64 `maxminddb:"latitude"`
+		Longitude *float64 `maxminddb:"longitude"`
+	} `maxminddb:"location"`
+}
+
+// Enricher attaches deployment tags, parsed user agents and IP geolocation
+// to log entries
+type Enricher struct {
+	environment string
+	cluster     string
+	tags        map[string]string
+	uaPaths     [][]pathSegment
+	geo         *maxminddb.Reader // nil when no GeoIP database is configured
+}
+
+// NewEnricher opens the GeoIP database, if any, and compiles the user agent paths
+func NewEnricher(cfg Config) (*Enricher, error) {
+	e := &Enricher{
+		environment: cfg.EnrichEnvironment,
+		cluster:     cfg.EnrichCluster,
+	}
+
+	for _, pair := range strings.Split(cfg.EnrichTags, ",") {
+		if pair = strings.TrimSpace(pair); pair == "" {
+			continue
+		}
+		k, v, ok := strings.Cut(pair, "=")
+		if !ok || k == "" {
+			return nil, fmt.Errorf("invalid tag %q, expected key=value", pair)
+		}
+		if e.tags == nil {
+			e.tags = make(map[string]string)
+		}
+		e.tags[k] = v
+	}
+
+	for _, path := range strings.Split(cfg.UserAgentPaths, ",") {
+		if path = strings.TrimSpace(path); path == "" {
+			continue
+		}
+		segs, err := parsePath(path)
+		if err != nil {
+			return nil, err
+		}
+		e.uaPaths = append(e.uaPaths, segs)
+	}
+
+	if cfg.GeoIPDatabase != "" {
+		geo, err := maxminddb.Open(cfg.GeoIPDatabase)
+		if err != nil {
+			return nil, fmt.Errorf("error opening GeoIP database: %w", err)
+		}
+		e.geo = geo
+	}
+	return e, nil
+}
+
+// Enrich sets logEntry.Enrich, replacing anything the producer sent there.
+// Lookups that find nothing leave their field empty.
+func (e *Enricher) Enrich(logEntry *LogEntry) {
+	logEntry.Enrich = nil
+	enrichment := &Enrichment{
+		Environment: e.environment,
+		Cluster:     e.cluster,
+		Tags:        e.tags,
+		UserAgent:   e.userAgent(logEntry),
+		Geo:         e.geolocate(logEntry.RemoteIP),
+	}
+	if enrichment.Environment == "" && enrichment.Cluster == "" && len(enrichment.Tags) == 0 &&
+		enrichment.UserAgent == nil && enrichment.Geo == nil {
+		return
+	}
+	logEntry.Enrich = enrichment
+}
+
+// userAgent parses the first user agent string found at the configured paths
+func (e *Enricher) userAgent(logEntry *LogEntry) *UserAgentInfo {
+	for _, segs := range e.uaPaths {
+		for _, v := range lookupPath(logEntry.Data, segs) {
+			s, ok := v.(string)
+			if !ok || s == "" {
+				continue
+			}
+			ua := useragent.New(s)
+			info := &UserAgentInfo{Original: s, Device: "desktop"}
+			info.Browser, info.BrowserVersion = ua.Browser()
+			osInfo := ua.OSInfo()
+			info.OS, info.OSVersion = osInfo.Name, osInfo.Version
+			switch {
+			case ua.Bot():
+				info.Device = "bot"
+			case ua.Mobile():
+				info.Device = "mobile"
+			}
+			enrichments.WithLabelValues("user_agent", "ok").Inc()
+			return info
+		}
+	}
+	return nil
+}
+
+// geolocate looks ip up in the GeoIP database
+func (e *Enricher) geolocate(ip string) *GeoInfo {
+	if e.geo == nil || ip == "" {
+		return nil
+	}
+	addr := net.ParseIP(ip)
+	if addr == nil {
+		enrichments.WithLabelValues("geo", "invalid").Inc()
+		return nil
+	}
+	if addr.IsPrivate() || addr.IsLoopback() || addr.IsUnspecified() {
+		enrichments.WithLabelValues("geo", "private").Inc()
+		return nil
+	}
+
+	var record geoRecord
+	if err := e.geo.Lookup(addr, &record); err != nil {
+		enrichments.WithLabelValues("geo", "error").Inc()
+		return nil
+	}
+	if record.Country.ISOCode == "" && record.Location.Latitude == nil {
+		enrichments.WithLabelValues("geo", "miss").Inc()
+		return nil
+	}
+
+	info := &GeoInfo{
+		CountryISOCode: record.Country.ISOCode,
+		CountryName:    record.Country.Names["en"],
+		City:           record.City.Names["en"],
+	}
+	if len(record.Subdivisions) > 0 {
+		info.Region = record.Subdivisions[0].Names["en"]
+	}
+	if record.Location.Latitude != nil && record.Location.Longitude != nil {
+		info.Location = &GeoLocation{Lat: *record.Location.Latitude, Lon: *record.Location.Longitude}
+	}
+	enrichments.WithLabelValues("geo", "ok").Inc()
+	return info
+}
+
+// Close releases the GeoIP database
+func (e *Enricher) Close() error {
+	if e.geo == nil {
+		return nil
+	}
+	return e.geo.Close()
+}
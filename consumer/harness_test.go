@@ -0,0 +1,431 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math/rand"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/IBM/sarama"
+)
+
+// fakeElasticsearch is an httptest stand-in for the Elasticsearch endpoints
+// the consumer uses. It records every bulk-indexed document.
+type fakeElasticsearch struct {
+	*httptest.Server
+
+	mu         sync.Mutex
+	docs       map[string]json.RawMessage // _id -> document
+	indices    map[string]int             // _index -> documents
+	bulkCalls  int
+	bulkStatus int                  // HTTP status for _bulk; 0 for 200
+	rejectDoc  func(id string) bool // documents to reject with a per-item error
+	delay      time.Duration        // added to every _bulk response
+	onBulk     func(ids []string)   // called with the IDs of each bulk request
+}
+
+func newFakeElasticsearch(t testing.TB) *fakeElasticsearch {
+	f := &fakeElasticsearch{
+		docs:    make(map[string]json.RawMessage),
+		indices: make(map[string]int),
+	}
+	f.Server = httptest.NewServer(http.HandlerFunc(f.serve))
+	t.Cleanup(f.Close)
+	return f
+}
+
+func (f *fakeElasticsearch) serve(w http.ResponseWriter, r *http.Request) {
+	// The client refuses responses without the product header
+	w.Header().Set("X-Elastic-Product", "Elasticsearch")
+	w.Header().Set("Content-Type", "application/json")
+
+	switch {
+	case r.URL.Path == "/" && r.Method == http.MethodGet:
+		fmt.Fprint(w, `{"name":"fake","cluster_name":"test","version":{"number":"8.11.0"},"tagline":"You Know, for Search"}`)
+	case strings.HasPrefix(r.URL.Path, "/_index_template/"):
+		io.Copy(io.Discard, r.Body)
+		fmt.Fprint(w, `{"acknowledged":true}`)
+	case r.URL.Path == "/_cluster/health":
+		fmt.Fprint(w, `{"status":"green"}`)
+	case r.URL.Path == "/_bulk":
+		f.bulk(w, r)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func (f *fakeElasticsearch) bulk(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	delay, status, reject, onBulk := f.delay, f.bulkStatus, f.rejectDoc, f.onBulk
+	f.bulkCalls++
+	f.mu.Unlock()
+
+	// The server only notices a client disconnect once the body is read
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		return
+	}
+	if delay > 0 {
+		select {
+		case <-time.After(delay):
+		case <-r.Context().Done():
+			return
+		}
+	}
+	if status != 0 && status != http.StatusOK {
+		w.WriteHeader(status)
+		fmt.Fprint(w, `{"error":{"type":"fake_error","reason":"injected failure"}}`)
+		return
+	}
+
+	type item struct {
+		Status int `json:"status"`
+		Error  any `json:"error,omitempty"`
+	}
+	var items []map[string]item
+	var ids []string
+	hasErrors := false
+
+	scanner := bufio.NewScanner(bytes.NewReader(body))
+	scanner.Buffer(make([]byte, 1<<20), 1<<24)
+	for scanner.Scan() {
+		var meta struct {
+			Index struct {
+				Index string `json:"_index"`
+				ID    string `json:"_id"`
+			} `json:"index"`
+		}
+		if err := json.Unmarshal(scanner.Bytes(), &meta); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		if !scanner.Scan() {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		doc := append(json.RawMessage(nil), scanner.Bytes()...)
+		id := meta.Index.ID
+		ids = append(ids, id)
+
+		if reject != nil && reject(id) {
+			hasErrors = true
+			items = append(items, map[string]item{"index": {Status: 400, Error: map[string]string{"type": "mapper_parsing_exception", "reason": "injected"}}})
+			continue
+		}
+		f.mu.Lock()
+		f.docs[id] = doc
+		f.indices[meta.Index.Index]++
+		f.mu.Unlock()
+		items = append(items, map[string]item{"index": {Status: 201}})
+	}
+
+	if onBulk != nil {
+		onBulk(ids)
+	}
+	json.NewEncoder(w).Encode(map[string]any{"took": 1, "errors": hasErrors, "items": items})
+}
+
+func (f *fakeElasticsearch) docCount() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return len(f.docs)
+}
+
+func (f *fakeElasticsearch) bulkCallCount() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.bulkCalls
+}
+
+func (f *fakeElasticsearch) doc(id string) (LogEntry, bool) {
+	f.mu.Lock()
+	raw, ok := f.docs[id]
+	f.mu.Unlock()
+	var logEntry LogEntry
+	if ok {
+		json.Unmarshal(raw, &logEntry)
+	}
+	return logEntry, ok
+}
+
+func (f *fakeElasticsearch) set(fn func(f *fakeElasticsearch)) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	fn(f)
+}
+
+// fakeSession is a sarama.ConsumerGroupSession that records marked offsets.
+// Kafka is otherwise mocked with sarama/mocks (the replay consumer and the
+// dead letter producer), but mocks has no consumer group, session or claim,
+// so these two are hand-written. They let a test drive ConsumeClaim directly,
+// revoke a session and redeliver from an offset.
+type fakeSession struct {
+	ctx context.Context
+
+	mu      sync.Mutex
+	marked  map[int32]int64 // partition -> next offset to consume
+	commits int
+}
+
+func newFakeSession(ctx context.Context) *fakeSession {
+	return &fakeSession{ctx: ctx, marked: make(map[int32]int64)}
+}
+
+func (s *fakeSession) Claims() map[string][]int32 { return nil }
+func (s *fakeSession) MemberID() string           { return "test-member" }
+func (s *fakeSession) GenerationID() int32        { return 1 }
+func (s *fakeSession) Context() context.Context   { return s.ctx }
+
+func (s *fakeSession) MarkOffset(topic string, partition int32, offset int64, metadata string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if offset > s.marked[partition] {
+		s.marked[partition] = offset
+	}
+}
+
+func (s *fakeSession) MarkMessage(msg *sarama.ConsumerMessage, metadata string) {
+	s.MarkOffset(msg.Topic, msg.Partition, msg.Offset+1, metadata)
+}
+
+func (s *fakeSession) ResetOffset(topic string, partition int32, offset int64, metadata string) {}
+
+func (s *fakeSession) Commit() {
+	s.mu.Lock()
+	s.commits++
+	s.mu.Unlock()
+}
+
+func (s *fakeSession) markedOffset(partition int32) int64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.marked[partition]
+}
+
+// fakeClaim is a sarama.ConsumerGroupClaim fed from a channel
+type fakeClaim struct {
+	topic     string
+	partition int32
+	messages  chan *sarama.ConsumerMessage
+	next      atomic.Int64
+}
+
+func newFakeClaim(topic string, partition int32, offset int64) *fakeClaim {
+	c := &fakeClaim{topic: topic, partition: partition, messages: make(chan *sarama.ConsumerMessage, 1024)}
+	c.next.Store(offset)
+	return c
+}
+
+func (c *fakeClaim) Topic() string                            { return c.topic }
+func (c *fakeClaim) Partition() int32                         { return c.partition }
+func (c *fakeClaim) InitialOffset() int64                     { return 0 }
+func (c *fakeClaim) HighWaterMarkOffset() int64               { return c.next.Load() }
+func (c *fakeClaim) Messages() <-chan *sarama.ConsumerMessage { return c.messages }
+
+// send queues value as the next message of the claim
+func (c *fakeClaim) send(value []byte) *sarama.ConsumerMessage {
+	msg := &sarama.ConsumerMessage{
+		Topic:     c.topic,
+		Partition: c.partition,
+		Offset:    c.next.Add(1) - 1,
+		Value:     value,
+		Timestamp: time.Now(),
+	}
+	c.messages <- msg
+	return msg
+}
+
+func (c *fakeClaim) sendEntries(t testing.TB, entries []LogEntry) {
+	for _, logEntry := range entries {
+		c.send(mustJSON(t, logEntry))
+	}
+}
+
+func mustJSON(t testing.TB, v any) []byte {
+	data, err := json.Marshal(v)
+	if err != nil {
+		t.Fatalf("marshal: %v", err)
+	}
+	return data
+}
+
+// testConfig is a consumer configuration suited to tests: small batches and
+// a long flush interval, so flushes happen when a test expects them
+func testConfig() Config {
+	return Config{
+		Topic:            "logharbour-logs",
+		BatchSize:        10,
+		FlushInterval:    time.Hour,
+		Workers:          4,
+		SchemaValidation: true,
+		DedupWindow:      time.Minute,
+		DedupCacheSize:   1000,
+		ShutdownTimeout:  5 * time.Second,
+	}
+}
+
+// newTestConsumer builds a Consumer writing to a fake Elasticsearch, with
+// schema validation enabled as in production
+func newTestConsumer(t testing.TB, cfg Config, es *fakeElasticsearch) *Consumer {
+	sink, err := NewElasticsearchSink(es.URL, "")
+	if err != nil {
+		t.Fatalf("NewElasticsearchSink: %v", err)
+	}
+	t.Cleanup(func() { sink.Close() })
+
+	consumer := &Consumer{
+		sinks:    []Sink{sink},
+		cfg:      cfg,
+		health:   NewHealth(),
+		indexCtx: context.Background(),
+	}
+	if cfg.SchemaValidation {
+		if consumer.validator, err = NewValidator(); err != nil {
+			t.Fatalf("NewValidator: %v", err)
+		}
+	}
+	return consumer
+}
+
+// runClaim runs ConsumeClaim in the background and returns a channel that
+// is closed when it returns
+func runClaim(t testing.TB, consumer *Consumer, session *fakeSession, claim *fakeClaim) <-chan struct{} {
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		if err := consumer.ConsumeClaim(session, claim); err != nil {
+			t.Errorf("ConsumeClaim: %v", err)
+		}
+	}()
+	return done
+}
+
+func waitFor(t testing.TB, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func waitDone(t testing.TB, done <-chan struct{}) {
+	t.Helper()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("ConsumeClaim did not return")
+	}
+}
+
+// entryGenerator produces a realistic stream of user service log entries:
+// mostly debug and info activity logs, some data changes, occasional errors,
+// grouped into request traces
+type entryGenerator struct {
+	rng   *rand.Rand
+	seq   int
+	start time.Time
+}
+
+func newEntryGenerator(seed int64) *entryGenerator {
+	return &entryGenerator{rng: rand.New(rand.NewSource(seed)), start: time.Date(2024, 6, 22, 10, 0, 0, 0, time.UTC)}
+}
+
+var (
+	genModules  = []string{"userservice", "http", "pg", "auth"}
+	genMessages = []string{"Fetching user", "User retrieved", "Validating request", "Database error", "HTTP Request", "Cache miss"}
+	genAgents   = []string{
+		"Mozilla/5.0 (X11; Linux x86_64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/120.0.0.0 Safari/537.36",
+		"curl/8.4.0",
+		"PostmanRuntime/7.36.0",
+	}
+)
+
+func (g *entryGenerator) next() LogEntry {
+	g.seq++
+	traceID := fmt.Sprintf("trace-%06d", g.seq/5)
+	logEntry := LogEntry{
+		ID:       fmt.Sprintf("log-%08d", g.seq),
+		App:      "usersvc",
+		System:   "demo",
+		Module:   genModules[g.rng.Intn(len(genModules))],
+		When:     g.start.Add(time.Duration(g.seq) * time.Millisecond).Format(time.RFC3339Nano),
+		Who:      fmt.Sprintf("user%d", g.rng.Intn(50)),
+		RemoteIP: fmt.Sprintf("203.0.113.%d", g.rng.Intn(254)+1),
+		TraceID:  traceID,
+	}
+
+	switch n := g.rng.Intn(100); {
+	case n < 10:
+		logEntry.Type = "C"
+		logEntry.Priority = "Info"
+		logEntry.Instance = fmt.Sprint(g.rng.Intn(1000))
+		logEntry.Msg = "User updated"
+		logEntry.Data = map[string]interface{}{
+			"change_data": map[string]interface{}{
+				"entity": "User",
+				"op":     "update",
+				"changes": []interface{}{
+					map[string]interface{}{"field": "name", "old_value": "Jane", "new_value": "Jane Doe"},
+					map[string]interface{}{"field": "email", "old_value": "jane@example.com", "new_value": "jane.doe@example.com"},
+				},
+			},
+		}
+	case n < 15:
+		logEntry.Type = "A"
+		logEntry.Priority = "Err"
+		logEntry.Msg = "Database error"
+	case n < 60:
+		logEntry.Type = "D"
+		logEntry.Priority = []string{"Debug2", "Debug1", "Debug0"}[g.rng.Intn(3)]
+		logEntry.Msg = genMessages[g.rng.Intn(len(genMessages))]
+		logEntry.Data = map[string]interface{}{"step": g.rng.Intn(10), "cache": g.rng.Intn(2) == 0}
+	default:
+		logEntry.Type = "A"
+		logEntry.Priority = "Info"
+		logEntry.Msg = "HTTP Request"
+		logEntry.Data = map[string]interface{}{
+			"method":      "POST",
+			"path":        "/user_get",
+			"status":      200,
+			"duration_ms": g.rng.Intn(200),
+			"user_agent":  genAgents[g.rng.Intn(len(genAgents))],
+		}
+	}
+	return logEntry
+}
+
+func (g *entryGenerator) entries(n int) []LogEntry {
+	out := make([]LogEntry, n)
+	for i := range out {
+		out[i] = g.next()
+	}
+	return out
+}
+
+// messages encodes n generated entries as consecutive messages of
+// partition 0, starting at offset 0
+func (g *entryGenerator) messages(t testing.TB, n int) []*sarama.ConsumerMessage {
+	out := make([]*sarama.ConsumerMessage, n)
+	for i := range out {
+		out[i] = &sarama.ConsumerMessage{
+			Topic:     "logharbour-logs",
+			Offset:    int64(i),
+			Value:     mustJSON(t, g.next()),
+			Timestamp: g.start,
+		}
+	}
+	return out
+}
@@ -0,0 +1,163 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// Health tracks the state of the consumer's dependencies for /healthz and /readyz
+type Health struct {
+	kafkaSession atomic.Bool
+
+	mu    sync.RWMutex
+	sinks map[string]sinkStatus
+}
+
+type sinkStatus struct {
+	Status    string `json:"status"`
+	Healthy   bool   `json:"healthy"`
+	Error     string `json:"error,omitempty"`
+	CheckedAt string `json:"checked_at,omitempty"`
+}
+
+func NewHealth() *Health {
+	return &Health{sinks: make(map[string]sinkStatus)}
+}
+
+// SetKafkaSession records whether a consumer group session is currently active
+func (h *Health) SetKafkaSession(active bool) {
+	h.kafkaSession.Store(active)
+	if active {
+		kafkaSessionActive.Set(1)
+	} else {
+		kafkaSessionActive.Set(0)
+	}
+}
+
+func (h *Health) setSink(name, status string, healthy bool, err error) {
+	st := sinkStatus{
+		Status:    status,
+		Healthy:   healthy,
+		CheckedAt: time.Now().UTC().Format(time.RFC3339),
+	}
+	if err != nil {
+		st.Error = err.Error()
+	}
+
+	h.mu.Lock()
+	h.sinks[name] = st
+	h.mu.Unlock()
+
+	if healthy {
+		sinkUp.WithLabelValues(name).Set(1)
+	} else {
+		sinkUp.WithLabelValues(name).Set(0)
+	}
+}
+
+// WatchSinks polls the health of every sink that implements HealthChecker
+// until ctx is cancelled
+func (h *Health) WatchSinks(ctx context.Context, sinks []Sink, interval time.Duration) {
+	var checkers []Sink
+	for _, sink := range sinks {
+		if _, ok := sink.(HealthChecker); ok {
+			checkers = append(checkers, sink)
+			h.setSink(sink.Name(), "unknown", false, nil)
+		}
+	}
+	if len(checkers) == 0 {
+		return
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		for _, sink := range checkers {
+			checkCtx, cancel := context.WithTimeout(ctx, 5*time.Second)
+			status, healthy, err := sink.(HealthChecker).CheckHealth(checkCtx)
+			cancel()
+			h.setSink(sink.Name(), status, healthy, err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+type healthReport struct {
+	Status       string                `json:"status"`
+	KafkaSession bool                  `json:"kafka_session"`
+	Sinks        map[string]sinkStatus `json:"sinks,omitempty"`
+}
+
+// report snapshots the current state. sinksOK is true when every checked
+// sink is healthy.
+func (h *Health) report() (report healthReport, sinksOK bool) {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	report = healthReport{
+		KafkaSession: h.kafkaSession.Load(),
+		Sinks:        make(map[string]sinkStatus, len(h.sinks)),
+	}
+	sinksOK = true
+	for name, st := range h.sinks {
+		report.Sinks[name] = st
+		sinksOK = sinksOK && st.Healthy
+	}
+	return report, sinksOK
+}
+
+// handleHealthz reports liveness: the process is up and serving. Sink and
+// Kafka outages only fail readiness, so that they do not get the consumer
+// restarted.
+func (h *Health) handleHealthz(w http.ResponseWriter, r *http.Request) {
+	writeHealth(w, healthReport{KafkaSession: h.kafkaSession.Load()}, true)
+}
+
+// handleReadyz reports readiness: a Kafka session is held and every sink can accept writes
+func (h *Health) handleReadyz(w http.ResponseWriter, r *http.Request) {
+	report, sinksOK := h.report()
+	writeHealth(w, report, sinksOK && report.KafkaSession)
+}
+
+func writeHealth(w http.ResponseWriter, report healthReport, ok bool) {
+	w.Header().Set("Content-Type", "application/json")
+	if ok {
+		report.Status = "ok"
+		w.WriteHeader(http.StatusOK)
+	} else {
+		report.Status = "unavailable"
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	json.NewEncoder(w).Encode(report)
+}
+
+// startMetricsServer serves /metrics, /healthz and /readyz on addr
+func startMetricsServer(addr string, health *Health) *http.Server {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", promhttp.Handler())
+	mux.HandleFunc("/healthz", health.handleHealthz)
+	mux.HandleFunc("/readyz", health.handleReadyz)
+
+	srv := &http.Server{
+		Addr:              addr,
+		Handler:           mux,
+		ReadHeaderTimeout: 5 * time.Second,
+	}
+	go func() {
+		if err := srv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			log.Printf("Error from metrics server: %v", err)
+		}
+	}()
+	log.Printf("Metrics and health endpoints listening on %s", addr)
+	return srv
+}
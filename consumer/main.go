@@ -2,72 +2,128 @@ package main
 
 import (
 	"context"
-	"encoding/json"
-	"fmt"
 	"log"
 	"os"
 	"os/signal"
-	"strings"
-	"time"
+	"syscall"
 
 	"github.com/IBM/sarama"
-	"github.com/elastic/go-elasticsearch/v8"
-	"github.com/elastic/go-elasticsearch/v8/esapi"
 )
 
 type LogEntry struct {
 	ID       string                 `json:"id"`
 	App      string                 `json:"app"`
+	Tenant   string                 `json:"tenant,omitempty"`
 	System   string                 `json:"system"`
 	Module   string                 `json:"module,omitempty"`
 	Type     string                 `json:"type"`
 	Priority string                 `json:"pri"`
 	When     string                 `json:"when"`
 	Who      string                 `json:"who,omitempty"`
+	Instance string                 `json:"instance,omitempty"`
 	RemoteIP string                 `json:"remote_ip,omitempty"`
 	TraceID  string                 `json:"trace_id,omitempty"`
 	Msg      string                 `json:"msg"`
 	Data     map[string]interface{} `json:"data,omitempty"`
+
+	// Enrich is added by the consumer, never by producers
+	Enrich *Enrichment `json:"enrich,omitempty"`
 }
 
 func main() {
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "replay":
+			runReplay(os.Args[2:])
+			return
+		case "restore":
+			runRestore(os.Args[2:])
+			return
+		case "compact":
+			runCompact(os.Args[2:])
+			return
+		}
+	}
+	runConsumer()
+}
+
+// runConsumer runs the live consumer group until SIGINT or SIGTERM
+func runConsumer() {
 	// Get configuration from environment
-	kafkaBrokers := os.Getenv("KAFKA_BROKERS")
-	if kafkaBrokers == "" {
-		kafkaBrokers = "localhost:9092"
+	cfg := loadConfig()
+
+	// Connect the configured sinks (Elasticsearch by default)
+	sinks, err := buildSinks(context.Background(), cfg)
+	if err != nil {
+		log.Fatalf("Error creating sinks: %s", err)
 	}
-	
-	elasticsearchURL := os.Getenv("ELASTICSEARCH_URL")
-	if elasticsearchURL == "" {
-		elasticsearchURL = "http://localhost:9200"
+	log.Printf("Writing logs to sinks: %v", cfg.Sinks)
+
+	// Load message schemas and the dead letter route
+	var validator *Validator
+	if cfg.SchemaValidation {
+		validator, err = NewValidator()
+		if err != nil {
+			log.Fatalf("Error loading message schemas: %s", err)
+		}
+	}
+	var deadLetter *DeadLetter
+	if cfg.InvalidTopic != "" {
+		deadLetter, err = NewDeadLetter(cfg.KafkaBrokers, cfg.InvalidTopic)
+		if err != nil {
+			log.Fatalf("Error creating dead letter route: %s", err)
+		}
+		log.Printf("Rejected messages will be routed to %s", cfg.InvalidTopic)
 	}
 
-	// Kafka configuration
-	brokers := strings.Split(kafkaBrokers, ",")
-	topic := "logharbour-logs"
-	group := "logharbour-consumer"
-
-	// Elasticsearch configuration
-	cfg := elasticsearch.Config{
-		Addresses: []string{elasticsearchURL},
+	// Load PII redaction rules
+	var redactor *Redactor
+	if cfg.RedactionConfig != "" {
+		redactor, err = LoadRedactor(cfg.RedactionConfig, cfg.RedactionHashKey)
+		if err != nil {
+			log.Fatalf("Error loading redaction rules: %s", err)
+		}
+		log.Printf("PII redaction enabled using %s", cfg.RedactionConfig)
 	}
 
-	// Create Elasticsearch client
-	es, err := elasticsearch.NewClient(cfg)
+	// Enrichment with deployment tags, user agents and IP geolocation
+	enricher, err := NewEnricher(cfg)
 	if err != nil {
-		log.Fatalf("Error creating Elasticsearch client: %s", err)
+		log.Fatalf("Error creating enricher: %s", err)
 	}
 
-	// Test Elasticsearch connection
-	res, err := es.Info()
-	if err != nil {
-		log.Fatalf("Error getting Elasticsearch info: %s", err)
+	// Sample low-priority entries
+	var sampler *Sampler
+	if cfg.SamplingRates != "" {
+		sampler, err = NewSampler(cfg.SamplingRates, cfg.SamplingModuleLimit, cfg.SamplingTraceWindow)
+		if err != nil {
+			log.Fatalf("Error parsing sampling rates: %s", err)
+		}
+		log.Printf("Sampling enabled: %s", cfg.SamplingRates)
+	}
+
+	// Remember recently indexed log IDs so redeliveries are not indexed twice
+	var dedup *Deduplicator
+	if cfg.DedupEnabled {
+		var store SeenStore
+		if cfg.DedupDatabaseURL != "" {
+			store, err = NewPostgresSeenStore(context.Background(), cfg.DedupDatabaseURL, cfg.DedupWindow)
+			if err != nil {
+				log.Fatalf("Error creating dedup store: %s", err)
+			}
+		}
+		dedup = NewDeduplicator(cfg.DedupCacheSize, cfg.DedupWindow, store)
+		log.Printf("Deduplicating log IDs over a %s window (persistent store: %t)", cfg.DedupWindow, store != nil)
 	}
-	defer res.Body.Close()
-	log.Println("Elasticsearch connected successfully")
 
-	// Create index template for logs
-	createIndexTemplate(es)
+	// Load alert rules
+	var alerts *AlertEngine
+	if cfg.AlertRules != "" {
+		alerts, err = NewAlertEngine(cfg.AlertRules)
+		if err != nil {
+			log.Fatalf("Error loading alert rules: %s", err)
+		}
+	}
 
 	// Kafka consumer configuration
 	config := sarama.NewConfig()
@@ -76,28 +132,70 @@ func main() {
 	config.Version = sarama.V2_6_0_0
 
 	// Create consumer group
-	consumerGroup, err := sarama.NewConsumerGroup(brokers, group, config)
+	consumerGroup, err := sarama.NewConsumerGroup(cfg.KafkaBrokers, cfg.Group, config)
 	if err != nil {
 		log.Fatalf("Error creating consumer group: %s", err)
 	}
-	defer consumerGroup.Close()
-
-	// Create consumer handler
-	consumer := &Consumer{
-		es: es,
-	}
 
 	// Setup signal handling
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
 
+	// Index requests use their own context so that pending batches can still
+	// be flushed after fetching stops
+	indexCtx, abortIndexing := context.WithCancel(context.Background())
+	defer abortIndexing()
+
+	// Expose metrics and health endpoints
+	health := NewHealth()
+	go health.WatchSinks(ctx, sinks, cfg.HealthCheckInterval)
+	metricsServer := startMetricsServer(cfg.MetricsAddr, health)
+
+	// Index sampling drop counts alongside the logs
+	if sampler != nil {
+		go sampler.ReportDrops(ctx, indexCtx, sinks, cfg.SamplingReportInterval)
+	}
+
+	// Create consumer handler
+	consumer := &Consumer{
+		sinks:      sinks,
+		cfg:        cfg,
+		health:     health,
+		redactor:   redactor,
+		enricher:   enricher,
+		sampler:    sampler,
+		validator:  validator,
+		deadLetter: deadLetter,
+		dedup:      dedup,
+		alerts:     alerts,
+		indexCtx:   indexCtx,
+	}
+
 	sigterm := make(chan os.Signal, 1)
-	signal.Notify(sigterm, os.Interrupt)
+	signal.Notify(sigterm, os.Interrupt, syscall.SIGTERM)
+
+	// SIGHUP reloads the alert rules
+	if alerts != nil {
+		if cfg.AlertReloadInterval > 0 {
+			go alerts.WatchRules(ctx, cfg.AlertReloadInterval)
+		}
+		sighup := make(chan os.Signal, 1)
+		signal.Notify(sighup, syscall.SIGHUP)
+		go func() {
+			for range sighup {
+				if err := alerts.Reload(); err != nil {
+					log.Printf("Error reloading alert rules, keeping current rules: %v", err)
+				}
+			}
+		}()
+	}
 
 	// Start consuming
+	consumeDone := make(chan struct{})
 	go func() {
+		defer close(consumeDone)
 		for {
-			if err := consumerGroup.Consume(ctx, []string{topic}, consumer); err != nil {
+			if err := consumerGroup.Consume(ctx, []string{cfg.Topic}, consumer); err != nil {
 				log.Printf("Error from consumer: %v", err)
 			}
 			if ctx.Err() != nil {
@@ -107,124 +205,56 @@ func main() {
 	}()
 
 	log.Println("LogHarbour consumer started. Press Ctrl+C to exit.")
-	<-sigterm
-	log.Println("Shutting down consumer...")
-}
+	sig := <-sigterm
+	log.Printf("Received %s, shutting down consumer (deadline %s)...", sig, cfg.ShutdownTimeout)
 
-// Consumer represents a Sarama consumer group consumer
-type Consumer struct {
-	es *elasticsearch.Client
-}
-
-// Setup is run at the beginning of a new session, before ConsumeClaim
-func (consumer *Consumer) Setup(sarama.ConsumerGroupSession) error {
-	return nil
-}
-
-// Cleanup is run at the end of a session, once all ConsumeClaim goroutines have exited
-func (consumer *Consumer) Cleanup(sarama.ConsumerGroupSession) error {
-	return nil
-}
-
-// ConsumeClaim must start a consumer loop of ConsumerGroupClaim's Messages()
-func (consumer *Consumer) ConsumeClaim(session sarama.ConsumerGroupSession, claim sarama.ConsumerGroupClaim) error {
-	for message := range claim.Messages() {
-		// Parse log entry
-		var logEntry LogEntry
-		if err := json.Unmarshal(message.Value, &logEntry); err != nil {
-			log.Printf("Error parsing message: %v", err)
-			session.MarkMessage(message, "")
-			continue
-		}
+	// A second signal skips the drain
+	go func() {
+		<-sigterm
+		log.Println("Second signal received, exiting immediately")
+		os.Exit(1)
+	}()
 
-		// Index to Elasticsearch
-		if err := consumer.indexLog(logEntry); err != nil {
-			log.Printf("Error indexing log: %v", err)
-			// Continue processing other messages even if one fails
-		}
+	shutdownCtx, shutdownCancel := context.WithTimeout(context.Background(), cfg.ShutdownTimeout)
+	defer shutdownCancel()
+	go func() {
+		<-shutdownCtx.Done()
+		abortIndexing()
+	}()
 
-		session.MarkMessage(message, "")
+	// Step 1: Stop fetching. ConsumeClaim flushes its pending batch and
+	// Cleanup commits the marked offsets before the session ends.
+	cancel()
+	select {
+	case <-consumeDone:
+		log.Println("In-flight batches drained and offsets committed")
+	case <-shutdownCtx.Done():
+		log.Println("Shutdown deadline exceeded; undrained messages will be redelivered")
 	}
-	return nil
-}
 
-func (consumer *Consumer) indexLog(logEntry LogEntry) error {
-	// Determine index name based on log type and date
-	indexName := fmt.Sprintf("logharbour-%s-%s", 
-		strings.ToLower(logEntry.Type), 
-		time.Now().Format("2006.01.02"))
-
-	// Convert log entry to JSON
-	data, err := json.Marshal(logEntry)
-	if err != nil {
-		return fmt.Errorf("error marshaling log entry: %w", err)
+	// Step 2: Leave the consumer group
+	if err := consumerGroup.Close(); err != nil {
+		log.Printf("Error closing consumer group: %v", err)
 	}
 
-	// Create index request
-	req := esapi.IndexRequest{
-		Index:      indexName,
-		DocumentID: logEntry.ID,
-		Body:       strings.NewReader(string(data)),
-		Refresh:    "false",
+	// Step 3: Stop serving metrics and release sink connections
+	if err := metricsServer.Shutdown(shutdownCtx); err != nil {
+		log.Printf("Error stopping metrics server: %v", err)
 	}
-
-	// Perform the request
-	res, err := req.Do(context.Background(), consumer.es)
-	if err != nil {
-		return fmt.Errorf("error indexing document: %w", err)
+	if sampler != nil {
+		sampler.Flush(indexCtx, sinks)
 	}
-	defer res.Body.Close()
-
-	if res.IsError() {
-		return fmt.Errorf("error indexing document: %s", res.String())
+	closeSinks(sinks)
+	if deadLetter != nil {
+		deadLetter.Close()
 	}
-
-	return nil
-}
-
-func createIndexTemplate(es *elasticsearch.Client) {
-	// Create an index template for LogHarbour logs
-	template := `{
-		"index_patterns": ["logharbour-*"],
-		"template": {
-			"settings": {
-				"number_of_shards": 1,
-				"number_of_replicas": 0
-			},
-			"mappings": {
-				"properties": {
-					"id": { "type": "keyword" },
-					"app": { "type": "keyword" },
-					"system": { "type": "keyword" },
-					"module": { "type": "keyword" },
-					"type": { "type": "keyword" },
-					"pri": { "type": "keyword" },
-					"when": { "type": "date" },
-					"who": { "type": "keyword" },
-					"remote_ip": { "type": "ip" },
-					"trace_id": { "type": "keyword" },
-					"msg": { "type": "text" },
-					"data": { "type": "object" }
-				}
-			}
-		}
-	}`
-
-	req := esapi.IndicesPutIndexTemplateRequest{
-		Name: "logharbour-template",
-		Body: strings.NewReader(template),
+	enricher.Close()
+	if dedup != nil {
+		dedup.Close()
 	}
-
-	res, err := req.Do(context.Background(), es)
-	if err != nil {
-		log.Printf("Error creating index template: %s", err)
-		return
+	if alerts != nil {
+		alerts.Close()
 	}
-	defer res.Body.Close()
 
-	if res.IsError() {
-		log.Printf("Error creating index template: %s", res.String())
-	} else {
-		log.Println("Index template created successfully")
-	}
-}
\ No newline at end of file
+	log.Println("Consumer stopped")
+}
@@ -0,0 +1,141 @@
+package main
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// Prometheus metrics exposed on /metrics
+var (
+	messagesConsumed = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "logharbour_consumer_messages_consumed_total",
+		Help: "Messages read from Kafka.",
+	}, []string{"topic", "partition"})
+
+	parseFailures = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "logharbour_consumer_parse_failures_total",
+		Help: "Messages that could not be decoded as a LogEntry.",
+	}, []string{"topic"})
+
+	schemaViolations = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "logharbour_consumer_schema_violations_total",
+		Help: "Messages rejected by validation, by reason and schema version.",
+	}, []string{"reason", "version"})
+
+	deadLettered = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "logharbour_consumer_dead_lettered_total",
+		Help: "Rejected messages routed to the dead letter topic.",
+	})
+
+	deadLetterErrors = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "logharbour_consumer_dead_letter_errors_total",
+		Help: "Rejected messages that could not be routed to the dead letter topic.",
+	})
+
+	sinkDocuments = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "logharbour_consumer_sink_documents_total",
+		Help: "Documents written to each sink, by result.",
+	}, []string{"sink", "result"})
+
+	sinkWriteDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "logharbour_consumer_sink_write_duration_seconds",
+		Help:    "Latency of batch writes to each sink.",
+		Buckets: prometheus.ExponentialBuckets(0.005, 2, 12),
+	}, []string{"sink"})
+
+	bulkSize = promauto.NewHistogram(prometheus.HistogramOpts{
+		Name:    "logharbour_consumer_bulk_size",
+		Help:    "Number of entries per batch written to the sinks.",
+		Buckets: prometheus.ExponentialBuckets(1, 2, 11),
+	})
+
+	redactions = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "logharbour_consumer_redactions_total",
+		Help: "Entries changed by a redaction rule, by rule kind and name.",
+	}, []string{"kind", "rule"})
+
+	redactionFailures = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "logharbour_consumer_redaction_failures_total",
+		Help: "Entries dropped because redaction could not be applied.",
+	})
+
+	enrichments = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "logharbour_consumer_enrichments_total",
+		Help: "User agent and GeoIP lookups, by kind and result.",
+	}, []string{"kind", "result"})
+
+	sampledEntries = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "logharbour_consumer_sampled_entries_total",
+		Help: "Entries subject to sampling, by priority and decision.",
+	}, []string{"priority", "decision"})
+
+	dedupChecked = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "logharbour_consumer_dedup_checked_total",
+		Help: "Entries checked for duplicates.",
+	})
+
+	duplicates = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "logharbour_consumer_duplicates_total",
+		Help: "Entries dropped as duplicates, by where the earlier copy was found.",
+	}, []string{"source"})
+
+	dedupStoreErrors = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "logharbour_consumer_dedup_store_errors_total",
+		Help: "Batches written without the persistent dedup check because the store failed.",
+	})
+
+	alertsFired = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "logharbour_consumer_alerts_fired_total",
+		Help: "Alerts fired, by rule.",
+	}, []string{"rule"})
+
+	alertNotifications = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "logharbour_consumer_alert_notifications_total",
+		Help: "Alert deliveries, by notifier and result.",
+	}, []string{"notifier", "result"})
+
+	alertRuleReloads = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "logharbour_consumer_alert_rule_reloads_total",
+		Help: "Loads of the alert rules file, by result.",
+	}, []string{"result"})
+
+	changeLogRows = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "logharbour_consumer_changelog_rows_total",
+		Help: "Field changes written to the Postgres change log table.",
+	})
+
+	changeLogErrors = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "logharbour_consumer_changelog_errors_total",
+		Help: "Field changes or change logs that could not be written to Postgres.",
+	})
+
+	archiveUploads = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "logharbour_consumer_archive_uploads_total",
+		Help: "Archive files uploaded to the object store, by result.",
+	}, []string{"result"})
+
+	archiveUploadedBytes = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "logharbour_consumer_archive_uploaded_bytes_total",
+		Help: "Compressed bytes uploaded to the archive.",
+	})
+
+	consumerLag = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "logharbour_consumer_lag",
+		Help: "Messages between the last consumed offset and the partition high watermark.",
+	}, []string{"topic", "partition"})
+
+	batchesInFlight = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "logharbour_consumer_batches_in_flight",
+		Help: "Batches being processed or written by the partition's workers.",
+	}, []string{"topic", "partition"})
+
+	kafkaSessionActive = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "logharbour_consumer_kafka_session_active",
+		Help: "1 while the consumer holds a Kafka consumer group session.",
+	})
+
+	sinkUp = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "logharbour_consumer_sink_up",
+		Help: "1 when the last health check of the sink's backend succeeded.",
+	}, []string{"sink"})
+)
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+)
+
+// Notifier delivers fired alerts
+type Notifier interface {
+	Name() string
+	Notify(ctx context.Context, alert Alert) error
+}
+
+// NotifierConfig declares a notifier in the alert rules file
+type NotifierConfig struct {
+	Name    string            `yaml:"name"`
+	Type    string            `yaml:"type"`
+	URL     string            `yaml:"url"`     // webhook
+	Headers map[string]string `yaml:"headers"` // webhook
+	Path    string            `yaml:"path"`    // file
+}
+
+func newNotifier(cfg NotifierConfig) (Notifier, error) {
+	if cfg.Name == "" {
+		return nil, fmt.Errorf("notifier without a name")
+	}
+	switch cfg.Type {
+	case "webhook":
+		if cfg.URL == "" {
+			return nil, fmt.Errorf("webhook notifier %q requires url", cfg.Name)
+		}
+		return &WebhookNotifier{
+			name:    cfg.Name,
+			url:     cfg.URL,
+			headers: cfg.Headers,
+			client:  &http.Client{Timeout: 10 * time.Second},
+		}, nil
+	case "file":
+		if cfg.Path == "" {
+			return nil, fmt.Errorf("file notifier %q requires path", cfg.Name)
+		}
+		return &FileNotifier{name: cfg.Name, path: cfg.Path}, nil
+	default:
+		return nil, fmt.Errorf("notifier %q: unknown type %q", cfg.Name, cfg.Type)
+	}
+}
+
+// WebhookNotifier POSTs each alert as JSON
+type WebhookNotifier struct {
+	name    string
+	url     string
+	headers map[string]string
+	client  *http.Client
+}
+
+func (n *WebhookNotifier) Name() string { return n.name }
+
+func (n *WebhookNotifier) Notify(ctx context.Context, alert Alert) error {
+	body, err := json.Marshal(alert)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, n.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for k, v := range n.headers {
+		req.Header.Set(k, v)
+	}
+
+	res, err := n.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if res.StatusCode >= 300 {
+		msg, _ := io.ReadAll(io.LimitReader(res.Body, 1024))
+		return fmt.Errorf("webhook returned %s: %s", res.Status, msg)
+	}
+	return nil
+}
+
+// FileNotifier appends each alert as a JSON line. The file is opened per
+// alert so that it can be rotated externally.
+type FileNotifier struct {
+	name string
+	path string
+	mu   sync.Mutex
+}
+
+func (n *FileNotifier) Name() string { return n.name }
+
+func (n *FileNotifier) Notify(_ context.Context, alert Alert) error {
+	line, err := json.Marshal(alert)
+	if err != nil {
+		return err
+	}
+	line = append(line, '\n')
+
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	f, err := os.OpenFile(n.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(line); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
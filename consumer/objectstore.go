@@ -0,0 +1,399 @@
+package main
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// ObjectStore holds archived log files. Keys are slash-separated paths
+// relative to the store's root.
+type ObjectStore interface {
+	Put(ctx context.Context, key string, body io.ReadSeeker) error
+	Get(ctx context.Context, key string) (io.ReadCloser, error)
+	// List returns the keys starting with prefix, sorted
+	List(ctx context.Context, prefix string) ([]string, error)
+	Delete(ctx context.Context, key string) error
+}
+
+// openObjectStore opens the store at rawURL: s3://bucket/prefix for an
+// S3-compatible endpoint, or a file:// URL or plain path for a directory
+func openObjectStore(rawURL string, s3cfg S3Config) (ObjectStore, error) {
+	if rawURL == "" {
+		return nil, fmt.Errorf("no archive location configured")
+	}
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid archive URL %q: %w", rawURL, err)
+	}
+	switch u.Scheme {
+	case "s3":
+		return NewS3Store(u.Host, strings.TrimPrefix(u.Path, "/"), s3cfg)
+	case "file":
+		return NewDirStore(u.Path)
+	case "":
+		return NewDirStore(rawURL)
+	default:
+		return nil, fmt.Errorf("unsupported archive URL scheme %q", u.Scheme)
+	}
+}
+
+// DirStore is an ObjectStore backed by a local directory, for development
+// and for archives on mounted network storage
+type DirStore struct {
+	root string
+}
+
+func NewDirStore(root string) (*DirStore, error) {
+	if err := os.MkdirAll(root, 0o755); err != nil {
+		return nil, fmt.Errorf("error creating archive directory: %w", err)
+	}
+	return &DirStore{root: root}, nil
+}
+
+// Put writes body to a temporary file first, so readers never see a
+// partial object
+func (s *DirStore) Put(ctx context.Context, key string, body io.ReadSeeker) error {
+	target := filepath.Join(s.root, filepath.FromSlash(key))
+	if err := os.MkdirAll(filepath.Dir(target), 0o755); err != nil {
+		return fmt.Errorf("error creating %s: %w", filepath.Dir(target), err)
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(target), ".upload-*")
+	if err != nil {
+		return fmt.Errorf("error creating %s: %w", key, err)
+	}
+	defer os.Remove(tmp.Name())
+
+	_, err = io.Copy(tmp, body)
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), target)
+	}
+	if err != nil {
+		return fmt.Errorf("error writing %s: %w", key, err)
+	}
+	return nil
+}
+
+func (s *DirStore) Get(ctx context.Context, key string) (io.ReadCloser, error) {
+	return os.Open(filepath.Join(s.root, filepath.FromSlash(key)))
+}
+
+func (s *DirStore) List(ctx context.Context, prefix string) ([]string, error) {
+	// Only walk the directory the prefix points into
+	start := s.root
+	if dir := path.Dir(prefix); strings.Contains(prefix, "/") {
+		start = filepath.Join(s.root, filepath.FromSlash(dir))
+	}
+
+	var keys []string
+	err := filepath.WalkDir(start, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			if errors.Is(err, fs.ErrNotExist) {
+				return nil
+			}
+			return err
+		}
+		if d.IsDir() || strings.HasPrefix(d.Name(), ".") {
+			return nil
+		}
+		rel, err := filepath.Rel(s.root, p)
+		if err != nil {
+			return err
+		}
+		if key := filepath.ToSlash(rel); strings.HasPrefix(key, prefix) {
+			keys = append(keys, key)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error listing %s: %w", s.root, err)
+	}
+	sort.Strings(keys)
+	return keys, nil
+}
+
+func (s *DirStore) Delete(ctx context.Context, key string) error {
+	err := os.Remove(filepath.Join(s.root, filepath.FromSlash(key)))
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	return nil
+}
+
+// S3Config holds the connection settings for S3-compatible stores
+type S3Config struct {
+	Endpoint        string // e.g. http://minio:9000; AWS when empty
+	Region          string
+	AccessKeyID     string
+	SecretAccessKey string
+}
+
+// S3Store is an ObjectStore on Amazon S3 or a compatible service such as
+// MinIO. It signs requests with AWS Signature Version 4 and uses path-style
+// addressing, which every S3-compatible server supports.
+type S3Store struct {
+	endpoint *url.URL
+	bucket   string
+	prefix   string // prepended to every key, empty or ending in "/"
+	cfg      S3Config
+	client   *http.Client
+}
+
+func NewS3Store(bucket, prefix string, cfg S3Config) (*S3Store, error) {
+	if bucket == "" {
+		return nil, fmt.Errorf("archive URL has no bucket")
+	}
+	if cfg.AccessKeyID == "" || cfg.SecretAccessKey == "" {
+		return nil, fmt.Errorf("S3 archive requires AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY")
+	}
+	if cfg.Region == "" {
+		cfg.Region = "us-east-1"
+	}
+	endpoint := cfg.Endpoint
+	if endpoint == "" {
+		endpoint = "https://s3." + cfg.Region + ".amazonaws.com"
+	}
+	u, err := url.Parse(endpoint)
+	if err != nil || u.Host == "" {
+		return nil, fmt.Errorf("invalid S3 endpoint %q", endpoint)
+	}
+	if prefix != "" && !strings.HasSuffix(prefix, "/") {
+		prefix += "/"
+	}
+	return &S3Store{
+		endpoint: u,
+		bucket:   bucket,
+		prefix:   prefix,
+		cfg:      cfg,
+		client:   &http.Client{Timeout: 5 * time.Minute},
+	}, nil
+}
+
+// EnsureBucket creates the bucket if it does not exist
+func (s *S3Store) EnsureBucket(ctx context.Context) error {
+	resp, err := s.do(ctx, http.MethodHead, "", nil, nil)
+	if err == nil {
+		resp.Body.Close()
+		return nil
+	}
+	var s3err *s3Error
+	if !errors.As(err, &s3err) || s3err.status != http.StatusNotFound {
+		return err
+	}
+	resp, err = s.do(ctx, http.MethodPut, "", nil, nil)
+	if err != nil {
+		return fmt.Errorf("error creating bucket %s: %w", s.bucket, err)
+	}
+	resp.Body.Close()
+	return nil
+}
+
+func (s *S3Store) Put(ctx context.Context, key string, body io.ReadSeeker) error {
+	resp, err := s.do(ctx, http.MethodPut, key, nil, body)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+
+func (s *S3Store) Get(ctx context.Context, key string) (io.ReadCloser, error) {
+	resp, err := s.do(ctx, http.MethodGet, key, nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	return resp.Body, nil
+}
+
+func (s *S3Store) List(ctx context.Context, prefix string) ([]string, error) {
+	var keys []string
+	query := url.Values{"list-type": {"2"}, "prefix": {s.prefix + prefix}}
+	for {
+		resp, err := s.do(ctx, http.MethodGet, "", query, nil)
+		if err != nil {
+			return nil, err
+		}
+		var result struct {
+			Contents []struct {
+				Key string
+			}
+			IsTruncated           bool
+			NextContinuationToken string
+		}
+		err = xml.NewDecoder(resp.Body).Decode(&result)
+		resp.Body.Close()
+		if err != nil {
+			return nil, fmt.Errorf("error decoding bucket listing: %w", err)
+		}
+		for _, c := range result.Contents {
+			keys = append(keys, strings.TrimPrefix(c.Key, s.prefix))
+		}
+		if !result.IsTruncated {
+			break
+		}
+		query.Set("continuation-token", result.NextContinuationToken)
+	}
+	sort.Strings(keys)
+	return keys, nil
+}
+
+func (s *S3Store) Delete(ctx context.Context, key string) error {
+	resp, err := s.do(ctx, http.MethodDelete, key, nil, nil)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+
+// s3Error is a non-2xx response
+type s3Error struct {
+	status int
+	method string
+	key    string
+	body   string
+}
+
+func (e *s3Error) Error() string {
+	return fmt.Sprintf("S3 %s %s: %d %s", e.method, e.key, e.status, e.body)
+}
+
+// do sends a signed request for key (the bucket itself when key is empty)
+func (s *S3Store) do(ctx context.Context, method, key string, query url.Values, body io.ReadSeeker) (*http.Response, error) {
+	u := *s.endpoint
+	u.Path = "/" + s.bucket
+	if key != "" {
+		u.Path += "/" + s.prefix + key
+	}
+	u.RawPath = s3Escape(u.Path, false)
+	u.RawQuery = canonicalQuery(query)
+
+	payloadHash := emptySHA256
+	var size int64
+	if body != nil {
+		h := sha256.New()
+		n, err := io.Copy(h, body)
+		if err != nil {
+			return nil, fmt.Errorf("error reading %s: %w", key, err)
+		}
+		if _, err := body.Seek(0, io.SeekStart); err != nil {
+			return nil, fmt.Errorf("error reading %s: %w", key, err)
+		}
+		payloadHash = hex.EncodeToString(h.Sum(nil))
+		size = n
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, u.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+	if body != nil {
+		req.Body = io.NopCloser(body)
+		req.ContentLength = size
+	}
+	s.sign(req, u.RawPath, payloadHash, time.Now().UTC())
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("S3 %s %s: %w", method, key, err)
+	}
+	if resp.StatusCode >= 300 {
+		detail, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		resp.Body.Close()
+		return nil, &s3Error{status: resp.StatusCode, method: method, key: key, body: strings.TrimSpace(string(detail))}
+	}
+	return resp, nil
+}
+
+const emptySHA256 = "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"
+
+// sign adds AWS Signature Version 4 headers to req
+func (s *S3Store) sign(req *http.Request, canonicalURI, payloadHash string, now time.Time) {
+	amzDate := now.Format("20060102T150405Z")
+	date := now.Format("20060102")
+	req.Header.Set("x-amz-date", amzDate)
+	req.Header.Set("x-amz-content-sha256", payloadHash)
+
+	const signedHeaders = "host;x-amz-content-sha256;x-amz-date"
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		canonicalURI,
+		req.URL.RawQuery,
+		"host:" + req.URL.Host + "\n" +
+			"x-amz-content-sha256:" + payloadHash + "\n" +
+			"x-amz-date:" + amzDate + "\n",
+		signedHeaders,
+		payloadHash,
+	}, "\n")
+
+	scope := date + "/" + s.cfg.Region + "/s3/aws4_request"
+	requestHash := sha256.Sum256([]byte(canonicalRequest))
+	stringToSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + hex.EncodeToString(requestHash[:])
+
+	key := hmacSHA256([]byte("AWS4"+s.cfg.SecretAccessKey), date)
+	key = hmacSHA256(key, s.cfg.Region)
+	key = hmacSHA256(key, "s3")
+	key = hmacSHA256(key, "aws4_request")
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		s.cfg.AccessKeyID, scope, signedHeaders, signature))
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
+
+// canonicalQuery encodes query sorted by key, as SigV4 requires
+func canonicalQuery(query url.Values) string {
+	keys := make([]string, 0, len(query))
+	for k := range query {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	var parts []string
+	for _, k := range keys {
+		for _, v := range query[k] {
+			parts = append(parts, s3Escape(k, true)+"="+s3Escape(v, true))
+		}
+	}
+	return strings.Join(parts, "&")
+}
+
+// s3Escape percent-encodes everything but unreserved characters, and
+// slashes unless encodeSlash is set
+func s3Escape(s string, encodeSlash bool) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case 'A' <= c && c <= 'Z', 'a' <= c && c <= 'z', '0' <= c && c <= '9',
+			c == '-', c == '_', c == '.', c == '~':
+			b.WriteByte(c)
+		case c == '/' && !encodeSlash:
+			b.WriteByte(c)
+		default:
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+	return b.String()
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"sync"
+
+	"github.com/IBM/sarama"
+)
+
+// offsetTracker marks a partition's offsets as batches complete. Batches are
+// numbered in the order they were read and may finish in any order; the
+// committed offset only advances past a batch once every earlier batch has
+// finished too, so a restart never skips an unwritten message.
+type offsetTracker struct {
+	session   sarama.ConsumerGroupSession
+	topic     string
+	partition int32
+
+	mu        sync.Mutex
+	nextSeq   uint64           // oldest batch that has not completed
+	completed map[uint64]int64 // completed batches after nextSeq -> offset after their last message
+}
+
+func newOffsetTracker(session sarama.ConsumerGroupSession, topic string, partition int32) *offsetTracker {
+	return &offsetTracker{
+		session:   session,
+		topic:     topic,
+		partition: partition,
+		completed: make(map[uint64]int64),
+	}
+}
+
+// complete records that batch seq, ending before offset end, is done, and
+// marks the newest offset that no unfinished batch precedes
+func (t *offsetTracker) complete(seq uint64, end int64) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.completed[seq] = end
+	mark := int64(-1)
+	for {
+		offset, ok := t.completed[t.nextSeq]
+		if !ok {
+			break
+		}
+		delete(t.completed, t.nextSeq)
+		t.nextSeq++
+		mark = offset
+	}
+	if mark >= 0 {
+		t.session.MarkOffset(t.topic, t.partition, mark, "")
+	}
+}
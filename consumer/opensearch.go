@@ -0,0 +1,117 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// OpenSearchSink bulk-indexes entries into OpenSearch. The Elasticsearch
+// client refuses to talk to OpenSearch, so this sink uses the REST API directly.
+type OpenSearchSink struct {
+	url      string
+	username string
+	password string
+	client   *http.Client
+}
+
+// NewOpenSearchSink checks the cluster is reachable and installs the index template
+func NewOpenSearchSink(ctx context.Context, url, username, password string) (*OpenSearchSink, error) {
+	if url == "" {
+		return nil, fmt.Errorf("opensearch sink requires OPENSEARCH_URL")
+	}
+	s := &OpenSearchSink{
+		url:      strings.TrimRight(url, "/"),
+		username: username,
+		password: password,
+		client: &http.Client{
+			Transport: http.DefaultTransport.(*http.Transport).Clone(),
+			Timeout:   30 * time.Second,
+		},
+	}
+
+	res, err := s.do(ctx, http.MethodGet, "/", "", nil)
+	if err != nil {
+		return nil, fmt.Errorf("error connecting to OpenSearch: %w", err)
+	}
+	res.Body.Close()
+	log.Println("OpenSearch connected successfully")
+
+	res, err = s.do(ctx, http.MethodPut, "/_index_template/logharbour-template", "application/json", strings.NewReader(indexTemplate))
+	if err != nil {
+		log.Printf("Error creating OpenSearch index template: %s", err)
+	} else {
+		res.Body.Close()
+		log.Println("OpenSearch index template created successfully")
+	}
+
+	return s, nil
+}
+
+// do performs a request and returns an error for non-2xx responses
+func (s *OpenSearchSink) do(ctx context.Context, method, path, contentType string, body io.Reader) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, method, s.url+path, body)
+	if err != nil {
+		return nil, err
+	}
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+	if s.username != "" {
+		req.SetBasicAuth(s.username, s.password)
+	}
+
+	res, err := s.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if res.StatusCode >= 300 {
+		msg, _ := io.ReadAll(io.LimitReader(res.Body, 1024))
+		res.Body.Close()
+		return nil, fmt.Errorf("%s %s: %s: %s", method, path, res.Status, msg)
+	}
+	return res, nil
+}
+
+func (s *OpenSearchSink) Name() string { return "opensearch" }
+
+// Write sends entries in a single _bulk request
+func (s *OpenSearchSink) Write(ctx context.Context, entries []LogEntry) error {
+	body, err := bulkBody(entries, "")
+	if err != nil {
+		return err
+	}
+	res, err := s.do(ctx, http.MethodPost, "/_bulk", "application/x-ndjson", body)
+	if err != nil {
+		return fmt.Errorf("error indexing documents: %w", err)
+	}
+	defer res.Body.Close()
+	return checkBulkResponse(res.Body, len(entries))
+}
+
+// CheckHealth reports the cluster health status
+func (s *OpenSearchSink) CheckHealth(ctx context.Context) (string, bool, error) {
+	res, err := s.do(ctx, http.MethodGet, "/_cluster/health", "", nil)
+	if err != nil {
+		return "unreachable", false, err
+	}
+	defer res.Body.Close()
+
+	var body struct {
+		Status string `json:"status"`
+	}
+	if err := json.NewDecoder(res.Body).Decode(&body); err != nil {
+		return "unreachable", false, fmt.Errorf("error decoding cluster health: %w", err)
+	}
+	return body.Status, clusterHealthy(body.Status), nil
+}
+
+func (s *OpenSearchSink) Close() error {
+	s.client.CloseIdleConnections()
+	return nil
+}
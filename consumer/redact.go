@@ -0,0 +1,345 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"regexp"
+	"slices"
+	"strings"
+)
+
+// Redaction modes
+const (
+	RedactDrop = "drop" // remove the field entirely
+	RedactMask = "mask" // replace the value (or matched text) with a fixed mask
+	RedactHash = "hash" // replace with a keyed HMAC so equal values stay joinable
+)
+
+// Built-in detectors available by name in the redaction config
+var builtinDetectors = map[string]string{
+	"email": `[A-Za-z0-9._%+\-]+@[A-Za-z0-9.\-]+\.[A-Za-z]{2,}`,
+	"e164":  `\+[1-9][0-9]{7,14}\b`,
+	"token": `(?i)bearer\s+[A-Za-z0-9._~+/=\-]+|eyJ[A-Za-z0-9_\-]+\.[A-Za-z0-9_\-]+\.[A-Za-z0-9_\-]+`,
+}
+
+// detectorRoots are the top-level fields scanned by detectors
+var detectorRoots = []string{"msg", "who", "data"}
+
+// structuralFields identify, route and order entries. No rule rewrites them,
+// not even a "*" at the top of a field path.
+var structuralFields = []string{"id", "app", "tenant", "system", "module", "type", "pri", "when", "trace_id"}
+
+// RedactionConfig is loaded from the JSON file named by REDACTION_CONFIG
+type RedactionConfig struct {
+	Mask      string         `json:"mask"`
+	Fields    []FieldRule    `json:"fields"`
+	Detectors []DetectorRule `json:"detectors"`
+}
+
+// FieldRule redacts the value at a dot-separated path, e.g. "data.username".
+// Arrays are traversed implicitly. A segment may be "*" to match every key,
+// or carry a filter such as "changes[field=email]" to descend only into
+// array elements whose field equals the given value.
+type FieldRule struct {
+	Path string `json:"path"`
+	Mode string `json:"mode"`
+}
+
+// DetectorRule redacts text matching a built-in detector or a custom pattern
+type DetectorRule struct {
+	Name    string `json:"name"`
+	Pattern string `json:"pattern,omitempty"`
+	Mode    string `json:"mode"`
+}
+
+type pathSegment struct {
+	key       string
+	filterKey string
+	filterVal string
+}
+
+type compiledField struct {
+	path string
+	segs []pathSegment
+	mode string
+}
+
+type compiledDetector struct {
+	name string
+	re   *regexp.Regexp
+	mode string
+}
+
+// Redactor removes personal data from log entries before they are indexed
+type Redactor struct {
+	mask      string
+	hashKey   []byte
+	fields    []compiledField
+	detectors []compiledDetector
+}
+
+// LoadRedactor reads redaction rules from path. hashKey is required when any
+// rule uses hash mode.
+func LoadRedactor(path string, hashKey string) (*Redactor, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("error reading redaction config: %w", err)
+	}
+	var cfg RedactionConfig
+	if err := json.Unmarshal(data, &cfg); err != nil {
+		return nil, fmt.Errorf("error parsing redaction config: %w", err)
+	}
+	return NewRedactor(cfg, hashKey)
+}
+
+// NewRedactor validates and compiles a redaction config
+func NewRedactor(cfg RedactionConfig, hashKey string) (*Redactor, error) {
+	r := &Redactor{mask: cfg.Mask, hashKey: []byte(hashKey)}
+	if r.mask == "" {
+		r.mask = "****"
+	}
+
+	for _, rule := range cfg.Fields {
+		if err := r.checkMode(rule.Mode); err != nil {
+			return nil, fmt.Errorf("field rule %q: %w", rule.Path, err)
+		}
+		segs, err := parsePath(rule.Path)
+		if err != nil {
+			return nil, err
+		}
+		if slices.Contains(structuralFields, segs[0].key) {
+			return nil, fmt.Errorf("field rule %q: %s cannot be redacted", rule.Path, segs[0].key)
+		}
+		r.fields = append(r.fields, compiledField{path: rule.Path, segs: segs, mode: rule.Mode})
+	}
+
+	for _, rule := range cfg.Detectors {
+		if err := r.checkMode(rule.Mode); err != nil {
+			return nil, fmt.Errorf("detector %q: %w", rule.Name, err)
+		}
+		pattern := rule.Pattern
+		if pattern == "" {
+			builtin, ok := builtinDetectors[rule.Name]
+			if !ok {
+				return nil, fmt.Errorf("detector %q: unknown built-in detector and no pattern given", rule.Name)
+			}
+			pattern = builtin
+		}
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			return nil, fmt.Errorf("detector %q: %w", rule.Name, err)
+		}
+		r.detectors = append(r.detectors, compiledDetector{name: rule.Name, re: re, mode: rule.Mode})
+	}
+
+	return r, nil
+}
+
+func (r *Redactor) checkMode(mode string) error {
+	switch mode {
+	case RedactDrop, RedactMask:
+		return nil
+	case RedactHash:
+		if len(r.hashKey) == 0 {
+			return fmt.Errorf("hash mode requires REDACTION_HASH_KEY")
+		}
+		return nil
+	default:
+		return fmt.Errorf("unknown mode %q", mode)
+	}
+}
+
+func parsePath(path string) ([]pathSegment, error) {
+	if path == "" {
+		return nil, fmt.Errorf("empty path")
+	}
+	var segs []pathSegment
+	for _, part := range strings.Split(path, ".") {
+		seg := pathSegment{key: part}
+		if i := strings.IndexByte(part, '['); i >= 0 {
+			if !strings.HasSuffix(part, "]") {
+				return nil, fmt.Errorf("invalid filter in path %q", path)
+			}
+			k, v, ok := strings.Cut(part[i+1:len(part)-1], "=")
+			if !ok {
+				return nil, fmt.Errorf("invalid filter in path %q", path)
+			}
+			seg = pathSegment{key: part[:i], filterKey: k, filterVal: v}
+		}
+		if seg.key == "" {
+			return nil, fmt.Errorf("empty segment in path %q", path)
+		}
+		segs = append(segs, seg)
+	}
+	return segs, nil
+}
+
+// Redact applies all field rules and then all detectors to logEntry in place
+func (r *Redactor) Redact(logEntry *LogEntry) error {
+	if len(r.fields) == 0 && len(r.detectors) == 0 {
+		return nil
+	}
+
+	// Rules address fields by their JSON names, so work on the generic form
+	raw, err := json.Marshal(logEntry)
+	if err != nil {
+		return fmt.Errorf("error marshaling log entry: %w", err)
+	}
+	var doc map[string]any
+	if err := json.Unmarshal(raw, &doc); err != nil {
+		return fmt.Errorf("error decoding log entry: %w", err)
+	}
+
+	// Set structural fields aside while the rules run
+	structural := make(map[string]any)
+	for _, key := range structuralFields {
+		if v, ok := doc[key]; ok {
+			structural[key] = v
+			delete(doc, key)
+		}
+	}
+	for _, rule := range r.fields {
+		if r.applyField(doc, rule.segs, rule.mode) > 0 {
+			redactions.WithLabelValues("field", rule.path).Inc()
+		}
+	}
+	for _, root := range detectorRoots {
+		if v, ok := doc[root]; ok {
+			if r.scan(doc, root, v) {
+				delete(doc, root)
+			}
+		}
+	}
+	for key, v := range structural {
+		doc[key] = v
+	}
+
+	raw, err = json.Marshal(doc)
+	if err != nil {
+		return fmt.Errorf("error marshaling redacted entry: %w", err)
+	}
+	var redacted LogEntry
+	if err := json.Unmarshal(raw, &redacted); err != nil {
+		return fmt.Errorf("error decoding redacted entry: %w", err)
+	}
+	*logEntry = redacted
+	return nil
+}
+
+// applyField walks segs from node and redacts every matching leaf.
+// It returns the number of values redacted.
+func (r *Redactor) applyField(node any, segs []pathSegment, mode string) int {
+	obj, ok := node.(map[string]any)
+	if !ok {
+		return 0
+	}
+	seg := segs[0]
+
+	var keys []string
+	if seg.key == "*" {
+		for k := range obj {
+			keys = append(keys, k)
+		}
+	} else if _, ok := obj[seg.key]; ok {
+		keys = []string{seg.key}
+	}
+
+	count := 0
+	for _, k := range keys {
+		child := obj[k]
+		if arr, ok := child.([]any); ok && (seg.filterKey != "" || len(segs) > 1) {
+			// Descend into matching array elements
+			for _, elem := range arr {
+				if !matchesFilter(elem, seg) {
+					continue
+				}
+				if len(segs) > 1 {
+					count += r.applyField(elem, segs[1:], mode)
+				}
+			}
+			continue
+		}
+		if len(segs) > 1 {
+			count += r.applyField(child, segs[1:], mode)
+			continue
+		}
+		if mode == RedactDrop {
+			delete(obj, k)
+		} else {
+			obj[k] = r.replace(fmt.Sprint(child), mode)
+		}
+		count++
+	}
+	return count
+}
+
+func matchesFilter(elem any, seg pathSegment) bool {
+	if seg.filterKey == "" {
+		return true
+	}
+	obj, ok := elem.(map[string]any)
+	if !ok {
+		return false
+	}
+	return fmt.Sprint(obj[seg.filterKey]) == seg.filterVal
+}
+
+// scan runs detectors over every string below v, rewriting matches in the
+// parent container. It returns true if the value itself must be dropped.
+func (r *Redactor) scan(parent map[string]any, key string, v any) bool {
+	switch val := v.(type) {
+	case string:
+		out, drop := r.detect(val)
+		if !drop {
+			parent[key] = out
+		}
+		return drop
+	case map[string]any:
+		for k, child := range val {
+			if r.scan(val, k, child) {
+				delete(val, k)
+			}
+		}
+	case []any:
+		for i, child := range val {
+			holder := map[string]any{"v": child}
+			if r.scan(holder, "v", child) {
+				val[i] = nil
+			} else {
+				val[i] = holder["v"]
+			}
+		}
+	}
+	return false
+}
+
+// detect applies detectors to s. drop is true if a drop-mode detector matched.
+func (r *Redactor) detect(s string) (out string, drop bool) {
+	out = s
+	for _, d := range r.detectors {
+		if !d.re.MatchString(out) {
+			continue
+		}
+		redactions.WithLabelValues("detector", d.name).Inc()
+		if d.mode == RedactDrop {
+			return "", true
+		}
+		out = d.re.ReplaceAllStringFunc(out, func(match string) string {
+			return r.replace(match, d.mode)
+		})
+	}
+	return out, false
+}
+
+func (r *Redactor) replace(value, mode string) string {
+	if mode == RedactHash {
+		mac := hmac.New(sha256.New, r.hashKey)
+		mac.Write([]byte(value))
+		return "hmac:" + hex.EncodeToString(mac.Sum(nil))[:16]
+	}
+	return r.mask
+}
@@ -0,0 +1,329 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+const testHashKey = "redaction-test-key"
+
+// redactionEntry is a change log shaped like those redaction.example.json
+// is written for, with a few extra data fields for the path forms
+const redactionEntry = `{
+	"id": "log-00000042",
+	"app": "usersvc",
+	"system": "host-1",
+	"module": "UserService",
+	"type": "C",
+	"pri": "Info",
+	"when": "2024-06-22T10:00:00Z",
+	"who": "admin@example.com",
+	"remote_ip": "10.0.0.7",
+	"trace_id": "trace-1",
+	"msg": "User updated",
+	"data": {
+		"username": "jsmith",
+		"change_data": {
+			"entity": "User",
+			"op": "Update",
+			"changes": [
+				{"field": "name", "old_value": "John Smith", "new_value": "Jon Smith"},
+				{"field": "email", "old_value": "john@example.com", "new_value": "jon@example.com"}
+			]
+		},
+		"contacts": [
+			{"kind": "work", "phone": "+919876543210"},
+			{"kind": "home", "phone": "+14155550100"}
+		],
+		"profiles": {
+			"home": {"city": "Pune", "nickname": "JS"},
+			"work": {"city": "Mumbai", "nickname": "John"}
+		}
+	}
+}`
+
+func testRedactor(t *testing.T, cfg RedactionConfig) *Redactor {
+	t.Helper()
+	r, err := NewRedactor(cfg, testHashKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return r
+}
+
+// redacted runs r over redactionEntry and returns the result in generic form
+func redacted(t *testing.T, r *Redactor) map[string]any {
+	t.Helper()
+	var logEntry LogEntry
+	if err := json.Unmarshal([]byte(redactionEntry), &logEntry); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.Redact(&logEntry); err != nil {
+		t.Fatal(err)
+	}
+	return toGeneric(t, logEntry)
+}
+
+func toGeneric(t *testing.T, v any) map[string]any {
+	t.Helper()
+	raw, err := json.Marshal(v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var doc map[string]any
+	if err := json.Unmarshal(raw, &doc); err != nil {
+		t.Fatal(err)
+	}
+	return doc
+}
+
+// original is redactionEntry after a round trip through LogEntry
+func original(t *testing.T) map[string]any {
+	t.Helper()
+	var logEntry LogEntry
+	if err := json.Unmarshal([]byte(redactionEntry), &logEntry); err != nil {
+		t.Fatal(err)
+	}
+	return toGeneric(t, logEntry)
+}
+
+func hashOf(value string) string {
+	mac := hmac.New(sha256.New, []byte(testHashKey))
+	mac.Write([]byte(value))
+	return "hmac:" + hex.EncodeToString(mac.Sum(nil))[:16]
+}
+
+// at returns the value at a dot-separated path, indexing arrays by number
+func at(t *testing.T, doc any, path string) any {
+	t.Helper()
+	node := doc
+	for _, key := range strings.Split(path, ".") {
+		switch n := node.(type) {
+		case map[string]any:
+			node = n[key]
+		case []any:
+			i := int(key[0] - '0')
+			node = n[i]
+		default:
+			t.Fatalf("%s: no %s in %v", path, key, node)
+		}
+	}
+	return node
+}
+
+func TestRedactFieldRules(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		path string
+		// targets are the values the path selects; everything else must
+		// come through unchanged
+		targets []string
+	}{
+		{"plain path", "data.username", []string{"data.username"}},
+		{"top-level field", "remote_ip", []string{"remote_ip"}},
+		{"filter", "data.change_data.changes[field=name].old_value", []string{"data.change_data.changes.0.old_value"}},
+		{"implicit array traversal", "data.contacts.phone", []string{"data.contacts.0.phone", "data.contacts.1.phone"}},
+		{"filter inside array traversal", "data.contacts[kind=home].phone", []string{"data.contacts.1.phone"}},
+		{"wildcard", "data.profiles.*.nickname", []string{"data.profiles.home.nickname", "data.profiles.work.nickname"}},
+		{"missing path", "data.address.city", nil},
+	} {
+		for _, mode := range []string{RedactMask, RedactHash, RedactDrop} {
+			t.Run(tc.name+"/"+mode, func(t *testing.T) {
+				r := testRedactor(t, RedactionConfig{Fields: []FieldRule{{Path: tc.path, Mode: mode}}})
+				got := redacted(t, r)
+
+				want := original(t)
+				for _, target := range tc.targets {
+					parent, key := want, target
+					if i := strings.LastIndexByte(target, '.'); i >= 0 {
+						parent, key = at(t, want, target[:i]).(map[string]any), target[i+1:]
+					}
+					switch mode {
+					case RedactMask:
+						parent[key] = "****"
+					case RedactHash:
+						parent[key] = hashOf(parent[key].(string))
+					case RedactDrop:
+						delete(parent, key)
+					}
+				}
+				if !reflect.DeepEqual(got, want) {
+					gotJSON, _ := json.MarshalIndent(got, "", "  ")
+					t.Errorf("redacted entry:\n%s", gotJSON)
+				}
+			})
+		}
+	}
+}
+
+func TestRedactFilterMasksOnlyMatchingChange(t *testing.T) {
+	r := testRedactor(t, RedactionConfig{Fields: []FieldRule{
+		{Path: "data.change_data.changes[field=name].old_value", Mode: RedactMask},
+	}})
+	got := redacted(t, r)
+
+	changes := at(t, got, "data.change_data.changes").([]any)
+	want := []any{
+		map[string]any{"field": "name", "old_value": "****", "new_value": "Jon Smith"},
+		map[string]any{"field": "email", "old_value": "john@example.com", "new_value": "jon@example.com"},
+	}
+	if !reflect.DeepEqual(changes, want) {
+		t.Errorf("changes = %v, want %v", changes, want)
+	}
+}
+
+func TestRedactDetectors(t *testing.T) {
+	for _, tc := range []struct {
+		name  string
+		rule  DetectorRule
+		check func(t *testing.T, doc map[string]any)
+	}{
+		{"mask email", DetectorRule{Name: "email", Mode: RedactMask}, func(t *testing.T, doc map[string]any) {
+			if doc["who"] != "****" {
+				t.Errorf("who = %v", doc["who"])
+			}
+			if got := at(t, doc, "data.change_data.changes.1.old_value"); got != "****" {
+				t.Errorf("old email = %v", got)
+			}
+			if got := at(t, doc, "data.change_data.changes.0.old_value"); got != "John Smith" {
+				t.Errorf("name = %v", got)
+			}
+		}},
+		{"hash email", DetectorRule{Name: "email", Mode: RedactHash}, func(t *testing.T, doc map[string]any) {
+			if doc["who"] != hashOf("admin@example.com") {
+				t.Errorf("who = %v", doc["who"])
+			}
+		}},
+		{"mask phone inside array", DetectorRule{Name: "e164", Mode: RedactMask}, func(t *testing.T, doc map[string]any) {
+			for _, path := range []string{"data.contacts.0.phone", "data.contacts.1.phone"} {
+				if got := at(t, doc, path); got != "****" {
+					t.Errorf("%s = %v", path, got)
+				}
+			}
+		}},
+		{"custom pattern masks only the match", DetectorRule{Name: "surname", Pattern: `Smith`, Mode: RedactMask}, func(t *testing.T, doc map[string]any) {
+			if got := at(t, doc, "data.change_data.changes.0.old_value"); got != "John ****" {
+				t.Errorf("old name = %v", got)
+			}
+		}},
+		{"drop removes the containing field", DetectorRule{Name: "email", Mode: RedactDrop}, func(t *testing.T, doc map[string]any) {
+			if _, ok := doc["who"]; ok {
+				t.Errorf("who = %v, want dropped", doc["who"])
+			}
+			change := at(t, doc, "data.change_data.changes.1").(map[string]any)
+			if _, ok := change["old_value"]; ok {
+				t.Errorf("change = %v, want old_value dropped", change)
+			}
+			if change["field"] != "email" {
+				t.Errorf("change = %v, want field kept", change)
+			}
+		}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			tc.check(t, redacted(t, testRedactor(t, RedactionConfig{Detectors: []DetectorRule{tc.rule}})))
+		})
+	}
+}
+
+func TestRedactDetectorDropInsideArray(t *testing.T) {
+	r := testRedactor(t, RedactionConfig{Detectors: []DetectorRule{{Name: "token", Mode: RedactDrop}}})
+	logEntry := LogEntry{ID: "log-1", Msg: "login", Data: map[string]any{
+		"headers": []any{"Accept: */*", "Authorization: Bearer abc.def", map[string]any{"token": "eyJa.eyJb.sig"}},
+	}}
+	if err := r.Redact(&logEntry); err != nil {
+		t.Fatal(err)
+	}
+	// A dropped array element is left as null so positions are kept
+	want := []any{"Accept: */*", nil, map[string]any{}}
+	if got := logEntry.Data["headers"]; !reflect.DeepEqual(got, want) {
+		t.Errorf("headers = %#v, want %#v", got, want)
+	}
+}
+
+func TestRedactNeverRewritesStructuralFields(t *testing.T) {
+	r := testRedactor(t, RedactionConfig{
+		Fields: []FieldRule{{Path: "*", Mode: RedactMask}},
+		Detectors: []DetectorRule{
+			{Name: "everything", Pattern: `.+`, Mode: RedactDrop},
+		},
+	})
+	got := redacted(t, r)
+
+	want := original(t)
+	for _, key := range structuralFields {
+		if !reflect.DeepEqual(got[key], want[key]) {
+			t.Errorf("%s = %v, want %v", key, got[key], want[key])
+		}
+	}
+	if got["remote_ip"] != "****" {
+		t.Errorf("remote_ip = %v, want masked", got["remote_ip"])
+	}
+
+	for _, path := range []string{"id", "when", "app", "tenant.name", "trace_id"} {
+		if _, err := NewRedactor(RedactionConfig{Fields: []FieldRule{{Path: path, Mode: RedactDrop}}}, ""); err == nil {
+			t.Errorf("field rule on %s accepted", path)
+		}
+	}
+}
+
+func TestNewRedactorRejectsBadRules(t *testing.T) {
+	for _, tc := range []struct {
+		name    string
+		cfg     RedactionConfig
+		hashKey string
+	}{
+		{"unknown mode", RedactionConfig{Fields: []FieldRule{{Path: "data.username", Mode: "blur"}}}, testHashKey},
+		{"hash without key", RedactionConfig{Fields: []FieldRule{{Path: "data.username", Mode: RedactHash}}}, ""},
+		{"empty path", RedactionConfig{Fields: []FieldRule{{Path: "", Mode: RedactMask}}}, ""},
+		{"empty segment", RedactionConfig{Fields: []FieldRule{{Path: "data..username", Mode: RedactMask}}}, ""},
+		{"unclosed filter", RedactionConfig{Fields: []FieldRule{{Path: "data.changes[field=name", Mode: RedactMask}}}, ""},
+		{"filter without value", RedactionConfig{Fields: []FieldRule{{Path: "data.changes[field].old_value", Mode: RedactMask}}}, ""},
+		{"unknown detector", RedactionConfig{Detectors: []DetectorRule{{Name: "ssn", Mode: RedactMask}}}, ""},
+		{"bad pattern", RedactionConfig{Detectors: []DetectorRule{{Name: "custom", Pattern: "(", Mode: RedactMask}}}, ""},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			if _, err := NewRedactor(tc.cfg, tc.hashKey); err == nil {
+				t.Error("config accepted")
+			}
+		})
+	}
+}
+
+func TestLoadRedactorExample(t *testing.T) {
+	r, err := LoadRedactor("redaction.example.json", testHashKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := redacted(t, r)
+
+	if got["data"].(map[string]any)["username"] != hashOf("jsmith") {
+		t.Errorf("username = %v", at(t, got, "data.username"))
+	}
+	if _, ok := got["remote_ip"]; ok {
+		t.Error("remote_ip not dropped")
+	}
+	want := []any{
+		map[string]any{"field": "name", "old_value": "****", "new_value": "****"},
+		map[string]any{"field": "email", "old_value": hashOf("john@example.com"), "new_value": hashOf("jon@example.com")},
+	}
+	if changes := at(t, got, "data.change_data.changes"); !reflect.DeepEqual(changes, want) {
+		t.Errorf("changes = %v, want %v", changes, want)
+	}
+
+	// Without a hash key the example's hash rules are refused
+	if _, err := LoadRedactor("redaction.example.json", ""); err == nil {
+		t.Error("hash rules loaded without a key")
+	}
+	bad := filepath.Join(t.TempDir(), "redaction.json")
+	os.WriteFile(bad, []byte(`{"fields": [`), 0o600)
+	if _, err := LoadRedactor(bad, testHashKey); err == nil {
+		t.Error("malformed config loaded")
+	}
+}
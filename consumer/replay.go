@@ -0,0 +1,372 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"sort"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"github.com/IBM/sarama"
+	"golang.org/x/time/rate"
+)
+
+// replayOptions are the flags of the replay subcommand
+type replayOptions struct {
+	fromOffset int64
+	from       time.Time
+	to         time.Time
+	partition  int
+	index      string
+	rate       float64
+	batchSize  int
+	dryRun     bool
+}
+
+// partitionRange is the half-open offset range [start, end) to replay
+type partitionRange struct {
+	partition int32
+	start     int64
+	end       int64
+}
+
+// replayStats are updated by the reader and read by the progress reporter
+type replayStats struct {
+	read     atomic.Int64
+	written  atomic.Int64
+	failed   atomic.Int64
+	invalid  atomic.Int64
+	byType   map[string]int64 // only touched by the reader goroutine
+	position map[int32]*atomic.Int64
+}
+
+// runReplay re-reads a topic range into a target index. It uses a plain
+// partition consumer rather than the consumer group, so the live consumer's
+// offsets are never touched.
+func runReplay(args []string) {
+	fs := flag.NewFlagSet("replay", flag.ExitOnError)
+	fromOffset := fs.Int64("from-offset", -1, "first offset to replay in each partition")
+	from := fs.String("from", "", "replay messages at or after this time (RFC3339)")
+	to := fs.String("to", "", "replay messages before this time (RFC3339); defaults to the current end of the topic")
+	partition := fs.Int("partition", -1, "replay only this partition")
+	index := fs.String("index", "", "target index; defaults to the daily logharbour-{type}-{date} indices")
+	limit := fs.Float64("rate", 0, "maximum messages per second (0 for unlimited)")
+	batchSize := fs.Int("batch", 500, "entries per bulk request")
+	dryRun := fs.Bool("dry-run", false, "count messages without writing them")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: consumer replay [flags]\n\nRe-reads %s from Kafka into Elasticsearch.\n\nFlags:\n", getEnv("KAFKA_TOPIC", "logharbour-logs"))
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+
+	opts := replayOptions{
+		fromOffset: *fromOffset,
+		partition:  *partition,
+		index:      *index,
+		rate:       *limit,
+		batchSize:  *batchSize,
+		dryRun:     *dryRun,
+	}
+	var err error
+	if *from != "" {
+		if opts.from, err = time.Parse(time.RFC3339, *from); err != nil {
+			log.Fatalf("Invalid -from: %s", err)
+		}
+	}
+	if *to != "" {
+		if opts.to, err = time.Parse(time.RFC3339, *to); err != nil {
+			log.Fatalf("Invalid -to: %s", err)
+		}
+	}
+	if opts.fromOffset >= 0 && !opts.from.IsZero() {
+		log.Fatalf("Use either -from-offset or -from, not both")
+	}
+	if opts.batchSize <= 0 {
+		log.Fatalf("-batch must be positive")
+	}
+
+	cfg := loadConfig()
+
+	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer cancel()
+
+	if err := replay(ctx, cfg, opts); err != nil {
+		log.Fatalf("Replay failed: %s", err)
+	}
+}
+
+func replay(ctx context.Context, cfg Config, opts replayOptions) error {
+	config := sarama.NewConfig()
+	config.Version = sarama.V2_6_0_0
+	config.Consumer.Return.Errors = true
+
+	client, err := sarama.NewClient(cfg.KafkaBrokers, config)
+	if err != nil {
+		return fmt.Errorf("error creating Kafka client: %w", err)
+	}
+	defer client.Close()
+
+	ranges, err := replayRanges(client, cfg.Topic, opts)
+	if err != nil {
+		return err
+	}
+
+	var total int64
+	for _, r := range ranges {
+		log.Printf("Partition %d: offsets %d to %d (%d messages)", r.partition, r.start, r.end, r.end-r.start)
+		total += r.end - r.start
+	}
+	log.Printf("%d messages to replay from %s", total, cfg.Topic)
+	if total == 0 {
+		return nil
+	}
+
+	// Replayed messages go through the same validation, redaction and
+	// enrichment as live ones; rejected messages are counted but not
+	// dead-lettered again
+	pipeline := &Consumer{cfg: cfg}
+	if cfg.SchemaValidation {
+		if pipeline.validator, err = NewValidator(); err != nil {
+			return fmt.Errorf("error loading message schemas: %w", err)
+		}
+	}
+	if cfg.RedactionConfig != "" {
+		if pipeline.redactor, err = LoadRedactor(cfg.RedactionConfig, cfg.RedactionHashKey); err != nil {
+			return fmt.Errorf("error loading redaction rules: %w", err)
+		}
+	}
+
+	if pipeline.enricher, err = NewEnricher(cfg); err != nil {
+		return fmt.Errorf("error creating enricher: %w", err)
+	}
+	defer pipeline.enricher.Close()
+
+	var sink Sink
+	if !opts.dryRun {
+		if sink, err = NewElasticsearchSink(cfg.ElasticsearchURL, opts.index); err != nil {
+			return err
+		}
+		defer sink.Close()
+	}
+
+	consumer, err := sarama.NewConsumerFromClient(client)
+	if err != nil {
+		return fmt.Errorf("error creating Kafka consumer: %w", err)
+	}
+	defer consumer.Close()
+
+	limiter := rate.NewLimiter(rate.Inf, 1)
+	if opts.rate > 0 {
+		limiter = rate.NewLimiter(rate.Limit(opts.rate), opts.batchSize)
+	}
+
+	stats := &replayStats{byType: make(map[string]int64), position: make(map[int32]*atomic.Int64)}
+	for _, r := range ranges {
+		stats.position[r.partition] = &atomic.Int64{}
+		stats.position[r.partition].Store(r.start)
+	}
+
+	progressDone := make(chan struct{})
+	go reportProgress(ranges, stats, total, progressDone)
+	defer close(progressDone)
+
+	start := time.Now()
+	for _, r := range ranges {
+		if err := replayPartition(ctx, consumer, cfg.Topic, r, opts, limiter, pipeline, sink, stats); err != nil {
+			return err
+		}
+	}
+
+	elapsed := time.Since(start)
+	log.Printf("Replay finished in %s: read %d, written %d, rejected %d, invalid %d",
+		elapsed.Round(time.Millisecond), stats.read.Load(), stats.written.Load(), stats.failed.Load(), stats.invalid.Load())
+	if opts.dryRun {
+		types := make([]string, 0, len(stats.byType))
+		for t := range stats.byType {
+			types = append(types, t)
+		}
+		sort.Strings(types)
+		for _, t := range types {
+			log.Printf("  type %s: %d entries", t, stats.byType[t])
+		}
+	}
+	return nil
+}
+
+// replayRanges resolves the offset range of every selected partition
+func replayRanges(client sarama.Client, topic string, opts replayOptions) ([]partitionRange, error) {
+	partitions, err := client.Partitions(topic)
+	if err != nil {
+		return nil, fmt.Errorf("error listing partitions of %s: %w", topic, err)
+	}
+
+	var ranges []partitionRange
+	for _, p := range partitions {
+		if opts.partition >= 0 && int32(opts.partition) != p {
+			continue
+		}
+		oldest, err := client.GetOffset(topic, p, sarama.OffsetOldest)
+		if err != nil {
+			return nil, fmt.Errorf("error getting oldest offset of partition %d: %w", p, err)
+		}
+		newest, err := client.GetOffset(topic, p, sarama.OffsetNewest)
+		if err != nil {
+			return nil, fmt.Errorf("error getting newest offset of partition %d: %w", p, err)
+		}
+
+		r := partitionRange{partition: p, start: oldest, end: newest}
+		switch {
+		case opts.fromOffset >= 0:
+			r.start = max(opts.fromOffset, oldest)
+		case !opts.from.IsZero():
+			if r.start, err = offsetForTime(client, topic, p, opts.from, newest); err != nil {
+				return nil, err
+			}
+		}
+		if !opts.to.IsZero() {
+			if r.end, err = offsetForTime(client, topic, p, opts.to, newest); err != nil {
+				return nil, err
+			}
+		}
+		if r.end < r.start {
+			r.end = r.start
+		}
+		ranges = append(ranges, r)
+	}
+	if opts.partition >= 0 && len(ranges) == 0 {
+		return nil, fmt.Errorf("partition %d not found in %s", opts.partition, topic)
+	}
+	return ranges, nil
+}
+
+// offsetForTime returns the first offset with a timestamp at or after t, or
+// newest if there is none
+func offsetForTime(client sarama.Client, topic string, partition int32, t time.Time, newest int64) (int64, error) {
+	offset, err := client.GetOffset(topic, partition, t.UnixMilli())
+	if err != nil {
+		return 0, fmt.Errorf("error looking up offset for %s in partition %d: %w", t.Format(time.RFC3339), partition, err)
+	}
+	if offset < 0 {
+		return newest, nil
+	}
+	return offset, nil
+}
+
+// replayIdleWait is how long replayPartition waits for another message once
+// the partition's high water mark has reached the end of the range. Offsets
+// taken by transaction markers or removed by compaction are never delivered,
+// so the message just before the end may not exist.
+var replayIdleWait = 2 * time.Second
+
+func replayPartition(ctx context.Context, consumer sarama.Consumer, topic string, r partitionRange, opts replayOptions,
+	limiter *rate.Limiter, pipeline *Consumer, sink Sink, stats *replayStats) error {
+	if r.start >= r.end {
+		return nil
+	}
+
+	pc, err := consumer.ConsumePartition(topic, r.partition, r.start)
+	if err != nil {
+		return fmt.Errorf("error consuming partition %d: %w", r.partition, err)
+	}
+	defer pc.Close()
+
+	var entries []LogEntry
+	flush := func() {
+		if len(entries) == 0 {
+			return
+		}
+		if !opts.dryRun {
+			err := sink.Write(ctx, entries)
+			failed := 0
+			var partial *PartialWriteError
+			if errors.As(err, &partial) {
+				failed = partial.Failed
+			} else if err != nil {
+				failed = len(entries)
+			}
+			if err != nil {
+				log.Printf("Error writing replayed logs: %v", err)
+			}
+			stats.written.Add(int64(len(entries) - failed))
+			stats.failed.Add(int64(failed))
+		}
+		entries = entries[:0]
+	}
+	defer flush()
+
+	idle := time.NewTimer(replayIdleWait)
+	defer idle.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case err := <-pc.Errors():
+			return fmt.Errorf("error reading partition %d: %w", r.partition, err)
+		case <-idle.C:
+			if pc.HighWaterMarkOffset() >= r.end {
+				stats.position[r.partition].Store(r.end)
+				return nil
+			}
+			idle.Reset(replayIdleWait)
+		case message := <-pc.Messages():
+			// After a gap the next message may already be past the range
+			if message.Offset >= r.end {
+				stats.position[r.partition].Store(r.end)
+				return nil
+			}
+			if err := limiter.Wait(ctx); err != nil {
+				return err
+			}
+			stats.read.Add(1)
+			stats.position[r.partition].Store(message.Offset + 1)
+
+			if logEntry, ok := pipeline.process(message); !ok {
+				stats.invalid.Add(1)
+			} else {
+				stats.byType[logEntry.Type]++
+				entries = append(entries, logEntry)
+			}
+
+			if len(entries) >= opts.batchSize {
+				flush()
+			}
+			if message.Offset+1 >= r.end {
+				return nil
+			}
+			// Restart the wait only now, so a slow -rate does not count as idle
+			idle.Stop()
+			select {
+			case <-idle.C:
+			default:
+			}
+			idle.Reset(replayIdleWait)
+		}
+	}
+}
+
+// reportProgress logs replay progress every few seconds until done is closed
+func reportProgress(ranges []partitionRange, stats *replayStats, total int64, done <-chan struct{}) {
+	ticker := time.NewTicker(5 * time.Second)
+	defer ticker.Stop()
+	start := time.Now()
+	for {
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+			read := stats.read.Load()
+			perSec := float64(read) / time.Since(start).Seconds()
+			log.Printf("Progress: %d/%d messages (%.1f%%), %.0f msg/s, written %d",
+				read, total, 100*float64(read)/float64(total), perSec, stats.written.Load())
+			for _, r := range ranges {
+				log.Printf("  partition %d: offset %d of %d", r.partition, stats.position[r.partition].Load(), r.end)
+			}
+		}
+	}
+}
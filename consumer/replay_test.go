@@ -0,0 +1,187 @@
+package main
+
+import (
+	"context"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/IBM/sarama"
+	"github.com/IBM/sarama/mocks"
+	"golang.org/x/time/rate"
+)
+
+func TestReplayPartitionStopsAtRangeEnd(t *testing.T) {
+	es := newFakeElasticsearch(t)
+	sink, err := NewElasticsearchSink(es.URL, "logharbour-replay")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sink.Close()
+
+	consumer := mocks.NewConsumer(t, nil)
+	pc := consumer.ExpectConsumePartition("logharbour-logs", 0, 5)
+	gen := newEntryGenerator(9)
+	for _, logEntry := range gen.entries(4) {
+		pc.YieldMessage(&sarama.ConsumerMessage{Value: mustJSON(t, logEntry)})
+	}
+	pc.YieldMessage(&sarama.ConsumerMessage{Value: []byte(`not json`)})
+	for _, logEntry := range gen.entries(3) {
+		pc.YieldMessage(&sarama.ConsumerMessage{Value: mustJSON(t, logEntry)})
+	}
+
+	pipeline := newTestConsumer(t, testConfig(), es)
+	pipeline.sinks = nil
+	stats := &replayStats{byType: make(map[string]int64), position: map[int32]*atomic.Int64{0: {}}}
+
+	// Offsets 5 to 10 hold four entries and the malformed message; the
+	// remaining three are past the end of the range and must not be read
+	r := partitionRange{partition: 0, start: 5, end: 10}
+	opts := replayOptions{batchSize: 2}
+	if err := replayPartition(context.Background(), consumer, "logharbour-logs", r, opts, rate.NewLimiter(rate.Inf, 1), pipeline, sink, stats); err != nil {
+		t.Fatal(err)
+	}
+
+	if got := stats.read.Load(); got != 5 {
+		t.Errorf("read %d messages, want 5", got)
+	}
+	if got := stats.invalid.Load(); got != 1 {
+		t.Errorf("invalid = %d, want 1", got)
+	}
+	if got := stats.written.Load(); got != 4 {
+		t.Errorf("written = %d, want 4", got)
+	}
+	if got := stats.position[0].Load(); got != 10 {
+		t.Errorf("position = %d, want 10", got)
+	}
+	es.mu.Lock()
+	defer es.mu.Unlock()
+	if got := es.indices["logharbour-replay"]; got != 4 {
+		t.Errorf("indexed %d documents into logharbour-replay, want 4", got)
+	}
+	if err := consumer.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestReplayWritesToOriginalDailyIndices(t *testing.T) {
+	es := newFakeElasticsearch(t)
+	sink, err := NewElasticsearchSink(es.URL, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sink.Close()
+
+	consumer := mocks.NewConsumer(t, nil)
+	pc := consumer.ExpectConsumePartition("logharbour-logs", 0, 0)
+	for _, logEntry := range newEntryGenerator(3).entries(6) {
+		pc.YieldMessage(&sarama.ConsumerMessage{Value: mustJSON(t, logEntry)})
+	}
+
+	pipeline := newTestConsumer(t, testConfig(), es)
+	pipeline.sinks = nil
+	stats := &replayStats{byType: make(map[string]int64), position: map[int32]*atomic.Int64{0: {}}}
+
+	// Without -index, entries logged on 2024-06-22 go back to that day's
+	// indices rather than today's, where they would be second copies
+	r := partitionRange{partition: 0, start: 0, end: 6}
+	if err := replayPartition(context.Background(), consumer, "logharbour-logs", r, replayOptions{batchSize: 4}, rate.NewLimiter(rate.Inf, 1), pipeline, sink, stats); err != nil {
+		t.Fatal(err)
+	}
+
+	es.mu.Lock()
+	defer es.mu.Unlock()
+	total := 0
+	for index, count := range es.indices {
+		if !strings.HasSuffix(index, "-2024.06.22") {
+			t.Errorf("replayed %d documents into %s, want the 2024.06.22 indices", count, index)
+		}
+		total += count
+	}
+	if total != 6 {
+		t.Errorf("indexed %d documents, want 6", total)
+	}
+	if err := consumer.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// gapConsumer serves one partition whose messages carry the offsets they were
+// given, unlike the mocks, which number messages consecutively
+type gapConsumer struct {
+	sarama.Consumer
+	pc *gapPartitionConsumer
+}
+
+func (c *gapConsumer) ConsumePartition(string, int32, int64) (sarama.PartitionConsumer, error) {
+	return c.pc, nil
+}
+
+type gapPartitionConsumer struct {
+	sarama.PartitionConsumer
+	messages      chan *sarama.ConsumerMessage
+	highWaterMark int64
+}
+
+func (pc *gapPartitionConsumer) Messages() <-chan *sarama.ConsumerMessage { return pc.messages }
+func (pc *gapPartitionConsumer) Errors() <-chan *sarama.ConsumerError     { return nil }
+func (pc *gapPartitionConsumer) HighWaterMarkOffset() int64               { return pc.highWaterMark }
+func (pc *gapPartitionConsumer) Close() error                             { return nil }
+
+func TestReplayPartitionSkipsOffsetGaps(t *testing.T) {
+	defer func(wait time.Duration) { replayIdleWait = wait }(replayIdleWait)
+	replayIdleWait = 50 * time.Millisecond
+
+	gen := newEntryGenerator(5)
+	for _, tc := range []struct {
+		name    string
+		offsets []int64
+		end     int64
+		written int64
+	}{
+		// Offset 3 is a transaction marker and 5 the commit marker ending the
+		// range; neither is delivered, so the range ends on the idle wait
+		{"range ends in a gap", []int64{0, 1, 2, 4}, 6, 4},
+		// Offset 3 was compacted away; offset 4 is past the range and must
+		// not be written
+		{"message past the range", []int64{0, 1, 2, 4}, 4, 3},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			es := newFakeElasticsearch(t)
+			sink, err := NewElasticsearchSink(es.URL, "logharbour-replay")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer sink.Close()
+
+			pc := &gapPartitionConsumer{messages: make(chan *sarama.ConsumerMessage, len(tc.offsets)), highWaterMark: tc.end}
+			for i, logEntry := range gen.entries(len(tc.offsets)) {
+				pc.messages <- &sarama.ConsumerMessage{Offset: tc.offsets[i], Value: mustJSON(t, logEntry)}
+			}
+
+			pipeline := newTestConsumer(t, testConfig(), es)
+			pipeline.sinks = nil
+			stats := &replayStats{byType: make(map[string]int64), position: map[int32]*atomic.Int64{0: {}}}
+
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+			r := partitionRange{partition: 0, start: 0, end: tc.end}
+			if err := replayPartition(ctx, &gapConsumer{pc: pc}, "logharbour-logs", r, replayOptions{batchSize: 10}, rate.NewLimiter(rate.Inf, 1), pipeline, sink, stats); err != nil {
+				t.Fatal(err)
+			}
+
+			if got := stats.written.Load(); got != tc.written {
+				t.Errorf("written = %d, want %d", got, tc.written)
+			}
+			if got := stats.position[0].Load(); got != tc.end {
+				t.Errorf("position = %d, want %d", got, tc.end)
+			}
+			es.mu.Lock()
+			defer es.mu.Unlock()
+			if got := es.indices["logharbour-replay"]; int64(got) != tc.written {
+				t.Errorf("indexed %d documents, want %d", got, tc.written)
+			}
+		})
+	}
+}
@@ -0,0 +1,328 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"path"
+	"sort"
+	"strings"
+	"syscall"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+// archiveFilter selects archived objects by partition
+type archiveFilter struct {
+	from time.Time
+	to   time.Time
+	app  string
+	typ  string
+}
+
+// keys lists the objects whose partition overlaps the filter
+func (f archiveFilter) keys(ctx context.Context, store ObjectStore) ([]string, error) {
+	prefix := ""
+	if f.app != "" {
+		prefix = "app=" + archiveSegment(f.app) + "/"
+	}
+	all, err := store.List(ctx, prefix)
+	if err != nil {
+		return nil, err
+	}
+	var keys []string
+	for _, key := range all {
+		k, ok := parseArchiveKey(key)
+		if !ok {
+			continue
+		}
+		if f.typ != "" && k.typ != strings.ToUpper(f.typ) {
+			continue
+		}
+		if !f.from.IsZero() && !k.hour.Add(time.Hour).After(f.from) {
+			continue
+		}
+		if !f.to.IsZero() && !k.hour.Before(f.to) {
+			continue
+		}
+		keys = append(keys, key)
+	}
+	return keys, nil
+}
+
+// restoreOptions are the flags of the restore subcommand
+type restoreOptions struct {
+	archiveFilter
+	index     string
+	rate      float64
+	batchSize int
+	dryRun    bool
+}
+
+// restoreStats counts restored entries
+type restoreStats struct {
+	objects int
+	read    int64
+	written int64
+	failed  int64
+}
+
+// runRestore re-indexes a time range of the archive into Elasticsearch
+func runRestore(args []string) {
+	fs := flag.NewFlagSet("restore", flag.ExitOnError)
+	from := fs.String("from", "", "restore entries at or after this time (RFC3339)")
+	to := fs.String("to", "", "restore entries before this time (RFC3339)")
+	app := fs.String("app", "", "restore only this app")
+	typ := fs.String("type", "", "restore only this log type (A, C or D)")
+	index := fs.String("index", "", "target index; defaults to the daily logharbour-{type}-{date} indices")
+	limit := fs.Float64("rate", 0, "maximum entries per second (0 for unlimited)")
+	batchSize := fs.Int("batch", 500, "entries per bulk request")
+	dryRun := fs.Bool("dry-run", false, "count entries without writing them")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: consumer restore -from TIME -to TIME [flags]\n\nRe-indexes archived logs from %s into Elasticsearch.\n\nFlags:\n", getEnv("ARCHIVE_URL", "ARCHIVE_URL"))
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+
+	opts := restoreOptions{
+		archiveFilter: archiveFilter{app: *app, typ: *typ},
+		index:         *index,
+		rate:          *limit,
+		batchSize:     *batchSize,
+		dryRun:        *dryRun,
+	}
+	var err error
+	if opts.from, err = time.Parse(time.RFC3339, *from); err != nil {
+		log.Fatalf("Invalid -from: %s", err)
+	}
+	if opts.to, err = time.Parse(time.RFC3339, *to); err != nil {
+		log.Fatalf("Invalid -to: %s", err)
+	}
+	if !opts.from.Before(opts.to) {
+		log.Fatalf("-from must be before -to")
+	}
+	if opts.batchSize <= 0 {
+		log.Fatalf("-batch must be positive")
+	}
+
+	cfg := loadConfig()
+	store, err := openObjectStore(cfg.ArchiveURL, cfg.ArchiveS3)
+	if err != nil {
+		log.Fatalf("Error opening archive: %s", err)
+	}
+
+	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer cancel()
+
+	var sink Sink
+	if !opts.dryRun {
+		if sink, err = NewElasticsearchSink(cfg.ElasticsearchURL, opts.index); err != nil {
+			log.Fatalf("Error connecting to Elasticsearch: %s", err)
+		}
+		defer sink.Close()
+	}
+
+	start := time.Now()
+	stats, err := restore(ctx, store, opts, sink)
+	log.Printf("Restored %d objects in %s: read %d, written %d, rejected %d",
+		stats.objects, time.Since(start).Round(time.Millisecond), stats.read, stats.written, stats.failed)
+	if err != nil {
+		log.Fatalf("Restore failed: %s", err)
+	}
+}
+
+// restore writes the archived entries in opts' time range to sink. Entries
+// are written as archived, since they were validated, redacted and
+// enriched before they were archived.
+func restore(ctx context.Context, store ObjectStore, opts restoreOptions, sink Sink) (restoreStats, error) {
+	var stats restoreStats
+	keys, err := opts.keys(ctx, store)
+	if err != nil {
+		return stats, err
+	}
+	log.Printf("%d archived objects overlap %s to %s", len(keys), opts.from.Format(time.RFC3339), opts.to.Format(time.RFC3339))
+
+	limiter := rate.NewLimiter(rate.Inf, 1)
+	if opts.rate > 0 {
+		limiter = rate.NewLimiter(rate.Limit(opts.rate), opts.batchSize)
+	}
+
+	var entries []LogEntry
+	flush := func() error {
+		if len(entries) == 0 || opts.dryRun {
+			entries = entries[:0]
+			return nil
+		}
+		if err := limiter.WaitN(ctx, len(entries)); err != nil {
+			return err
+		}
+		err := sink.Write(ctx, entries)
+		failed := 0
+		var partial *PartialWriteError
+		if errors.As(err, &partial) {
+			failed = partial.Failed
+		} else if err != nil {
+			failed = len(entries)
+		}
+		if err != nil {
+			log.Printf("Error writing restored logs: %v", err)
+		}
+		stats.written += int64(len(entries) - failed)
+		stats.failed += int64(failed)
+		entries = entries[:0]
+		return ctx.Err()
+	}
+
+	for _, key := range keys {
+		err := readArchive(ctx, store, key, func(logEntry LogEntry) error {
+			if when, err := time.Parse(time.RFC3339Nano, logEntry.When); err == nil &&
+				(when.Before(opts.from) || !when.Before(opts.to)) {
+				return nil
+			}
+			stats.read++
+			entries = append(entries, logEntry)
+			if len(entries) >= opts.batchSize {
+				return flush()
+			}
+			return nil
+		})
+		if err != nil {
+			return stats, err
+		}
+		stats.objects++
+	}
+	return stats, flush()
+}
+
+// compactOptions are the flags of the compact subcommand
+type compactOptions struct {
+	archiveFilter
+	dryRun bool
+}
+
+// runCompact merges the objects of each completed archive hour into one
+func runCompact(args []string) {
+	fs := flag.NewFlagSet("compact", flag.ExitOnError)
+	before := fs.String("before", "", "compact hours that ended before this time (RFC3339); defaults to two hours ago")
+	app := fs.String("app", "", "compact only this app")
+	dryRun := fs.Bool("dry-run", false, "list the partitions that would be compacted")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: consumer compact [flags]\n\nMerges the archive files of each hour into a single file.\n\nFlags:\n")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+
+	// Consumers may still upload files for the last hour or so
+	opts := compactOptions{archiveFilter: archiveFilter{app: *app, to: time.Now().Add(-2 * time.Hour)}, dryRun: *dryRun}
+	if *before != "" {
+		var err error
+		if opts.to, err = time.Parse(time.RFC3339, *before); err != nil {
+			log.Fatalf("Invalid -before: %s", err)
+		}
+	}
+	opts.to = opts.to.Truncate(time.Hour)
+
+	cfg := loadConfig()
+	store, err := openObjectStore(cfg.ArchiveURL, cfg.ArchiveS3)
+	if err != nil {
+		log.Fatalf("Error opening archive: %s", err)
+	}
+
+	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer cancel()
+
+	if err := compact(ctx, store, opts); err != nil {
+		log.Fatalf("Compaction failed: %s", err)
+	}
+}
+
+// compact replaces the objects of every partition with more than one by a
+// single object, dropping entries archived twice. The merged object is
+// uploaded before the originals are deleted, so an interrupted compaction
+// leaves duplicates rather than gaps. A restore writes those duplicates only
+// once: entries keep their log ID as _id and, unless -index is given, go to
+// the daily index of the day they were logged, where their originals are.
+func compact(ctx context.Context, store ObjectStore, opts compactOptions) error {
+	keys, err := opts.keys(ctx, store)
+	if err != nil {
+		return err
+	}
+	partitions := make(map[string][]string)
+	for _, key := range keys {
+		dir := path.Dir(key)
+		partitions[dir] = append(partitions[dir], key)
+	}
+	dirs := make([]string, 0, len(partitions))
+	for dir, objects := range partitions {
+		if len(objects) > 1 {
+			dirs = append(dirs, dir)
+		}
+	}
+	sort.Strings(dirs)
+
+	for _, dir := range dirs {
+		objects := partitions[dir]
+		if opts.dryRun {
+			log.Printf("Would compact %d objects in %s", len(objects), dir)
+			continue
+		}
+		n, err := compactPartition(ctx, store, dir, objects)
+		if err != nil {
+			return fmt.Errorf("error compacting %s: %w", dir, err)
+		}
+		log.Printf("Compacted %d objects in %s into %d entries", len(objects), dir, n)
+	}
+	return nil
+}
+
+func compactPartition(ctx context.Context, store ObjectStore, dir string, objects []string) (int, error) {
+	seen := make(map[string]bool)
+	var entries []LogEntry
+	for _, key := range objects {
+		err := readArchive(ctx, store, key, func(logEntry LogEntry) error {
+			if logEntry.ID != "" {
+				if seen[logEntry.ID] {
+					return nil
+				}
+				seen[logEntry.ID] = true
+			}
+			entries = append(entries, logEntry)
+			return nil
+		})
+		if err != nil {
+			return 0, err
+		}
+	}
+	sort.SliceStable(entries, func(i, j int) bool { return entries[i].When < entries[j].When })
+
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	enc := json.NewEncoder(gz)
+	for _, logEntry := range entries {
+		if err := enc.Encode(logEntry); err != nil {
+			return 0, err
+		}
+	}
+	if err := gz.Close(); err != nil {
+		return 0, err
+	}
+
+	merged := fmt.Sprintf("%s/compacted-%s.ndjson.gz", dir, time.Now().UTC().Format("20060102T150405Z"))
+	if err := store.Put(ctx, merged, bytes.NewReader(buf.Bytes())); err != nil {
+		return 0, err
+	}
+	for _, key := range objects {
+		if err := store.Delete(ctx, key); err != nil {
+			return 0, err
+		}
+	}
+	return len(entries), nil
+}
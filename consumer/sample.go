@@ -0,0 +1,262 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"hash/fnv"
+	"log"
+	mathrand "math/rand"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/hashicorp/golang-lru/v2/expirable"
+	"golang.org/x/time/rate"
+)
+
+// priorityRank orders LogHarbour priorities from least to most severe
+var priorityRank = map[string]int{
+	"Debug2": 0,
+	"Debug1": 1,
+	"Debug0": 2,
+	"Info":   3,
+	"Warn":   4,
+	"Err":    5,
+	"Crit":   6,
+	"Sec":    7,
+}
+
+// alwaysKeepRank is the rank from which entries are never sampled (Warn)
+const alwaysKeepRank = 4
+
+// Sampler drops a share of low-priority entries. Rates are set per priority,
+// optionally per module. Decisions for entries with a trace_id depend only
+// on the trace, so every entry of a sampled trace is kept, and a trace with
+// any kept entry (such as a Warn) keeps its later debug entries too. The
+// per-module limit is applied to traces as a whole: it is consulted on the
+// first sampled entry of a trace, and its answer holds for the rest.
+type Sampler struct {
+	rates       map[string]float64 // priority -> keep rate
+	moduleRates map[string]float64 // module + ":" + priority -> keep rate
+	limit       float64            // max sampled entries kept per module per second, 0 for no limit
+
+	keptTraces    *expirable.LRU[string, struct{}]
+	limitedTraces *expirable.LRU[string, struct{}] // selected traces the limit turned away
+
+	mu       sync.Mutex
+	limiters map[string]*rate.Limiter
+	dropped  map[string]map[string]int64 // module -> priority -> count since last report
+	since    time.Time
+}
+
+// NewSampler parses rates in the form "[module:]priority=rate,...". Rates
+// for Warn and above are rejected since those entries are always kept.
+func NewSampler(rates string, limit float64, traceWindow time.Duration) (*Sampler, error) {
+	s := &Sampler{
+		rates:         make(map[string]float64),
+		moduleRates:   make(map[string]float64),
+		limit:         limit,
+		keptTraces:    expirable.NewLRU[string, struct{}](100000, nil, traceWindow),
+		limitedTraces: expirable.NewLRU[string, struct{}](100000, nil, traceWindow),
+		limiters:      make(map[string]*rate.Limiter),
+		dropped:       make(map[string]map[string]int64),
+		since:         time.Now(),
+	}
+
+	for _, item := range strings.Split(rates, ",") {
+		if item = strings.TrimSpace(item); item == "" {
+			continue
+		}
+		key, value, ok := strings.Cut(item, "=")
+		if !ok {
+			return nil, fmt.Errorf("invalid sampling rate %q, expected [module:]priority=rate", item)
+		}
+		module, pri, hasModule := strings.Cut(key, ":")
+		if !hasModule {
+			module, pri = "", key
+		}
+		rank, ok := priorityRank[pri]
+		if !ok {
+			return nil, fmt.Errorf("invalid sampling rate %q: unknown priority %q", item, pri)
+		}
+		if rank >= alwaysKeepRank {
+			return nil, fmt.Errorf("invalid sampling rate %q: Warn and above are always kept", item)
+		}
+		keep, err := strconv.ParseFloat(value, 64)
+		if err != nil || keep < 0 || keep > 1 {
+			return nil, fmt.Errorf("invalid sampling rate %q: rate must be between 0 and 1", item)
+		}
+		if hasModule {
+			s.moduleRates[module+":"+pri] = keep
+		} else {
+			s.rates[pri] = keep
+		}
+	}
+	return s, nil
+}
+
+// Keep decides whether logEntry is written, and counts it if not
+func (s *Sampler) Keep(logEntry *LogEntry) bool {
+	rank, known := priorityRank[logEntry.Priority]
+	if !known || rank >= alwaysKeepRank {
+		s.remember(logEntry.TraceID)
+		return true
+	}
+	if logEntry.TraceID != "" && s.keptTraces.Contains(logEntry.TraceID) {
+		return true
+	}
+
+	keepRate, sampled := s.rateFor(logEntry.Module, logEntry.Priority)
+	if !sampled {
+		s.remember(logEntry.TraceID)
+		return true
+	}
+
+	var keep bool
+	if logEntry.TraceID != "" {
+		keep = traceFraction(logEntry.TraceID) < keepRate && !s.limitedTraces.Contains(logEntry.TraceID)
+		if keep && !s.allow(logEntry.Module) {
+			// Later entries of the trace are dropped too, even once the
+			// limit would let them through
+			s.limitedTraces.Add(logEntry.TraceID, struct{}{})
+			keep = false
+		}
+	} else {
+		keep = mathrand.Float64() < keepRate && s.allow(logEntry.Module)
+	}
+
+	if keep {
+		s.remember(logEntry.TraceID)
+		sampledEntries.WithLabelValues(logEntry.Priority, "kept").Inc()
+		return true
+	}
+	s.countDrop(logEntry.Module, logEntry.Priority)
+	sampledEntries.WithLabelValues(logEntry.Priority, "dropped").Inc()
+	return false
+}
+
+// rateFor returns the keep rate for module and priority. sampled is false
+// when no rate is configured, in which case everything is kept.
+func (s *Sampler) rateFor(module, pri string) (keepRate float64, sampled bool) {
+	if r, ok := s.moduleRates[module+":"+pri]; ok {
+		return r, true
+	}
+	r, ok := s.rates[pri]
+	return r, ok
+}
+
+// allow applies the per-module limit on kept sampled entries, so a module
+// that suddenly logs far more debug output is sampled harder
+func (s *Sampler) allow(module string) bool {
+	if s.limit <= 0 {
+		return true
+	}
+	s.mu.Lock()
+	limiter, ok := s.limiters[module]
+	if !ok {
+		limiter = rate.NewLimiter(rate.Limit(s.limit), max(1, int(s.limit)))
+		s.limiters[module] = limiter
+	}
+	s.mu.Unlock()
+	return limiter.Allow()
+}
+
+func (s *Sampler) remember(traceID string) {
+	if traceID != "" {
+		s.keptTraces.Add(traceID, struct{}{})
+	}
+}
+
+func (s *Sampler) countDrop(module, pri string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	byPri, ok := s.dropped[module]
+	if !ok {
+		byPri = make(map[string]int64)
+		s.dropped[module] = byPri
+	}
+	byPri[pri]++
+}
+
+// traceFraction maps a trace ID to [0, 1), identically on every instance
+func traceFraction(traceID string) float64 {
+	h := fnv.New64a()
+	h.Write([]byte(traceID))
+	return float64(h.Sum64()>>11) / (1 << 53)
+}
+
+// ReportDrops writes a summary of dropped entries to the sinks every
+// interval until ctx is cancelled
+func (s *Sampler) ReportDrops(ctx context.Context, writeCtx context.Context, sinks []Sink, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			s.Flush(writeCtx, sinks)
+		}
+	}
+}
+
+// Flush writes the drop counts accumulated since the last report, if any,
+// as a LogHarbour activity entry so they can be found next to the logs
+func (s *Sampler) Flush(ctx context.Context, sinks []Sink) {
+	s.mu.Lock()
+	dropped := s.dropped
+	since := s.since
+	s.dropped = make(map[string]map[string]int64)
+	s.since = time.Now()
+	s.mu.Unlock()
+
+	if len(dropped) == 0 {
+		return
+	}
+	var total int64
+	counts := make(map[string]interface{}, len(dropped))
+	for module, byPri := range dropped {
+		m := make(map[string]interface{}, len(byPri))
+		for pri, n := range byPri {
+			m[pri] = n
+			total += n
+		}
+		if module == "" {
+			module = "(none)"
+		}
+		counts[module] = m
+	}
+
+	now := time.Now().UTC()
+	report := LogEntry{
+		ID:       newReportID(),
+		App:      "logharbour-consumer",
+		System:   "logharbour",
+		Module:   "sampler",
+		Type:     "A",
+		Priority: "Info",
+		When:     now.Format(time.RFC3339),
+		Msg:      fmt.Sprintf("Sampling dropped %d log entries", total),
+		Data: map[string]interface{}{
+			"sampling": map[string]interface{}{
+				"dropped":      counts,
+				"total":        total,
+				"period_start": since.UTC().Format(time.RFC3339),
+				"period_end":   now.Format(time.RFC3339),
+			},
+		},
+	}
+	for _, sink := range sinks {
+		writeToSink(ctx, sink, []LogEntry{report})
+	}
+	log.Printf("Sampling dropped %d log entries since %s", total, since.Format(time.RFC3339))
+}
+
+func newReportID() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
@@ -0,0 +1,43 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+func TestSampleLimitDecidesWholeTraces(t *testing.T) {
+	sampler, err := NewSampler("Debug0=1", 1, time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	entry := func(traceID string) *LogEntry {
+		return &LogEntry{Module: "pg", Priority: "Debug0", TraceID: traceID}
+	}
+
+	// The limit allows one entry per second: trace-a takes it, trace-b is
+	// turned away
+	if !sampler.Keep(entry("trace-a")) {
+		t.Fatal("first trace dropped")
+	}
+	if sampler.Keep(entry("trace-b")) {
+		t.Fatal("second trace kept beyond the limit")
+	}
+
+	// Once the limit has room again, both traces keep their decision
+	sampler.limiters["pg"] = rate.NewLimiter(rate.Inf, 1)
+	for i := 0; i < 3; i++ {
+		if !sampler.Keep(entry("trace-a")) {
+			t.Errorf("entry %d of the kept trace dropped", i)
+		}
+		if sampler.Keep(entry("trace-b")) {
+			t.Errorf("entry %d of the limited trace kept", i)
+		}
+	}
+
+	// A Warn still keeps the rest of a limited trace, as for any trace
+	if !sampler.Keep(&LogEntry{Module: "pg", Priority: "Warn", TraceID: "trace-b"}) || !sampler.Keep(entry("trace-b")) {
+		t.Error("trace with a Warn dropped its later entries")
+	}
+}
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strings"
+	"sync"
+)
+
+// Sink receives batches of processed log entries. Write is called with the
+// same batch for every configured sink; a failing sink does not stop the others.
+type Sink interface {
+	Name() string
+	Write(ctx context.Context, entries []LogEntry) error
+	Close() error
+}
+
+// HealthChecker is implemented by sinks whose backend health is reported on
+// /readyz
+type HealthChecker interface {
+	CheckHealth(ctx context.Context) (status string, healthy bool, err error)
+}
+
+// PartialWriteError reports documents a sink rejected while accepting the rest
+// of the batch
+type PartialWriteError struct {
+	Failed int
+	Total  int
+}
+
+func (e *PartialWriteError) Error() string {
+	return fmt.Sprintf("%d of %d documents rejected", e.Failed, e.Total)
+}
+
+// buildSinks creates the sinks named in cfg.Sinks in order
+func buildSinks(ctx context.Context, cfg Config) ([]Sink, error) {
+	var sinks []Sink
+	for _, name := range cfg.Sinks {
+		sink, err := newSink(ctx, strings.TrimSpace(name), cfg)
+		if err != nil {
+			closeSinks(sinks)
+			return nil, err
+		}
+		sinks = append(sinks, sink)
+	}
+	if len(sinks) == 0 {
+		return nil, fmt.Errorf("no sinks configured")
+	}
+	return sinks, nil
+}
+
+func newSink(ctx context.Context, name string, cfg Config) (Sink, error) {
+	switch name {
+	case "elasticsearch":
+		return NewElasticsearchSink(cfg.ElasticsearchURL, "")
+	case "opensearch":
+		return NewOpenSearchSink(ctx, cfg.OpenSearchURL, cfg.OpenSearchUsername, cfg.OpenSearchPassword)
+	case "file":
+		return NewFileSink(cfg.FileSinkDir, cfg.FileSinkMaxBytes, cfg.FileSinkRotateInterval)
+	case "stdout":
+		return NewStdoutSink(os.Stdout), nil
+	case "changelog":
+		if cfg.ChangeLogDatabaseURL == "" {
+			return nil, fmt.Errorf("changelog sink requires CHANGELOG_DATABASE_URL")
+		}
+		return NewChangeLogSink(ctx, cfg.ChangeLogDatabaseURL)
+	case "archive":
+		store, err := openObjectStore(cfg.ArchiveURL, cfg.ArchiveS3)
+		if err != nil {
+			return nil, fmt.Errorf("archive sink: %w", err)
+		}
+		if s3, ok := store.(*S3Store); ok {
+			if err := s3.EnsureBucket(ctx); err != nil {
+				return nil, fmt.Errorf("archive sink: %w", err)
+			}
+		}
+		return NewArchiveSink(store, cfg.ArchiveSpoolDir, cfg.ArchiveMaxBytes, cfg.ArchiveRotateInterval)
+	default:
+		return nil, fmt.Errorf("unknown sink %q", name)
+	}
+}
+
+func closeSinks(sinks []Sink) {
+	for _, sink := range sinks {
+		if err := sink.Close(); err != nil {
+			log.Printf("Error closing %s sink: %v", sink.Name(), err)
+		}
+	}
+}
+
+// StdoutSink writes entries as NDJSON, mainly for running the pipeline locally
+type StdoutSink struct {
+	mu  sync.Mutex
+	out *bufio.Writer
+}
+
+func NewStdoutSink(w io.Writer) *StdoutSink {
+	return &StdoutSink{out: bufio.NewWriter(w)}
+}
+
+func (s *StdoutSink) Name() string { return "stdout" }
+
+func (s *StdoutSink) Write(ctx context.Context, entries []LogEntry) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	enc := json.NewEncoder(s.out)
+	for _, logEntry := range entries {
+		if err := enc.Encode(logEntry); err != nil {
+			return fmt.Errorf("error writing log entry: %w", err)
+		}
+	}
+	return s.out.Flush()
+}
+
+func (s *StdoutSink) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.out.Flush()
+}
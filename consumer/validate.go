@@ -0,0 +1,212 @@
+package main
+
+import (
+	"bytes"
+	"embed"
+	"encoding/json"
+	"fmt"
+	"io/fs"
+	"regexp"
+	"strings"
+
+	"github.com/santhosh-tekuri/jsonschema/v5"
+)
+
+// schemaFiles holds one JSON schema per message version, named logentry.v<N>.json
+//
+//go:embed schema/logentry.v*.json
+var schemaFiles embed.FS
+
+// defaultSchemaVersion applies to messages without a schema_version field,
+// which is everything LogHarbour currently publishes
+const defaultSchemaVersion = "1"
+
+var schemaFileName = regexp.MustCompile(`^logentry\.v(\d+)\.json$`)
+
+// Violation reasons
+const (
+	ViolationMalformed      = "malformed_json"
+	ViolationUnknownVersion = "unknown_version"
+	ViolationSchema         = "schema"
+)
+
+// Violation describes why a message was rejected
+type Violation struct {
+	Reason  string
+	Version string
+	Detail  string
+}
+
+func (v *Violation) Error() string {
+	if v.Detail == "" {
+		return v.Reason
+	}
+	return fmt.Sprintf("%s: %s", v.Reason, v.Detail)
+}
+
+// canonicalPriorities maps lower-cased priorities to LogHarbour's spelling
+var canonicalPriorities = map[string]string{
+	"debug2": "Debug2",
+	"debug1": "Debug1",
+	"debug0": "Debug0",
+	"info":   "Info",
+	"warn":   "Warn",
+	"err":    "Err",
+	"crit":   "Crit",
+	"sec":    "Sec",
+}
+
+// Validator normalizes incoming messages and validates them against the
+// schema version they declare
+type Validator struct {
+	schemas map[string]*jsonschema.Schema
+}
+
+// NewValidator compiles the embedded schemas
+func NewValidator() (*Validator, error) {
+	v := &Validator{schemas: make(map[string]*jsonschema.Schema)}
+
+	names, err := fs.Glob(schemaFiles, "schema/logentry.v*.json")
+	if err != nil {
+		return nil, err
+	}
+	for _, name := range names {
+		m := schemaFileName.FindStringSubmatch(strings.TrimPrefix(name, "schema/"))
+		if m == nil {
+			continue
+		}
+		data, err := schemaFiles.ReadFile(name)
+		if err != nil {
+			return nil, err
+		}
+
+		compiler := jsonschema.NewCompiler()
+		compiler.AssertFormat = true
+		if err := compiler.AddResource(name, bytes.NewReader(data)); err != nil {
+			return nil, fmt.Errorf("error loading %s: %w", name, err)
+		}
+		schema, err := compiler.Compile(name)
+		if err != nil {
+			return nil, fmt.Errorf("error compiling %s: %w", name, err)
+		}
+		v.schemas[m[1]] = schema
+	}
+	if _, ok := v.schemas[defaultSchemaVersion]; !ok {
+		return nil, fmt.Errorf("schema version %s not found", defaultSchemaVersion)
+	}
+	return v, nil
+}
+
+// Decode normalizes raw, validates it and decodes it into a LogEntry
+func (v *Validator) Decode(raw []byte) (LogEntry, *Violation) {
+	var logEntry LogEntry
+
+	dec := json.NewDecoder(bytes.NewReader(raw))
+	dec.UseNumber()
+	var doc any
+	if err := dec.Decode(&doc); err != nil {
+		return logEntry, &Violation{Reason: ViolationMalformed, Detail: err.Error()}
+	}
+	obj, ok := doc.(map[string]any)
+	if !ok {
+		return logEntry, &Violation{Reason: ViolationMalformed, Detail: "message is not a JSON object"}
+	}
+
+	version := defaultSchemaVersion
+	if sv, ok := obj["schema_version"]; ok {
+		version = fmt.Sprint(sv)
+	}
+	schema, ok := v.schemas[version]
+	if !ok {
+		return logEntry, &Violation{Reason: ViolationUnknownVersion, Version: version}
+	}
+
+	normalize(obj)
+	if err := schema.Validate(obj); err != nil {
+		return logEntry, &Violation{Reason: ViolationSchema, Version: version, Detail: validationDetail(err)}
+	}
+
+	data, err := json.Marshal(obj)
+	if err != nil {
+		return logEntry, &Violation{Reason: ViolationMalformed, Version: version, Detail: err.Error()}
+	}
+	if err := json.Unmarshal(data, &logEntry); err != nil {
+		return logEntry, &Violation{Reason: ViolationMalformed, Version: version, Detail: err.Error()}
+	}
+	return logEntry, nil
+}
+
+// normalize fixes representational differences that would otherwise fail
+// validation or conflict with the index mappings:
+//   - type is upper-cased and pri is mapped to LogHarbour's spelling
+//   - change values are stringified, since old and new values of a field
+//     may be numbers, booleans or strings across entries
+func normalize(obj map[string]any) {
+	if t, ok := obj["type"].(string); ok {
+		obj["type"] = strings.ToUpper(strings.TrimSpace(t))
+	}
+	if p, ok := obj["pri"].(string); ok {
+		if canonical, ok := canonicalPriorities[strings.ToLower(strings.TrimSpace(p))]; ok {
+			obj["pri"] = canonical
+		}
+	}
+
+	data, _ := obj["data"].(map[string]any)
+	change, _ := data["change_data"].(map[string]any)
+	changes, _ := change["changes"].([]any)
+	for _, c := range changes {
+		detail, ok := c.(map[string]any)
+		if !ok {
+			continue
+		}
+		for _, key := range []string{"old_value", "new_value"} {
+			if val, ok := detail[key]; ok {
+				detail[key] = stringifyScalar(val)
+			}
+		}
+	}
+}
+
+// stringifyScalar converts numbers and booleans to strings. Objects and
+// arrays are encoded as JSON; null is left as is.
+func stringifyScalar(v any) any {
+	switch val := v.(type) {
+	case nil, string:
+		return val
+	case json.Number:
+		return val.String()
+	case bool:
+		return fmt.Sprint(val)
+	default:
+		data, err := json.Marshal(val)
+		if err != nil {
+			return fmt.Sprint(val)
+		}
+		return string(data)
+	}
+}
+
+// validationDetail flattens a schema error to its most specific causes
+func validationDetail(err error) string {
+	verr, ok := err.(*jsonschema.ValidationError)
+	if !ok {
+		return err.Error()
+	}
+	var details []string
+	var walk func(*jsonschema.ValidationError)
+	walk = func(e *jsonschema.ValidationError) {
+		if len(e.Causes) == 0 {
+			loc := e.InstanceLocation
+			if loc == "" {
+				loc = "/"
+			}
+			details = append(details, fmt.Sprintf("%s: %s", loc, e.Message))
+			return
+		}
+		for _, c := range e.Causes {
+			walk(c)
+		}
+	}
+	walk(verr)
+	return strings.Join(details, "; ")
+}
@@ -7,6 +7,7 @@ toolchain go1.23.3
 require (
 	github.com/gin-gonic/gin v1.9.1
 	github.com/go-playground/validator/v10 v10.16.0
+	github.com/hashicorp/golang-lru/v2 v2.0.7
 	github.com/jackc/pgx/v5 v5.7.5
 	github.com/remiges-tech/alya v0.24.0
 	github.com/remiges-tech/logharbour v0.21.0
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"log"
+	"os"
+	"strconv"
+	"time"
+)
+
+// Config holds search service settings read from the environment
+type Config struct {
+	ListenAddr       string
+	ElasticsearchURL string
+
+	// IndexPattern selects the indices written by the consumer
+	IndexPattern string
+
+	DefaultPageSize int
+	MaxPageSize     int
+
+	// SearchTimeout bounds each Elasticsearch request
+	SearchTimeout time.Duration
+}
+
+func loadConfig() Config {
+	return Config{
+		ListenAddr:       getEnv("LISTEN_ADDR", ":8091"),
+		ElasticsearchURL: getEnv("ELASTICSEARCH_URL", "http://localhost:9200"),
+
+		IndexPattern: getEnv("INDEX_PATTERN", "logharbour-*"),
+
+		DefaultPageSize: getEnvInt("DEFAULT_PAGE_SIZE", 50),
+		MaxPageSize:     getEnvInt("MAX_PAGE_SIZE", 500),
+
+		SearchTimeout: getEnvDuration("SEARCH_TIMEOUT", 10*time.Second),
+	}
+}
+
+func getEnv(key, def string) string {
+	if v := os.Getenv(key); v != "" {
+		return v
+	}
+	return def
+}
+
+func getEnvInt(key string, def int) int {
+	v := os.Getenv(key)
+	if v == "" {
+		return def
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil {
+		log.Printf("Invalid value for %s (%q), using default %d", key, v, def)
+		return def
+	}
+	return n
+}
+
+func getEnvDuration(key string, def time.Duration) time.Duration {
+	v := os.Getenv(key)
+	if v == "" {
+		return def
+	}
+	d, err := time.ParseDuration(v)
+	if err != nil {
+		log.Printf("Invalid value for %s (%q), using default %s", key, v, def)
+		return def
+	}
+	return d
+}
@@ -0,0 +1,7 @@
+module github.com/synapsewave/remiges-demo/logsearch
+
+go 1.21.3
+
+require github.com/elastic/go-elasticsearch/v8 v8.11.0
+
+require github.com/elastic/elastic-transport-go/v8 v8.3.0 // indirect
@@ -0,0 +1,165 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// response follows the status/data/messages envelope of the user service API
+type response struct {
+	Status   string         `json:"status"`
+	Data     any            `json:"data"`
+	Messages []errorMessage `json:"messages"`
+}
+
+type errorMessage struct {
+	ErrCode string   `json:"errcode"`
+	Field   string   `json:"field,omitempty"`
+	Vals    []string `json:"vals,omitempty"`
+}
+
+type handler struct {
+	searcher *Searcher
+	cfg      Config
+}
+
+func newRouter(searcher *Searcher, cfg Config) http.Handler {
+	h := &handler{searcher: searcher, cfg: cfg}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/v1/logs", h.handleSearch)
+	mux.HandleFunc("/api/v1/logs/", h.handleGet)
+	mux.HandleFunc("/healthz", h.handleHealthz)
+	return mux
+}
+
+// handleSearch serves GET /api/v1/logs
+func (h *handler) handleSearch(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, errorMessage{ErrCode: "method_not_allowed"})
+		return
+	}
+
+	params, msgs := h.parseSearch(r)
+	if len(msgs) > 0 {
+		writeError(w, http.StatusBadRequest, msgs...)
+		return
+	}
+
+	result, err := h.searcher.Search(r.Context(), params)
+	if errors.Is(err, errBadCursor) {
+		writeError(w, http.StatusBadRequest, errorMessage{ErrCode: "invalid", Field: "cursor"})
+		return
+	}
+	if err != nil {
+		log.Printf("Error searching logs: %v", err)
+		writeError(w, http.StatusBadGateway, errorMessage{ErrCode: "search_failed"})
+		return
+	}
+	writeJSON(w, http.StatusOK, response{Status: "success", Data: result, Messages: []errorMessage{}})
+}
+
+// handleGet serves GET /api/v1/logs/{id}
+func (h *handler) handleGet(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, errorMessage{ErrCode: "method_not_allowed"})
+		return
+	}
+	id := strings.TrimPrefix(r.URL.Path, "/api/v1/logs/")
+	if id == "" || strings.Contains(id, "/") {
+		writeError(w, http.StatusNotFound, errorMessage{ErrCode: "not_found"})
+		return
+	}
+
+	logEntry, ok, err := h.searcher.Get(r.Context(), id)
+	if err != nil {
+		log.Printf("Error fetching log %s: %v", id, err)
+		writeError(w, http.StatusBadGateway, errorMessage{ErrCode: "search_failed"})
+		return
+	}
+	if !ok {
+		writeError(w, http.StatusNotFound, errorMessage{ErrCode: "not_found", Field: "id", Vals: []string{id}})
+		return
+	}
+	writeJSON(w, http.StatusOK, response{Status: "success", Data: logEntry, Messages: []errorMessage{}})
+}
+
+// handleHealthz reports whether Elasticsearch is reachable
+func (h *handler) handleHealthz(w http.ResponseWriter, r *http.Request) {
+	if err := h.searcher.Ping(r.Context()); err != nil {
+		writeJSON(w, http.StatusServiceUnavailable, map[string]string{"status": "unavailable", "error": err.Error()})
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]string{"status": "ok"})
+}
+
+// parseSearch reads search parameters from the query string
+func (h *handler) parseSearch(r *http.Request) (SearchParams, []errorMessage) {
+	q := r.URL.Query()
+	p := SearchParams{
+		Apps:      splitList(q.Get("app")),
+		Tenants:   splitList(q.Get("tenant")),
+		Modules:   splitList(q.Get("module")),
+		Types:     splitList(strings.ToUpper(q.Get("type"))),
+		Pris:      splitList(q.Get("pri")),
+		Instances: splitList(q.Get("instance")),
+		TraceIDs:  splitList(q.Get("trace_id")),
+		Whos:      splitList(q.Get("who")),
+		Text:      strings.TrimSpace(q.Get("q")),
+		Size:      h.cfg.DefaultPageSize,
+		Cursor:    q.Get("cursor"),
+	}
+
+	var msgs []errorMessage
+	for _, bound := range []struct {
+		name string
+		dst  *time.Time
+	}{{"from", &p.From}, {"to", &p.To}} {
+		v := q.Get(bound.name)
+		if v == "" {
+			continue
+		}
+		t, err := time.Parse(time.RFC3339, v)
+		if err != nil {
+			msgs = append(msgs, errorMessage{ErrCode: "datetime", Field: bound.name, Vals: []string{v}})
+			continue
+		}
+		*bound.dst = t
+	}
+	if !p.From.IsZero() && !p.To.IsZero() && !p.From.Before(p.To) {
+		msgs = append(msgs, errorMessage{ErrCode: "range", Field: "from"})
+	}
+
+	if v := q.Get("size"); v != "" {
+		size, err := strconv.Atoi(v)
+		if err != nil || size < 1 || size > h.cfg.MaxPageSize {
+			msgs = append(msgs, errorMessage{ErrCode: "range", Field: "size", Vals: []string{"1", strconv.Itoa(h.cfg.MaxPageSize)}})
+		} else {
+			p.Size = size
+		}
+	}
+
+	switch q.Get("order") {
+	case "", "desc":
+	case "asc":
+		p.Ascending = true
+	default:
+		msgs = append(msgs, errorMessage{ErrCode: "oneof", Field: "order", Vals: []string{"asc", "desc"}})
+	}
+
+	return p, msgs
+}
+
+func writeError(w http.ResponseWriter, status int, msgs ...errorMessage) {
+	writeJSON(w, status, response{Status: "error", Data: nil, Messages: msgs})
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
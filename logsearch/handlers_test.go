@@ -0,0 +1,117 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+)
+
+func testConfig() Config {
+	return Config{IndexPattern: "logharbour-*", DefaultPageSize: 50, MaxPageSize: 500}
+}
+
+func TestParseSearch(t *testing.T) {
+	h := &handler{cfg: testConfig()}
+
+	r := httptest.NewRequest(http.MethodGet, "/api/v1/logs?app=usersvc&type=c,a&tenant=acme&from=2024-06-22T00:00:00Z&to=2024-06-23T00:00:00Z&size=20&order=asc&q=+updated+", nil)
+	p, msgs := h.parseSearch(r)
+	if len(msgs) > 0 {
+		t.Fatalf("messages = %v", msgs)
+	}
+	if !reflect.DeepEqual(p.Types, []string{"C", "A"}) || !reflect.DeepEqual(p.Tenants, []string{"acme"}) {
+		t.Errorf("types = %v, tenants = %v", p.Types, p.Tenants)
+	}
+	if p.Size != 20 || !p.Ascending || p.Text != "updated" || p.From.IsZero() || p.To.IsZero() {
+		t.Errorf("params = %+v", p)
+	}
+
+	p, _ = h.parseSearch(httptest.NewRequest(http.MethodGet, "/api/v1/logs", nil))
+	if p.Size != 50 || p.Ascending {
+		t.Errorf("defaults = %+v, want size 50 and newest first", p)
+	}
+}
+
+func TestParseSearchRejectsBadParameters(t *testing.T) {
+	h := &handler{cfg: testConfig()}
+
+	for _, tc := range []struct {
+		query string
+		want  []errorMessage
+	}{
+		{"from=yesterday", []errorMessage{{ErrCode: "datetime", Field: "from", Vals: []string{"yesterday"}}}},
+		{"to=2024-06-22", []errorMessage{{ErrCode: "datetime", Field: "to", Vals: []string{"2024-06-22"}}}},
+		{"from=2024-06-23T00:00:00Z&to=2024-06-22T00:00:00Z", []errorMessage{{ErrCode: "range", Field: "from"}}},
+		{"from=2024-06-22T00:00:00Z&to=2024-06-22T00:00:00Z", []errorMessage{{ErrCode: "range", Field: "from"}}},
+		{"size=0", []errorMessage{{ErrCode: "range", Field: "size", Vals: []string{"1", "500"}}}},
+		{"size=501", []errorMessage{{ErrCode: "range", Field: "size", Vals: []string{"1", "500"}}}},
+		{"size=ten", []errorMessage{{ErrCode: "range", Field: "size", Vals: []string{"1", "500"}}}},
+		{"order=newest", []errorMessage{{ErrCode: "oneof", Field: "order", Vals: []string{"asc", "desc"}}}},
+		{"from=x&size=0", []errorMessage{
+			{ErrCode: "datetime", Field: "from", Vals: []string{"x"}},
+			{ErrCode: "range", Field: "size", Vals: []string{"1", "500"}},
+		}},
+	} {
+		t.Run(tc.query, func(t *testing.T) {
+			_, msgs := h.parseSearch(httptest.NewRequest(http.MethodGet, "/api/v1/logs?"+tc.query, nil))
+			if !reflect.DeepEqual(msgs, tc.want) {
+				t.Errorf("messages = %+v, want %+v", msgs, tc.want)
+			}
+		})
+	}
+}
+
+// serve sends a request through the router and decodes the envelope
+func serve(t *testing.T, router http.Handler, method, target string) (int, response) {
+	t.Helper()
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, httptest.NewRequest(method, target, nil))
+	var resp response
+	if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("decoding %s: %v", rec.Body, err)
+	}
+	return rec.Code, resp
+}
+
+func TestHandleSearch(t *testing.T) {
+	es := newFakeElasticsearch(t, "log-1")
+	router := newRouter(newTestSearcher(t, es), testConfig())
+
+	badCursor := base64.RawURLEncoding.EncodeToString([]byte(`[1]`))
+	for _, tc := range []struct {
+		name    string
+		method  string
+		target  string
+		code    int
+		errcode string
+		field   string
+	}{
+		{"valid", http.MethodGet, "/api/v1/logs?module=pg", http.StatusOK, "", ""},
+		{"bad parameter", http.MethodGet, "/api/v1/logs?size=0", http.StatusBadRequest, "range", "size"},
+		{"bad cursor", http.MethodGet, "/api/v1/logs?cursor=" + badCursor, http.StatusBadRequest, "invalid", "cursor"},
+		{"wrong method", http.MethodPost, "/api/v1/logs", http.StatusMethodNotAllowed, "method_not_allowed", ""},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			code, resp := serve(t, router, tc.method, tc.target)
+			if code != tc.code {
+				t.Fatalf("status = %d, want %d", code, tc.code)
+			}
+			if tc.errcode == "" {
+				if resp.Status != "success" {
+					t.Errorf("response = %+v", resp)
+				}
+				return
+			}
+			if resp.Status != "error" || len(resp.Messages) != 1 || resp.Messages[0].ErrCode != tc.errcode || resp.Messages[0].Field != tc.field {
+				t.Errorf("response = %+v, want errcode %q on %q", resp, tc.errcode, tc.field)
+			}
+		})
+	}
+
+	// Only the valid request reaches Elasticsearch
+	if got := es.queryCount(); got != 1 {
+		t.Errorf("sent %d queries, want 1", got)
+	}
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+)
+
+// LogEntry is a LogHarbour log entry as indexed by the consumer
+type LogEntry struct {
+	ID       string                 `json:"id"`
+	App      string                 `json:"app"`
+	Tenant   string                 `json:"tenant,omitempty"`
+	System   string                 `json:"system"`
+	Module   string                 `json:"module,omitempty"`
+	Type     string                 `json:"type"`
+	Priority string                 `json:"pri"`
+	When     string                 `json:"when"`
+	Who      string                 `json:"who,omitempty"`
+	Instance string                 `json:"instance,omitempty"`
+	RemoteIP string                 `json:"remote_ip,omitempty"`
+	TraceID  string                 `json:"trace_id,omitempty"`
+	Msg      string                 `json:"msg"`
+	Data     map[string]interface{} `json:"data,omitempty"`
+
+	// Enrich holds the consumer's deployment tags, user agent and geolocation
+	Enrich map[string]interface{} `json:"enrich,omitempty"`
+}
+
+func main() {
+	// Get configuration from environment
+	cfg := loadConfig()
+
+	searcher, err := NewSearcher(cfg)
+	if err != nil {
+		log.Fatalf("Error creating Elasticsearch client: %s", err)
+	}
+
+	srv := &http.Server{
+		Addr:              cfg.ListenAddr,
+		Handler:           newRouter(searcher, cfg),
+		ReadHeaderTimeout: 5 * time.Second,
+	}
+	go func() {
+		if err := srv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			log.Fatalf("Error from HTTP server: %s", err)
+		}
+	}()
+	log.Printf("Log search API listening on %s, searching %s", cfg.ListenAddr, cfg.IndexPattern)
+
+	sigterm := make(chan os.Signal, 1)
+	signal.Notify(sigterm, os.Interrupt, syscall.SIGTERM)
+	<-sigterm
+	log.Println("Shutting down log search API...")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if err := srv.Shutdown(ctx); err != nil {
+		log.Printf("Error stopping HTTP server: %v", err)
+	}
+	searcher.Close()
+}
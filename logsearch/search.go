@@ -0,0 +1,248 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/elastic/go-elasticsearch/v8"
+)
+
+// SearchParams are the filters of a log search. Empty fields are not applied.
+type SearchParams struct {
+	Apps      []string
+	Tenants   []string
+	Modules   []string
+	Types     []string
+	Pris      []string
+	Instances []string
+	TraceIDs  []string
+	Whos      []string
+	From      time.Time // inclusive
+	To        time.Time // exclusive
+	Text      string    // free text matched against msg
+	Size      int
+	Ascending bool
+	Cursor    string // next_cursor of the previous page
+}
+
+// SearchResult is one page of matching entries. NextCursor is empty on the last page.
+type SearchResult struct {
+	Logs          []LogEntry `json:"logs"`
+	Total         int64      `json:"total"`
+	TotalRelation string     `json:"total_relation"` // "eq", or "gte" when the count was capped
+	NextCursor    string     `json:"next_cursor,omitempty"`
+}
+
+// errBadCursor is returned for a cursor that was not issued by this service
+var errBadCursor = errors.New("invalid cursor")
+
+// Searcher runs log queries against the consumer's indices
+type Searcher struct {
+	es        *elasticsearch.Client
+	transport *http.Transport
+	index     string
+	timeout   time.Duration
+}
+
+// NewSearcher creates an Elasticsearch client for cfg.ElasticsearchURL. The
+// connection is not checked, so the API starts even while Elasticsearch is down.
+func NewSearcher(cfg Config) (*Searcher, error) {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	es, err := elasticsearch.NewClient(elasticsearch.Config{
+		Addresses: []string{cfg.ElasticsearchURL},
+		Transport: transport,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &Searcher{es: es, transport: transport, index: cfg.IndexPattern, timeout: cfg.SearchTimeout}, nil
+}
+
+type searchHit struct {
+	Source LogEntry        `json:"_source"`
+	Sort   json.RawMessage `json:"sort"`
+}
+
+type searchResponse struct {
+	Hits struct {
+		Total struct {
+			Value    int64  `json:"value"`
+			Relation string `json:"relation"`
+		} `json:"total"`
+		Hits []searchHit `json:"hits"`
+	} `json:"hits"`
+}
+
+// Search returns one page of entries matching p, newest first unless
+// p.Ascending is set. Pages are chained with search_after on (when, id),
+// which stays stable while new entries are indexed.
+func (s *Searcher) Search(ctx context.Context, p SearchParams) (SearchResult, error) {
+	body, err := searchBody(p)
+	if err != nil {
+		return SearchResult{}, err
+	}
+	res, err := s.search(ctx, body)
+	if err != nil {
+		return SearchResult{}, err
+	}
+
+	result := SearchResult{
+		Logs:          make([]LogEntry, 0, len(res.Hits.Hits)),
+		Total:         res.Hits.Total.Value,
+		TotalRelation: res.Hits.Total.Relation,
+	}
+	for _, hit := range res.Hits.Hits {
+		result.Logs = append(result.Logs, hit.Source)
+	}
+	if n := len(res.Hits.Hits); n == p.Size {
+		result.NextCursor = base64.RawURLEncoding.EncodeToString(res.Hits.Hits[n-1].Sort)
+	}
+	return result, nil
+}
+
+// Get returns the entry with the given log ID. ok is false if there is none.
+func (s *Searcher) Get(ctx context.Context, id string) (logEntry LogEntry, ok bool, err error) {
+	body, err := json.Marshal(map[string]any{
+		"size":  1,
+		"query": map[string]any{"term": map[string]any{"id": id}},
+	})
+	if err != nil {
+		return logEntry, false, err
+	}
+	res, err := s.search(ctx, body)
+	if err != nil {
+		return logEntry, false, err
+	}
+	if len(res.Hits.Hits) == 0 {
+		return logEntry, false, nil
+	}
+	return res.Hits.Hits[0].Source, true, nil
+}
+
+// Ping checks that Elasticsearch is reachable
+func (s *Searcher) Ping(ctx context.Context) error {
+	res, err := s.es.Ping(s.es.Ping.WithContext(ctx))
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if res.IsError() {
+		return fmt.Errorf("ping: %s", res.Status())
+	}
+	return nil
+}
+
+func (s *Searcher) Close() {
+	s.transport.CloseIdleConnections()
+}
+
+func (s *Searcher) search(ctx context.Context, body []byte) (*searchResponse, error) {
+	ctx, cancel := context.WithTimeout(ctx, s.timeout)
+	defer cancel()
+
+	res, err := s.es.Search(
+		s.es.Search.WithContext(ctx),
+		s.es.Search.WithIndex(s.index),
+		s.es.Search.WithBody(bytes.NewReader(body)),
+		s.es.Search.WithIgnoreUnavailable(true),
+		s.es.Search.WithAllowNoIndices(true),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("error searching logs: %w", err)
+	}
+	defer res.Body.Close()
+	if res.IsError() {
+		return nil, fmt.Errorf("error searching logs: %s", res.String())
+	}
+
+	var sr searchResponse
+	if err := json.NewDecoder(res.Body).Decode(&sr); err != nil {
+		return nil, fmt.Errorf("error decoding search response: %w", err)
+	}
+	return &sr, nil
+}
+
+// searchBody builds the Elasticsearch query for p
+func searchBody(p SearchParams) ([]byte, error) {
+	filters := []any{}
+	for _, term := range []struct {
+		field  string
+		values []string
+	}{
+		{"app", p.Apps},
+		{"tenant", p.Tenants},
+		{"module", p.Modules},
+		{"type", p.Types},
+		{"pri", p.Pris},
+		{"instance", p.Instances},
+		{"trace_id", p.TraceIDs},
+		{"who", p.Whos},
+	} {
+		if len(term.values) > 0 {
+			filters = append(filters, map[string]any{"terms": map[string]any{term.field: term.values}})
+		}
+	}
+	if !p.From.IsZero() || !p.To.IsZero() {
+		when := map[string]any{}
+		if !p.From.IsZero() {
+			when["gte"] = p.From.UTC().Format(time.RFC3339Nano)
+		}
+		if !p.To.IsZero() {
+			when["lt"] = p.To.UTC().Format(time.RFC3339Nano)
+		}
+		filters = append(filters, map[string]any{"range": map[string]any{"when": when}})
+	}
+
+	boolQuery := map[string]any{"filter": filters}
+	if p.Text != "" {
+		boolQuery["must"] = map[string]any{
+			"simple_query_string": map[string]any{
+				"query":            p.Text,
+				"fields":           []string{"msg"},
+				"default_operator": "and",
+			},
+		}
+	}
+
+	order := "desc"
+	if p.Ascending {
+		order = "asc"
+	}
+	query := map[string]any{
+		"size":  p.Size,
+		"query": map[string]any{"bool": boolQuery},
+		"sort":  []any{map[string]any{"when": order}, map[string]any{"id": order}},
+	}
+
+	if p.Cursor != "" {
+		searchAfter, err := base64.RawURLEncoding.DecodeString(p.Cursor)
+		if err != nil {
+			return nil, errBadCursor
+		}
+		var values []any
+		if err := json.Unmarshal(searchAfter, &values); err != nil || len(values) != 2 {
+			return nil, errBadCursor
+		}
+		query["search_after"] = json.RawMessage(searchAfter)
+	}
+
+	return json.Marshal(query)
+}
+
+// splitList splits a comma-separated query parameter, dropping empty items
+func splitList(v string) []string {
+	var items []string
+	for _, item := range strings.Split(v, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
@@ -0,0 +1,245 @@
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+)
+
+// decodeBody runs searchBody and decodes the query for inspection
+func decodeBody(t *testing.T, p SearchParams) map[string]any {
+	t.Helper()
+	body, err := searchBody(p)
+	if err != nil {
+		t.Fatalf("searchBody: %v", err)
+	}
+	var query map[string]any
+	if err := json.Unmarshal(body, &query); err != nil {
+		t.Fatalf("decoding query %s: %v", body, err)
+	}
+	return query
+}
+
+// boolQuery returns the bool query of a decoded search body
+func boolQuery(t *testing.T, query map[string]any) map[string]any {
+	t.Helper()
+	b, ok := query["query"].(map[string]any)["bool"].(map[string]any)
+	if !ok {
+		t.Fatalf("query has no bool clause: %v", query)
+	}
+	return b
+}
+
+func TestSearchBodyTermsFilters(t *testing.T) {
+	query := decodeBody(t, SearchParams{
+		Apps:     []string{"usersvc"},
+		Tenants:  []string{"acme", "globex"},
+		Types:    []string{"C"},
+		TraceIDs: []string{"trace-1"},
+		Size:     20,
+	})
+
+	want := []any{
+		map[string]any{"terms": map[string]any{"app": []any{"usersvc"}}},
+		map[string]any{"terms": map[string]any{"tenant": []any{"acme", "globex"}}},
+		map[string]any{"terms": map[string]any{"type": []any{"C"}}},
+		map[string]any{"terms": map[string]any{"trace_id": []any{"trace-1"}}},
+	}
+	if got := boolQuery(t, query)["filter"]; !reflect.DeepEqual(got, want) {
+		t.Errorf("filter = %v, want %v", got, want)
+	}
+	if got := query["size"]; got != float64(20) {
+		t.Errorf("size = %v, want 20", got)
+	}
+	if _, ok := boolQuery(t, query)["must"]; ok {
+		t.Error("must clause without free text")
+	}
+}
+
+func TestSearchBodyWhenRange(t *testing.T) {
+	from := time.Date(2024, 6, 22, 10, 0, 0, 0, time.FixedZone("IST", 5*3600+1800))
+	to := time.Date(2024, 6, 23, 0, 0, 0, 0, time.UTC)
+
+	for _, tc := range []struct {
+		name     string
+		from, to time.Time
+		want     map[string]any
+	}{
+		{"both", from, to, map[string]any{"gte": "2024-06-22T04:30:00Z", "lt": "2024-06-23T00:00:00Z"}},
+		{"from only", from, time.Time{}, map[string]any{"gte": "2024-06-22T04:30:00Z"}},
+		{"to only", time.Time{}, to, map[string]any{"lt": "2024-06-23T00:00:00Z"}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			filters := boolQuery(t, decodeBody(t, SearchParams{From: tc.from, To: tc.to, Size: 10}))["filter"].([]any)
+			if len(filters) != 1 {
+				t.Fatalf("filters = %v, want one range", filters)
+			}
+			want := map[string]any{"range": map[string]any{"when": tc.want}}
+			if !reflect.DeepEqual(filters[0], want) {
+				t.Errorf("range = %v, want %v", filters[0], want)
+			}
+		})
+	}
+
+	if filters := boolQuery(t, decodeBody(t, SearchParams{Size: 10}))["filter"].([]any); len(filters) != 0 {
+		t.Errorf("filters without parameters = %v, want none", filters)
+	}
+}
+
+func TestSearchBodySortAndText(t *testing.T) {
+	query := decodeBody(t, SearchParams{Text: "user updated", Size: 10, Ascending: true})
+
+	wantSort := []any{map[string]any{"when": "asc"}, map[string]any{"id": "asc"}}
+	if !reflect.DeepEqual(query["sort"], wantSort) {
+		t.Errorf("sort = %v, want %v", query["sort"], wantSort)
+	}
+	must := boolQuery(t, query)["must"].(map[string]any)["simple_query_string"].(map[string]any)
+	if must["query"] != "user updated" || must["default_operator"] != "and" {
+		t.Errorf("simple_query_string = %v", must)
+	}
+
+	query = decodeBody(t, SearchParams{Size: 10})
+	wantSort = []any{map[string]any{"when": "desc"}, map[string]any{"id": "desc"}}
+	if !reflect.DeepEqual(query["sort"], wantSort) {
+		t.Errorf("default sort = %v, want %v", query["sort"], wantSort)
+	}
+}
+
+func TestSearchBodyCursor(t *testing.T) {
+	cursor := base64.RawURLEncoding.EncodeToString([]byte(`[1719050400000,"log-00000042"]`))
+	query := decodeBody(t, SearchParams{Size: 10, Cursor: cursor})
+	want := []any{float64(1719050400000), "log-00000042"}
+	if !reflect.DeepEqual(query["search_after"], want) {
+		t.Errorf("search_after = %v, want %v", query["search_after"], want)
+	}
+
+	for _, tc := range []struct {
+		name   string
+		cursor string
+	}{
+		{"not base64", "!!!"},
+		{"not JSON", base64.RawURLEncoding.EncodeToString([]byte(`when`))},
+		{"one value", base64.RawURLEncoding.EncodeToString([]byte(`[1719050400000]`))},
+		{"object", base64.RawURLEncoding.EncodeToString([]byte(`{"when":1}`))},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			if _, err := searchBody(SearchParams{Size: 10, Cursor: tc.cursor}); !errors.Is(err, errBadCursor) {
+				t.Errorf("err = %v, want errBadCursor", err)
+			}
+		})
+	}
+}
+
+// fakeElasticsearch answers searches with hits, recording each query body
+type fakeElasticsearch struct {
+	*httptest.Server
+	hits []string // _id of each hit
+
+	mu      sync.Mutex
+	queries []map[string]any
+}
+
+func newFakeElasticsearch(t *testing.T, hits ...string) *fakeElasticsearch {
+	f := &fakeElasticsearch{hits: hits}
+	f.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// The client refuses responses without the product header
+		w.Header().Set("X-Elastic-Product", "Elasticsearch")
+		w.Header().Set("Content-Type", "application/json")
+		var query map[string]any
+		body, _ := io.ReadAll(r.Body)
+		json.Unmarshal(body, &query)
+		f.mu.Lock()
+		f.queries = append(f.queries, query)
+		f.mu.Unlock()
+
+		hits := make([]map[string]any, 0, len(f.hits))
+		for i, id := range f.hits {
+			hits = append(hits, map[string]any{
+				"_source": map[string]any{"id": id, "msg": "User updated"},
+				"sort":    []any{1719050400000 + i, id},
+			})
+		}
+		json.NewEncoder(w).Encode(map[string]any{
+			"hits": map[string]any{
+				"total": map[string]any{"value": len(f.hits), "relation": "eq"},
+				"hits":  hits,
+			},
+		})
+	}))
+	t.Cleanup(f.Close)
+	return f
+}
+
+func (f *fakeElasticsearch) query(i int) map[string]any {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.queries[i]
+}
+
+func (f *fakeElasticsearch) queryCount() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return len(f.queries)
+}
+
+func newTestSearcher(t *testing.T, es *fakeElasticsearch) *Searcher {
+	t.Helper()
+	searcher, err := NewSearcher(Config{ElasticsearchURL: es.URL, IndexPattern: "logharbour-*", SearchTimeout: 5 * time.Second})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(searcher.Close)
+	return searcher
+}
+
+func TestSearchCursorChainsPages(t *testing.T) {
+	es := newFakeElasticsearch(t, "log-1", "log-2")
+	searcher := newTestSearcher(t, es)
+
+	page, err := searcher.Search(context.Background(), SearchParams{Size: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(page.Logs) != 2 || page.NextCursor == "" {
+		t.Fatalf("page = %+v, want 2 logs and a cursor", page)
+	}
+
+	// The cursor of a full page resumes after its last hit
+	if _, err := searcher.Search(context.Background(), SearchParams{Size: 2, Cursor: page.NextCursor}); err != nil {
+		t.Fatal(err)
+	}
+	want := []any{float64(1719050400001), "log-2"}
+	if got := es.query(1)["search_after"]; !reflect.DeepEqual(got, want) {
+		t.Errorf("search_after = %v, want %v", got, want)
+	}
+
+	// A short page is the last one
+	page, err = searcher.Search(context.Background(), SearchParams{Size: 3})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if page.NextCursor != "" {
+		t.Errorf("next_cursor = %q on the last page", page.NextCursor)
+	}
+}
+
+func TestSplitList(t *testing.T) {
+	for in, want := range map[string][]string{
+		"":              nil,
+		"a":             {"a"},
+		" a , ,b,":      {"a", "b"},
+		"usersvc,auth ": {"usersvc", "auth"},
+	} {
+		if got := splitList(in); !reflect.DeepEqual(got, want) {
+			t.Errorf("splitList(%q) = %v, want %v", in, got, want)
+		}
+	}
+}
@@ -2,15 +2,18 @@ package main
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"io"
 	"os"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/synapsewave/remiges-demo/pg"
 	usersvc "github.com/synapsewave/remiges-demo/userservice"
 	"github.com/remiges-tech/alya/config"
-	"github.com/remiges-tech/alya/router"
 	"github.com/remiges-tech/alya/service"
 	"github.com/remiges-tech/logharbour/logharbour"
 
@@ -43,20 +46,23 @@ func main() {
 	kafkaWriter, err := logharbour.NewKafkaWriter(kafkaConfig, logharbour.WithPoolSize(10))
 	
 	// Initialize logger with appropriate writer
-	var logger *logharbour.Logger
+	var logWriter io.Writer
 	if err != nil {
 		// If Kafka is not available, use stdout only
 		fmt.Printf("Warning: Failed to create Kafka writer: %v. Using stdout only.\n", err)
-		fallbackWriter := logharbour.NewFallbackWriter(os.Stdout, os.Stdout)
-		logger = logharbour.NewLogger(lctx, "UserService", fallbackWriter)
+		logWriter = logharbour.NewFallbackWriter(os.Stdout, os.Stdout)
 	} else {
 		// Create multi-writer to write to both Kafka and stdout using Go's io.MultiWriter
 		defer kafkaWriter.Close()
 		multiWriter := io.MultiWriter(kafkaWriter, os.Stdout)
-		fallbackWriter := logharbour.NewFallbackWriter(multiWriter, os.Stdout)
-		logger = logharbour.NewLogger(lctx, "UserService", fallbackWriter)
+		logWriter = logharbour.NewFallbackWriter(multiWriter, os.Stdout)
 	}
+	logger := logharbour.NewLogger(lctx, "UserService", logWriter)
 	logger.WithPriority(logharbour.Debug2)
+
+	// Request handling logs through a logger per tenant, which tags every
+	// entry with the tenant
+	tenantLoggers := usersvc.NewTenantLoggers(lctx, "UserService", logWriter)
 	
 	logger.Info().LogActivity("Starting User Service", nil)
 
@@ -89,62 +95,117 @@ func main() {
 	})
 
 	// ===== Database Configuration from Rigel =====
-	// Get database configuration from Rigel (etcd)
-	host, err := rigelClient.Get(ctx, "database.host")
+	// Get database connection and pool configuration from Rigel (etcd)
+	dbConfig, err := loadDatabaseConfig(ctx, rigelClient)
 	if err != nil {
-		logger.Error(fmt.Errorf("failed to get database host: %w", err)).LogActivity("Configuration error", nil)
+		logger.Error(err).LogActivity("Configuration error", nil)
 		os.Exit(1)
 	}
-	port, err := rigelClient.GetInt(ctx, "database.port")
+	autoMigrate, err := loadAutoMigrate(ctx, rigelClient)
 	if err != nil {
-		logger.Error(fmt.Errorf("failed to get database port: %w", err)).LogActivity("Configuration error", nil)
+		logger.Error(err).LogActivity("Configuration error", nil)
 		os.Exit(1)
 	}
-	user, err := rigelClient.Get(ctx, "database.user")
+	statusInterval, err := loadStatusWorkerInterval(ctx, rigelClient)
 	if err != nil {
-		logger.Error(fmt.Errorf("failed to get database user: %w", err)).LogActivity("Configuration error", nil)
+		logger.Error(err).LogActivity("Configuration error", nil)
 		os.Exit(1)
 	}
-	password, err := rigelClient.Get(ctx, "database.password")
+	tenantResolver, err := usersvc.NewTenantResolver(ctx, rigelClient, tenantLoggers)
 	if err != nil {
-		logger.Error(fmt.Errorf("failed to get database password: %w", err)).LogActivity("Configuration error", nil)
+		logger.Error(err).LogActivity("Configuration error", nil)
 		os.Exit(1)
 	}
-	dbname, err := rigelClient.Get(ctx, "database.dbname")
-	if err != nil {
-		logger.Error(fmt.Errorf("failed to get database name: %w", err)).LogActivity("Configuration error", nil)
-		os.Exit(1)
+	dbConfig.OnRetry = func(attempt int, err error, wait time.Duration) {
+		logger.Warn().LogActivity("Database not reachable, retrying", map[string]any{
+			"attempt": attempt,
+			"error":   err.Error(),
+			"wait":    wait.String(),
+		})
+	}
+
+	dbConfig.OnReplicaState = func(state pg.ReplicaState) {
+		if state.Healthy {
+			logger.Info().LogActivity("Database replica in rotation", map[string]any{
+				"replica": state.Addr,
+				"lag":     state.Lag.String(),
+			})
+			return
+		}
+		logger.Warn().LogActivity("Database replica out of rotation", map[string]any{
+			"replica": state.Addr,
+			"error":   fmt.Sprint(state.Err),
+		})
+	}
+
+	// `usersvc migrate ...` manages the schema and `usersvc audit ...`
+	// checks the audit trail; both exit when done
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "migrate":
+			os.Exit(runMigrate(ctx, dbConfig, os.Args[2:]))
+		case "audit":
+			os.Exit(runAudit(ctx, dbConfig, os.Args[2:]))
+		}
 	}
 
 	// ===== Database Initialization =====
 	// Initialize database using Rigel configuration
-	dbConfig := pg.Config{
-		Host:     host,
-		Port:     port,
-		User:     user,
-		Password: password,
-		DBName:   dbname,
-	}
-	provider := pg.NewProvider(dbConfig)
+	provider, err := pg.NewProvider(ctx, dbConfig)
+	if err != nil {
+		logger.Error(err).LogActivity("Database connection failed", nil)
+		os.Exit(1)
+	}
 	defer provider.Close() // Ensure connection pool is closed on exit
 	db := provider.Queries()
+	poolConfig := provider.Pool().Config()
 	logger.Info().LogActivity("Database connection initialized", map[string]any{
-		"host": host,
-		"port": port,
-		"user": user,
-		"db":   dbname,
+		"host":      dbConfig.Host,
+		"port":      dbConfig.Port,
+		"user":      dbConfig.User,
+		"db":        dbConfig.DBName,
+		"tls":       poolConfig.ConnConfig.TLSConfig != nil,
+		"max_conns": poolConfig.MaxConns,
+		"min_conns": poolConfig.MinConns,
+		"replicas":  len(dbConfig.Replicas),
 	})
+	if provider.Router() != nil {
+		for _, state := range provider.Router().Replicas() {
+			if !state.Healthy {
+				logger.Warn().LogActivity("Database replica not available, reads use the primary until it recovers", map[string]any{
+					"replica": state.Addr,
+				})
+			}
+		}
+	}
 
-	// ===== HTTP Router and Middleware Setup =====
-	// Create LogHarbour adapter for request logging
-	// This enables automatic logging of all HTTP requests with comprehensive details
-	logAdapter := router.NewLogHarbourAdapter(logger)
+	// ===== Schema Migrations =====
+	// Opt-in: apply pending migrations before serving. Instances starting
+	// together wait on an advisory lock, so only one of them migrates.
+	// The live schema is then compared with the migrations and any drift
+	// is logged as a warning.
+	if autoMigrate {
+		if err := migrateOnStartup(ctx, provider, logger); err != nil {
+			logger.Error(err).LogActivity("Database migration failed", nil)
+			os.Exit(1)
+		}
+	}
+	checkSchemaOnStartup(ctx, provider, logger)
 
+	// ===== Status Worker =====
+	// Apply scheduled status changes, such as the end of a suspension
+	go usersvc.RunStatusWorker(ctx, db, tenantLoggers, statusInterval)
+
+	// Pick up changes to the tenant.* keys
+	go tenantResolver.Refresh(ctx)
+
+	// ===== HTTP Router and Middleware Setup =====
 	// Create Gin router with middleware
 	// Note: Using gin.New() instead of gin.Default() to have full control over middleware
 	r := gin.New()
 	r.Use(gin.Recovery()) // Recover from panics and return 500 error
-	r.Use(router.LogRequest(logAdapter)) // Log all HTTP requests automatically
+	r.Use(usersvc.LogRequests(tenantLoggers)) // Log all HTTP requests automatically, tagged with the tenant
+	r.Use(tenantResolver.Middleware()) // Resolve the tenant from the token or header
 
 	// ===== Alya Service Setup =====
 	// Create service with Rigel client
@@ -157,6 +218,15 @@ func main() {
 	s.RegisterRoute("POST", "/user_create", usersvc.HandleCreateUserRequest)
 	s.RegisterRoute("POST", "/user_get", usersvc.HandleGetUserRequest)
 	s.RegisterRoute("POST", "/user_update", usersvc.HandleUpdateUserRequest)
+	s.RegisterRoute("POST", "/user_username_change", usersvc.HandleChangeUsernameRequest)
+	s.RegisterRoute("POST", "/user_status_change", usersvc.HandleChangeUserStatusRequest)
+	s.RegisterRoute("POST", "/user_groups", usersvc.HandleListUserGroupsRequest)
+	s.RegisterRoute("POST", "/group_create", usersvc.HandleCreateGroupRequest)
+	s.RegisterRoute("POST", "/group_rename", usersvc.HandleRenameGroupRequest)
+	s.RegisterRoute("POST", "/group_delete", usersvc.HandleDeleteGroupRequest)
+	s.RegisterRoute("POST", "/group_member_add", usersvc.HandleAddGroupMemberRequest)
+	s.RegisterRoute("POST", "/group_member_remove", usersvc.HandleRemoveGroupMemberRequest)
+	s.RegisterRoute("POST", "/group_members", usersvc.HandleListGroupMembersRequest)
 	logger.Info().LogActivity("Routes registered", nil)
 
 	// ===== Server Configuration and Startup =====
@@ -168,3 +238,128 @@ func main() {
 		os.Exit(1)
 	}
 }
+
+// loadDatabaseConfig reads the database settings from Rigel. Connection
+// details are required; pool tuning keys are optional and fall back to the
+// pg package defaults when unset.
+func loadDatabaseConfig(ctx context.Context, rigelClient *rigel.Rigel) (pg.Config, error) {
+	var cfg pg.Config
+	var err error
+	if cfg.Host, err = rigelClient.Get(ctx, "database.host"); err != nil {
+		return cfg, fmt.Errorf("failed to get database host: %w", err)
+	}
+	if cfg.Port, err = rigelClient.GetInt(ctx, "database.port"); err != nil {
+		return cfg, fmt.Errorf("failed to get database port: %w", err)
+	}
+	if cfg.User, err = rigelClient.Get(ctx, "database.user"); err != nil {
+		return cfg, fmt.Errorf("failed to get database user: %w", err)
+	}
+	if cfg.Password, err = rigelClient.Get(ctx, "database.password"); err != nil {
+		return cfg, fmt.Errorf("failed to get database password: %w", err)
+	}
+	if cfg.DBName, err = rigelClient.Get(ctx, "database.dbname"); err != nil {
+		return cfg, fmt.Errorf("failed to get database name: %w", err)
+	}
+
+	opt := optionalConfig{ctx: ctx, rigel: rigelClient}
+	cfg.SSLMode = opt.string("database.sslmode")
+	cfg.ApplicationName = opt.string("database.application_name")
+	cfg.MaxConns = int32(opt.int("database.pool.max_conns"))
+	cfg.MinConns = int32(opt.int("database.pool.min_conns"))
+	cfg.MaxConnLifetime = opt.duration("database.pool.max_conn_lifetime")
+	cfg.MaxConnIdleTime = opt.duration("database.pool.max_conn_idle_time")
+	cfg.HealthCheckPeriod = opt.duration("database.pool.health_check_period")
+	cfg.StatementTimeout = opt.duration("database.statement_timeout")
+	cfg.ConnectTimeout = opt.duration("database.connect_timeout")
+	cfg.ConnectRetries = opt.int("database.connect_retries")
+	cfg.RetryBackoff = opt.duration("database.connect_retry_backoff")
+	cfg.Replicas = opt.list("database.replicas")
+	cfg.MaxReplicaLag = opt.duration("database.replica.max_lag")
+	cfg.ReplicaCheckInterval = opt.duration("database.replica.check_interval")
+	cfg.ReplicaQueries = opt.list("database.replica.queries")
+	return cfg, opt.err
+}
+
+// loadAutoMigrate reads database.auto_migrate, which defaults to false
+func loadAutoMigrate(ctx context.Context, rigelClient *rigel.Rigel) (bool, error) {
+	opt := optionalConfig{ctx: ctx, rigel: rigelClient}
+	return opt.bool("database.auto_migrate"), opt.err
+}
+
+// loadStatusWorkerInterval reads status.worker.interval, how often scheduled
+// status changes are applied, which defaults to a minute
+func loadStatusWorkerInterval(ctx context.Context, rigelClient *rigel.Rigel) (time.Duration, error) {
+	opt := optionalConfig{ctx: ctx, rigel: rigelClient}
+	interval := opt.duration("status.worker.interval")
+	if interval <= 0 {
+		interval = time.Minute
+	}
+	return interval, opt.err
+}
+
+// optionalConfig reads Rigel keys that may be unset, or missing from a
+// schema loaded before they were added. The first invalid value is kept in err.
+type optionalConfig struct {
+	ctx   context.Context
+	rigel *rigel.Rigel
+	err   error
+}
+
+func (o *optionalConfig) string(key string) string {
+	value, err := o.rigel.Get(o.ctx, key)
+	var notFound *rigel.KeyNotFoundError
+	if errors.As(err, &notFound) {
+		return ""
+	}
+	if err != nil && o.err == nil {
+		o.err = fmt.Errorf("failed to get %s: %w", key, err)
+	}
+	return value
+}
+
+func (o *optionalConfig) int(key string) int {
+	value := o.string(key)
+	if value == "" {
+		return 0
+	}
+	n, err := strconv.Atoi(value)
+	if err != nil && o.err == nil {
+		o.err = fmt.Errorf("invalid %s %q: %w", key, value, err)
+	}
+	return n
+}
+
+func (o *optionalConfig) bool(key string) bool {
+	value := o.string(key)
+	if value == "" {
+		return false
+	}
+	b, err := strconv.ParseBool(value)
+	if err != nil && o.err == nil {
+		o.err = fmt.Errorf("invalid %s %q: %w", key, value, err)
+	}
+	return b
+}
+
+func (o *optionalConfig) duration(key string) time.Duration {
+	value := o.string(key)
+	if value == "" {
+		return 0
+	}
+	d, err := time.ParseDuration(value)
+	if err != nil && o.err == nil {
+		o.err = fmt.Errorf("invalid %s %q: %w", key, value, err)
+	}
+	return d
+}
+
+// list reads a comma-separated value, dropping empty items
+func (o *optionalConfig) list(key string) []string {
+	var items []string
+	for _, item := range strings.Split(o.string(key), ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
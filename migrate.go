@@ -0,0 +1,191 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/remiges-tech/logharbour/logharbour"
+	"github.com/synapsewave/remiges-demo/pg"
+	"github.com/synapsewave/remiges-demo/pg/migrations"
+)
+
+// runMigrate implements `usersvc migrate up|down|status|check` and returns
+// the process exit code
+func runMigrate(ctx context.Context, dbConfig pg.Config, args []string) int {
+	fs := flag.NewFlagSet("migrate", flag.ExitOnError)
+	to := fs.Int("to", -1, "target version; up defaults to the latest, down to one step back")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: usersvc migrate up|down|status|check [-to VERSION]\n\nApplies the schema migrations built into the binary. check compares the\nlive schema with the migrations and the sqlc models and exits 1 on drift.\n\nFlags:\n")
+		fs.PrintDefaults()
+	}
+	if len(args) == 0 {
+		fs.Usage()
+		return 2
+	}
+	command := args[0]
+	fs.Parse(args[1:])
+
+	// Migrations only need a single connection
+	dbConfig.MaxConns, dbConfig.MinConns = 2, 0
+	dbConfig.Replicas = nil
+	provider, err := pg.NewProvider(ctx, dbConfig)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return 1
+	}
+	defer provider.Close()
+
+	migrator, err := pg.NewMigrator(provider.Pool(), migrations.FS)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return 1
+	}
+	migrator.OnStep = func(step pg.MigrationStep) {
+		direction := "Applied"
+		if step.Down {
+			direction = "Reverted"
+		}
+		fmt.Printf("%s %s in %s\n", direction, step.Migration.Name, step.Duration.Round(time.Millisecond))
+	}
+
+	current, err := migrator.CurrentVersion(ctx)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return 1
+	}
+
+	target := int32(*to)
+	switch command {
+	case "status":
+		fmt.Printf("Database is at version %d of %d\n", current, migrator.Latest())
+		for _, m := range migrator.Migrations() {
+			state := "pending"
+			if m.Version <= current {
+				state = "applied"
+			}
+			fmt.Printf("  %-8s %s\n", state, m.Name)
+		}
+		return 0
+	case "check":
+		report, err := migrator.CheckSchema(ctx)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			return 1
+		}
+		for _, diff := range report.Differences {
+			fmt.Println(diff)
+		}
+		if len(report.Unmanaged) > 0 {
+			fmt.Printf("Ignored tables not created by the migrations: %s\n", strings.Join(report.Unmanaged, ", "))
+		}
+		if report.Version < report.Latest {
+			fmt.Printf("%d migrations pending\n", report.Latest-report.Version)
+		}
+		if len(report.Differences) > 0 {
+			fmt.Printf("Schema drift: %d differences\n", len(report.Differences))
+			return 1
+		}
+		fmt.Printf("Schema matches migrations up to version %d\n", report.Version)
+		return 0
+	case "up":
+		if *to < 0 {
+			target = migrator.Latest()
+		}
+		if target < current {
+			fmt.Fprintf(os.Stderr, "Error: target version %d is below the current version %d; use down\n", target, current)
+			return 1
+		}
+	case "down":
+		if *to < 0 {
+			target = max(current-1, 0)
+		}
+		if target > current {
+			fmt.Fprintf(os.Stderr, "Error: target version %d is above the current version %d; use up\n", target, current)
+			return 1
+		}
+	default:
+		fs.Usage()
+		return 2
+	}
+
+	steps, err := migrator.MigrateTo(ctx, target)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return 1
+	}
+	if len(steps) == 0 {
+		fmt.Printf("Database is already at version %d\n", target)
+	}
+	return 0
+}
+
+// migrateOnStartup applies pending migrations. A schema newer than this
+// build is left alone so a rolled-back instance can still start.
+func migrateOnStartup(ctx context.Context, provider *pg.Provider, logger *logharbour.Logger) error {
+	migrator, err := pg.NewMigrator(provider.Pool(), migrations.FS)
+	if err != nil {
+		return err
+	}
+	migrator.OnStep = func(step pg.MigrationStep) {
+		logger.Info().LogActivity("Database migration applied", map[string]any{
+			"migration": step.Migration.Name,
+			"version":   step.Migration.Version,
+			"duration":  step.Duration.String(),
+		})
+	}
+	steps, err := migrator.Up(ctx)
+	if errors.Is(err, pg.ErrSchemaNewer) {
+		logger.Warn().LogActivity("Database schema is newer than this build, skipping migrations", map[string]any{
+			"error": err.Error(),
+		})
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	logger.Info().LogActivity("Database schema up to date", map[string]any{
+		"version": migrator.Latest(),
+		"applied": len(steps),
+	})
+	return nil
+}
+
+// checkSchemaOnStartup logs every difference between the live schema and
+// the migrations as a warning. Drift never stops the service from starting.
+func checkSchemaOnStartup(ctx context.Context, provider *pg.Provider, logger *logharbour.Logger) {
+	migrator, err := pg.NewMigrator(provider.Pool(), migrations.FS)
+	if err != nil {
+		logger.Warn().LogActivity("Schema check skipped", map[string]any{"error": err.Error()})
+		return
+	}
+	report, err := migrator.CheckSchema(ctx)
+	if err != nil {
+		logger.Warn().LogActivity("Schema check skipped", map[string]any{"error": err.Error()})
+		return
+	}
+	for _, diff := range report.Differences {
+		logger.Warn().LogActivity("Schema drift detected", map[string]any{
+			"table":    diff.Table,
+			"kind":     diff.Kind,
+			"name":     diff.Name,
+			"expected": diff.Expected,
+			"actual":   diff.Actual,
+		})
+	}
+	if report.Version < report.Latest {
+		logger.Warn().LogActivity("Database migrations pending", map[string]any{
+			"version": report.Version,
+			"latest":  report.Latest,
+		})
+	}
+	logger.Info().LogActivity("Schema check completed", map[string]any{
+		"version":     report.Version,
+		"differences": len(report.Differences),
+		"unmanaged":   report.Unmanaged,
+	})
+}
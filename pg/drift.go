@@ -0,0 +1,318 @@
+package pg
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"reflect"
+	"sort"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/synapsewave/remiges-demo/pg/sqlc-gen"
+)
+
+// sqlcModels maps tables to the sqlc-generated structs that mirror them
+var sqlcModels = map[string]any{
+	"group_members":    sqlc.GroupMember{},
+	"groups":           sqlc.Group{},
+	"username_history": sqlc.UsernameHistory{},
+	"users":            sqlc.User{},
+	"users_audit":      sqlc.UsersAudit{},
+}
+
+// Column is a table column as seen in information_schema
+type Column struct {
+	Type    string
+	NotNull bool
+}
+
+// Table is the shape of one table
+type Table struct {
+	Columns     map[string]Column
+	Constraints map[string]string // name -> pg_get_constraintdef
+}
+
+// Schema maps table names to their shape
+type Schema map[string]*Table
+
+// SchemaDifference is one way the live database differs from what the
+// migrations or the sqlc models expect
+type SchemaDifference struct {
+	Table    string
+	Kind     string
+	Name     string
+	Expected string
+	Actual   string
+}
+
+func (d SchemaDifference) String() string {
+	s := fmt.Sprintf("%s: %s", d.Table, d.Kind)
+	if d.Name != "" {
+		s += " " + d.Name
+	}
+	switch {
+	case d.Expected != "" && d.Actual != "":
+		s += fmt.Sprintf(" (expected %q, found %q)", d.Expected, d.Actual)
+	case d.Expected != "":
+		s += fmt.Sprintf(" (expected %q)", d.Expected)
+	case d.Actual != "":
+		s += fmt.Sprintf(" (found %q)", d.Actual)
+	}
+	return s
+}
+
+// SchemaReport is the result of CheckSchema
+type SchemaReport struct {
+	Version     int32
+	Latest      int32
+	Differences []SchemaDifference
+
+	// Unmanaged lists the live tables no migration creates, such as the
+	// log consumer's. They are left out of the comparison.
+	Unmanaged []string
+}
+
+// CheckSchema compares the live public schema with the schema the applied
+// migrations produce, and the sqlc models with the schema of all migrations.
+// Tables that none of the migrations create belong to someone else and are
+// only listed.
+func (m *Migrator) CheckSchema(ctx context.Context) (SchemaReport, error) {
+	report := SchemaReport{Latest: m.Latest()}
+	var err error
+	if report.Version, err = m.CurrentVersion(ctx); err != nil {
+		return report, err
+	}
+	if report.Version > report.Latest {
+		return report, fmt.Errorf("%w: database is at version %d, this build has %d migrations", ErrSchemaNewer, report.Version, report.Latest)
+	}
+
+	expected, err := m.ExpectedSchema(ctx, report.Version)
+	if err != nil {
+		return report, err
+	}
+	live, err := m.liveSchema(ctx)
+	if err != nil {
+		return report, err
+	}
+	delete(live, "schema_version")
+
+	latest := expected
+	if report.Version != report.Latest {
+		if latest, err = m.ExpectedSchema(ctx, report.Latest); err != nil {
+			return report, err
+		}
+	}
+	report.Unmanaged = removeUnmanaged(live, expected, latest)
+	report.Differences = append(diffSchemas(expected, live), diffModels(latest)...)
+	return report, nil
+}
+
+// ExpectedSchema applies the migrations up to version to an empty scratch
+// schema, reads its shape and rolls everything back. Migrations must
+// therefore refer to their tables without a schema name.
+func (m *Migrator) ExpectedSchema(ctx context.Context, version int32) (Schema, error) {
+	suffix := make([]byte, 6)
+	rand.Read(suffix)
+	scratch := "schema_check_" + hex.EncodeToString(suffix)
+
+	tx, err := m.pool.Begin(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback(context.Background())
+
+	ident := pgx.Identifier{scratch}.Sanitize()
+	if _, err := tx.Exec(ctx, "CREATE SCHEMA "+ident+"; SET LOCAL search_path TO "+ident); err != nil {
+		return nil, fmt.Errorf("creating scratch schema: %w", err)
+	}
+	for _, migration := range m.migrations[:version] {
+		if _, err := tx.Exec(ctx, migration.Up); err != nil {
+			return nil, fmt.Errorf("replaying migration %s: %w", migration.Name, err)
+		}
+	}
+	return introspect(ctx, tx, scratch)
+}
+
+func (m *Migrator) liveSchema(ctx context.Context) (Schema, error) {
+	tx, err := m.pool.BeginTx(ctx, pgx.TxOptions{AccessMode: pgx.ReadOnly})
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback(context.Background())
+	// Constraint definitions name tables relative to the search path, so
+	// both schemas are read with only themselves on it
+	if _, err := tx.Exec(ctx, "SET LOCAL search_path TO public"); err != nil {
+		return nil, err
+	}
+	return introspect(ctx, tx, "public")
+}
+
+// introspect reads the tables, columns and constraints of schema
+func introspect(ctx context.Context, tx pgx.Tx, schema string) (Schema, error) {
+	result := make(Schema)
+	table := func(name string) *Table {
+		t, ok := result[name]
+		if !ok {
+			t = &Table{Columns: make(map[string]Column), Constraints: make(map[string]string)}
+			result[name] = t
+		}
+		return t
+	}
+
+	rows, err := tx.Query(ctx, `SELECT c.table_name, c.column_name, c.data_type, c.character_maximum_length, c.is_nullable = 'NO'
+FROM information_schema.columns c
+JOIN information_schema.tables t ON t.table_schema = c.table_schema AND t.table_name = c.table_name
+WHERE c.table_schema = $1 AND t.table_type = 'BASE TABLE'`, schema)
+	if err != nil {
+		return nil, fmt.Errorf("reading columns: %w", err)
+	}
+	for rows.Next() {
+		var tableName, column, dataType string
+		var maxLength *int32
+		var col Column
+		if err := rows.Scan(&tableName, &column, &dataType, &maxLength, &col.NotNull); err != nil {
+			rows.Close()
+			return nil, fmt.Errorf("reading columns: %w", err)
+		}
+		col.Type = dataType
+		if maxLength != nil {
+			col.Type = fmt.Sprintf("%s(%d)", dataType, *maxLength)
+		}
+		table(tableName).Columns[column] = col
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("reading columns: %w", err)
+	}
+
+	// NOT NULL is compared per column, so only table constraints are read
+	rows, err = tx.Query(ctx, `SELECT cl.relname, co.conname, pg_get_constraintdef(co.oid)
+FROM pg_constraint co
+JOIN pg_class cl ON cl.oid = co.conrelid
+JOIN pg_namespace n ON n.oid = cl.relnamespace
+WHERE n.nspname = $1 AND co.contype IN ('p', 'u', 'f', 'c', 'x')`, schema)
+	if err != nil {
+		return nil, fmt.Errorf("reading constraints: %w", err)
+	}
+	for rows.Next() {
+		var tableName, name, def string
+		if err := rows.Scan(&tableName, &name, &def); err != nil {
+			rows.Close()
+			return nil, fmt.Errorf("reading constraints: %w", err)
+		}
+		table(tableName).Constraints[name] = def
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("reading constraints: %w", err)
+	}
+	return result, nil
+}
+
+// removeUnmanaged deletes the tables of live that neither the applied nor
+// the latest migrations have, and returns their names. A table a later
+// migration creates is still compared, since it should not exist yet.
+func removeUnmanaged(live, applied, latest Schema) []string {
+	var unmanaged []string
+	for _, name := range sortedKeys(live) {
+		if applied[name] == nil && latest[name] == nil {
+			unmanaged = append(unmanaged, name)
+			delete(live, name)
+		}
+	}
+	return unmanaged
+}
+
+// diffSchemas lists the differences between expected and actual in a
+// stable order. Callers remove tables the migrations do not manage from
+// actual first.
+func diffSchemas(expected, actual Schema) []SchemaDifference {
+	var diffs []SchemaDifference
+	for _, name := range sortedKeys(expected) {
+		want, have := expected[name], actual[name]
+		if have == nil {
+			diffs = append(diffs, SchemaDifference{Table: name, Kind: "missing table"})
+			continue
+		}
+		for _, column := range sortedKeys(want.Columns) {
+			w := want.Columns[column]
+			h, ok := have.Columns[column]
+			switch {
+			case !ok:
+				diffs = append(diffs, SchemaDifference{Table: name, Kind: "missing column", Name: column})
+			case w.Type != h.Type:
+				diffs = append(diffs, SchemaDifference{Table: name, Kind: "column type", Name: column, Expected: w.Type, Actual: h.Type})
+			case w.NotNull != h.NotNull:
+				diffs = append(diffs, SchemaDifference{Table: name, Kind: "nullability", Name: column, Expected: nullability(w.NotNull), Actual: nullability(h.NotNull)})
+			}
+		}
+		for _, column := range sortedKeys(have.Columns) {
+			if _, ok := want.Columns[column]; !ok {
+				diffs = append(diffs, SchemaDifference{Table: name, Kind: "unexpected column", Name: column})
+			}
+		}
+		for _, constraint := range sortedKeys(want.Constraints) {
+			def, ok := have.Constraints[constraint]
+			switch {
+			case !ok:
+				diffs = append(diffs, SchemaDifference{Table: name, Kind: "missing constraint", Name: constraint, Expected: want.Constraints[constraint]})
+			case def != want.Constraints[constraint]:
+				diffs = append(diffs, SchemaDifference{Table: name, Kind: "constraint definition", Name: constraint, Expected: want.Constraints[constraint], Actual: def})
+			}
+		}
+		for _, constraint := range sortedKeys(have.Constraints) {
+			if _, ok := want.Constraints[constraint]; !ok {
+				diffs = append(diffs, SchemaDifference{Table: name, Kind: "unexpected constraint", Name: constraint, Actual: have.Constraints[constraint]})
+			}
+		}
+	}
+	for _, name := range sortedKeys(actual) {
+		if _, ok := expected[name]; !ok {
+			diffs = append(diffs, SchemaDifference{Table: name, Kind: "unexpected table"})
+		}
+	}
+	return diffs
+}
+
+// diffModels compares the db tags of the sqlc models with the columns the
+// migrations define, which catches sqlc-gen not being regenerated
+func diffModels(expected Schema) []SchemaDifference {
+	var diffs []SchemaDifference
+	for _, name := range sortedKeys(sqlcModels) {
+		model := reflect.TypeOf(sqlcModels[name])
+		table := expected[name]
+		if table == nil {
+			diffs = append(diffs, SchemaDifference{Table: name, Kind: "sqlc model without table", Name: model.Name()})
+			continue
+		}
+		fields := make(map[string]bool)
+		for i := 0; i < model.NumField(); i++ {
+			column := model.Field(i).Tag.Get("db")
+			fields[column] = true
+			if _, ok := table.Columns[column]; !ok {
+				diffs = append(diffs, SchemaDifference{Table: name, Kind: "sqlc field without column", Name: model.Name() + "." + model.Field(i).Name})
+			}
+		}
+		for _, column := range sortedKeys(table.Columns) {
+			if !fields[column] {
+				diffs = append(diffs, SchemaDifference{Table: name, Kind: "column missing from sqlc model", Name: column})
+			}
+		}
+	}
+	return diffs
+}
+
+func nullability(notNull bool) string {
+	if notNull {
+		return "NOT NULL"
+	}
+	return "NULL"
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,60 @@
+package pg
+
+import (
+	"reflect"
+	"testing"
+)
+
+func usersTable() *Table {
+	return &Table{
+		Columns: map[string]Column{
+			"id":       {Type: "integer", NotNull: true},
+			"username": {Type: "character varying(30)", NotNull: true},
+		},
+		Constraints: map[string]string{"users_pkey": "PRIMARY KEY (id)"},
+	}
+}
+
+func TestRemoveUnmanaged(t *testing.T) {
+	applied := Schema{"users": usersTable()}
+	latest := Schema{"users": usersTable(), "username_history": {}}
+	live := Schema{
+		"users":              usersTable(),
+		"username_history":   {},
+		"entity_change_log":  {},
+		"consumer_seen_logs": {},
+	}
+
+	unmanaged := removeUnmanaged(live, applied, latest)
+	if want := []string{"consumer_seen_logs", "entity_change_log"}; !reflect.DeepEqual(unmanaged, want) {
+		t.Errorf("unmanaged = %v, want %v", unmanaged, want)
+	}
+
+	// A table of a pending migration is still drift, the consumer's are not
+	want := []SchemaDifference{{Table: "username_history", Kind: "unexpected table"}}
+	if diffs := diffSchemas(applied, live); !reflect.DeepEqual(diffs, want) {
+		t.Errorf("differences = %v, want %v", diffs, want)
+	}
+}
+
+func TestDiffSchemas(t *testing.T) {
+	live := usersTable()
+	live.Columns["username"] = Column{Type: "character varying(50)", NotNull: true}
+	live.Columns["nickname"] = Column{Type: "text"}
+	delete(live.Constraints, "users_pkey")
+
+	// Tables are visited in name order
+	want := []SchemaDifference{
+		{Table: "groups", Kind: "missing table"},
+		{Table: "users", Kind: "column type", Name: "username", Expected: "character varying(30)", Actual: "character varying(50)"},
+		{Table: "users", Kind: "unexpected column", Name: "nickname"},
+		{Table: "users", Kind: "missing constraint", Name: "users_pkey", Expected: "PRIMARY KEY (id)"},
+	}
+	got := diffSchemas(Schema{"users": usersTable(), "groups": {}}, Schema{"users": live})
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("differences = %v\nwant %v", got, want)
+	}
+	if diffs := diffSchemas(Schema{"users": usersTable()}, Schema{"users": usersTable()}); len(diffs) != 0 {
+		t.Errorf("identical schemas differ: %v", diffs)
+	}
+}
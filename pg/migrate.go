@@ -0,0 +1,223 @@
+package pg
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// The migrator keeps tern's file format and version table, so databases
+// migrated with tern continue from the version tern recorded
+const (
+	migrationSeparator = "---- create above / drop below ----"
+	versionTable       = "public.schema_version"
+
+	// migrationLockID is the advisory lock that serialises migrations
+	// between service instances starting at the same time
+	migrationLockID int64 = 0x75737276636d6967 // "usrvcmig"
+)
+
+// ErrSchemaNewer is returned when the database has migrations this build
+// does not know about, typically during a rollback of the service
+var ErrSchemaNewer = errors.New("database schema is newer than this build")
+
+// Migration is one numbered schema change
+type Migration struct {
+	Version int32
+	Name    string
+	Up      string
+	Down    string
+}
+
+// MigrationStep is an applied migration
+type MigrationStep struct {
+	Migration Migration
+	Down      bool
+	Duration  time.Duration
+}
+
+// LoadMigrations reads <version>_<name>.sql files from fsys. Versions must
+// start at 1 and have no gaps.
+func LoadMigrations(fsys fs.FS) ([]Migration, error) {
+	names, err := fs.Glob(fsys, "*.sql")
+	if err != nil {
+		return nil, err
+	}
+	var migrations []Migration
+	for _, name := range names {
+		prefix, _, ok := strings.Cut(name, "_")
+		version, err := strconv.ParseInt(prefix, 10, 32)
+		if !ok || err != nil {
+			return nil, fmt.Errorf("migration %s: name must start with <version>_", name)
+		}
+		data, err := fs.ReadFile(fsys, name)
+		if err != nil {
+			return nil, err
+		}
+		up, down, _ := strings.Cut(string(data), migrationSeparator)
+		migrations = append(migrations, Migration{
+			Version: int32(version),
+			Name:    path.Base(name),
+			Up:      strings.TrimSpace(up),
+			Down:    strings.TrimSpace(down),
+		})
+	}
+	sort.Slice(migrations, func(i, j int) bool { return migrations[i].Version < migrations[j].Version })
+	for i, m := range migrations {
+		if m.Version != int32(i+1) {
+			return nil, fmt.Errorf("migration %s: expected version %d", m.Name, i+1)
+		}
+	}
+	return migrations, nil
+}
+
+// Migrator applies migrations to the database behind pool
+type Migrator struct {
+	pool       *pgxpool.Pool
+	migrations []Migration
+
+	// OnStep, if set, is called after each migration is committed
+	OnStep func(MigrationStep)
+}
+
+// NewMigrator loads the migrations in fsys
+func NewMigrator(pool *pgxpool.Pool, fsys fs.FS) (*Migrator, error) {
+	migrations, err := LoadMigrations(fsys)
+	if err != nil {
+		return nil, fmt.Errorf("loading migrations: %w", err)
+	}
+	return &Migrator{pool: pool, migrations: migrations}, nil
+}
+
+// Migrations returns the known migrations in version order
+func (m *Migrator) Migrations() []Migration {
+	return m.migrations
+}
+
+// Latest is the version after applying every known migration
+func (m *Migrator) Latest() int32 {
+	return int32(len(m.migrations))
+}
+
+// CurrentVersion reports the version recorded in the database, 0 if it has
+// never been migrated
+func (m *Migrator) CurrentVersion(ctx context.Context) (int32, error) {
+	var exists bool
+	if err := m.pool.QueryRow(ctx, "SELECT to_regclass($1) IS NOT NULL", versionTable).Scan(&exists); err != nil {
+		return 0, fmt.Errorf("reading schema version: %w", err)
+	}
+	if !exists {
+		return 0, nil
+	}
+	var version int32
+	err := m.pool.QueryRow(ctx, "SELECT version FROM "+versionTable).Scan(&version)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, fmt.Errorf("reading schema version: %w", err)
+	}
+	return version, nil
+}
+
+// Up applies every pending migration
+func (m *Migrator) Up(ctx context.Context) ([]MigrationStep, error) {
+	return m.MigrateTo(ctx, m.Latest())
+}
+
+// MigrateTo migrates up or down to target. It holds an advisory lock for the
+// whole run, so an instance that waited for another one to finish finds the
+// work already done. Each migration runs in its own transaction together
+// with the version update.
+func (m *Migrator) MigrateTo(ctx context.Context, target int32) (steps []MigrationStep, err error) {
+	if target < 0 || target > m.Latest() {
+		return nil, fmt.Errorf("target version %d out of range 0 to %d", target, m.Latest())
+	}
+
+	conn, err := m.pool.Acquire(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Release()
+	if _, err := conn.Exec(ctx, "SELECT pg_advisory_lock($1)", migrationLockID); err != nil {
+		return nil, fmt.Errorf("acquiring migration lock: %w", err)
+	}
+	defer func() {
+		// ctx may be done; an unlock that fails must not return a locked
+		// connection to the pool
+		if _, unlockErr := conn.Exec(context.Background(), "SELECT pg_advisory_unlock($1)", migrationLockID); unlockErr != nil {
+			conn.Conn().Close(context.Background())
+		}
+	}()
+
+	if _, err := conn.Exec(ctx, fmt.Sprintf(`CREATE TABLE IF NOT EXISTS %[1]s (version int4 NOT NULL);
+INSERT INTO %[1]s (version) SELECT 0 WHERE NOT EXISTS (SELECT 1 FROM %[1]s)`, versionTable)); err != nil {
+		return nil, fmt.Errorf("creating %s: %w", versionTable, err)
+	}
+	var current int32
+	if err := conn.QueryRow(ctx, "SELECT version FROM "+versionTable).Scan(&current); err != nil {
+		return nil, fmt.Errorf("reading schema version: %w", err)
+	}
+	if current > m.Latest() {
+		return nil, fmt.Errorf("%w: database is at version %d, this build has %d migrations", ErrSchemaNewer, current, m.Latest())
+	}
+
+	plan, err := m.plan(current, target)
+	if err != nil {
+		return nil, err
+	}
+	for _, step := range plan {
+		sql, next := step.Migration.Up, step.Migration.Version
+		if step.Down {
+			sql, next = step.Migration.Down, step.Migration.Version-1
+		}
+
+		start := time.Now()
+		err := pgx.BeginFunc(ctx, conn, func(tx pgx.Tx) error {
+			if _, err := tx.Exec(ctx, sql); err != nil {
+				return err
+			}
+			_, err := tx.Exec(ctx, "UPDATE "+versionTable+" SET version = $1", next)
+			return err
+		})
+		if err != nil {
+			return steps, fmt.Errorf("migration %s: %w", step.Migration.Name, err)
+		}
+		step.Duration = time.Since(start)
+		steps = append(steps, step)
+		if m.OnStep != nil {
+			m.OnStep(step)
+		}
+	}
+	return steps, nil
+}
+
+// plan lists the migrations that take the schema from current to target, in
+// the order they run. Going down, it fails before anything is applied if a
+// migration on the way cannot be reverted.
+func (m *Migrator) plan(current, target int32) ([]MigrationStep, error) {
+	if current < 0 || current > m.Latest() || target < 0 || target > m.Latest() {
+		return nil, fmt.Errorf("cannot migrate from version %d to %d with %d migrations", current, target, m.Latest())
+	}
+	var plan []MigrationStep
+	for v := current; v < target; v++ {
+		plan = append(plan, MigrationStep{Migration: m.migrations[v]})
+	}
+	for v := current; v > target; v-- {
+		step := MigrationStep{Migration: m.migrations[v-1], Down: true}
+		if step.Migration.Down == "" {
+			return nil, fmt.Errorf("migration %s cannot be reverted", step.Migration.Name)
+		}
+		plan = append(plan, step)
+	}
+	return plan, nil
+}
@@ -0,0 +1,176 @@
+package pg
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"testing/fstest"
+
+	"github.com/synapsewave/remiges-demo/pg/migrations"
+)
+
+func TestLoadMigrationsSplitsUpAndDown(t *testing.T) {
+	fsys := fstest.MapFS{
+		"001_users.sql": {Data: []byte("CREATE TABLE users (id int);\n\n" + migrationSeparator + "\n\nDROP TABLE users;\n")},
+		"002_index.sql": {Data: []byte("CREATE INDEX users_id ON users (id);\n")},
+		"notes.txt":     {Data: []byte("not a migration")},
+	}
+
+	got, err := LoadMigrations(fsys)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []Migration{
+		{Version: 1, Name: "001_users.sql", Up: "CREATE TABLE users (id int);", Down: "DROP TABLE users;"},
+		{Version: 2, Name: "002_index.sql", Up: "CREATE INDEX users_id ON users (id);"},
+	}
+	if len(got) != len(want) {
+		t.Fatalf("migrations = %+v, want %+v", got, want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("migration %d = %+v, want %+v", i, got[i], want[i])
+		}
+	}
+}
+
+func TestLoadMigrationsOrdersByVersion(t *testing.T) {
+	// Glob sorts names as text, which puts 10 before 2
+	fsys := fstest.MapFS{}
+	for _, name := range []string{"1_a.sql", "10_j.sql", "2_b.sql", "3_c.sql", "4_d.sql", "5_e.sql", "6_f.sql", "7_g.sql", "8_h.sql", "9_i.sql"} {
+		fsys[name] = &fstest.MapFile{Data: []byte("SELECT 1")}
+	}
+
+	got, err := LoadMigrations(fsys)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, m := range got {
+		if m.Version != int32(i+1) {
+			t.Fatalf("migration %d is version %d (%s)", i, m.Version, m.Name)
+		}
+	}
+}
+
+func TestLoadMigrationsRejectsBadFiles(t *testing.T) {
+	for _, tc := range []struct {
+		name    string
+		files   []string
+		wantErr string
+	}{
+		{"gap", []string{"001_a.sql", "003_c.sql"}, "003_c.sql: expected version 2"},
+		{"not from 1", []string{"002_b.sql"}, "002_b.sql: expected version 1"},
+		{"duplicate", []string{"001_a.sql", "001_b.sql"}, "expected version 2"},
+		{"no version", []string{"users.sql"}, "users.sql: name must start with <version>_"},
+		{"bad version", []string{"v1_users.sql"}, "v1_users.sql: name must start with <version>_"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			fsys := fstest.MapFS{}
+			for _, name := range tc.files {
+				fsys[name] = &fstest.MapFile{Data: []byte("SELECT 1")}
+			}
+			if _, err := LoadMigrations(fsys); err == nil || !strings.Contains(err.Error(), tc.wantErr) {
+				t.Errorf("err = %v, want %q", err, tc.wantErr)
+			}
+		})
+	}
+}
+
+func TestEmbeddedMigrations(t *testing.T) {
+	got, err := LoadMigrations(migrations.FS)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) == 0 {
+		t.Fatal("no embedded migrations")
+	}
+	for _, m := range got {
+		if m.Up == "" {
+			t.Errorf("migration %s has nothing to apply", m.Name)
+		}
+		if m.Down == "" {
+			t.Errorf("migration %s cannot be reverted", m.Name)
+		}
+		if strings.Contains(m.Down, migrationSeparator) {
+			t.Errorf("migration %s has more than one separator", m.Name)
+		}
+	}
+}
+
+func testMigrator(t *testing.T) *Migrator {
+	t.Helper()
+	m, err := NewMigrator(nil, fstest.MapFS{
+		"001_users.sql":  {Data: []byte("CREATE TABLE users (id int);\n" + migrationSeparator + "\nDROP TABLE users;")},
+		"002_seed.sql":   {Data: []byte("INSERT INTO users VALUES (1);")},
+		"003_index.sql":  {Data: []byte("CREATE INDEX users_id ON users (id);\n" + migrationSeparator + "\nDROP INDEX users_id;")},
+		"004_status.sql": {Data: []byte("ALTER TABLE users ADD status text;\n" + migrationSeparator + "\nALTER TABLE users DROP status;")},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return m
+}
+
+// planned describes a plan as "+N" for each up step and "-N" for each down
+func planned(plan []MigrationStep) string {
+	var parts []string
+	for _, step := range plan {
+		sign := "+"
+		if step.Down {
+			sign = "-"
+		}
+		parts = append(parts, sign+strings.TrimLeft(step.Migration.Name[:3], "0"))
+	}
+	return strings.Join(parts, " ")
+}
+
+func TestMigrationPlan(t *testing.T) {
+	m := testMigrator(t)
+
+	for _, tc := range []struct {
+		name            string
+		current, target int32
+		want            string
+		wantErr         string
+	}{
+		{"up from empty", 0, 4, "+1 +2 +3 +4", ""},
+		{"up part way", 1, 3, "+2 +3", ""},
+		{"already there", 4, 4, "", ""},
+		{"down one", 4, 3, "-4", ""},
+		{"down to the irreversible migration", 4, 2, "-4 -3", ""},
+		{"down past the irreversible migration", 4, 1, "", "002_seed.sql cannot be reverted"},
+		{"down to empty", 4, 0, "", "002_seed.sql cannot be reverted"},
+		{"target above latest", 0, 5, "", "from version 0 to 5 with 4 migrations"},
+		{"negative target", 4, -1, "", "from version 4 to -1"},
+		{"current above latest", 5, 4, "", "from version 5 to 4"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			plan, err := m.plan(tc.current, tc.target)
+			if tc.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tc.wantErr) {
+					t.Fatalf("err = %v, want %q", err, tc.wantErr)
+				}
+				if plan != nil {
+					t.Errorf("plan %q returned with an error", planned(plan))
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := planned(plan); got != tc.want {
+				t.Errorf("plan = %q, want %q", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestMigrateToRejectsOutOfRangeTargets(t *testing.T) {
+	// The range is checked before the pool is touched, so a nil pool is fine
+	m := testMigrator(t)
+	for _, target := range []int32{-1, m.Latest() + 1} {
+		if _, err := m.MigrateTo(context.Background(), target); err == nil || !strings.Contains(err.Error(), "out of range 0 to 4") {
+			t.Errorf("MigrateTo(%d) err = %v, want out of range", target, err)
+		}
+	}
+}
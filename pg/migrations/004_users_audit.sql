@@ -0,0 +1,46 @@
+-- Row-level audit trail for users. The database writes it itself, so changes
+-- made outside the service (manual SQL fixes, scripts) are recorded too.
+CREATE TABLE IF NOT EXISTS users_audit (
+    id BIGSERIAL PRIMARY KEY,
+    operation VARCHAR(6) NOT NULL,
+    row_id TEXT,
+    old_row JSONB,
+    new_row JSONB,
+    txid BIGINT NOT NULL,
+    application_name TEXT NOT NULL,
+    db_user TEXT NOT NULL,
+    changed_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT clock_timestamp()
+);
+
+CREATE INDEX IF NOT EXISTS users_audit_changed_at_idx ON users_audit (changed_at);
+CREATE INDEX IF NOT EXISTS users_audit_row_id_idx ON users_audit (row_id);
+
+-- Generic row audit trigger; the audit table is passed as the trigger argument
+CREATE OR REPLACE FUNCTION audit_row_change() RETURNS trigger AS $$
+DECLARE
+    old_row JSONB;
+    new_row JSONB;
+BEGIN
+    IF TG_OP IN ('UPDATE', 'DELETE') THEN
+        old_row := to_jsonb(OLD);
+    END IF;
+    IF TG_OP IN ('INSERT', 'UPDATE') THEN
+        new_row := to_jsonb(NEW);
+    END IF;
+    EXECUTE format(
+        'INSERT INTO %I (operation, row_id, old_row, new_row, txid, application_name, db_user) VALUES ($1, $2, $3, $4, $5, $6, $7)',
+        TG_ARGV[0])
+    USING TG_OP, COALESCE(new_row, old_row)->>'id', old_row, new_row, txid_current(),
+        COALESCE(current_setting('application_name', true), ''), session_user;
+    RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER users_audit AFTER INSERT OR UPDATE OR DELETE ON users
+    FOR EACH ROW EXECUTE FUNCTION audit_row_change('users_audit');
+
+---- create above / drop below ----
+
+DROP TRIGGER IF EXISTS users_audit ON users;
+DROP FUNCTION IF EXISTS audit_row_change();
+DROP TABLE IF EXISTS users_audit;
\ No newline at end of file
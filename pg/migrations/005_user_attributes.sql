@@ -0,0 +1,11 @@
+-- Custom profile attributes. Allowed keys and their rules live in the Rigel
+-- attribute schema, so new attributes need no migration.
+ALTER TABLE users
+ADD COLUMN attributes JSONB NOT NULL DEFAULT '{}'::jsonb,
+ADD CONSTRAINT users_attributes_object CHECK (jsonb_typeof(attributes) = 'object');
+
+---- create above / drop below ----
+
+ALTER TABLE users
+DROP CONSTRAINT IF EXISTS users_attributes_object,
+DROP COLUMN IF EXISTS attributes;
\ No newline at end of file
@@ -0,0 +1,36 @@
+-- Multi-tenancy. Every user belongs to a tenant, and row-level security
+-- limits each session to the tenant in its app.tenant_id setting, which the
+-- pg package sets from the request context. Existing users move to the
+-- 'default' tenant.
+ALTER TABLE users ADD COLUMN tenant_id VARCHAR(63) NOT NULL DEFAULT 'default';
+ALTER TABLE users ALTER COLUMN tenant_id DROP DEFAULT;
+
+-- Emails and usernames are unique within a tenant
+ALTER TABLE users
+DROP CONSTRAINT IF EXISTS users_email_unique,
+DROP CONSTRAINT IF EXISTS users_username_unique,
+ADD CONSTRAINT users_tenant_email_unique UNIQUE (tenant_id, email),
+ADD CONSTRAINT users_tenant_username_unique UNIQUE (tenant_id, username);
+
+-- FORCE applies the policy to the table owner, which the service connects as
+ALTER TABLE users ENABLE ROW LEVEL SECURITY;
+ALTER TABLE users FORCE ROW LEVEL SECURITY;
+
+CREATE POLICY users_tenant_isolation ON users
+    USING (tenant_id = current_setting('app.tenant_id', true))
+    WITH CHECK (tenant_id = current_setting('app.tenant_id', true));
+
+---- create above / drop below ----
+
+-- Fails if two tenants share an email or username
+DROP POLICY IF EXISTS users_tenant_isolation ON users;
+ALTER TABLE users NO FORCE ROW LEVEL SECURITY;
+ALTER TABLE users DISABLE ROW LEVEL SECURITY;
+
+ALTER TABLE users
+DROP CONSTRAINT IF EXISTS users_tenant_email_unique,
+DROP CONSTRAINT IF EXISTS users_tenant_username_unique,
+ADD CONSTRAINT users_email_unique UNIQUE (email),
+ADD CONSTRAINT users_username_unique UNIQUE (username);
+
+ALTER TABLE users DROP COLUMN IF EXISTS tenant_id;
\ No newline at end of file
@@ -0,0 +1,48 @@
+-- Groups organise the users of a tenant into teams. Memberships carry the
+-- tenant too, and the composite foreign keys keep a group and its members in
+-- the same tenant. Row-level security works as on users.
+ALTER TABLE users ADD CONSTRAINT users_tenant_id_unique UNIQUE (tenant_id, id);
+
+CREATE TABLE IF NOT EXISTS groups (
+    id SERIAL PRIMARY KEY,
+    tenant_id VARCHAR(63) NOT NULL,
+    name VARCHAR(100) NOT NULL,
+    created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    CONSTRAINT groups_tenant_name_unique UNIQUE (tenant_id, name),
+    CONSTRAINT groups_tenant_id_unique UNIQUE (tenant_id, id)
+);
+
+CREATE TABLE IF NOT EXISTS group_members (
+    tenant_id VARCHAR(63) NOT NULL,
+    group_id INTEGER NOT NULL,
+    user_id INTEGER NOT NULL,
+    added_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (group_id, user_id),
+    CONSTRAINT group_members_group_fk FOREIGN KEY (tenant_id, group_id)
+        REFERENCES groups (tenant_id, id) ON DELETE CASCADE,
+    CONSTRAINT group_members_user_fk FOREIGN KEY (tenant_id, user_id)
+        REFERENCES users (tenant_id, id) ON DELETE CASCADE
+);
+
+-- Lists the groups of a user
+CREATE INDEX IF NOT EXISTS group_members_user_id_idx ON group_members (user_id);
+
+ALTER TABLE groups ENABLE ROW LEVEL SECURITY;
+ALTER TABLE groups FORCE ROW LEVEL SECURITY;
+ALTER TABLE group_members ENABLE ROW LEVEL SECURITY;
+ALTER TABLE group_members FORCE ROW LEVEL SECURITY;
+
+CREATE POLICY groups_tenant_isolation ON groups
+    USING (tenant_id = current_setting('app.tenant_id', true))
+    WITH CHECK (tenant_id = current_setting('app.tenant_id', true));
+
+CREATE POLICY group_members_tenant_isolation ON group_members
+    USING (tenant_id = current_setting('app.tenant_id', true))
+    WITH CHECK (tenant_id = current_setting('app.tenant_id', true));
+
+---- create above / drop below ----
+
+DROP TABLE IF EXISTS group_members;
+DROP TABLE IF EXISTS groups;
+ALTER TABLE users DROP CONSTRAINT IF EXISTS users_tenant_id_unique;
\ No newline at end of file
@@ -0,0 +1,40 @@
+-- Account lifecycle. userservice decides which status changes are allowed;
+-- the database only keeps the values valid. A change may schedule a
+-- follow-up, such as the end of a suspension, which the status worker
+-- applies once scheduled_at has passed. Existing users are active.
+ALTER TABLE users
+ADD COLUMN status VARCHAR(20) NOT NULL DEFAULT 'active',
+ADD COLUMN status_reason TEXT,
+ADD COLUMN status_changed_at TIMESTAMP WITH TIME ZONE,
+ADD COLUMN scheduled_status VARCHAR(20),
+ADD COLUMN scheduled_at TIMESTAMP WITH TIME ZONE,
+ADD CONSTRAINT users_status_check
+    CHECK (status IN ('pending', 'active', 'suspended', 'locked', 'deactivated')),
+ADD CONSTRAINT users_scheduled_status_check
+    CHECK (scheduled_status IN ('pending', 'active', 'suspended', 'locked', 'deactivated')),
+ADD CONSTRAINT users_scheduled_check
+    CHECK ((scheduled_status IS NULL) = (scheduled_at IS NULL));
+
+CREATE INDEX IF NOT EXISTS users_scheduled_at_idx ON users (scheduled_at)
+    WHERE scheduled_at IS NOT NULL;
+
+-- The status worker looks for due changes in every tenant. Sessions whose
+-- tenant is '*', which no request can resolve to, may read all users. They
+-- cannot write any, as users_tenant_isolation still applies to writes.
+CREATE POLICY users_all_tenants_read ON users FOR SELECT
+    USING (current_setting('app.tenant_id', true) = '*');
+
+---- create above / drop below ----
+
+DROP POLICY IF EXISTS users_all_tenants_read ON users;
+DROP INDEX IF EXISTS users_scheduled_at_idx;
+
+ALTER TABLE users
+DROP CONSTRAINT IF EXISTS users_scheduled_check,
+DROP CONSTRAINT IF EXISTS users_scheduled_status_check,
+DROP CONSTRAINT IF EXISTS users_status_check,
+DROP COLUMN IF EXISTS scheduled_at,
+DROP COLUMN IF EXISTS scheduled_status,
+DROP COLUMN IF EXISTS status_changed_at,
+DROP COLUMN IF EXISTS status_reason,
+DROP COLUMN IF EXISTS status;
\ No newline at end of file
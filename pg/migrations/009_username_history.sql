@@ -0,0 +1,27 @@
+-- Previous usernames. A renamed user's old username stays reserved for them
+-- until reserved_until: nobody else can take it, and lookups by it find the
+-- user. Rows are kept after the reservation ends as the rename history.
+CREATE TABLE IF NOT EXISTS username_history (
+    id BIGSERIAL PRIMARY KEY,
+    tenant_id VARCHAR(63) NOT NULL,
+    user_id INTEGER NOT NULL,
+    username VARCHAR(255) NOT NULL,
+    changed_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    reserved_until TIMESTAMP WITH TIME ZONE NOT NULL,
+    CONSTRAINT username_history_user_fk FOREIGN KEY (tenant_id, user_id)
+        REFERENCES users (tenant_id, id) ON DELETE CASCADE
+);
+
+CREATE INDEX IF NOT EXISTS username_history_username_idx ON username_history (tenant_id, username);
+CREATE INDEX IF NOT EXISTS username_history_user_id_idx ON username_history (user_id);
+
+ALTER TABLE username_history ENABLE ROW LEVEL SECURITY;
+ALTER TABLE username_history FORCE ROW LEVEL SECURITY;
+
+CREATE POLICY username_history_tenant_isolation ON username_history
+    USING (tenant_id = current_setting('app.tenant_id', true))
+    WITH CHECK (tenant_id = current_setting('app.tenant_id', true));
+
+---- create above / drop below ----
+
+DROP TABLE IF EXISTS username_history;
\ No newline at end of file
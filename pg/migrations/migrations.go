@@ -0,0 +1,10 @@
+// Package migrations embeds the schema migrations so the service binary can
+// apply them without a copy of this directory
+package migrations
+
+import "embed"
+
+// FS holds the migration files, named <version>_<name>.sql in tern's format
+//
+//go:embed *.sql
+var FS embed.FS
@@ -3,68 +3,218 @@ package pg
 import (
 	"context"
 	"fmt"
-	"log"
+	"net"
+	"net/url"
+	"strconv"
+	"time"
 
 	"github.com/jackc/pgx/v5/pgxpool"
 	"github.com/synapsewave/remiges-demo/pg/sqlc-gen"
 )
 
+// Config holds the connection and pool settings for the user database.
+// Zero values leave pgxpool's defaults in place.
 type Config struct {
 	Host     string
 	Port     int
 	User     string
 	Password string
 	DBName   string
+
+	// SSLMode is a libpq sslmode such as disable, require or verify-full.
+	// Defaults to disable.
+	SSLMode string
+	// ApplicationName is reported in pg_stat_activity
+	ApplicationName string
+
+	// Pool sizing and connection recycling
+	MaxConns          int32
+	MinConns          int32
+	MaxConnLifetime   time.Duration
+	MaxConnIdleTime   time.Duration
+	HealthCheckPeriod time.Duration
+
+	// StatementTimeout cancels statements running longer than this on the server
+	StatementTimeout time.Duration
+	// ConnectTimeout bounds each connection attempt
+	ConnectTimeout time.Duration
+
+	// ConnectRetries is how many times the initial connection is attempted.
+	// The wait starts at RetryBackoff and doubles after every failed attempt.
+	ConnectRetries int
+	RetryBackoff   time.Duration
+
+	// OnRetry, if set, is called after each failed attempt that will be retried
+	OnRetry func(attempt int, err error, wait time.Duration)
+
+	// Replicas are host:port addresses of read replicas. They share the
+	// primary's credentials and pool settings.
+	Replicas []string
+	// MaxReplicaLag takes a replica out of rotation while it is further
+	// behind than this. Defaults to 10s.
+	MaxReplicaLag time.Duration
+	// ReplicaCheckInterval is how often replica health and lag are checked.
+	// Defaults to 5s.
+	ReplicaCheckInterval time.Duration
+	// ReplicaQueries names the sqlc queries that may run on a replica.
+	// Defaults to DefaultReplicaQueries.
+	ReplicaQueries []string
+	// OnReplicaState, if set, is called when a replica becomes healthy or
+	// unhealthy
+	OnReplicaState func(ReplicaState)
 }
 
+const (
+	defaultConnectRetries = 5
+	defaultRetryBackoff   = time.Second
+	maxRetryBackoff       = 30 * time.Second
+)
+
 type Provider struct {
 	pool    *pgxpool.Pool
+	router  *Router
 	queries *sqlc.Queries
 }
 
-func NewProvider(cfg Config) *Provider {
-	ctx := context.Background()
-	
-	// Build connection string in pgx format
-	connStr := fmt.Sprintf("postgres://%s:%s@%s:%d/%s?sslmode=disable", 
-		cfg.User, cfg.Password, cfg.Host, cfg.Port, cfg.DBName)
+// NewProvider creates the connection pool and waits until the database
+// answers a ping, retrying with exponential backoff so the service can
+// start before Postgres is ready
+func NewProvider(ctx context.Context, cfg Config) (*Provider, error) {
+	poolConfig, err := PoolConfig(cfg)
+	if err != nil {
+		return nil, err
+	}
 
-	// Create a connection pool
-	poolConfig, err := pgxpool.ParseConfig(connStr)
+	pool, err := connect(ctx, poolConfig, cfg)
 	if err != nil {
-		log.Fatalf("Failed to parse connection string: %v", err)
-	}
-	
-	// You can configure pool settings here if needed
-	// poolConfig.MaxConns = 25
-	// poolConfig.MinConns = 5
-	
-	pool, err := pgxpool.NewWithConfig(ctx, poolConfig)
+		return nil, err
+	}
+	if len(cfg.Replicas) == 0 {
+		return &Provider{pool: pool, queries: sqlc.New(pool)}, nil
+	}
+
+	router, err := newRouter(ctx, pool, cfg)
 	if err != nil {
-		log.Fatalf("Failed to create connection pool: %v", err)
+		pool.Close()
+		return nil, err
+	}
+	return &Provider{pool: pool, router: router, queries: sqlc.New(router)}, nil
+}
+
+// PoolConfig builds the pgxpool configuration for cfg
+func PoolConfig(cfg Config) (*pgxpool.Config, error) {
+	sslMode := cfg.SSLMode
+	if sslMode == "" {
+		sslMode = "disable"
+	}
+	query := url.Values{"sslmode": {sslMode}}
+	if cfg.ApplicationName != "" {
+		query.Set("application_name", cfg.ApplicationName)
+	}
+	if cfg.ConnectTimeout > 0 {
+		query.Set("connect_timeout", strconv.Itoa(max(1, int(cfg.ConnectTimeout.Seconds()))))
+	}
+	// url.URL escapes the credentials, which may contain any character
+	connURL := url.URL{
+		Scheme:   "postgres",
+		User:     url.UserPassword(cfg.User, cfg.Password),
+		Host:     net.JoinHostPort(cfg.Host, strconv.Itoa(cfg.Port)),
+		Path:     "/" + cfg.DBName,
+		RawQuery: query.Encode(),
 	}
 
-	// Test the connection
-	err = pool.Ping(ctx)
+	poolConfig, err := pgxpool.ParseConfig(connURL.String())
 	if err != nil {
-		log.Fatalf("Failed to ping database: %v", err)
+		return nil, fmt.Errorf("invalid database configuration: %w", err)
+	}
+
+	if cfg.MaxConns > 0 {
+		poolConfig.MaxConns = cfg.MaxConns
+	}
+	if cfg.MinConns > 0 {
+		poolConfig.MinConns = cfg.MinConns
+	}
+	if poolConfig.MinConns > poolConfig.MaxConns {
+		return nil, fmt.Errorf("invalid database configuration: min conns (%d) exceeds max conns (%d)", poolConfig.MinConns, poolConfig.MaxConns)
+	}
+	if cfg.MaxConnLifetime > 0 {
+		poolConfig.MaxConnLifetime = cfg.MaxConnLifetime
+	}
+	if cfg.MaxConnIdleTime > 0 {
+		poolConfig.MaxConnIdleTime = cfg.MaxConnIdleTime
+	}
+	if cfg.HealthCheckPeriod > 0 {
+		poolConfig.HealthCheckPeriod = cfg.HealthCheckPeriod
+	}
+	if cfg.StatementTimeout > 0 {
+		poolConfig.ConnConfig.RuntimeParams["statement_timeout"] = strconv.FormatInt(cfg.StatementTimeout.Milliseconds(), 10)
 	}
 
-	log.Println("Successfully connected to the database using pgxpool")
+	// Row-level security reads the tenant from the session; replica pools
+	// copy these hooks with the rest of the configuration
+	sessions := &tenantSessions{}
+	poolConfig.BeforeAcquire = sessions.beforeAcquire
+	poolConfig.BeforeClose = sessions.beforeClose
+	return poolConfig, nil
+}
 
-	queries := sqlc.New(pool)
+// connect opens the pool and pings it until it succeeds, the retries run
+// out or ctx is cancelled
+func connect(ctx context.Context, poolConfig *pgxpool.Config, cfg Config) (*pgxpool.Pool, error) {
+	retries := cfg.ConnectRetries
+	if retries <= 0 {
+		retries = defaultConnectRetries
+	}
+	wait := cfg.RetryBackoff
+	if wait <= 0 {
+		wait = defaultRetryBackoff
+	}
 
-	return &Provider{pool: pool, queries: queries}
+	var lastErr error
+	for attempt := 1; ; attempt++ {
+		pool, err := pgxpool.NewWithConfig(ctx, poolConfig)
+		if err == nil {
+			if err = pool.Ping(ctx); err == nil {
+				return pool, nil
+			}
+			pool.Close()
+		}
+		lastErr = err
+
+		if attempt >= retries {
+			break
+		}
+		if cfg.OnRetry != nil {
+			cfg.OnRetry(attempt, err, wait)
+		}
+		select {
+		case <-ctx.Done():
+			return nil, fmt.Errorf("connecting to database: %w (last error: %v)", ctx.Err(), lastErr)
+		case <-time.After(wait):
+		}
+		wait = min(2*wait, maxRetryBackoff)
+	}
+	return nil, fmt.Errorf("connecting to database after %d attempts: %w", retries, lastErr)
 }
 
 func (p *Provider) Pool() *pgxpool.Pool {
 	return p.pool
 }
 
+// Queries runs read-only queries on a replica when replicas are configured;
+// see Router
 func (p *Provider) Queries() *sqlc.Queries {
 	return p.queries
 }
 
+// Router returns the replica router, or nil when no replicas are configured
+func (p *Provider) Router() *Router {
+	return p.router
+}
+
 func (p *Provider) Close() {
+	if p.router != nil {
+		p.router.Close()
+	}
 	p.pool.Close()
-}
\ No newline at end of file
+}
@@ -0,0 +1,197 @@
+package pg
+
+import (
+	"context"
+	"errors"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestPoolConfig(t *testing.T) {
+	base := Config{Host: "db.internal", Port: 5432, User: "alyatest", Password: "alyatest", DBName: "alyatest"}
+
+	for _, tc := range []struct {
+		name    string
+		edit    func(*Config)
+		wantErr string
+		check   func(t *testing.T, cfg Config)
+	}{
+		{
+			name: "credentials are escaped",
+			edit: func(c *Config) { c.User, c.Password, c.DBName = "app@svc", "p@ss:w/rd?#% x", "users db" },
+			check: func(t *testing.T, cfg Config) {
+				pc, _ := PoolConfig(cfg)
+				if pc.ConnConfig.User != "app@svc" || pc.ConnConfig.Password != "p@ss:w/rd?#% x" || pc.ConnConfig.Database != "users db" {
+					t.Errorf("user %q, password %q, database %q", pc.ConnConfig.User, pc.ConnConfig.Password, pc.ConnConfig.Database)
+				}
+				if pc.ConnConfig.Host != "db.internal" || pc.ConnConfig.Port != 5432 {
+					t.Errorf("host %s:%d", pc.ConnConfig.Host, pc.ConnConfig.Port)
+				}
+			},
+		},
+		{
+			name: "sslmode defaults to disable",
+			check: func(t *testing.T, cfg Config) {
+				pc, _ := PoolConfig(cfg)
+				if pc.ConnConfig.TLSConfig != nil {
+					t.Error("TLS configured without an sslmode")
+				}
+			},
+		},
+		{
+			name: "sslmode require uses TLS",
+			edit: func(c *Config) { c.SSLMode = "require" },
+			check: func(t *testing.T, cfg Config) {
+				pc, _ := PoolConfig(cfg)
+				if pc.ConnConfig.TLSConfig == nil {
+					t.Error("no TLS for sslmode require")
+				}
+			},
+		},
+		{
+			name:    "unknown sslmode",
+			edit:    func(c *Config) { c.SSLMode = "sometimes" },
+			wantErr: "invalid database configuration",
+		},
+		{
+			name:    "min conns above max conns",
+			edit:    func(c *Config) { c.MinConns, c.MaxConns = 10, 5 },
+			wantErr: "min conns (10) exceeds max conns (5)",
+		},
+		{
+			name:    "min conns above the default max conns",
+			edit:    func(c *Config) { c.MinConns = 1000 },
+			wantErr: "exceeds max conns",
+		},
+		{
+			name: "pool sizing and timeouts",
+			edit: func(c *Config) {
+				c.MinConns, c.MaxConns = 2, 8
+				c.StatementTimeout = 1500 * time.Millisecond
+				c.ConnectTimeout = 3 * time.Second
+				c.ApplicationName = "usersvc"
+			},
+			check: func(t *testing.T, cfg Config) {
+				pc, _ := PoolConfig(cfg)
+				if pc.MinConns != 2 || pc.MaxConns != 8 {
+					t.Errorf("conns %d-%d, want 2-8", pc.MinConns, pc.MaxConns)
+				}
+				params := pc.ConnConfig.RuntimeParams
+				if params["statement_timeout"] != "1500" {
+					t.Errorf("statement_timeout = %q, want 1500", params["statement_timeout"])
+				}
+				if params["application_name"] != "usersvc" {
+					t.Errorf("application_name = %q", params["application_name"])
+				}
+				if pc.ConnConfig.ConnectTimeout != 3*time.Second {
+					t.Errorf("connect timeout = %s, want 3s", pc.ConnConfig.ConnectTimeout)
+				}
+			},
+		},
+		{
+			name: "no statement timeout by default",
+			check: func(t *testing.T, cfg Config) {
+				pc, _ := PoolConfig(cfg)
+				if _, ok := pc.ConnConfig.RuntimeParams["statement_timeout"]; ok {
+					t.Error("statement_timeout set without a configured timeout")
+				}
+			},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			cfg := base
+			if tc.edit != nil {
+				tc.edit(&cfg)
+			}
+			_, err := PoolConfig(cfg)
+			if tc.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tc.wantErr) {
+					t.Fatalf("err = %v, want %q", err, tc.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			tc.check(t, cfg)
+		})
+	}
+}
+
+// closedPort returns a local address nothing listens on
+func closedPort(t *testing.T) int {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	port := l.Addr().(*net.TCPAddr).Port
+	l.Close()
+	return port
+}
+
+func unreachableConfig(t *testing.T) Config {
+	return Config{Host: "127.0.0.1", Port: closedPort(t), User: "alyatest", Password: "alyatest", DBName: "alyatest", ConnectTimeout: time.Second}
+}
+
+func TestConnectRetriesWithBackoff(t *testing.T) {
+	cfg := unreachableConfig(t)
+	cfg.ConnectRetries = 4
+	cfg.RetryBackoff = time.Millisecond
+	var waits []time.Duration
+	cfg.OnRetry = func(attempt int, err error, wait time.Duration) {
+		if attempt != len(waits)+1 || err == nil {
+			t.Errorf("OnRetry(%d, %v)", attempt, err)
+		}
+		waits = append(waits, wait)
+	}
+	poolConfig, err := PoolConfig(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = connect(context.Background(), poolConfig, cfg)
+	if err == nil || !strings.Contains(err.Error(), "after 4 attempts") {
+		t.Fatalf("err = %v, want failure after 4 attempts", err)
+	}
+	want := []time.Duration{time.Millisecond, 2 * time.Millisecond, 4 * time.Millisecond}
+	if len(waits) != len(want) {
+		t.Fatalf("waits = %v, want %v", waits, want)
+	}
+	for i := range want {
+		if waits[i] != want[i] {
+			t.Errorf("waits = %v, want %v", waits, want)
+			break
+		}
+	}
+}
+
+func TestConnectStopsWhenContextIsCancelled(t *testing.T) {
+	cfg := unreachableConfig(t)
+	cfg.ConnectRetries = 100
+	cfg.RetryBackoff = time.Hour
+	ctx, cancel := context.WithCancel(context.Background())
+	retries := 0
+	cfg.OnRetry = func(int, error, time.Duration) {
+		retries++
+		cancel()
+	}
+	poolConfig, err := PoolConfig(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	start := time.Now()
+	_, err = connect(ctx, poolConfig, cfg)
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("err = %v, want context.Canceled", err)
+	}
+	if retries != 1 {
+		t.Errorf("retried %d times after cancellation, want 1", retries)
+	}
+	if elapsed := time.Since(start); elapsed > 10*time.Second {
+		t.Errorf("connect took %s after cancellation", elapsed)
+	}
+}
@@ -5,20 +5,29 @@ INSERT INTO users (
     username,
     created_at,
     updated_at,
-    phone_number
+    phone_number,
+    attributes,
+    tenant_id,
+    status
 ) VALUES (
-    $1, $2, $3, CURRENT_TIMESTAMP, CURRENT_TIMESTAMP, $4
-) RETURNING id, name, email, username, phone_number, created_at, updated_at;
+    $1, $2, $3, CURRENT_TIMESTAMP, CURRENT_TIMESTAMP, $4, $5, $6, $7
+) RETURNING id, name, email, username, phone_number, created_at, updated_at, attributes, tenant_id, status, status_reason, status_changed_at, scheduled_status, scheduled_at;
 
 -- name: CheckUsernameExists :one
 SELECT EXISTS(
-    SELECT 1 FROM users WHERE username = $1
+    SELECT 1 FROM users WHERE tenant_id = $1 AND username = $2
 ) AS exists;
 
 -- name: GetUserByID :one
-SELECT id, name, email, username, phone_number, created_at, updated_at
+SELECT id, name, email, username, phone_number, created_at, updated_at, attributes, tenant_id, status, status_reason, status_changed_at, scheduled_status, scheduled_at
 FROM users
-WHERE id = $1;
+WHERE tenant_id = $1 AND id = $2;
+
+-- name: ListUsersAudit :many
+SELECT id, operation, row_id, old_row, new_row, txid, application_name, db_user, changed_at
+FROM users_audit
+WHERE changed_at >= sqlc.arg(changed_from) AND changed_at < sqlc.arg(changed_to)
+ORDER BY id;
 
 -- name: UpdateUser :one
 UPDATE users
@@ -26,11 +35,163 @@ SET
     name = COALESCE(sqlc.narg(name), name),
     email = COALESCE(sqlc.narg(email), email),
     phone_number = COALESCE(sqlc.narg(phone_number), phone_number),
+    attributes = (attributes || COALESCE(sqlc.narg(set_attributes)::jsonb, '{}')) - COALESCE(sqlc.narg(unset_attributes)::text[], '{}'),
     updated_at = CURRENT_TIMESTAMP
-WHERE id = $1
-RETURNING id, name, email, username, phone_number, created_at, updated_at;
+WHERE id = $1 AND tenant_id = $2
+RETURNING id, name, email, username, phone_number, created_at, updated_at, attributes, tenant_id, status, status_reason, status_changed_at, scheduled_status, scheduled_at;
 
 -- name: CheckEmailExistsForUpdate :one
 SELECT EXISTS(
-    SELECT 1 FROM users WHERE email = $1 AND id != $2
-) AS exists;
\ No newline at end of file
+    SELECT 1 FROM users WHERE tenant_id = $1 AND email = $2 AND id != $3
+) AS exists;
+
+-- name: CheckGroupNameExists :one
+SELECT EXISTS(
+    SELECT 1 FROM groups WHERE tenant_id = $1 AND name = $2
+) AS exists;
+
+-- name: CheckGroupNameExistsForRename :one
+SELECT EXISTS(
+    SELECT 1 FROM groups WHERE tenant_id = $1 AND name = $2 AND id != $3
+) AS exists;
+
+-- name: CreateGroup :one
+INSERT INTO groups (
+    tenant_id,
+    name,
+    created_at,
+    updated_at
+) VALUES (
+    $1, $2, CURRENT_TIMESTAMP, CURRENT_TIMESTAMP
+) RETURNING id, tenant_id, name, created_at, updated_at;
+
+-- name: GetGroupByID :one
+SELECT id, tenant_id, name, created_at, updated_at
+FROM groups
+WHERE tenant_id = $1 AND id = $2;
+
+-- name: RenameGroup :one
+UPDATE groups
+SET
+    name = $3,
+    updated_at = CURRENT_TIMESTAMP
+WHERE tenant_id = $1 AND id = $2
+RETURNING id, tenant_id, name, created_at, updated_at;
+
+-- name: DeleteGroup :execrows
+DELETE FROM groups
+WHERE tenant_id = $1 AND id = $2;
+
+-- name: CountGroupMembers :one
+SELECT COUNT(*) FROM group_members
+WHERE tenant_id = $1 AND group_id = $2;
+
+-- name: AddGroupMember :execrows
+INSERT INTO group_members (
+    tenant_id,
+    group_id,
+    user_id,
+    added_at
+) VALUES (
+    $1, $2, $3, CURRENT_TIMESTAMP
+) ON CONFLICT (group_id, user_id) DO NOTHING;
+
+-- name: RemoveGroupMember :execrows
+DELETE FROM group_members
+WHERE tenant_id = $1 AND group_id = $2 AND user_id = $3;
+
+-- name: ListUserGroups :many
+SELECT g.id, g.tenant_id, g.name, g.created_at, g.updated_at
+FROM groups g
+JOIN group_members m ON m.tenant_id = g.tenant_id AND m.group_id = g.id
+WHERE m.tenant_id = $1 AND m.user_id = $2
+ORDER BY g.name, g.id;
+
+-- name: ListGroupMembers :many
+SELECT u.id, u.name, u.email, u.username, m.added_at
+FROM group_members m
+JOIN users u ON u.tenant_id = m.tenant_id AND u.id = m.user_id
+WHERE m.tenant_id = sqlc.arg(tenant_id) AND m.group_id = sqlc.arg(group_id) AND m.user_id > sqlc.arg(after_id)
+ORDER BY m.user_id
+LIMIT sqlc.arg(page_size);
+
+-- name: ChangeUserStatus :one
+UPDATE users
+SET
+    status = sqlc.arg(status),
+    status_reason = sqlc.arg(status_reason),
+    status_changed_at = CURRENT_TIMESTAMP,
+    scheduled_status = sqlc.narg(scheduled_status),
+    scheduled_at = sqlc.narg(scheduled_at),
+    updated_at = CURRENT_TIMESTAMP
+WHERE tenant_id = sqlc.arg(tenant_id) AND id = sqlc.arg(id) AND status = sqlc.arg(from_status)
+RETURNING id, name, email, username, phone_number, created_at, updated_at, attributes, tenant_id, status, status_reason, status_changed_at, scheduled_status, scheduled_at;
+
+-- name: ListDueStatusChanges :many
+SELECT id, tenant_id, status, status_reason, scheduled_status, scheduled_at
+FROM users
+WHERE scheduled_at <= CURRENT_TIMESTAMP
+ORDER BY scheduled_at
+LIMIT $1;
+
+-- name: ApplyScheduledStatus :execrows
+UPDATE users
+SET
+    status = scheduled_status,
+    status_reason = $5,
+    status_changed_at = CURRENT_TIMESTAMP,
+    scheduled_status = NULL,
+    scheduled_at = NULL,
+    updated_at = CURRENT_TIMESTAMP
+WHERE tenant_id = $1 AND id = $2 AND status = $3 AND scheduled_at = $4;
+
+-- name: GetUserByUsername :one
+SELECT id, name, email, username, phone_number, created_at, updated_at, attributes, tenant_id, status, status_reason, status_changed_at, scheduled_status, scheduled_at
+FROM users
+WHERE tenant_id = $1 AND username = $2;
+
+-- name: GetUserByPreviousUsername :one
+SELECT u.id, u.name, u.email, u.username, u.phone_number, u.created_at, u.updated_at, u.attributes, u.tenant_id, u.status, u.status_reason, u.status_changed_at, u.scheduled_status, u.scheduled_at
+FROM username_history h
+JOIN users u ON u.tenant_id = h.tenant_id AND u.id = h.user_id
+WHERE h.tenant_id = $1 AND h.username = $2 AND h.reserved_until > CURRENT_TIMESTAMP
+ORDER BY h.changed_at DESC
+LIMIT 1;
+
+-- name: CheckUsernameReserved :one
+SELECT EXISTS(
+    SELECT 1 FROM username_history
+    WHERE tenant_id = $1 AND username = $2 AND user_id != $3 AND reserved_until > CURRENT_TIMESTAMP
+) AS exists;
+
+-- name: ChangeUsername :one
+-- Renames only if nobody else has new_username and it is not reserved for
+-- another user. A rename that gives a username up commits its history row
+-- together with the change, so the statement's snapshot sees either the
+-- holder or the reservation.
+WITH target AS (
+    SELECT tenant_id, id, username
+    FROM users
+    WHERE tenant_id = sqlc.arg(tenant_id) AND id = sqlc.arg(id) AND username = sqlc.arg(old_username)
+        AND NOT EXISTS (
+            SELECT 1 FROM users other
+            WHERE other.tenant_id = sqlc.arg(tenant_id) AND other.username = sqlc.arg(new_username) AND other.id != sqlc.arg(id)
+        )
+        AND NOT EXISTS (
+            SELECT 1 FROM username_history h
+            WHERE h.tenant_id = sqlc.arg(tenant_id) AND h.username = sqlc.arg(new_username)
+                AND h.user_id != sqlc.arg(id) AND h.reserved_until > CURRENT_TIMESTAMP
+        )
+    FOR UPDATE OF users
+), history AS (
+    INSERT INTO username_history (tenant_id, user_id, username, changed_at, reserved_until)
+    SELECT tenant_id, id, username, CURRENT_TIMESTAMP, sqlc.arg(reserved_until)::timestamptz
+    FROM target
+)
+UPDATE users
+SET
+    username = sqlc.arg(new_username),
+    updated_at = CURRENT_TIMESTAMP
+FROM target
+WHERE users.tenant_id = target.tenant_id AND users.id = target.id
+RETURNING users.*;
\ No newline at end of file
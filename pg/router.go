@@ -0,0 +1,241 @@
+package pg
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/synapsewave/remiges-demo/pg/sqlc-gen"
+)
+
+const (
+	defaultMaxReplicaLag        = 10 * time.Second
+	defaultReplicaCheckInterval = 5 * time.Second
+)
+
+// DefaultReplicaQueries are the sqlc queries sent to replicas when
+// Config.ReplicaQueries is empty. Uniqueness checks stay on the primary
+// because they guard writes.
+var DefaultReplicaQueries = []string{"GetUserByID"}
+
+// ReplicaState describes a replica after a health check
+type ReplicaState struct {
+	Addr    string
+	Healthy bool
+	Lag     time.Duration
+	Err     error
+}
+
+// replicaLagQuery reports replay lag in seconds. A replica that has replayed
+// everything it received is treated as current, since the last replay
+// timestamp only moves when the primary commits.
+const replicaLagQuery = `SELECT pg_is_in_recovery(),
+	CASE WHEN pg_last_wal_receive_lsn() = pg_last_wal_replay_lsn() THEN 0
+	     ELSE COALESCE(EXTRACT(EPOCH FROM now() - pg_last_xact_replay_timestamp()), 0)
+	END::float8`
+
+type replica struct {
+	addr    string
+	pool    *pgxpool.Pool
+	healthy atomic.Bool
+	lag     atomic.Int64 // nanoseconds
+}
+
+// Router is a sqlc.DBTX that sends read-only queries to replicas. Queries
+// are recognised by the name sqlc puts at the start of their text; anything
+// not listed, every Exec and every query made with a WithPrimary context
+// goes to the primary. Replicas are picked round-robin among those that
+// passed their last health check within the lag limit, falling back to the
+// primary when there are none.
+type Router struct {
+	primary  *pgxpool.Pool
+	replicas []*replica
+	readOnly map[string]bool
+	maxLag   time.Duration
+	interval time.Duration
+	onState  func(ReplicaState)
+	next     atomic.Uint64
+
+	stop chan struct{}
+	done sync.WaitGroup
+}
+
+var _ sqlc.DBTX = (*Router)(nil)
+
+type primaryKey struct{}
+
+// WithPrimary returns a context whose queries always go to the primary. Use
+// it when a read must see writes the caller has just made.
+func WithPrimary(ctx context.Context) context.Context {
+	return context.WithValue(ctx, primaryKey{}, true)
+}
+
+// newRouter opens a pool for every replica in cfg. Replica pools connect
+// lazily, so a replica that is down at startup is only marked unhealthy.
+func newRouter(ctx context.Context, primary *pgxpool.Pool, cfg Config) (*Router, error) {
+	r := &Router{
+		primary:  primary,
+		readOnly: make(map[string]bool),
+		maxLag:   cfg.MaxReplicaLag,
+		interval: cfg.ReplicaCheckInterval,
+		onState:  cfg.OnReplicaState,
+		stop:     make(chan struct{}),
+	}
+	if r.maxLag <= 0 {
+		r.maxLag = defaultMaxReplicaLag
+	}
+	if r.interval <= 0 {
+		r.interval = defaultReplicaCheckInterval
+	}
+	names := cfg.ReplicaQueries
+	if len(names) == 0 {
+		names = DefaultReplicaQueries
+	}
+	for _, name := range names {
+		r.readOnly[name] = true
+	}
+
+	for _, addr := range cfg.Replicas {
+		host, portStr, err := net.SplitHostPort(addr)
+		if err != nil {
+			r.Close()
+			return nil, fmt.Errorf("invalid replica address %q: %w", addr, err)
+		}
+		port, err := strconv.ParseUint(portStr, 10, 16)
+		if err != nil {
+			r.Close()
+			return nil, fmt.Errorf("invalid replica address %q: %w", addr, err)
+		}
+		poolConfig := primary.Config()
+		poolConfig.ConnConfig.Host = host
+		poolConfig.ConnConfig.Port = uint16(port)
+		poolConfig.ConnConfig.Fallbacks = nil
+		pool, err := pgxpool.NewWithConfig(ctx, poolConfig)
+		if err != nil {
+			r.Close()
+			return nil, fmt.Errorf("opening replica %s: %w", addr, err)
+		}
+		r.replicas = append(r.replicas, &replica{addr: addr, pool: pool})
+	}
+
+	// Check once before serving so reads use replicas straight away
+	r.checkAll(ctx)
+	r.done.Add(1)
+	go r.run()
+	return r, nil
+}
+
+func (r *Router) run() {
+	defer r.done.Done()
+	ticker := time.NewTicker(r.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-r.stop:
+			return
+		case <-ticker.C:
+			ctx, cancel := context.WithTimeout(context.Background(), r.interval)
+			r.checkAll(ctx)
+			cancel()
+		}
+	}
+}
+
+func (r *Router) checkAll(ctx context.Context) {
+	for _, rep := range r.replicas {
+		state := r.check(ctx, rep)
+		changed := rep.healthy.Swap(state.Healthy) != state.Healthy
+		rep.lag.Store(int64(state.Lag))
+		if changed && r.onState != nil {
+			r.onState(state)
+		}
+	}
+}
+
+func (r *Router) check(ctx context.Context, rep *replica) ReplicaState {
+	var inRecovery bool
+	var lagSeconds float64
+	if err := rep.pool.QueryRow(ctx, replicaLagQuery).Scan(&inRecovery, &lagSeconds); err != nil {
+		return ReplicaState{Addr: rep.addr, Err: err}
+	}
+	return r.evaluate(rep.addr, inRecovery, lagSeconds)
+}
+
+// evaluate decides the health of a replica from the result of replicaLagQuery
+func (r *Router) evaluate(addr string, inRecovery bool, lagSeconds float64) ReplicaState {
+	state := ReplicaState{Addr: addr, Lag: time.Duration(lagSeconds * float64(time.Second))}
+	switch {
+	case !inRecovery:
+		// A promoted replica no longer follows the primary
+		state.Err = fmt.Errorf("replica %s is not in recovery", addr)
+	case state.Lag > r.maxLag:
+		state.Err = fmt.Errorf("replica %s is %s behind", addr, state.Lag.Round(time.Millisecond))
+	default:
+		state.Healthy = true
+	}
+	return state
+}
+
+// Replicas reports the state of every replica as of its last check
+func (r *Router) Replicas() []ReplicaState {
+	states := make([]ReplicaState, len(r.replicas))
+	for i, rep := range r.replicas {
+		states[i] = ReplicaState{Addr: rep.addr, Healthy: rep.healthy.Load(), Lag: time.Duration(rep.lag.Load())}
+	}
+	return states
+}
+
+// pick returns the pool to run sql on
+func (r *Router) pick(ctx context.Context, sql string) *pgxpool.Pool {
+	if len(r.replicas) == 0 || ctx.Value(primaryKey{}) != nil || !r.readOnly[queryName(sql)] {
+		return r.primary
+	}
+	n := uint64(len(r.replicas))
+	start := r.next.Add(1)
+	for i := uint64(0); i < n; i++ {
+		if rep := r.replicas[(start+i)%n]; rep.healthy.Load() {
+			return rep.pool
+		}
+	}
+	return r.primary
+}
+
+// queryName extracts the name from sqlc's "-- name: GetUserByID :one" header
+func queryName(sql string) string {
+	rest, ok := strings.CutPrefix(sql, "-- name: ")
+	if !ok {
+		return ""
+	}
+	name, _, _ := strings.Cut(rest, " ")
+	return name
+}
+
+func (r *Router) Exec(ctx context.Context, sql string, args ...interface{}) (pgconn.CommandTag, error) {
+	return r.primary.Exec(ctx, sql, args...)
+}
+
+func (r *Router) Query(ctx context.Context, sql string, args ...interface{}) (pgx.Rows, error) {
+	return r.pick(ctx, sql).Query(ctx, sql, args...)
+}
+
+func (r *Router) QueryRow(ctx context.Context, sql string, args ...interface{}) pgx.Row {
+	return r.pick(ctx, sql).QueryRow(ctx, sql, args...)
+}
+
+// Close stops the health checks and closes the replica pools. The primary
+// pool belongs to the Provider.
+func (r *Router) Close() {
+	close(r.stop)
+	r.done.Wait()
+	for _, rep := range r.replicas {
+		rep.pool.Close()
+	}
+}
@@ -0,0 +1,195 @@
+package pg
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+const (
+	readOnlySQL = "-- name: GetUserByID :one\nSELECT id FROM users WHERE id = $1"
+	writeSQL    = "-- name: UpdateUser :one\nUPDATE users SET name = $2 WHERE id = $1 RETURNING id"
+)
+
+// lazyPool opens a pool on an address nothing listens on. Pools connect
+// lazily, so it serves as a distinct handle for routing decisions.
+func lazyPool(t *testing.T) *pgxpool.Pool {
+	t.Helper()
+	poolConfig, err := PoolConfig(unreachableConfig(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	pool, err := pgxpool.NewWithConfig(context.Background(), poolConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(pool.Close)
+	return pool
+}
+
+// testRouter builds a Router over lazy pools whose replicas start healthy
+func testRouter(t *testing.T, replicas int) *Router {
+	r := &Router{
+		primary:  lazyPool(t),
+		readOnly: map[string]bool{"GetUserByID": true},
+		maxLag:   defaultMaxReplicaLag,
+	}
+	for i := 0; i < replicas; i++ {
+		rep := &replica{addr: fmt.Sprintf("replica-%d:5432", i), pool: lazyPool(t)}
+		rep.healthy.Store(true)
+		r.replicas = append(r.replicas, rep)
+	}
+	return r
+}
+
+// isReplica reports whether pool belongs to a replica of r
+func isReplica(r *Router, pool *pgxpool.Pool) bool {
+	for _, rep := range r.replicas {
+		if rep.pool == pool {
+			return true
+		}
+	}
+	return false
+}
+
+func TestQueryName(t *testing.T) {
+	for sql, want := range map[string]string{
+		readOnlySQL:                        "GetUserByID",
+		"-- name: ListUsers :many\nSELECT": "ListUsers",
+		"SELECT 1":                         "",
+		"  -- name: GetUserByID :one":      "",
+		"-- GetUserByID":                   "",
+	} {
+		if got := queryName(sql); got != want {
+			t.Errorf("queryName(%q) = %q, want %q", sql, got, want)
+		}
+	}
+}
+
+func TestRouterRoutesByQueryName(t *testing.T) {
+	r := testRouter(t, 2)
+	ctx := context.Background()
+
+	if !isReplica(r, r.pick(ctx, readOnlySQL)) {
+		t.Error("read-only query went to the primary")
+	}
+	for _, sql := range []string{writeSQL, "SELECT 1", "-- name: CheckUsernameExists :one\nSELECT"} {
+		if r.pick(ctx, sql) != r.primary {
+			t.Errorf("%q went to a replica", sql)
+		}
+	}
+
+	// Successive reads alternate between healthy replicas
+	first, second := r.pick(ctx, readOnlySQL), r.pick(ctx, readOnlySQL)
+	if first == second {
+		t.Error("reads were not spread across replicas")
+	}
+}
+
+func TestRouterWithPrimary(t *testing.T) {
+	r := testRouter(t, 2)
+	if pool := r.pick(WithPrimary(context.Background()), readOnlySQL); pool != r.primary {
+		t.Error("WithPrimary read went to a replica")
+	}
+}
+
+func TestRouterSkipsUnhealthyReplicas(t *testing.T) {
+	r := testRouter(t, 3)
+	ctx := context.Background()
+
+	r.replicas[0].healthy.Store(false)
+	r.replicas[2].healthy.Store(false)
+	for i := 0; i < 6; i++ {
+		if pool := r.pick(ctx, readOnlySQL); pool != r.replicas[1].pool {
+			t.Fatalf("read %d did not go to the only healthy replica", i)
+		}
+	}
+
+	r.replicas[1].healthy.Store(false)
+	for i := 0; i < 3; i++ {
+		if pool := r.pick(ctx, readOnlySQL); pool != r.primary {
+			t.Fatalf("read %d with every replica unhealthy did not go to the primary", i)
+		}
+	}
+
+	if none := testRouter(t, 0); none.pick(ctx, readOnlySQL) != none.primary {
+		t.Error("read without replicas did not go to the primary")
+	}
+}
+
+func TestReplicaLagCutoff(t *testing.T) {
+	r := &Router{maxLag: 10 * time.Second}
+
+	for _, tc := range []struct {
+		name       string
+		inRecovery bool
+		lagSeconds float64
+		healthy    bool
+		wantErr    string
+	}{
+		{"caught up", true, 0, true, ""},
+		{"within the limit", true, 9.5, true, ""},
+		{"at the limit", true, 10, true, ""},
+		{"past the limit", true, 10.25, false, "is 10.25s behind"},
+		{"promoted", false, 0, false, "is not in recovery"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			state := r.evaluate("replica:5432", tc.inRecovery, tc.lagSeconds)
+			if state.Healthy != tc.healthy {
+				t.Errorf("healthy = %v, want %v", state.Healthy, tc.healthy)
+			}
+			if want := time.Duration(tc.lagSeconds * float64(time.Second)); state.Lag != want {
+				t.Errorf("lag = %s, want %s", state.Lag, want)
+			}
+			if tc.wantErr == "" && state.Err != nil {
+				t.Errorf("err = %v", state.Err)
+			}
+			if tc.wantErr != "" && (state.Err == nil || !strings.Contains(state.Err.Error(), tc.wantErr)) {
+				t.Errorf("err = %v, want %q", state.Err, tc.wantErr)
+			}
+		})
+	}
+}
+
+func TestNewRouterFallsBackWhenReplicasAreDown(t *testing.T) {
+	primary := lazyPool(t)
+	cfg := Config{
+		Replicas: []string{
+			fmt.Sprintf("127.0.0.1:%d", closedPort(t)),
+			fmt.Sprintf("127.0.0.1:%d", closedPort(t)),
+		},
+		ReplicaCheckInterval: time.Hour,
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	r, err := newRouter(ctx, primary, cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+
+	for _, state := range r.Replicas() {
+		if state.Healthy {
+			t.Errorf("unreachable replica %s is healthy", state.Addr)
+		}
+	}
+	if r.pick(ctx, readOnlySQL) != primary {
+		t.Error("read did not fall back to the primary")
+	}
+	if r.maxLag != defaultMaxReplicaLag || !r.readOnly["GetUserByID"] {
+		t.Errorf("maxLag = %s, readOnly = %v, want the defaults", r.maxLag, r.readOnly)
+	}
+}
+
+func TestNewRouterRejectsBadReplicaAddress(t *testing.T) {
+	for _, addr := range []string{"replica", "replica:port", "replica:70000"} {
+		if _, err := newRouter(context.Background(), lazyPool(t), Config{Replicas: []string{addr}}); err == nil {
+			t.Errorf("replica address %q accepted", addr)
+		}
+	}
+}
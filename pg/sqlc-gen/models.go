@@ -8,12 +8,55 @@ import (
 	"github.com/jackc/pgx/v5/pgtype"
 )
 
+type Group struct {
+	ID        int32              `db:"id" json:"id"`
+	TenantID  string             `db:"tenant_id" json:"tenant_id"`
+	Name      string             `db:"name" json:"name"`
+	CreatedAt pgtype.Timestamptz `db:"created_at" json:"created_at"`
+	UpdatedAt pgtype.Timestamptz `db:"updated_at" json:"updated_at"`
+}
+
+type GroupMember struct {
+	TenantID string             `db:"tenant_id" json:"tenant_id"`
+	GroupID  int32              `db:"group_id" json:"group_id"`
+	UserID   int32              `db:"user_id" json:"user_id"`
+	AddedAt  pgtype.Timestamptz `db:"added_at" json:"added_at"`
+}
+
 type User struct {
-	ID          int32              `db:"id" json:"id"`
-	Name        string             `db:"name" json:"name"`
-	Email       string             `db:"email" json:"email"`
-	Username    string             `db:"username" json:"username"`
-	PhoneNumber pgtype.Text        `db:"phone_number" json:"phone_number"`
-	CreatedAt   pgtype.Timestamptz `db:"created_at" json:"created_at"`
-	UpdatedAt   pgtype.Timestamptz `db:"updated_at" json:"updated_at"`
+	ID              int32              `db:"id" json:"id"`
+	Name            string             `db:"name" json:"name"`
+	Email           string             `db:"email" json:"email"`
+	Username        string             `db:"username" json:"username"`
+	PhoneNumber     pgtype.Text        `db:"phone_number" json:"phone_number"`
+	CreatedAt       pgtype.Timestamptz `db:"created_at" json:"created_at"`
+	UpdatedAt       pgtype.Timestamptz `db:"updated_at" json:"updated_at"`
+	Attributes      []byte             `db:"attributes" json:"attributes"`
+	TenantID        string             `db:"tenant_id" json:"tenant_id"`
+	Status          string             `db:"status" json:"status"`
+	StatusReason    pgtype.Text        `db:"status_reason" json:"status_reason"`
+	StatusChangedAt pgtype.Timestamptz `db:"status_changed_at" json:"status_changed_at"`
+	ScheduledStatus pgtype.Text        `db:"scheduled_status" json:"scheduled_status"`
+	ScheduledAt     pgtype.Timestamptz `db:"scheduled_at" json:"scheduled_at"`
+}
+
+type UsernameHistory struct {
+	ID            int64              `db:"id" json:"id"`
+	TenantID      string             `db:"tenant_id" json:"tenant_id"`
+	UserID        int32              `db:"user_id" json:"user_id"`
+	Username      string             `db:"username" json:"username"`
+	ChangedAt     pgtype.Timestamptz `db:"changed_at" json:"changed_at"`
+	ReservedUntil pgtype.Timestamptz `db:"reserved_until" json:"reserved_until"`
+}
+
+type UsersAudit struct {
+	ID              int64              `db:"id" json:"id"`
+	Operation       string             `db:"operation" json:"operation"`
+	RowID           pgtype.Text        `db:"row_id" json:"row_id"`
+	OldRow          []byte             `db:"old_row" json:"old_row"`
+	NewRow          []byte             `db:"new_row" json:"new_row"`
+	Txid            int64              `db:"txid" json:"txid"`
+	ApplicationName string             `db:"application_name" json:"application_name"`
+	DbUser          string             `db:"db_user" json:"db_user"`
+	ChangedAt       pgtype.Timestamptz `db:"changed_at" json:"changed_at"`
 }
@@ -11,19 +11,238 @@ import (
 	"github.com/jackc/pgx/v5/pgtype"
 )
 
+const addGroupMember = `-- name: AddGroupMember :execrows
+INSERT INTO group_members (
+    tenant_id,
+    group_id,
+    user_id,
+    added_at
+) VALUES (
+    $1, $2, $3, CURRENT_TIMESTAMP
+) ON CONFLICT (group_id, user_id) DO NOTHING
+`
+
+type AddGroupMemberParams struct {
+	TenantID string `db:"tenant_id" json:"tenant_id"`
+	GroupID  int32  `db:"group_id" json:"group_id"`
+	UserID   int32  `db:"user_id" json:"user_id"`
+}
+
+func (q *Queries) AddGroupMember(ctx context.Context, arg AddGroupMemberParams) (int64, error) {
+	result, err := q.db.Exec(ctx, addGroupMember, arg.TenantID, arg.GroupID, arg.UserID)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected(), nil
+}
+
+const applyScheduledStatus = `-- name: ApplyScheduledStatus :execrows
+UPDATE users
+SET
+    status = scheduled_status,
+    status_reason = $5,
+    status_changed_at = CURRENT_TIMESTAMP,
+    scheduled_status = NULL,
+    scheduled_at = NULL,
+    updated_at = CURRENT_TIMESTAMP
+WHERE tenant_id = $1 AND id = $2 AND status = $3 AND scheduled_at = $4
+`
+
+type ApplyScheduledStatusParams struct {
+	TenantID     string             `db:"tenant_id" json:"tenant_id"`
+	ID           int32              `db:"id" json:"id"`
+	Status       string             `db:"status" json:"status"`
+	ScheduledAt  pgtype.Timestamptz `db:"scheduled_at" json:"scheduled_at"`
+	StatusReason pgtype.Text        `db:"status_reason" json:"status_reason"`
+}
+
+func (q *Queries) ApplyScheduledStatus(ctx context.Context, arg ApplyScheduledStatusParams) (int64, error) {
+	result, err := q.db.Exec(ctx, applyScheduledStatus,
+		arg.TenantID,
+		arg.ID,
+		arg.Status,
+		arg.ScheduledAt,
+		arg.StatusReason,
+	)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected(), nil
+}
+
+const changeUserStatus = `-- name: ChangeUserStatus :one
+UPDATE users
+SET
+    status = $1,
+    status_reason = $2,
+    status_changed_at = CURRENT_TIMESTAMP,
+    scheduled_status = $3,
+    scheduled_at = $4,
+    updated_at = CURRENT_TIMESTAMP
+WHERE tenant_id = $5 AND id = $6 AND status = $7
+RETURNING id, name, email, username, phone_number, created_at, updated_at, attributes, tenant_id, status, status_reason, status_changed_at, scheduled_status, scheduled_at
+`
+
+type ChangeUserStatusParams struct {
+	Status          string             `db:"status" json:"status"`
+	StatusReason    pgtype.Text        `db:"status_reason" json:"status_reason"`
+	ScheduledStatus pgtype.Text        `db:"scheduled_status" json:"scheduled_status"`
+	ScheduledAt     pgtype.Timestamptz `db:"scheduled_at" json:"scheduled_at"`
+	TenantID        string             `db:"tenant_id" json:"tenant_id"`
+	ID              int32              `db:"id" json:"id"`
+	FromStatus      string             `db:"from_status" json:"from_status"`
+}
+
+func (q *Queries) ChangeUserStatus(ctx context.Context, arg ChangeUserStatusParams) (User, error) {
+	row := q.db.QueryRow(ctx, changeUserStatus,
+		arg.Status,
+		arg.StatusReason,
+		arg.ScheduledStatus,
+		arg.ScheduledAt,
+		arg.TenantID,
+		arg.ID,
+		arg.FromStatus,
+	)
+	var i User
+	err := row.Scan(
+		&i.ID,
+		&i.Name,
+		&i.Email,
+		&i.Username,
+		&i.PhoneNumber,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+		&i.Attributes,
+		&i.TenantID,
+		&i.Status,
+		&i.StatusReason,
+		&i.StatusChangedAt,
+		&i.ScheduledStatus,
+		&i.ScheduledAt,
+	)
+	return i, err
+}
+
+const changeUsername = `-- name: ChangeUsername :one
+WITH target AS (
+    SELECT tenant_id, id, username
+    FROM users
+    WHERE tenant_id = $1 AND id = $2 AND username = $3
+        AND NOT EXISTS (
+            SELECT 1 FROM users other
+            WHERE other.tenant_id = $1 AND other.username = $4 AND other.id != $2
+        )
+        AND NOT EXISTS (
+            SELECT 1 FROM username_history h
+            WHERE h.tenant_id = $1 AND h.username = $4
+                AND h.user_id != $2 AND h.reserved_until > CURRENT_TIMESTAMP
+        )
+    FOR UPDATE OF users
+), history AS (
+    INSERT INTO username_history (tenant_id, user_id, username, changed_at, reserved_until)
+    SELECT tenant_id, id, username, CURRENT_TIMESTAMP, $5::timestamptz
+    FROM target
+)
+UPDATE users
+SET
+    username = $4,
+    updated_at = CURRENT_TIMESTAMP
+FROM target
+WHERE users.tenant_id = target.tenant_id AND users.id = target.id
+RETURNING users.id, users.name, users.email, users.username, users.phone_number, users.created_at, users.updated_at, users.attributes, users.tenant_id, users.status, users.status_reason, users.status_changed_at, users.scheduled_status, users.scheduled_at
+`
+
+type ChangeUsernameParams struct {
+	TenantID      string             `db:"tenant_id" json:"tenant_id"`
+	ID            int32              `db:"id" json:"id"`
+	OldUsername   string             `db:"old_username" json:"old_username"`
+	NewUsername   string             `db:"new_username" json:"new_username"`
+	ReservedUntil pgtype.Timestamptz `db:"reserved_until" json:"reserved_until"`
+}
+
+// Renames only if nobody else has new_username and it is not reserved for
+// another user. A rename that gives a username up commits its history row
+// together with the change, so the statement's snapshot sees either the
+// holder or the reservation.
+func (q *Queries) ChangeUsername(ctx context.Context, arg ChangeUsernameParams) (User, error) {
+	row := q.db.QueryRow(ctx, changeUsername,
+		arg.TenantID,
+		arg.ID,
+		arg.OldUsername,
+		arg.NewUsername,
+		arg.ReservedUntil,
+	)
+	var i User
+	err := row.Scan(
+		&i.ID,
+		&i.Name,
+		&i.Email,
+		&i.Username,
+		&i.PhoneNumber,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+		&i.Attributes,
+		&i.TenantID,
+		&i.Status,
+		&i.StatusReason,
+		&i.StatusChangedAt,
+		&i.ScheduledStatus,
+		&i.ScheduledAt,
+	)
+	return i, err
+}
+
 const checkEmailExistsForUpdate = `-- name: CheckEmailExistsForUpdate :one
 SELECT EXISTS(
-    SELECT 1 FROM users WHERE email = $1 AND id != $2
+    SELECT 1 FROM users WHERE tenant_id = $1 AND email = $2 AND id != $3
 ) AS exists
 `
 
 type CheckEmailExistsForUpdateParams struct {
-	Email string `db:"email" json:"email"`
-	ID    int32  `db:"id" json:"id"`
+	TenantID string `db:"tenant_id" json:"tenant_id"`
+	Email    string `db:"email" json:"email"`
+	ID       int32  `db:"id" json:"id"`
 }
 
 func (q *Queries) CheckEmailExistsForUpdate(ctx context.Context, arg CheckEmailExistsForUpdateParams) (bool, error) {
-	row := q.db.QueryRow(ctx, checkEmailExistsForUpdate, arg.Email, arg.ID)
+	row := q.db.QueryRow(ctx, checkEmailExistsForUpdate, arg.TenantID, arg.Email, arg.ID)
+	var exists bool
+	err := row.Scan(&exists)
+	return exists, err
+}
+
+const checkGroupNameExists = `-- name: CheckGroupNameExists :one
+SELECT EXISTS(
+    SELECT 1 FROM groups WHERE tenant_id = $1 AND name = $2
+) AS exists
+`
+
+type CheckGroupNameExistsParams struct {
+	TenantID string `db:"tenant_id" json:"tenant_id"`
+	Name     string `db:"name" json:"name"`
+}
+
+func (q *Queries) CheckGroupNameExists(ctx context.Context, arg CheckGroupNameExistsParams) (bool, error) {
+	row := q.db.QueryRow(ctx, checkGroupNameExists, arg.TenantID, arg.Name)
+	var exists bool
+	err := row.Scan(&exists)
+	return exists, err
+}
+
+const checkGroupNameExistsForRename = `-- name: CheckGroupNameExistsForRename :one
+SELECT EXISTS(
+    SELECT 1 FROM groups WHERE tenant_id = $1 AND name = $2 AND id != $3
+) AS exists
+`
+
+type CheckGroupNameExistsForRenameParams struct {
+	TenantID string `db:"tenant_id" json:"tenant_id"`
+	Name     string `db:"name" json:"name"`
+	ID       int32  `db:"id" json:"id"`
+}
+
+func (q *Queries) CheckGroupNameExistsForRename(ctx context.Context, arg CheckGroupNameExistsForRenameParams) (bool, error) {
+	row := q.db.QueryRow(ctx, checkGroupNameExistsForRename, arg.TenantID, arg.Name, arg.ID)
 	var exists bool
 	err := row.Scan(&exists)
 	return exists, err
@@ -31,17 +250,88 @@ func (q *Queries) CheckEmailExistsForUpdate(ctx context.Context, arg CheckEmailE
 
 const checkUsernameExists = `-- name: CheckUsernameExists :one
 SELECT EXISTS(
-    SELECT 1 FROM users WHERE username = $1
+    SELECT 1 FROM users WHERE tenant_id = $1 AND username = $2
 ) AS exists
 `
 
-func (q *Queries) CheckUsernameExists(ctx context.Context, username string) (bool, error) {
-	row := q.db.QueryRow(ctx, checkUsernameExists, username)
+type CheckUsernameExistsParams struct {
+	TenantID string `db:"tenant_id" json:"tenant_id"`
+	Username string `db:"username" json:"username"`
+}
+
+func (q *Queries) CheckUsernameExists(ctx context.Context, arg CheckUsernameExistsParams) (bool, error) {
+	row := q.db.QueryRow(ctx, checkUsernameExists, arg.TenantID, arg.Username)
 	var exists bool
 	err := row.Scan(&exists)
 	return exists, err
 }
 
+const checkUsernameReserved = `-- name: CheckUsernameReserved :one
+SELECT EXISTS(
+    SELECT 1 FROM username_history
+    WHERE tenant_id = $1 AND username = $2 AND user_id != $3 AND reserved_until > CURRENT_TIMESTAMP
+) AS exists
+`
+
+type CheckUsernameReservedParams struct {
+	TenantID string `db:"tenant_id" json:"tenant_id"`
+	Username string `db:"username" json:"username"`
+	UserID   int32  `db:"user_id" json:"user_id"`
+}
+
+func (q *Queries) CheckUsernameReserved(ctx context.Context, arg CheckUsernameReservedParams) (bool, error) {
+	row := q.db.QueryRow(ctx, checkUsernameReserved, arg.TenantID, arg.Username, arg.UserID)
+	var exists bool
+	err := row.Scan(&exists)
+	return exists, err
+}
+
+const countGroupMembers = `-- name: CountGroupMembers :one
+SELECT COUNT(*) FROM group_members
+WHERE tenant_id = $1 AND group_id = $2
+`
+
+type CountGroupMembersParams struct {
+	TenantID string `db:"tenant_id" json:"tenant_id"`
+	GroupID  int32  `db:"group_id" json:"group_id"`
+}
+
+func (q *Queries) CountGroupMembers(ctx context.Context, arg CountGroupMembersParams) (int64, error) {
+	row := q.db.QueryRow(ctx, countGroupMembers, arg.TenantID, arg.GroupID)
+	var count int64
+	err := row.Scan(&count)
+	return count, err
+}
+
+const createGroup = `-- name: CreateGroup :one
+INSERT INTO groups (
+    tenant_id,
+    name,
+    created_at,
+    updated_at
+) VALUES (
+    $1, $2, CURRENT_TIMESTAMP, CURRENT_TIMESTAMP
+) RETURNING id, tenant_id, name, created_at, updated_at
+`
+
+type CreateGroupParams struct {
+	TenantID string `db:"tenant_id" json:"tenant_id"`
+	Name     string `db:"name" json:"name"`
+}
+
+func (q *Queries) CreateGroup(ctx context.Context, arg CreateGroupParams) (Group, error) {
+	row := q.db.QueryRow(ctx, createGroup, arg.TenantID, arg.Name)
+	var i Group
+	err := row.Scan(
+		&i.ID,
+		&i.TenantID,
+		&i.Name,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+	)
+	return i, err
+}
+
 const createUser = `-- name: CreateUser :one
 INSERT INTO users (
     name,
@@ -49,10 +339,13 @@ INSERT INTO users (
     username,
     created_at,
     updated_at,
-    phone_number
+    phone_number,
+    attributes,
+    tenant_id,
+    status
 ) VALUES (
-    $1, $2, $3, CURRENT_TIMESTAMP, CURRENT_TIMESTAMP, $4
-) RETURNING id, name, email, username, phone_number, created_at, updated_at
+    $1, $2, $3, CURRENT_TIMESTAMP, CURRENT_TIMESTAMP, $4, $5, $6, $7
+) RETURNING id, name, email, username, phone_number, created_at, updated_at, attributes, tenant_id, status, status_reason, status_changed_at, scheduled_status, scheduled_at
 `
 
 type CreateUserParams struct {
@@ -60,6 +353,9 @@ type CreateUserParams struct {
 	Email       string      `db:"email" json:"email"`
 	Username    string      `db:"username" json:"username"`
 	PhoneNumber pgtype.Text `db:"phone_number" json:"phone_number"`
+	Attributes  []byte      `db:"attributes" json:"attributes"`
+	TenantID    string      `db:"tenant_id" json:"tenant_id"`
+	Status      string      `db:"status" json:"status"`
 }
 
 func (q *Queries) CreateUser(ctx context.Context, arg CreateUserParams) (User, error) {
@@ -68,6 +364,9 @@ func (q *Queries) CreateUser(ctx context.Context, arg CreateUserParams) (User, e
 		arg.Email,
 		arg.Username,
 		arg.PhoneNumber,
+		arg.Attributes,
+		arg.TenantID,
+		arg.Status,
 	)
 	var i User
 	err := row.Scan(
@@ -78,18 +377,108 @@ func (q *Queries) CreateUser(ctx context.Context, arg CreateUserParams) (User, e
 		&i.PhoneNumber,
 		&i.CreatedAt,
 		&i.UpdatedAt,
+		&i.Attributes,
+		&i.TenantID,
+		&i.Status,
+		&i.StatusReason,
+		&i.StatusChangedAt,
+		&i.ScheduledStatus,
+		&i.ScheduledAt,
+	)
+	return i, err
+}
+
+const deleteGroup = `-- name: DeleteGroup :execrows
+DELETE FROM groups
+WHERE tenant_id = $1 AND id = $2
+`
+
+type DeleteGroupParams struct {
+	TenantID string `db:"tenant_id" json:"tenant_id"`
+	ID       int32  `db:"id" json:"id"`
+}
+
+func (q *Queries) DeleteGroup(ctx context.Context, arg DeleteGroupParams) (int64, error) {
+	result, err := q.db.Exec(ctx, deleteGroup, arg.TenantID, arg.ID)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected(), nil
+}
+
+const getGroupByID = `-- name: GetGroupByID :one
+SELECT id, tenant_id, name, created_at, updated_at
+FROM groups
+WHERE tenant_id = $1 AND id = $2
+`
+
+type GetGroupByIDParams struct {
+	TenantID string `db:"tenant_id" json:"tenant_id"`
+	ID       int32  `db:"id" json:"id"`
+}
+
+func (q *Queries) GetGroupByID(ctx context.Context, arg GetGroupByIDParams) (Group, error) {
+	row := q.db.QueryRow(ctx, getGroupByID, arg.TenantID, arg.ID)
+	var i Group
+	err := row.Scan(
+		&i.ID,
+		&i.TenantID,
+		&i.Name,
+		&i.CreatedAt,
+		&i.UpdatedAt,
 	)
 	return i, err
 }
 
 const getUserByID = `-- name: GetUserByID :one
-SELECT id, name, email, username, phone_number, created_at, updated_at
+SELECT id, name, email, username, phone_number, created_at, updated_at, attributes, tenant_id, status, status_reason, status_changed_at, scheduled_status, scheduled_at
 FROM users
-WHERE id = $1
+WHERE tenant_id = $1 AND id = $2
+`
+
+type GetUserByIDParams struct {
+	TenantID string `db:"tenant_id" json:"tenant_id"`
+	ID       int32  `db:"id" json:"id"`
+}
+
+func (q *Queries) GetUserByID(ctx context.Context, arg GetUserByIDParams) (User, error) {
+	row := q.db.QueryRow(ctx, getUserByID, arg.TenantID, arg.ID)
+	var i User
+	err := row.Scan(
+		&i.ID,
+		&i.Name,
+		&i.Email,
+		&i.Username,
+		&i.PhoneNumber,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+		&i.Attributes,
+		&i.TenantID,
+		&i.Status,
+		&i.StatusReason,
+		&i.StatusChangedAt,
+		&i.ScheduledStatus,
+		&i.ScheduledAt,
+	)
+	return i, err
+}
+
+const getUserByPreviousUsername = `-- name: GetUserByPreviousUsername :one
+SELECT u.id, u.name, u.email, u.username, u.phone_number, u.created_at, u.updated_at, u.attributes, u.tenant_id, u.status, u.status_reason, u.status_changed_at, u.scheduled_status, u.scheduled_at
+FROM username_history h
+JOIN users u ON u.tenant_id = h.tenant_id AND u.id = h.user_id
+WHERE h.tenant_id = $1 AND h.username = $2 AND h.reserved_until > CURRENT_TIMESTAMP
+ORDER BY h.changed_at DESC
+LIMIT 1
 `
 
-func (q *Queries) GetUserByID(ctx context.Context, id int32) (User, error) {
-	row := q.db.QueryRow(ctx, getUserByID, id)
+type GetUserByPreviousUsernameParams struct {
+	TenantID string `db:"tenant_id" json:"tenant_id"`
+	Username string `db:"username" json:"username"`
+}
+
+func (q *Queries) GetUserByPreviousUsername(ctx context.Context, arg GetUserByPreviousUsernameParams) (User, error) {
+	row := q.db.QueryRow(ctx, getUserByPreviousUsername, arg.TenantID, arg.Username)
 	var i User
 	err := row.Scan(
 		&i.ID,
@@ -99,6 +488,273 @@ func (q *Queries) GetUserByID(ctx context.Context, id int32) (User, error) {
 		&i.PhoneNumber,
 		&i.CreatedAt,
 		&i.UpdatedAt,
+		&i.Attributes,
+		&i.TenantID,
+		&i.Status,
+		&i.StatusReason,
+		&i.StatusChangedAt,
+		&i.ScheduledStatus,
+		&i.ScheduledAt,
+	)
+	return i, err
+}
+
+const getUserByUsername = `-- name: GetUserByUsername :one
+SELECT id, name, email, username, phone_number, created_at, updated_at, attributes, tenant_id, status, status_reason, status_changed_at, scheduled_status, scheduled_at
+FROM users
+WHERE tenant_id = $1 AND username = $2
+`
+
+type GetUserByUsernameParams struct {
+	TenantID string `db:"tenant_id" json:"tenant_id"`
+	Username string `db:"username" json:"username"`
+}
+
+func (q *Queries) GetUserByUsername(ctx context.Context, arg GetUserByUsernameParams) (User, error) {
+	row := q.db.QueryRow(ctx, getUserByUsername, arg.TenantID, arg.Username)
+	var i User
+	err := row.Scan(
+		&i.ID,
+		&i.Name,
+		&i.Email,
+		&i.Username,
+		&i.PhoneNumber,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+		&i.Attributes,
+		&i.TenantID,
+		&i.Status,
+		&i.StatusReason,
+		&i.StatusChangedAt,
+		&i.ScheduledStatus,
+		&i.ScheduledAt,
+	)
+	return i, err
+}
+
+const listDueStatusChanges = `-- name: ListDueStatusChanges :many
+SELECT id, tenant_id, status, status_reason, scheduled_status, scheduled_at
+FROM users
+WHERE scheduled_at <= CURRENT_TIMESTAMP
+ORDER BY scheduled_at
+LIMIT $1
+`
+
+type ListDueStatusChangesRow struct {
+	ID              int32              `db:"id" json:"id"`
+	TenantID        string             `db:"tenant_id" json:"tenant_id"`
+	Status          string             `db:"status" json:"status"`
+	StatusReason    pgtype.Text        `db:"status_reason" json:"status_reason"`
+	ScheduledStatus pgtype.Text        `db:"scheduled_status" json:"scheduled_status"`
+	ScheduledAt     pgtype.Timestamptz `db:"scheduled_at" json:"scheduled_at"`
+}
+
+func (q *Queries) ListDueStatusChanges(ctx context.Context, limit int32) ([]ListDueStatusChangesRow, error) {
+	rows, err := q.db.Query(ctx, listDueStatusChanges, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []ListDueStatusChangesRow
+	for rows.Next() {
+		var i ListDueStatusChangesRow
+		if err := rows.Scan(
+			&i.ID,
+			&i.TenantID,
+			&i.Status,
+			&i.StatusReason,
+			&i.ScheduledStatus,
+			&i.ScheduledAt,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const listGroupMembers = `-- name: ListGroupMembers :many
+SELECT u.id, u.name, u.email, u.username, m.added_at
+FROM group_members m
+JOIN users u ON u.tenant_id = m.tenant_id AND u.id = m.user_id
+WHERE m.tenant_id = $1 AND m.group_id = $2 AND m.user_id > $3
+ORDER BY m.user_id
+LIMIT $4
+`
+
+type ListGroupMembersParams struct {
+	TenantID string `db:"tenant_id" json:"tenant_id"`
+	GroupID  int32  `db:"group_id" json:"group_id"`
+	AfterID  int32  `db:"after_id" json:"after_id"`
+	PageSize int32  `db:"page_size" json:"page_size"`
+}
+
+type ListGroupMembersRow struct {
+	ID       int32              `db:"id" json:"id"`
+	Name     string             `db:"name" json:"name"`
+	Email    string             `db:"email" json:"email"`
+	Username string             `db:"username" json:"username"`
+	AddedAt  pgtype.Timestamptz `db:"added_at" json:"added_at"`
+}
+
+func (q *Queries) ListGroupMembers(ctx context.Context, arg ListGroupMembersParams) ([]ListGroupMembersRow, error) {
+	rows, err := q.db.Query(ctx, listGroupMembers,
+		arg.TenantID,
+		arg.GroupID,
+		arg.AfterID,
+		arg.PageSize,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []ListGroupMembersRow
+	for rows.Next() {
+		var i ListGroupMembersRow
+		if err := rows.Scan(
+			&i.ID,
+			&i.Name,
+			&i.Email,
+			&i.Username,
+			&i.AddedAt,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const listUserGroups = `-- name: ListUserGroups :many
+SELECT g.id, g.tenant_id, g.name, g.created_at, g.updated_at
+FROM groups g
+JOIN group_members m ON m.tenant_id = g.tenant_id AND m.group_id = g.id
+WHERE m.tenant_id = $1 AND m.user_id = $2
+ORDER BY g.name, g.id
+`
+
+type ListUserGroupsParams struct {
+	TenantID string `db:"tenant_id" json:"tenant_id"`
+	UserID   int32  `db:"user_id" json:"user_id"`
+}
+
+func (q *Queries) ListUserGroups(ctx context.Context, arg ListUserGroupsParams) ([]Group, error) {
+	rows, err := q.db.Query(ctx, listUserGroups, arg.TenantID, arg.UserID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []Group
+	for rows.Next() {
+		var i Group
+		if err := rows.Scan(
+			&i.ID,
+			&i.TenantID,
+			&i.Name,
+			&i.CreatedAt,
+			&i.UpdatedAt,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const listUsersAudit = `-- name: ListUsersAudit :many
+SELECT id, operation, row_id, old_row, new_row, txid, application_name, db_user, changed_at
+FROM users_audit
+WHERE changed_at >= $1 AND changed_at < $2
+ORDER BY id
+`
+
+type ListUsersAuditParams struct {
+	ChangedFrom pgtype.Timestamptz `db:"changed_from" json:"changed_from"`
+	ChangedTo   pgtype.Timestamptz `db:"changed_to" json:"changed_to"`
+}
+
+func (q *Queries) ListUsersAudit(ctx context.Context, arg ListUsersAuditParams) ([]UsersAudit, error) {
+	rows, err := q.db.Query(ctx, listUsersAudit, arg.ChangedFrom, arg.ChangedTo)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []UsersAudit
+	for rows.Next() {
+		var i UsersAudit
+		if err := rows.Scan(
+			&i.ID,
+			&i.Operation,
+			&i.RowID,
+			&i.OldRow,
+			&i.NewRow,
+			&i.Txid,
+			&i.ApplicationName,
+			&i.DbUser,
+			&i.ChangedAt,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const removeGroupMember = `-- name: RemoveGroupMember :execrows
+DELETE FROM group_members
+WHERE tenant_id = $1 AND group_id = $2 AND user_id = $3
+`
+
+type RemoveGroupMemberParams struct {
+	TenantID string `db:"tenant_id" json:"tenant_id"`
+	GroupID  int32  `db:"group_id" json:"group_id"`
+	UserID   int32  `db:"user_id" json:"user_id"`
+}
+
+func (q *Queries) RemoveGroupMember(ctx context.Context, arg RemoveGroupMemberParams) (int64, error) {
+	result, err := q.db.Exec(ctx, removeGroupMember, arg.TenantID, arg.GroupID, arg.UserID)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected(), nil
+}
+
+const renameGroup = `-- name: RenameGroup :one
+UPDATE groups
+SET
+    name = $3,
+    updated_at = CURRENT_TIMESTAMP
+WHERE tenant_id = $1 AND id = $2
+RETURNING id, tenant_id, name, created_at, updated_at
+`
+
+type RenameGroupParams struct {
+	TenantID string `db:"tenant_id" json:"tenant_id"`
+	ID       int32  `db:"id" json:"id"`
+	Name     string `db:"name" json:"name"`
+}
+
+func (q *Queries) RenameGroup(ctx context.Context, arg RenameGroupParams) (Group, error) {
+	row := q.db.QueryRow(ctx, renameGroup, arg.TenantID, arg.ID, arg.Name)
+	var i Group
+	err := row.Scan(
+		&i.ID,
+		&i.TenantID,
+		&i.Name,
+		&i.CreatedAt,
+		&i.UpdatedAt,
 	)
 	return i, err
 }
@@ -106,27 +762,34 @@ func (q *Queries) GetUserByID(ctx context.Context, id int32) (User, error) {
 const updateUser = `-- name: UpdateUser :one
 UPDATE users
 SET 
-    name = COALESCE($2, name),
-    email = COALESCE($3, email),
-    phone_number = COALESCE($4, phone_number),
+    name = COALESCE($3, name),
+    email = COALESCE($4, email),
+    phone_number = COALESCE($5, phone_number),
+    attributes = (attributes || COALESCE($6::jsonb, '{}')) - COALESCE($7::text[], '{}'),
     updated_at = CURRENT_TIMESTAMP
-WHERE id = $1
-RETURNING id, name, email, username, phone_number, created_at, updated_at
+WHERE id = $1 AND tenant_id = $2
+RETURNING id, name, email, username, phone_number, created_at, updated_at, attributes, tenant_id, status, status_reason, status_changed_at, scheduled_status, scheduled_at
 `
 
 type UpdateUserParams struct {
-	ID          int32       `db:"id" json:"id"`
-	Name        pgtype.Text `db:"name" json:"name"`
-	Email       pgtype.Text `db:"email" json:"email"`
-	PhoneNumber pgtype.Text `db:"phone_number" json:"phone_number"`
+	ID              int32       `db:"id" json:"id"`
+	TenantID        string      `db:"tenant_id" json:"tenant_id"`
+	Name            pgtype.Text `db:"name" json:"name"`
+	Email           pgtype.Text `db:"email" json:"email"`
+	PhoneNumber     pgtype.Text `db:"phone_number" json:"phone_number"`
+	SetAttributes   []byte      `db:"set_attributes" json:"set_attributes"`
+	UnsetAttributes []string    `db:"unset_attributes" json:"unset_attributes"`
 }
 
 func (q *Queries) UpdateUser(ctx context.Context, arg UpdateUserParams) (User, error) {
 	row := q.db.QueryRow(ctx, updateUser,
 		arg.ID,
+		arg.TenantID,
 		arg.Name,
 		arg.Email,
 		arg.PhoneNumber,
+		arg.SetAttributes,
+		arg.UnsetAttributes,
 	)
 	var i User
 	err := row.Scan(
@@ -137,6 +800,13 @@ func (q *Queries) UpdateUser(ctx context.Context, arg UpdateUserParams) (User, e
 		&i.PhoneNumber,
 		&i.CreatedAt,
 		&i.UpdatedAt,
+		&i.Attributes,
+		&i.TenantID,
+		&i.Status,
+		&i.StatusReason,
+		&i.StatusChangedAt,
+		&i.ScheduledStatus,
+		&i.ScheduledAt,
 	)
 	return i, err
 }
@@ -0,0 +1,57 @@
+package pg
+
+import (
+	"context"
+	"sync"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// tenantSetting is the session setting the row-level security policies
+// compare tenant_id with
+const tenantSetting = "app.tenant_id"
+
+// AllTenants is the tenant of maintenance jobs that look across tenants.
+// Row-level security lets such sessions read every tenant's users but write
+// none, so changes are still made as the user's own tenant.
+const AllTenants = "*"
+
+type tenantKey struct{}
+
+// WithTenant returns a context whose queries run as tenant: row-level
+// security only shows and accepts rows of that tenant. Queries without a
+// tenant see no tenant rows at all.
+func WithTenant(ctx context.Context, tenant string) context.Context {
+	return context.WithValue(ctx, tenantKey{}, tenant)
+}
+
+// TenantFromContext returns the tenant set with WithTenant, or ""
+func TenantFromContext(ctx context.Context) string {
+	tenant, _ := ctx.Value(tenantKey{}).(string)
+	return tenant
+}
+
+// tenantSessions sets app.tenant_id on pooled connections as they are
+// acquired, remembering each connection's tenant so the setting is only
+// sent when it changes
+type tenantSessions struct {
+	tenants sync.Map // *pgx.Conn -> string
+}
+
+func (t *tenantSessions) beforeAcquire(ctx context.Context, conn *pgx.Conn) bool {
+	tenant := TenantFromContext(ctx)
+	if current, ok := t.tenants.Load(conn); ok && current == tenant {
+		return true
+	}
+	if _, err := conn.Exec(ctx, "SELECT set_config($1, $2, false)", tenantSetting, tenant); err != nil {
+		// The pool discards the connection and acquires another one
+		t.tenants.Delete(conn)
+		return false
+	}
+	t.tenants.Store(conn, tenant)
+	return true
+}
+
+func (t *tenantSessions) beforeClose(conn *pgx.Conn) {
+	t.tenants.Delete(conn)
+}
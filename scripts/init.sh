@@ -81,44 +81,8 @@ wait_for_service "Kafka" "docker exec demo-kafka kafka-broker-api-versions --boo
 wait_for_service "Elasticsearch" "curl -s http://localhost:9200/_cat/health"
 wait_for_service "Kibana" "curl -s http://localhost:5601/api/status"
 
-# Step 5: Run database migrations
-echo -e "\n${YELLOW}Step 5: Setting up database${NC}"
-
-# Check if tern is installed
-if ! command_exists tern; then
-    echo -e "${YELLOW}Installing tern for database migrations...${NC}"
-    go install github.com/jackc/tern/v2@latest
-    if [ $? -eq 0 ]; then
-        echo -e "${GREEN}✓ Tern installed${NC}"
-    else
-        echo -e "${RED}✗ Failed to install tern${NC}"
-        exit 1
-    fi
-fi
-
-# Check if migrations directory exists
-if [ -d "pg/migrations" ]; then
-    cd pg/migrations
-    
-    # Run migrations
-    echo "Running database migrations..."
-    tern migrate
-    if [ $? -eq 0 ]; then
-        echo -e "${GREEN}✓ Database migrations completed${NC}"
-    else
-        echo -e "${RED}✗ Database migration failed${NC}"
-        cd ../..
-        exit 1
-    fi
-    cd ../..
-else
-    echo -e "${RED}✗ Migrations directory not found at pg/migrations${NC}"
-    echo -e "${YELLOW}Please ensure you have the complete project structure${NC}"
-    exit 1
-fi
-
-# Step 6: Initialize Rigel configuration
-echo -e "\n${YELLOW}Step 6: Initializing Rigel configuration${NC}"
+# Step 5: Initialize Rigel configuration
+echo -e "\n${YELLOW}Step 5: Initializing Rigel configuration${NC}"
 if [ -f "$SCRIPT_DIR/setup-config.sh" ]; then
     "$SCRIPT_DIR/setup-config.sh" >/dev/null 2>&1
     echo -e "${GREEN}✓ Rigel configuration initialized${NC}"
@@ -126,6 +90,18 @@ else
     echo -e "${RED}✗ setup-config.sh not found${NC}"
 fi
 
+# Step 6: Run database migrations
+# The migrations are built into the service and read the database settings
+# from Rigel, so this runs after Step 5
+echo -e "\n${YELLOW}Step 6: Setting up database${NC}"
+echo "Running database migrations..."
+if go run . migrate up; then
+    echo -e "${GREEN}✓ Database migrations completed${NC}"
+else
+    echo -e "${RED}✗ Database migration failed${NC}"
+    exit 1
+fi
+
 # Step 7: Install Go dependencies
 echo -e "\n${YELLOW}Step 7: Installing Go dependencies${NC}"
 go mod download
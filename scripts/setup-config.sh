@@ -52,6 +52,23 @@ set_config "database.port" 5432
 set_config "database.user" "remiges"
 set_config "database.password" "remiges123"
 set_config "database.dbname" "userdb"
+set_config "database.sslmode" "disable"
+set_config "database.application_name" "usersvc"
+
+# Connection pool tuning
+set_config "database.pool.max_conns" 10
+set_config "database.pool.min_conns" 2
+set_config "database.pool.max_conn_lifetime" "1h"
+set_config "database.pool.max_conn_idle_time" "30m"
+set_config "database.pool.health_check_period" "1m"
+set_config "database.statement_timeout" "30s"
+set_config "database.connect_timeout" "5s"
+set_config "database.connect_retries" 5
+set_config "database.connect_retry_backoff" "1s"
+set_config "database.auto_migrate" "false"
+set_config "database.replica.max_lag" "10s"
+set_config "database.replica.check_interval" "5s"
+set_config "database.replica.queries" "GetUserByID"
 
 # Note: Server port is now loaded from config.json, not etcd
 echo "Note: Server port (8080) is configured in config.json"
@@ -65,4 +82,10 @@ set_config "validation.username.minLength" "3"
 set_config "validation.username.maxLength" "30"
 set_config "validation.email.maxLength" "100"
 
+# Custom attribute schema (JSON); keys not listed here are rejected
+set_config "attributes.schema" '{"department":{"type":"string","maxLength":50},"employee_id":{"type":"string","pattern":"^E[0-9]{5}$"},"level":{"type":"int","min":1,"max":10},"start_date":{"type":"date"}}'
+
+# Tenancy: requests without a tenant header or token claim use "default"
+set_config "tenant.default" "default"
+
 echo "Configuration setup complete!"
\ No newline at end of file
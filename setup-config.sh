@@ -4,12 +4,12 @@
 # This script:
 # 1. Loads the configuration schema into Rigel (via etcd)
 # 2. Sets up all required configuration values
-# 3. Runs database migrations using tern
+# 3. Runs the database migrations built into the service
 #
 # Prerequisites:
 # - Docker containers must be running (docker-compose up -d)
 # - rigelctl must be installed and in PATH
-# - tern must be installed (go install github.com/jackc/tern/v2@latest)
+# - Go must be installed (migrations run with `go run . migrate up`)
 #
 # Database credentials (from docker-compose.yml):
 # - User: remiges
@@ -62,6 +62,23 @@ set_config "database.port" "5432"
 set_config "database.user" "remiges"
 set_config "database.password" "remiges123"
 set_config "database.dbname" "userdb"
+set_config "database.sslmode" "disable"
+set_config "database.application_name" "usersvc"
+
+# Connection pool tuning
+set_config "database.pool.max_conns" "10"
+set_config "database.pool.min_conns" "2"
+set_config "database.pool.max_conn_lifetime" "1h"
+set_config "database.pool.max_conn_idle_time" "30m"
+set_config "database.pool.health_check_period" "1m"
+set_config "database.statement_timeout" "30s"
+set_config "database.connect_timeout" "5s"
+set_config "database.connect_retries" "5"
+set_config "database.connect_retry_backoff" "1s"
+set_config "database.auto_migrate" "false"
+set_config "database.replica.max_lag" "10s"
+set_config "database.replica.check_interval" "5s"
+set_config "database.replica.queries" "GetUserByID"
 
 # Server configuration
 set_config "server.port" "8080"
@@ -73,9 +90,15 @@ set_config "validation.username.minLength" "3"
 set_config "validation.username.maxLength" "30"
 set_config "validation.email.maxLength" "100"
 
+# Custom attribute schema (JSON); keys not listed here are rejected
+set_config "attributes.schema" '{"department":{"type":"string","maxLength":50},"employee_id":{"type":"string","pattern":"^E[0-9]{5}$"},"level":{"type":"int","min":1,"max":10},"start_date":{"type":"date"}}'
+
+# Tenancy: requests without a tenant header or token claim use "default"
+set_config "tenant.default" "default"
+
 echo "Configuration setup complete!"
 
-# Run database migrations with tern
+# Run database migrations
 echo ""
 echo "Running database migrations..."
 
@@ -86,24 +109,15 @@ while ! nc -z localhost 5432; do
 done
 echo "PostgreSQL is ready!"
 
-# Check if tern is installed
-if ! command -v tern &> /dev/null; then
-    echo "Error: tern is not installed. Please install it first:"
-    echo "  go install github.com/jackc/tern/v2@latest"
-    exit 1
-fi
-
 # Run migrations
 echo "Executing migrations..."
-cd pg/migrations
-if tern migrate; then
+if go run . migrate up; then
     echo "Database migrations completed successfully!"
 else
     echo "Failed to run database migrations"
     echo "Please check your database connection and credentials"
     exit 1
 fi
-cd ../..
 
 echo ""
 echo "Setup complete! You can now run the application with: go run ."
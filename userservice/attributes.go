@@ -0,0 +1,312 @@
+package usersvc
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"regexp"
+	"slices"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"github.com/remiges-tech/alya/wscutils"
+	"github.com/remiges-tech/rigel"
+)
+
+// attributeSchemaKey is the Rigel key holding the attribute schema as JSON
+const attributeSchemaKey = "attributes.schema"
+
+// Attribute types
+const (
+	AttrTypeString = "string"
+	AttrTypeInt    = "int"
+	AttrTypeNumber = "number"
+	AttrTypeBool   = "bool"
+	AttrTypeDate   = "date" // YYYY-MM-DD
+)
+
+// maxSafeInteger is the largest magnitude an int attribute may have. JSON
+// numbers are decoded as float64, which cannot tell larger integers apart.
+const maxSafeInteger = 1<<53 - 1
+
+// AttributeRule defines one custom attribute. Length rules apply to
+// strings, Min and Max to int and number attributes.
+type AttributeRule struct {
+	Type      string   `json:"type"`
+	Required  bool     `json:"required,omitempty"`
+	MinLength *int     `json:"minLength,omitempty"`
+	MaxLength *int     `json:"maxLength,omitempty"`
+	Pattern   string   `json:"pattern,omitempty"`
+	Enum      []string `json:"enum,omitempty"`
+	Min       *float64 `json:"min,omitempty"`
+	Max       *float64 `json:"max,omitempty"`
+
+	pattern *regexp.Regexp
+}
+
+// AttributeSchema maps attribute keys to their rules
+type AttributeSchema map[string]*AttributeRule
+
+// attributeSchemas caches parsed schemas by their raw JSON, so patterns are
+// compiled once per schema version rather than once per request
+var attributeSchemas sync.Map
+
+// loadAttributeSchema reads the tenant's attribute schema from Rigel. An
+// unset key means no attributes are allowed.
+func loadAttributeSchema(ctx context.Context, cfg tenantConfig) (AttributeSchema, error) {
+	raw, err := cfg.Get(ctx, attributeSchemaKey)
+	var notFound *rigel.KeyNotFoundError
+	if errors.As(err, &notFound) {
+		return AttributeSchema{}, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get %s: %w", attributeSchemaKey, err)
+	}
+	if cached, ok := attributeSchemas.Load(raw); ok {
+		return cached.(AttributeSchema), nil
+	}
+	schema, err := parseAttributeSchema(raw)
+	if err != nil {
+		return nil, err
+	}
+	attributeSchemas.Store(raw, schema)
+	return schema, nil
+}
+
+func parseAttributeSchema(raw string) (AttributeSchema, error) {
+	schema := AttributeSchema{}
+	if raw == "" {
+		return schema, nil
+	}
+	if err := json.Unmarshal([]byte(raw), &schema); err != nil {
+		return nil, fmt.Errorf("invalid %s: %w", attributeSchemaKey, err)
+	}
+	for key, rule := range schema {
+		switch rule.Type {
+		case AttrTypeString, AttrTypeInt, AttrTypeNumber, AttrTypeBool, AttrTypeDate:
+		default:
+			return nil, fmt.Errorf("invalid %s: attribute %s has unknown type %q", attributeSchemaKey, key, rule.Type)
+		}
+		if rule.Pattern != "" {
+			pattern, err := regexp.Compile(rule.Pattern)
+			if err != nil {
+				return nil, fmt.Errorf("invalid %s: attribute %s: %w", attributeSchemaKey, key, err)
+			}
+			rule.pattern = pattern
+		}
+	}
+	return schema, nil
+}
+
+// validateCreate checks the attributes of a new user, including that every
+// required attribute is present. Null values are dropped.
+func (s AttributeSchema) validateCreate(attrs map[string]any) []wscutils.ErrorMessage {
+	for key, value := range attrs {
+		if value == nil {
+			delete(attrs, key)
+		}
+	}
+	errs := s.validateValues(attrs)
+	for _, key := range s.sortedKeys() {
+		if _, ok := attrs[key]; !ok && s[key].Required {
+			errs = append(errs, wscutils.BuildErrorMessage(MsgIDValidation, ErrCodeRequired, attributeField(key)))
+		}
+	}
+	return errs
+}
+
+// validateUpdate checks an attribute patch, where null removes a key
+func (s AttributeSchema) validateUpdate(attrs map[string]any) []wscutils.ErrorMessage {
+	errs := s.validateValues(attrs)
+	for _, key := range sortedAttributeKeys(attrs) {
+		if rule, ok := s[key]; ok && attrs[key] == nil && rule.Required {
+			errs = append(errs, wscutils.BuildErrorMessage(MsgIDValidation, ErrCodeRequired, attributeField(key)))
+		}
+	}
+	return errs
+}
+
+// validateValues checks every non-null value against its rule and
+// normalises integers so they are stored without a fraction
+func (s AttributeSchema) validateValues(attrs map[string]any) []wscutils.ErrorMessage {
+	var errs []wscutils.ErrorMessage
+	for _, key := range sortedAttributeKeys(attrs) {
+		rule, ok := s[key]
+		if !ok {
+			errs = append(errs, wscutils.BuildErrorMessage(MsgIDUnknownAttribute, ErrCodeUnknownAttribute, attributeField(key)))
+			continue
+		}
+		if attrs[key] == nil {
+			continue
+		}
+		value, err := rule.check(key, attrs[key])
+		if err != nil {
+			errs = append(errs, *err)
+			continue
+		}
+		attrs[key] = value
+	}
+	return errs
+}
+
+func (r *AttributeRule) check(key string, value any) (any, *wscutils.ErrorMessage) {
+	field := attributeField(key)
+	invalid := func() (any, *wscutils.ErrorMessage) {
+		msg := wscutils.BuildErrorMessage(MsgIDValidation, ErrCodeInvalidFormat, field, r.Type)
+		return nil, &msg
+	}
+
+	switch r.Type {
+	case AttrTypeBool:
+		if _, ok := value.(bool); !ok {
+			return invalid()
+		}
+		return value, nil
+
+	case AttrTypeInt, AttrTypeNumber:
+		n, ok := value.(float64)
+		if !ok || (r.Type == AttrTypeInt && n != math.Trunc(n)) {
+			return invalid()
+		}
+		bounds := []string{formatBound(r.Min), formatBound(r.Max)}
+		if r.Type == AttrTypeInt && n > maxSafeInteger {
+			msg := wscutils.BuildErrorMessage(MsgIDValidation, ErrCodeTooBig, field, strconv.FormatFloat(n, 'f', -1, 64), bounds[0], strconv.Itoa(maxSafeInteger))
+			return nil, &msg
+		}
+		if r.Type == AttrTypeInt && n < -maxSafeInteger {
+			msg := wscutils.BuildErrorMessage(MsgIDValidation, ErrCodeTooSmall, field, strconv.FormatFloat(n, 'f', -1, 64), strconv.Itoa(-maxSafeInteger), bounds[1])
+			return nil, &msg
+		}
+		if r.Min != nil && n < *r.Min {
+			msg := wscutils.BuildErrorMessage(MsgIDValidation, ErrCodeTooSmall, field, append([]string{strconv.FormatFloat(n, 'f', -1, 64)}, bounds...)...)
+			return nil, &msg
+		}
+		if r.Max != nil && n > *r.Max {
+			msg := wscutils.BuildErrorMessage(MsgIDValidation, ErrCodeTooBig, field, append([]string{strconv.FormatFloat(n, 'f', -1, 64)}, bounds...)...)
+			return nil, &msg
+		}
+		if r.Type == AttrTypeInt {
+			return int64(n), nil
+		}
+		return n, nil
+
+	case AttrTypeDate:
+		str, ok := value.(string)
+		if !ok {
+			return invalid()
+		}
+		if _, err := time.Parse(time.DateOnly, str); err != nil {
+			return invalid()
+		}
+		return str, nil
+
+	default:
+		str, ok := value.(string)
+		if !ok {
+			return invalid()
+		}
+		length := utf8.RuneCountInString(str)
+		bounds := []string{formatLength(r.MinLength), formatLength(r.MaxLength)}
+		if r.MinLength != nil && length < *r.MinLength {
+			msg := wscutils.BuildErrorMessage(MsgIDValidation, ErrCodeTooSmall, field, append([]string{strconv.Itoa(length)}, bounds...)...)
+			return nil, &msg
+		}
+		if r.MaxLength != nil && length > *r.MaxLength {
+			msg := wscutils.BuildErrorMessage(MsgIDValidation, ErrCodeTooBig, field, append([]string{strconv.Itoa(length)}, bounds...)...)
+			return nil, &msg
+		}
+		if r.pattern != nil && !r.pattern.MatchString(str) {
+			msg := wscutils.BuildErrorMessage(MsgIDValidation, ErrCodeInvalidFormat, field, str)
+			return nil, &msg
+		}
+		if len(r.Enum) > 0 && !slices.Contains(r.Enum, str) {
+			msg := wscutils.BuildErrorMessage(MsgIDValidation, ErrCodeInvalidFormat, field, str)
+			return nil, &msg
+		}
+		return str, nil
+	}
+}
+
+func (s AttributeSchema) sortedKeys() []string {
+	keys := make([]string, 0, len(s))
+	for key := range s {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// attributeField is the field name reported in errors and change logs
+func attributeField(key string) string {
+	return "attributes." + key
+}
+
+// attributeValue renders an attribute value for change logs, with absent
+// or null as an empty string
+func attributeValue(v any) string {
+	switch v := v.(type) {
+	case nil:
+		return ""
+	case string:
+		return v
+	default:
+		data, _ := json.Marshal(v)
+		return string(data)
+	}
+}
+
+// splitAttributePatch separates an update's attributes into the JSON object
+// to merge and the keys to remove
+func splitAttributePatch(patch map[string]any) (set []byte, unset []string) {
+	values := make(map[string]any)
+	for _, key := range sortedAttributeKeys(patch) {
+		if patch[key] == nil {
+			unset = append(unset, key)
+		} else {
+			values[key] = patch[key]
+		}
+	}
+	if len(values) > 0 {
+		// Validated values are plain JSON types and always marshal
+		set, _ = json.Marshal(values)
+	}
+	return set, unset
+}
+
+// decodeAttributes reads the attributes column; NULL or invalid JSON reads
+// as no attributes
+func decodeAttributes(data []byte) map[string]any {
+	attrs := map[string]any{}
+	if len(data) > 0 {
+		json.Unmarshal(data, &attrs)
+	}
+	return attrs
+}
+
+func sortedAttributeKeys(attrs map[string]any) []string {
+	keys := make([]string, 0, len(attrs))
+	for key := range attrs {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func formatBound(v *float64) string {
+	if v == nil {
+		return ""
+	}
+	return strconv.FormatFloat(*v, 'f', -1, 64)
+}
+
+func formatLength(v *int) string {
+	if v == nil {
+		return ""
+	}
+	return strconv.Itoa(*v)
+}
@@ -0,0 +1,182 @@
+package usersvc
+
+import (
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/remiges-tech/alya/wscutils"
+)
+
+const testAttributeSchema = `{
+	"department":  {"type": "string", "minLength": 2, "maxLength": 10},
+	"employee_id": {"type": "string", "pattern": "^E[0-9]{5}$"},
+	"shift":       {"type": "string", "enum": ["day", "night"]},
+	"level":       {"type": "int", "min": 1, "max": 10, "required": true},
+	"badge":       {"type": "int"},
+	"rating":      {"type": "number", "min": 0, "max": 5},
+	"remote":      {"type": "bool"},
+	"start_date":  {"type": "date"}
+}`
+
+func testSchema(t *testing.T) AttributeSchema {
+	t.Helper()
+	schema, err := parseAttributeSchema(testAttributeSchema)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return schema
+}
+
+// decodeAttrs decodes attributes the way request binding does, with
+// numbers as float64
+func decodeAttrs(t *testing.T, data string) map[string]any {
+	t.Helper()
+	var attrs map[string]any
+	if err := json.Unmarshal([]byte(data), &attrs); err != nil {
+		t.Fatal(err)
+	}
+	return attrs
+}
+
+func TestParseAttributeSchemaRejectsBadRules(t *testing.T) {
+	for _, raw := range []string{
+		`{"level": {"type": "integer"}}`,
+		`{"code": {"type": "string", "pattern": "("}}`,
+		`[]`,
+	} {
+		if _, err := parseAttributeSchema(raw); err == nil || !strings.Contains(err.Error(), attributeSchemaKey) {
+			t.Errorf("parseAttributeSchema(%s) err = %v", raw, err)
+		}
+	}
+	if schema, err := parseAttributeSchema(""); err != nil || len(schema) != 0 {
+		t.Errorf("empty schema = %v, %v", schema, err)
+	}
+}
+
+func TestValidateCreate(t *testing.T) {
+	for _, tc := range []struct {
+		name  string
+		attrs string
+		want  []wscutils.ErrorMessage
+	}{
+		{"valid", `{"level": 3, "department": "Sales", "employee_id": "E12345", "shift": "day", "rating": 4.5, "remote": true, "start_date": "2024-06-22"}`, nil},
+		{"null dropped", `{"level": 3, "badge": null}`, nil},
+		{"required missing", `{"department": "Sales"}`, []wscutils.ErrorMessage{
+			{MsgID: MsgIDValidation, ErrCode: ErrCodeRequired, Field: "attributes.level"},
+		}},
+		{"required null", `{"level": null}`, []wscutils.ErrorMessage{
+			{MsgID: MsgIDValidation, ErrCode: ErrCodeRequired, Field: "attributes.level"},
+		}},
+		{"unknown key", `{"level": 3, "floor": 2}`, []wscutils.ErrorMessage{
+			{MsgID: MsgIDUnknownAttribute, ErrCode: ErrCodeUnknownAttribute, Field: "attributes.floor"},
+		}},
+		{"below min", `{"level": 0}`, []wscutils.ErrorMessage{
+			{MsgID: MsgIDValidation, ErrCode: ErrCodeTooSmall, Field: "attributes.level", Vals: []string{"0", "1", "10"}},
+		}},
+		{"above max", `{"level": 11, "rating": 5.5}`, []wscutils.ErrorMessage{
+			{MsgID: MsgIDValidation, ErrCode: ErrCodeTooBig, Field: "attributes.level", Vals: []string{"11", "1", "10"}},
+			{MsgID: MsgIDValidation, ErrCode: ErrCodeTooBig, Field: "attributes.rating", Vals: []string{"5.5", "0", "5"}},
+		}},
+		{"fractional int", `{"level": 2.5}`, []wscutils.ErrorMessage{
+			{MsgID: MsgIDValidation, ErrCode: ErrCodeInvalidFormat, Field: "attributes.level", Vals: []string{"int"}},
+		}},
+		{"too short", `{"level": 1, "department": "X"}`, []wscutils.ErrorMessage{
+			{MsgID: MsgIDValidation, ErrCode: ErrCodeTooSmall, Field: "attributes.department", Vals: []string{"1", "2", "10"}},
+		}},
+		{"too long counts runes", `{"level": 1, "department": "Öffentlich€x"}`, []wscutils.ErrorMessage{
+			{MsgID: MsgIDValidation, ErrCode: ErrCodeTooBig, Field: "attributes.department", Vals: []string{"12", "2", "10"}},
+		}},
+		{"pattern", `{"level": 1, "employee_id": "E1234"}`, []wscutils.ErrorMessage{
+			{MsgID: MsgIDValidation, ErrCode: ErrCodeInvalidFormat, Field: "attributes.employee_id", Vals: []string{"E1234"}},
+		}},
+		{"enum", `{"level": 1, "shift": "evening"}`, []wscutils.ErrorMessage{
+			{MsgID: MsgIDValidation, ErrCode: ErrCodeInvalidFormat, Field: "attributes.shift", Vals: []string{"evening"}},
+		}},
+		{"wrong types", `{"level": "3", "remote": "yes", "start_date": "22/06/2024"}`, []wscutils.ErrorMessage{
+			{MsgID: MsgIDValidation, ErrCode: ErrCodeInvalidFormat, Field: "attributes.level", Vals: []string{"int"}},
+			{MsgID: MsgIDValidation, ErrCode: ErrCodeInvalidFormat, Field: "attributes.remote", Vals: []string{"bool"}},
+			{MsgID: MsgIDValidation, ErrCode: ErrCodeInvalidFormat, Field: "attributes.start_date", Vals: []string{"date"}},
+		}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := testSchema(t).validateCreate(decodeAttrs(t, tc.attrs)); !reflect.DeepEqual(got, tc.want) {
+				t.Errorf("errors = %+v\nwant %+v", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestValidateIntegers(t *testing.T) {
+	schema := testSchema(t)
+
+	attrs := decodeAttrs(t, `{"level": 7, "badge": 9007199254740991, "rating": 3}`)
+	if errs := schema.validateCreate(attrs); errs != nil {
+		t.Fatalf("errors = %+v", errs)
+	}
+	// Integers are stored without a fraction, numbers as they came
+	want := map[string]any{"level": int64(7), "badge": int64(9007199254740991), "rating": float64(3)}
+	if !reflect.DeepEqual(attrs, want) {
+		t.Errorf("attributes = %#v, want %#v", attrs, want)
+	}
+
+	// Beyond 2^53 float64 cannot tell integers apart, so they are rejected
+	// rather than stored as a neighbouring or overflowed value
+	for _, tc := range []struct {
+		badge   string
+		errCode string
+	}{
+		{"9007199254740992", ErrCodeTooBig},
+		{"9007199254740993", ErrCodeTooBig},
+		{"1e19", ErrCodeTooBig},
+		{"-9007199254740993", ErrCodeTooSmall},
+		{"-1e19", ErrCodeTooSmall},
+	} {
+		errs := schema.validateCreate(decodeAttrs(t, `{"level": 1, "badge": `+tc.badge+`}`))
+		if len(errs) != 1 || errs[0].ErrCode != tc.errCode || errs[0].Field != "attributes.badge" {
+			t.Errorf("badge %s: errors = %+v, want %s", tc.badge, errs, tc.errCode)
+		}
+	}
+}
+
+func TestValidateUpdate(t *testing.T) {
+	for _, tc := range []struct {
+		name  string
+		attrs string
+		want  []wscutils.ErrorMessage
+	}{
+		// Required attributes need not be repeated in a patch
+		{"partial", `{"department": "Ops"}`, nil},
+		{"remove optional", `{"department": null, "badge": null}`, nil},
+		{"remove required", `{"level": null}`, []wscutils.ErrorMessage{
+			{MsgID: MsgIDValidation, ErrCode: ErrCodeRequired, Field: "attributes.level"},
+		}},
+		{"remove unknown", `{"floor": null}`, []wscutils.ErrorMessage{
+			{MsgID: MsgIDUnknownAttribute, ErrCode: ErrCodeUnknownAttribute, Field: "attributes.floor"},
+		}},
+		{"invalid values", `{"level": 12, "shift": "evening"}`, []wscutils.ErrorMessage{
+			{MsgID: MsgIDValidation, ErrCode: ErrCodeTooBig, Field: "attributes.level", Vals: []string{"12", "1", "10"}},
+			{MsgID: MsgIDValidation, ErrCode: ErrCodeInvalidFormat, Field: "attributes.shift", Vals: []string{"evening"}},
+		}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := testSchema(t).validateUpdate(decodeAttrs(t, tc.attrs)); !reflect.DeepEqual(got, tc.want) {
+				t.Errorf("errors = %+v\nwant %+v", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestSplitAttributePatch(t *testing.T) {
+	set, unset := splitAttributePatch(map[string]any{"level": int64(4), "badge": nil, "department": "Ops", "shift": nil})
+	if string(set) != `{"department":"Ops","level":4}` {
+		t.Errorf("set = %s", set)
+	}
+	if !reflect.DeepEqual(unset, []string{"badge", "shift"}) {
+		t.Errorf("unset = %v", unset)
+	}
+	if set, unset := splitAttributePatch(map[string]any{}); set != nil || unset != nil {
+		t.Errorf("empty patch = %s, %v", set, unset)
+	}
+}
@@ -0,0 +1,135 @@
+package usersvc
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/go-playground/validator/v10"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgtype"
+	"github.com/remiges-tech/alya/service"
+	"github.com/remiges-tech/alya/wscutils"
+	"github.com/remiges-tech/logharbour/logharbour"
+	"github.com/synapsewave/remiges-demo/pg"
+	"github.com/synapsewave/remiges-demo/pg/sqlc-gen"
+)
+
+// HandleChangeUserStatusRequest moves a user to another status, following
+// statusTransitions. Every change needs a reason. A suspension or lock may
+// be given an end time, when the status worker makes the user active
+// again; any other change cancels a scheduled one.
+func HandleChangeUserStatusRequest(c *gin.Context, s *service.Service) {
+	var statusReq ChangeUserStatusRequest
+	if err := wscutils.BindJSON(c, &statusReq); err != nil {
+		return
+	}
+
+	logger := requestLogger(c, s).WithModule("UserService").WithInstanceId(fmt.Sprintf("%d", statusReq.ID))
+	logger.Info().LogActivity("ChangeUserStatus request received", map[string]any{"status": statusReq.Status})
+
+	queries := s.Database.(*sqlc.Queries)
+	tenant := requestTenant(c)
+
+	// Validate request data
+	validationErrors := wscutils.WscValidate(statusReq, func(err validator.FieldError) []string {
+		switch err.Tag() {
+		case "max":
+			return []string{fmt.Sprintf("%d", len(err.Value().(string))), "1", err.Param()}
+		case "oneof":
+			return []string{err.Value().(string)}
+		default:
+			return []string{}
+		}
+	})
+
+	// Only statuses that expire take an end time, and it must be ahead
+	var scheduledStatus pgtype.Text
+	var scheduledAt pgtype.Timestamptz
+	if statusReq.Until != nil {
+		until := statusReq.Until.Format(time.RFC3339)
+		expiry, ok := statusExpiry[statusReq.Status]
+		switch {
+		case !ok:
+			validationErrors = append(validationErrors, wscutils.BuildErrorMessage(MsgIDValidation, ErrCodeNotSchedulable, "until", until))
+		case !statusReq.Until.After(time.Now()):
+			validationErrors = append(validationErrors, wscutils.BuildErrorMessage(MsgIDValidation, ErrCodeTooSmall, "until", until))
+		default:
+			scheduledStatus = pgtype.Text{String: expiry, Valid: true}
+			scheduledAt = pgtype.Timestamptz{Time: *statusReq.Until, Valid: true}
+		}
+	}
+
+	if len(validationErrors) > 0 {
+		wscutils.SendErrorResponse(c, wscutils.NewResponse("error", nil, validationErrors))
+		return
+	}
+
+	// Get the current status, from the primary since the change depends on it
+	currentUser, err := queries.GetUserByID(pg.WithPrimary(c.Request.Context()), sqlc.GetUserByIDParams{
+		TenantID: tenant,
+		ID:       statusReq.ID,
+	})
+	if err != nil {
+		if err == pgx.ErrNoRows {
+			logger.Info().LogActivity("User not found", map[string]any{"id": statusReq.ID})
+			notFoundError := wscutils.BuildErrorMessage(MsgIDNotFound, ErrCodeNotFound, "id", fmt.Sprintf("%d", statusReq.ID))
+			wscutils.SendErrorResponse(c, wscutils.NewResponse("error", nil, []wscutils.ErrorMessage{notFoundError}))
+			return
+		}
+		logger.Error(fmt.Errorf("error fetching user: %w", err)).LogActivity("Database error", nil)
+		internalError := wscutils.BuildErrorMessage(MsgIDInternalError, ErrCodeInternal, "", "")
+		wscutils.SendErrorResponse(c, wscutils.NewResponse("error", nil, []wscutils.ErrorMessage{internalError}))
+		return
+	}
+
+	// Enforce the transition table
+	if !canTransition(currentUser.Status, statusReq.Status) {
+		logger.Info().LogActivity("Status change not allowed", map[string]any{
+			"from": currentUser.Status,
+			"to":   statusReq.Status,
+		})
+		transitionError := wscutils.BuildErrorMessage(MsgIDInvalidStatus, ErrCodeTransition, "status", currentUser.Status, statusReq.Status)
+		wscutils.SendErrorResponse(c, wscutils.NewResponse("error", nil, []wscutils.ErrorMessage{transitionError}))
+		return
+	}
+
+	// Change status, provided nobody else changed it since it was read
+	user, err := queries.ChangeUserStatus(c.Request.Context(), sqlc.ChangeUserStatusParams{
+		Status:          statusReq.Status,
+		StatusReason:    pgtype.Text{String: statusReq.Reason, Valid: true},
+		ScheduledStatus: scheduledStatus,
+		ScheduledAt:     scheduledAt,
+		TenantID:        tenant,
+		ID:              statusReq.ID,
+		FromStatus:      currentUser.Status,
+	})
+	if err == pgx.ErrNoRows {
+		logger.Info().LogActivity("Status changed concurrently", map[string]any{"from": currentUser.Status})
+		conflictError := wscutils.BuildErrorMessage(MsgIDInvalidStatus, ErrCodeStatusConflict, "status", currentUser.Status, statusReq.Status)
+		wscutils.SendErrorResponse(c, wscutils.NewResponse("error", nil, []wscutils.ErrorMessage{conflictError}))
+		return
+	}
+	if err != nil {
+		logger.Error(fmt.Errorf("error changing user status: %w", err)).LogActivity("Database error", nil)
+		internalError := wscutils.BuildErrorMessage(MsgIDInternalError, ErrCodeInternal, "", "")
+		wscutils.SendErrorResponse(c, wscutils.NewResponse("error", nil, []wscutils.ErrorMessage{internalError}))
+		return
+	}
+
+	// Log the change like any other user update
+	changeInfo := logharbour.NewChangeInfo("User", "Update")
+	addStatusChanges(changeInfo, userStatusFields(currentUser), userStatusFields(user))
+	if len(changeInfo.Changes) > 0 {
+		logger.LogDataChange("User status changed", *changeInfo)
+	}
+
+	logger.Info().LogActivity("User status changed", map[string]any{
+		"from":      currentUser.Status,
+		"to":        user.Status,
+		"scheduled": user.ScheduledStatus.String,
+		"until":     formatStatusTime(user.ScheduledAt),
+	})
+
+	wscutils.SendSuccessResponse(c, wscutils.NewSuccessResponse(userToResponse(user)))
+}
@@ -0,0 +1,211 @@
+package usersvc
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/go-playground/validator/v10"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgtype"
+	"github.com/remiges-tech/alya/service"
+	"github.com/remiges-tech/alya/wscutils"
+	"github.com/remiges-tech/logharbour/logharbour"
+	"github.com/remiges-tech/rigel"
+	"github.com/synapsewave/remiges-demo/pg"
+	"github.com/synapsewave/remiges-demo/pg/sqlc-gen"
+)
+
+// reservationPeriodKey is the Rigel key with how long a username given up
+// in a rename stays reserved for its previous owner
+const reservationPeriodKey = "username.reservation_period"
+
+// defaultReservationPeriod applies when the tenant sets no period
+const defaultReservationPeriod = 30 * 24 * time.Hour
+
+// HandleChangeUsernameRequest renames a user. The old username is recorded
+// in username_history and reserved for the configured period: nobody else
+// can take it, and lookups by it still find the user.
+func HandleChangeUsernameRequest(c *gin.Context, s *service.Service) {
+	var renameReq ChangeUsernameRequest
+	if err := wscutils.BindJSON(c, &renameReq); err != nil {
+		return
+	}
+
+	logger := requestLogger(c, s).WithModule("UserService").WithInstanceId(fmt.Sprintf("%d", renameReq.ID))
+	logger.Info().LogActivity("ChangeUsername request received", map[string]any{"username": renameReq.Username})
+
+	queries := s.Database.(*sqlc.Queries)
+	tenant := requestTenant(c)
+
+	// Get validation constraints from Rigel, with the tenant's overrides
+	cfg := configFor(c, s)
+	minUsernameLength, err := cfg.Get(c.Request.Context(), "validation.username.minLength")
+	if err != nil {
+		minUsernameLength = "3" // Default value
+	}
+	maxUsernameLength, err := cfg.Get(c.Request.Context(), "validation.username.maxLength")
+	if err != nil {
+		maxUsernameLength = "30" // Default value
+	}
+
+	// Validate request data
+	validationErrors := wscutils.WscValidate(renameReq, func(err validator.FieldError) []string {
+		switch err.Tag() {
+		case "min", "max":
+			return []string{fmt.Sprintf("%d", len(err.Value().(string))), minUsernameLength, maxUsernameLength}
+		case "alphanum":
+			return []string{err.Value().(string)}
+		default:
+			return []string{}
+		}
+	})
+	if len(validationErrors) > 0 {
+		wscutils.SendErrorResponse(c, wscutils.NewResponse("error", nil, validationErrors))
+		return
+	}
+
+	reservationPeriod, err := loadReservationPeriod(c.Request.Context(), cfg)
+	if err != nil {
+		logger.Error(err).LogActivity("Configuration error", nil)
+		internalError := wscutils.BuildErrorMessage(MsgIDInternalError, ErrCodeInternal, "", "")
+		wscutils.SendErrorResponse(c, wscutils.NewResponse("error", nil, []wscutils.ErrorMessage{internalError}))
+		return
+	}
+
+	// Get the current username, from the primary since the rename depends on it
+	currentUser, err := queries.GetUserByID(pg.WithPrimary(c.Request.Context()), sqlc.GetUserByIDParams{
+		TenantID: tenant,
+		ID:       renameReq.ID,
+	})
+	if err != nil {
+		if err == pgx.ErrNoRows {
+			logger.Info().LogActivity("User not found", map[string]any{"id": renameReq.ID})
+			notFoundError := wscutils.BuildErrorMessage(MsgIDNotFound, ErrCodeNotFound, "id", fmt.Sprintf("%d", renameReq.ID))
+			wscutils.SendErrorResponse(c, wscutils.NewResponse("error", nil, []wscutils.ErrorMessage{notFoundError}))
+			return
+		}
+		logger.Error(fmt.Errorf("error fetching user: %w", err)).LogActivity("Database error", nil)
+		internalError := wscutils.BuildErrorMessage(MsgIDInternalError, ErrCodeInternal, "", "")
+		wscutils.SendErrorResponse(c, wscutils.NewResponse("error", nil, []wscutils.ErrorMessage{internalError}))
+		return
+	}
+
+	// Renaming to the current username changes nothing
+	if currentUser.Username == renameReq.Username {
+		wscutils.SendSuccessResponse(c, wscutils.NewSuccessResponse(userToResponse(currentUser)))
+		return
+	}
+
+	// Rename and record the old username. The statement itself checks that
+	// the new username is free and not reserved for someone else (the user
+	// may take back their own), and that nobody renamed the user since it
+	// was read, so concurrent renames cannot slip past the checks.
+	reservedUntil := time.Now().Add(reservationPeriod)
+	user, err := queries.ChangeUsername(c.Request.Context(), sqlc.ChangeUsernameParams{
+		TenantID:      tenant,
+		ID:            renameReq.ID,
+		OldUsername:   currentUser.Username,
+		NewUsername:   renameReq.Username,
+		ReservedUntil: pgtype.Timestamptz{Time: reservedUntil, Valid: true},
+	})
+	if err == pgx.ErrNoRows {
+		// Report a taken or reserved username as such; otherwise the user
+		// was renamed meanwhile
+		if !usernameAvailable(c, queries, logger, tenant, renameReq.Username, renameReq.ID) {
+			return
+		}
+		logger.Info().LogActivity("Username changed concurrently", map[string]any{"from": currentUser.Username})
+		conflictError := wscutils.BuildErrorMessage(MsgIDValidation, ErrCodeUsernameConflict, "username")
+		wscutils.SendErrorResponse(c, wscutils.NewResponse("error", nil, []wscutils.ErrorMessage{conflictError}))
+		return
+	}
+	if uniqueViolation(err) != "" {
+		// A concurrent rename or create took the username after the
+		// statement's own check
+		alreadyExistsError := wscutils.BuildErrorMessage(MsgIDAlreadyExists, ErrCodeAlreadyExists, "username")
+		wscutils.SendErrorResponse(c, wscutils.NewResponse("error", nil, []wscutils.ErrorMessage{alreadyExistsError}))
+		return
+	}
+	if err != nil {
+		logger.Error(fmt.Errorf("error changing username: %w", err)).LogActivity("Database error", nil)
+		internalError := wscutils.BuildErrorMessage(MsgIDInternalError, ErrCodeInternal, "", "")
+		wscutils.SendErrorResponse(c, wscutils.NewResponse("error", nil, []wscutils.ErrorMessage{internalError}))
+		return
+	}
+
+	// Log the change like any other user update
+	changeInfo := logharbour.NewChangeInfo("User", "Update")
+	changeInfo.AddChange("username", currentUser.Username, user.Username)
+	logger.LogDataChange("Username changed", *changeInfo)
+
+	logger.Info().LogActivity("Username changed", map[string]any{
+		"from":           currentUser.Username,
+		"to":             user.Username,
+		"reserved_until": reservedUntil.UTC().Format(time.RFC3339),
+	})
+
+	wscutils.SendSuccessResponse(c, wscutils.NewSuccessResponse(userToResponse(user)))
+}
+
+// loadReservationPeriod reads how long old usernames of the tenant stay
+// reserved, which defaults to defaultReservationPeriod
+func loadReservationPeriod(ctx context.Context, cfg tenantConfig) (time.Duration, error) {
+	value, err := cfg.Get(ctx, reservationPeriodKey)
+	var notFound *rigel.KeyNotFoundError
+	if errors.As(err, &notFound) || (err == nil && value == "") {
+		return defaultReservationPeriod, nil
+	}
+	if err != nil {
+		return 0, fmt.Errorf("failed to get %s: %w", reservationPeriodKey, err)
+	}
+	period, err := time.ParseDuration(value)
+	if err != nil || period < 0 {
+		return 0, fmt.Errorf("invalid %s %q: must be a non-negative duration", reservationPeriodKey, value)
+	}
+	return period, nil
+}
+
+// usernameAvailable reports whether userID may take username: no user of
+// the tenant has it and it is not reserved for another user. New users pass
+// userID 0. When it is not available, or the checks fail, it sends the
+// error response.
+func usernameAvailable(c *gin.Context, queries *sqlc.Queries, logger *logharbour.Logger, tenant, username string, userID int32) bool {
+	ctx := pg.WithPrimary(c.Request.Context())
+	exists, err := queries.CheckUsernameExists(ctx, sqlc.CheckUsernameExistsParams{
+		TenantID: tenant,
+		Username: username,
+	})
+	if err != nil {
+		logger.Error(fmt.Errorf("error checking existing user: %w", err)).LogActivity("Database error", nil)
+		internalError := wscutils.BuildErrorMessage(MsgIDInternalError, ErrCodeInternal, "", "")
+		wscutils.SendErrorResponse(c, wscutils.NewResponse("error", nil, []wscutils.ErrorMessage{internalError}))
+		return false
+	}
+	if exists {
+		alreadyExistsError := wscutils.BuildErrorMessage(MsgIDAlreadyExists, ErrCodeAlreadyExists, "username")
+		wscutils.SendErrorResponse(c, wscutils.NewResponse("error", nil, []wscutils.ErrorMessage{alreadyExistsError}))
+		return false
+	}
+
+	reserved, err := queries.CheckUsernameReserved(ctx, sqlc.CheckUsernameReservedParams{
+		TenantID: tenant,
+		Username: username,
+		UserID:   userID,
+	})
+	if err != nil {
+		logger.Error(fmt.Errorf("error checking reserved usernames: %w", err)).LogActivity("Database error", nil)
+		internalError := wscutils.BuildErrorMessage(MsgIDInternalError, ErrCodeInternal, "", "")
+		wscutils.SendErrorResponse(c, wscutils.NewResponse("error", nil, []wscutils.ErrorMessage{internalError}))
+		return false
+	}
+	if reserved {
+		logger.Info().LogActivity("Username reserved", map[string]any{"username": username})
+		reservedError := wscutils.BuildErrorMessage(MsgIDUsernameReserved, ErrCodeReserved, "username", username)
+		wscutils.SendErrorResponse(c, wscutils.NewResponse("error", nil, []wscutils.ErrorMessage{reservedError}))
+		return false
+	}
+	return true
+}
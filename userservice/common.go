@@ -1,8 +1,11 @@
 package usersvc
 
 import (
+	"errors"
 	"strings"
+	"time"
 
+	"github.com/jackc/pgx/v5/pgconn"
 	"github.com/remiges-tech/alya/wscutils"
 	"github.com/synapsewave/remiges-demo/pg/sqlc-gen"
 )
@@ -20,18 +23,32 @@ const (
 	MsgIDAlreadyExists    = 104 // Resource already exists (username, email)
 	MsgIDNotFound         = 105 // Resource not found
 	MsgIDNoFieldsToUpdate = 106 // No fields provided for update
+	MsgIDUnknownAttribute = 107 // Attribute not defined in the attribute schema
+	MsgIDInvalidTenant    = 108 // Tenant missing, malformed or not allowed
+	MsgIDInvalidStatus    = 109 // Status change not allowed
+	MsgIDUsernameReserved = 110 // Username held for its previous owner
 
 	// Error codes
 	// These are sent in the response and for machines to understand the error
-	ErrCodeRequired      = "required" // Field is required
-	ErrCodeTooSmall      = "toosmall" // Value too small/short (min validation)
-	ErrCodeTooBig        = "toobig"   // Value too big/long (max validation)
-	ErrCodeInvalidFormat = "datafmt"  // Invalid data format (email, phone, etc.)
-	ErrCodeInternal      = "internal" // Internal server error
-	ErrCodeBannedDomain  = "invalid"  // Business rule violation (banned domain)
-	ErrCodeAlreadyExists = "exists"   // Resource already exists
-	ErrCodeNotFound      = "missing"  // Resource not found
-	ErrCodeNoFields      = "missing"  // No fields provided
+	ErrCodeRequired         = "required"  // Field is required
+	ErrCodeTooSmall         = "toosmall"  // Value too small/short (min validation)
+	ErrCodeTooBig           = "toobig"    // Value too big/long (max validation)
+	ErrCodeInvalidFormat    = "datafmt"   // Invalid data format (email, phone, etc.)
+	ErrCodeInternal         = "internal"  // Internal server error
+	ErrCodeBannedDomain     = "invalid"   // Business rule violation (banned domain)
+	ErrCodeAlreadyExists    = "exists"    // Resource already exists
+	ErrCodeNotFound         = "missing"   // Resource not found
+	ErrCodeNoFields         = "missing"   // No fields provided
+	ErrCodeUnknownAttribute = "unknown"   // Attribute not defined in the attribute schema
+	ErrCodeUnknownTenant    = "unknown"   // Tenant not in tenant.allowed
+	ErrCodeTenantMismatch   = "mismatch"  // Header and token name different tenants
+	ErrCodeInvalidToken     = "token"     // Bearer token fails signature or expiry checks
+	ErrCodeUntrustedHeader  = "untrusted" // Tenant header without a token while it is not trusted
+	ErrCodeTransition       = "denied"    // Status may not change to the requested one
+	ErrCodeStatusConflict   = "conflict"  // Status changed by another request meanwhile
+	ErrCodeNotSchedulable   = "invalid"   // Until given for a status that does not expire
+	ErrCodeReserved         = "reserved"  // Username recently given up by another user
+	ErrCodeUsernameConflict = "conflict"  // Username changed by another request meanwhile
 
 	// Validation constraints
 	MinNameLength     = 2
@@ -39,6 +56,11 @@ const (
 	MinUsernameLength = 3
 	MaxUsernameLength = 30
 	MaxEmailLength    = 100
+
+	// Groups
+	MaxGroupNameLength     = 100
+	DefaultMembersPageSize = 50
+	MaxMembersPageSize     = 100
 )
 
 //-----------------------------------------------------------------------------
@@ -57,10 +79,15 @@ type CreateUserRequest struct {
 	Email       string `json:"email" validate:"required,email,max=100"`
 	Username    string `json:"username" validate:"required,min=3,max=30,alphanum"`
 	PhoneNumber string `json:"phone_number" validate:"omitempty,e164"`
+	// Attributes are checked against the Rigel attribute schema
+	Attributes map[string]any `json:"attributes"`
 }
 
+// GetUserRequest finds a user by ID or by username. A username given up in
+// a rename still finds its previous owner while it is reserved.
 type GetUserRequest struct {
-	ID int32 `json:"id" validate:"required"`
+	ID       int32  `json:"id" validate:"required_without=Username"`
+	Username string `json:"username" validate:"required_without=ID,omitempty,max=30"`
 }
 
 type UpdateUserRequest struct {
@@ -68,16 +95,97 @@ type UpdateUserRequest struct {
 	Name        *string `json:"name" validate:"omitempty,min=2,max=50"`
 	Email       *string `json:"email" validate:"omitempty,email,max=100"`
 	PhoneNumber *string `json:"phone_number" validate:"omitempty,e164"`
+	// Attributes are merged into the stored ones; null removes a key
+	Attributes map[string]any `json:"attributes"`
+}
+
+// ChangeUsernameRequest renames a user. The old username is kept reserved
+// for them for the configured period.
+type ChangeUsernameRequest struct {
+	ID       int32  `json:"id" validate:"required"`
+	Username string `json:"username" validate:"required,min=3,max=30,alphanum"`
+}
+
+// ChangeUserStatusRequest moves a user to another status. Until, for
+// suspended and locked, schedules the return to active.
+type ChangeUserStatusRequest struct {
+	ID     int32      `json:"id" validate:"required"`
+	Status string     `json:"status" validate:"required,oneof=pending active suspended locked deactivated"`
+	Reason string     `json:"reason" validate:"required,max=500"`
+	Until  *time.Time `json:"until"`
+}
+
+type CreateGroupRequest struct {
+	Name string `json:"name" validate:"required,max=100"`
+}
+
+type RenameGroupRequest struct {
+	ID   int32  `json:"id" validate:"required"`
+	Name string `json:"name" validate:"required,max=100"`
+}
+
+type DeleteGroupRequest struct {
+	ID int32 `json:"id" validate:"required"`
+}
+
+// GroupMemberRequest adds a user to a group or removes them from it
+type GroupMemberRequest struct {
+	GroupID int32 `json:"group_id" validate:"required"`
+	UserID  int32 `json:"user_id" validate:"required"`
+}
+
+type ListUserGroupsRequest struct {
+	UserID int32 `json:"user_id" validate:"required"`
+}
+
+// ListGroupMembersRequest pages through members in user ID order. AfterID
+// is the next_after_id of the previous page, or 0 for the first page.
+type ListGroupMembersRequest struct {
+	GroupID  int32 `json:"group_id" validate:"required"`
+	AfterID  int32 `json:"after_id" validate:"min=0"`
+	PageSize int32 `json:"page_size" validate:"omitempty,min=1,max=100"`
 }
 
 type UserResponse struct {
-	ID          int32   `json:"id"`
-	Name        string  `json:"name"`
-	Email       string  `json:"email"`
-	Username    string  `json:"username"`
-	PhoneNumber *string `json:"phone_number"`
-	CreatedAt   string  `json:"created_at"`
-	UpdatedAt   string  `json:"updated_at"`
+	ID          int32          `json:"id"`
+	Name        string         `json:"name"`
+	Email       string         `json:"email"`
+	Username    string         `json:"username"`
+	PhoneNumber *string        `json:"phone_number"`
+	CreatedAt   string         `json:"created_at"`
+	UpdatedAt   string         `json:"updated_at"`
+	Attributes  map[string]any `json:"attributes"`
+	Status      StatusResponse `json:"status"`
+}
+
+type StatusResponse struct {
+	Value     string  `json:"value"`
+	Reason    *string `json:"reason"`
+	ChangedAt *string `json:"changed_at"`
+	// Scheduled is the status change due at ScheduledAt, if any
+	Scheduled   *string `json:"scheduled"`
+	ScheduledAt *string `json:"scheduled_at"`
+}
+
+type GroupResponse struct {
+	ID        int32  `json:"id"`
+	Name      string `json:"name"`
+	CreatedAt string `json:"created_at"`
+	UpdatedAt string `json:"updated_at"`
+}
+
+type GroupMemberResponse struct {
+	ID       int32  `json:"id"`
+	Name     string `json:"name"`
+	Email    string `json:"email"`
+	Username string `json:"username"`
+	AddedAt  string `json:"added_at"`
+}
+
+type GroupMembersResponse struct {
+	Members []GroupMemberResponse `json:"members"`
+	// NextAfterID is set when there may be more members
+	NextAfterID *int32 `json:"next_after_id"`
 }
 
 //-----------------------------------------------------------------------------
@@ -87,22 +195,26 @@ type UserResponse struct {
 func init() {
 	// Step 1: Set up validation tag to error code mapping
 	wscutils.SetValidationTagToErrCodeMap(map[string]string{
-		"required": ErrCodeRequired,
-		"min":      ErrCodeTooSmall,
-		"max":      ErrCodeTooBig,
-		"email":    ErrCodeInvalidFormat,
-		"alphanum": ErrCodeInvalidFormat,
-		"e164":     ErrCodeInvalidFormat,
+		"required":         ErrCodeRequired,
+		"required_without": ErrCodeRequired,
+		"min":              ErrCodeTooSmall,
+		"max":              ErrCodeTooBig,
+		"email":            ErrCodeInvalidFormat,
+		"alphanum":         ErrCodeInvalidFormat,
+		"e164":             ErrCodeInvalidFormat,
+		"oneof":            ErrCodeInvalidFormat,
 	})
 
 	// Step 2: Set up validation tag to message ID mapping
 	wscutils.SetValidationTagToMsgIDMap(map[string]int{
-		"required": MsgIDValidation,
-		"min":      MsgIDValidation,
-		"max":      MsgIDValidation,
-		"email":    MsgIDValidation,
-		"alphanum": MsgIDValidation,
-		"e164":     MsgIDValidation,
+		"required":         MsgIDValidation,
+		"required_without": MsgIDValidation,
+		"min":              MsgIDValidation,
+		"max":              MsgIDValidation,
+		"email":            MsgIDValidation,
+		"alphanum":         MsgIDValidation,
+		"e164":             MsgIDValidation,
+		"oneof":            MsgIDValidation,
 	})
 
 	// Step 3: Set default error code and message ID
@@ -117,16 +229,31 @@ func init() {
 // Helper function to convert sqlc.User to UserResponse
 func userToResponse(user sqlc.User) UserResponse {
 	response := UserResponse{
-		ID:       user.ID,
-		Name:     user.Name,
-		Email:    user.Email,
-		Username: user.Username,
+		ID:         user.ID,
+		Name:       user.Name,
+		Email:      user.Email,
+		Username:   user.Username,
+		Attributes: decodeAttributes(user.Attributes),
 	}
 
 	if user.PhoneNumber.Valid {
 		response.PhoneNumber = &user.PhoneNumber.String
 	}
 
+	response.Status.Value = user.Status
+	if user.StatusReason.Valid {
+		response.Status.Reason = &user.StatusReason.String
+	}
+	if user.StatusChangedAt.Valid {
+		changedAt := user.StatusChangedAt.Time.Format("2006-01-02T15:04:05Z")
+		response.Status.ChangedAt = &changedAt
+	}
+	if user.ScheduledStatus.Valid && user.ScheduledAt.Valid {
+		scheduledAt := user.ScheduledAt.Time.Format("2006-01-02T15:04:05Z")
+		response.Status.Scheduled = &user.ScheduledStatus.String
+		response.Status.ScheduledAt = &scheduledAt
+	}
+
 	if user.CreatedAt.Valid {
 		response.CreatedAt = user.CreatedAt.Time.Format("2006-01-02T15:04:05Z")
 	}
@@ -138,6 +265,40 @@ func userToResponse(user sqlc.User) UserResponse {
 	return response
 }
 
+// Helper function to convert sqlc.Group to GroupResponse
+func groupToResponse(group sqlc.Group) GroupResponse {
+	response := GroupResponse{
+		ID:   group.ID,
+		Name: group.Name,
+	}
+
+	if group.CreatedAt.Valid {
+		response.CreatedAt = group.CreatedAt.Time.Format("2006-01-02T15:04:05Z")
+	}
+
+	if group.UpdatedAt.Valid {
+		response.UpdatedAt = group.UpdatedAt.Time.Format("2006-01-02T15:04:05Z")
+	}
+
+	return response
+}
+
+// Helper function to convert a member row to GroupMemberResponse
+func groupMemberToResponse(member sqlc.ListGroupMembersRow) GroupMemberResponse {
+	response := GroupMemberResponse{
+		ID:       member.ID,
+		Name:     member.Name,
+		Email:    member.Email,
+		Username: member.Username,
+	}
+
+	if member.AddedAt.Valid {
+		response.AddedAt = member.AddedAt.Time.Format("2006-01-02T15:04:05Z")
+	}
+
+	return response
+}
+
 // Helper function to check if email domain is banned
 func isEmailDomainBanned(email string) bool {
 	parts := strings.Split(email, "@")
@@ -154,3 +315,14 @@ func isEmailDomainBanned(email string) bool {
 	}
 	return false
 }
+
+// uniqueViolation returns the unique constraint err violates, or "" if err
+// is not a unique violation. The checks made before an insert or rename
+// cannot stop a concurrent request from taking the same value first.
+func uniqueViolation(err error) string {
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) && pgErr.Code == "23505" {
+		return pgErr.ConstraintName
+	}
+	return ""
+}
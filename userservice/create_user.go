@@ -1,6 +1,7 @@
 package usersvc
 
 import (
+	"encoding/json"
 	"fmt"
 
 	"github.com/gin-gonic/gin"
@@ -21,30 +22,32 @@ func HandleCreateUserRequest(c *gin.Context, s *service.Service) {
 	}
 
 	// Create logger with module and use username as instance ID for create operations
-	logger := s.LogHarbour.WithModule("UserService").WithInstanceId(createUserReq.Username)
+	logger := requestLogger(c, s).WithModule("UserService").WithInstanceId(createUserReq.Username)
 	logger.Info().LogActivity("CreateUser request received", nil)
 
 	// Get queries object once at the start
 	queries := s.Database.(*sqlc.Queries)
+	tenant := requestTenant(c)
 
-	// Get validation constraints from Rigel
-	minNameLength, err := s.RigelConfig.Get(c.Request.Context(), "validation.name.minLength")
+	// Get validation constraints from Rigel, with the tenant's overrides
+	cfg := configFor(c, s)
+	minNameLength, err := cfg.Get(c.Request.Context(), "validation.name.minLength")
 	if err != nil {
 		minNameLength = "2" // Default value
 	}
-	maxNameLength, err := s.RigelConfig.Get(c.Request.Context(), "validation.name.maxLength")
+	maxNameLength, err := cfg.Get(c.Request.Context(), "validation.name.maxLength")
 	if err != nil {
 		maxNameLength = "50" // Default value
 	}
-	minUsernameLength, err := s.RigelConfig.Get(c.Request.Context(), "validation.username.minLength")
+	minUsernameLength, err := cfg.Get(c.Request.Context(), "validation.username.minLength")
 	if err != nil {
 		minUsernameLength = "3" // Default value
 	}
-	maxUsernameLength, err := s.RigelConfig.Get(c.Request.Context(), "validation.username.maxLength")
+	maxUsernameLength, err := cfg.Get(c.Request.Context(), "validation.username.maxLength")
 	if err != nil {
 		maxUsernameLength = "30" // Default value
 	}
-	maxEmailLength, err := s.RigelConfig.Get(c.Request.Context(), "validation.email.maxLength")
+	maxEmailLength, err := cfg.Get(c.Request.Context(), "validation.email.maxLength")
 	if err != nil {
 		maxEmailLength = "100" // Default value
 	}
@@ -84,6 +87,16 @@ func HandleCreateUserRequest(c *gin.Context, s *service.Service) {
 		}
 	})
 
+	// Validate custom attributes against the attribute schema from Rigel
+	attributeSchema, err := loadAttributeSchema(c.Request.Context(), cfg)
+	if err != nil {
+		logger.Error(err).LogActivity("Configuration error", nil)
+		internalError := wscutils.BuildErrorMessage(MsgIDInternalError, ErrCodeInternal, "", "")
+		wscutils.SendErrorResponse(c, wscutils.NewResponse("error", nil, []wscutils.ErrorMessage{internalError}))
+		return
+	}
+	validationErrors = append(validationErrors, attributeSchema.validateCreate(createUserReq.Attributes)...)
+
 	if len(validationErrors) > 0 {
 		wscutils.SendErrorResponse(c, wscutils.NewResponse("error", nil, validationErrors))
 		return
@@ -101,28 +114,51 @@ func HandleCreateUserRequest(c *gin.Context, s *service.Service) {
 	//-------------------------------------------------------------------------
 	// Step 4: Check data dependencies
 	//-------------------------------------------------------------------------
-	exists, err := queries.CheckUsernameExists(c.Request.Context(), createUserReq.Username)
+	// Usernames recently given up in a rename are reserved too
+	if !usernameAvailable(c, queries, logger, tenant, createUserReq.Username, 0) {
+		return
+	}
+
+	//-------------------------------------------------------------------------
+	// Step 5: Perform core business logic
+	//-------------------------------------------------------------------------
+	status, err := loadInitialStatus(c.Request.Context(), cfg)
 	if err != nil {
-		logger.Error(fmt.Errorf("error checking existing user: %w", err)).LogActivity("Database error", nil)
+		logger.Error(err).LogActivity("Configuration error", nil)
 		internalError := wscutils.BuildErrorMessage(MsgIDInternalError, ErrCodeInternal, "", "")
 		wscutils.SendErrorResponse(c, wscutils.NewResponse("error", nil, []wscutils.ErrorMessage{internalError}))
 		return
 	}
-	if exists {
-		alreadyExistsError := wscutils.BuildErrorMessage(MsgIDAlreadyExists, ErrCodeAlreadyExists, "username")
-		wscutils.SendErrorResponse(c, wscutils.NewResponse("error", nil, []wscutils.ErrorMessage{alreadyExistsError}))
+	attributes := createUserReq.Attributes
+	if attributes == nil {
+		attributes = map[string]any{}
+	}
+	attributesJSON, err := json.Marshal(attributes)
+	if err != nil {
+		logger.Error(fmt.Errorf("error encoding attributes: %w", err)).LogActivity("Encoding error", nil)
+		internalError := wscutils.BuildErrorMessage(MsgIDInternalError, ErrCodeInternal, "", "")
+		wscutils.SendErrorResponse(c, wscutils.NewResponse("error", nil, []wscutils.ErrorMessage{internalError}))
 		return
 	}
-
-	//-------------------------------------------------------------------------
-	// Step 5: Perform core business logic
-	//-------------------------------------------------------------------------
 	user, err := queries.CreateUser(c.Request.Context(), sqlc.CreateUserParams{
 		Name:        createUserReq.Name,
 		Email:       createUserReq.Email,
 		Username:    createUserReq.Username,
 		PhoneNumber: pgtype.Text{String: createUserReq.PhoneNumber, Valid: createUserReq.PhoneNumber != ""},
+		Attributes:  attributesJSON,
+		TenantID:    tenant,
+		Status:      status,
 	})
+	if constraint := uniqueViolation(err); constraint != "" {
+		// Another request created a user with the same username or email
+		field := "username"
+		if constraint == "users_tenant_email_unique" {
+			field = "email"
+		}
+		alreadyExistsError := wscutils.BuildErrorMessage(MsgIDAlreadyExists, ErrCodeAlreadyExists, field)
+		wscutils.SendErrorResponse(c, wscutils.NewResponse("error", nil, []wscutils.ErrorMessage{alreadyExistsError}))
+		return
+	}
 	if err != nil {
 		logger.Error(fmt.Errorf("error creating user: %w", err)).LogActivity("Database error", nil)
 		internalError := wscutils.BuildErrorMessage(MsgIDInternalError, ErrCodeInternal, "", "")
@@ -11,11 +11,13 @@ import (
 	"github.com/remiges-tech/alya/wscutils"
 )
 
-// HandleGetUserRequest retrieves a user by ID
+// HandleGetUserRequest retrieves a user by ID or by username
 // Demonstrates:
-// 1. Simple GET operation with ID parameter
+// 1. Simple GET operation with ID or username parameter
 // 2. Error handling for not found cases
 // 3. Activity logging for audit trails
+// A username given up in a rename finds its previous owner while it is
+// reserved.
 func HandleGetUserRequest(c *gin.Context, s *service.Service) {
 	// Parse and bind request data first to get the ID
 	var getUserReq GetUserRequest
@@ -24,8 +26,8 @@ func HandleGetUserRequest(c *gin.Context, s *service.Service) {
 	}
 
 	// Create logger with module and instance information
-	logger := s.LogHarbour.WithModule("UserService").WithInstanceId(fmt.Sprintf("%d", getUserReq.ID))
-	logger.Info().LogActivity("GetUser request received", nil)
+	logger := requestLogger(c, s).WithModule("UserService").WithInstanceId(fmt.Sprintf("%d", getUserReq.ID))
+	logger.Info().LogActivity("GetUser request received", map[string]any{"username": getUserReq.Username})
 
 	// Get queries object
 	queries := s.Database.(*sqlc.Queries)
@@ -33,8 +35,10 @@ func HandleGetUserRequest(c *gin.Context, s *service.Service) {
 	// Validate request data
 	validationErrors := wscutils.WscValidate(getUserReq, func(err validator.FieldError) []string {
 		switch err.Tag() {
-		case "required":
+		case "required", "required_without":
 			return []string{} // Field name is already in ErrorMessage.field
+		case "max":
+			return []string{fmt.Sprintf("%d", len(err.Value().(string))), "0", err.Param()}
 		default:
 			return []string{}
 		}
@@ -45,12 +49,16 @@ func HandleGetUserRequest(c *gin.Context, s *service.Service) {
 		return
 	}
 
-	// Get user from database
-	user, err := queries.GetUserByID(c.Request.Context(), getUserReq.ID)
+	// Get user from database, by ID when one is given
+	user, err := getUserByRequest(c, queries, requestTenant(c), getUserReq)
 	if err != nil {
 		if err == pgx.ErrNoRows {
-			logger.Info().LogActivity("User not found", map[string]any{"id": getUserReq.ID})
-			notFoundError := wscutils.BuildErrorMessage(MsgIDNotFound, ErrCodeNotFound, "id", fmt.Sprintf("%d", getUserReq.ID))
+			field, value := "id", fmt.Sprintf("%d", getUserReq.ID)
+			if getUserReq.ID == 0 {
+				field, value = "username", getUserReq.Username
+			}
+			logger.Info().LogActivity("User not found", map[string]any{field: value})
+			notFoundError := wscutils.BuildErrorMessage(MsgIDNotFound, ErrCodeNotFound, field, value)
 			wscutils.SendErrorResponse(c, wscutils.NewResponse("error", nil, []wscutils.ErrorMessage{notFoundError}))
 			return
 		}
@@ -67,4 +75,27 @@ func HandleGetUserRequest(c *gin.Context, s *service.Service) {
 
 	// Send response
 	wscutils.SendSuccessResponse(c, wscutils.NewSuccessResponse(userToResponse(user)))
+}
+
+// getUserByRequest finds the user by ID or, failing that, by username: the
+// current one first, then a reserved one given up in a rename
+func getUserByRequest(c *gin.Context, queries *sqlc.Queries, tenant string, req GetUserRequest) (sqlc.User, error) {
+	ctx := c.Request.Context()
+	if req.ID != 0 {
+		return queries.GetUserByID(ctx, sqlc.GetUserByIDParams{
+			TenantID: tenant,
+			ID:       req.ID,
+		})
+	}
+	user, err := queries.GetUserByUsername(ctx, sqlc.GetUserByUsernameParams{
+		TenantID: tenant,
+		Username: req.Username,
+	})
+	if err != pgx.ErrNoRows {
+		return user, err
+	}
+	return queries.GetUserByPreviousUsername(ctx, sqlc.GetUserByPreviousUsernameParams{
+		TenantID: tenant,
+		Username: req.Username,
+	})
 }
\ No newline at end of file
@@ -0,0 +1,235 @@
+package usersvc
+
+import (
+	"fmt"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jackc/pgx/v5"
+	"github.com/remiges-tech/alya/service"
+	"github.com/remiges-tech/alya/wscutils"
+	"github.com/remiges-tech/logharbour/logharbour"
+	"github.com/synapsewave/remiges-demo/pg"
+	"github.com/synapsewave/remiges-demo/pg/sqlc-gen"
+)
+
+// HandleAddGroupMemberRequest adds a user to a group of the same tenant
+func HandleAddGroupMemberRequest(c *gin.Context, s *service.Service) {
+	var memberReq GroupMemberRequest
+	if err := wscutils.BindJSON(c, &memberReq); err != nil {
+		return
+	}
+
+	logger := requestLogger(c, s).WithModule("UserService").WithInstanceId(fmt.Sprintf("%d", memberReq.GroupID))
+	logger.Info().LogActivity("AddGroupMember request received", map[string]any{"user_id": memberReq.UserID})
+
+	queries := s.Database.(*sqlc.Queries)
+	tenant := requestTenant(c)
+
+	// Validate request data
+	validationErrors := wscutils.WscValidate(memberReq, groupValidationVals)
+	if len(validationErrors) > 0 {
+		wscutils.SendErrorResponse(c, wscutils.NewResponse("error", nil, validationErrors))
+		return
+	}
+
+	// Check that both the group and the user exist
+	if _, ok := getGroup(c, queries, logger, tenant, memberReq.GroupID, "group_id"); !ok {
+		return
+	}
+	if !userExists(c, queries, logger, tenant, memberReq.UserID) {
+		return
+	}
+
+	// Add member; an existing membership is left as it is
+	added, err := queries.AddGroupMember(c.Request.Context(), sqlc.AddGroupMemberParams{
+		TenantID: tenant,
+		GroupID:  memberReq.GroupID,
+		UserID:   memberReq.UserID,
+	})
+	if err != nil {
+		logger.Error(fmt.Errorf("error adding group member: %w", err)).LogActivity("Database error", nil)
+		internalError := wscutils.BuildErrorMessage(MsgIDInternalError, ErrCodeInternal, "", "")
+		wscutils.SendErrorResponse(c, wscutils.NewResponse("error", nil, []wscutils.ErrorMessage{internalError}))
+		return
+	}
+	if added == 0 {
+		alreadyExistsError := wscutils.BuildErrorMessage(MsgIDAlreadyExists, ErrCodeAlreadyExists, "user_id")
+		wscutils.SendErrorResponse(c, wscutils.NewResponse("error", nil, []wscutils.ErrorMessage{alreadyExistsError}))
+		return
+	}
+
+	changeInfo := logharbour.NewChangeInfo("GroupMember", "Create")
+	changeInfo.AddChange("user_id", "", fmt.Sprintf("%d", memberReq.UserID))
+	logger.LogDataChange("Group member added", *changeInfo)
+
+	wscutils.SendSuccessResponse(c, wscutils.NewSuccessResponse(nil))
+}
+
+// HandleRemoveGroupMemberRequest removes a user from a group
+func HandleRemoveGroupMemberRequest(c *gin.Context, s *service.Service) {
+	var memberReq GroupMemberRequest
+	if err := wscutils.BindJSON(c, &memberReq); err != nil {
+		return
+	}
+
+	logger := requestLogger(c, s).WithModule("UserService").WithInstanceId(fmt.Sprintf("%d", memberReq.GroupID))
+	logger.Info().LogActivity("RemoveGroupMember request received", map[string]any{"user_id": memberReq.UserID})
+
+	queries := s.Database.(*sqlc.Queries)
+	tenant := requestTenant(c)
+
+	// Validate request data
+	validationErrors := wscutils.WscValidate(memberReq, groupValidationVals)
+	if len(validationErrors) > 0 {
+		wscutils.SendErrorResponse(c, wscutils.NewResponse("error", nil, validationErrors))
+		return
+	}
+
+	if _, ok := getGroup(c, queries, logger, tenant, memberReq.GroupID, "group_id"); !ok {
+		return
+	}
+
+	// Remove member
+	removed, err := queries.RemoveGroupMember(c.Request.Context(), sqlc.RemoveGroupMemberParams{
+		TenantID: tenant,
+		GroupID:  memberReq.GroupID,
+		UserID:   memberReq.UserID,
+	})
+	if err != nil {
+		logger.Error(fmt.Errorf("error removing group member: %w", err)).LogActivity("Database error", nil)
+		internalError := wscutils.BuildErrorMessage(MsgIDInternalError, ErrCodeInternal, "", "")
+		wscutils.SendErrorResponse(c, wscutils.NewResponse("error", nil, []wscutils.ErrorMessage{internalError}))
+		return
+	}
+	if removed == 0 {
+		notFoundError := wscutils.BuildErrorMessage(MsgIDNotFound, ErrCodeNotFound, "user_id", fmt.Sprintf("%d", memberReq.UserID))
+		wscutils.SendErrorResponse(c, wscutils.NewResponse("error", nil, []wscutils.ErrorMessage{notFoundError}))
+		return
+	}
+
+	changeInfo := logharbour.NewChangeInfo("GroupMember", "Delete")
+	changeInfo.AddChange("user_id", fmt.Sprintf("%d", memberReq.UserID), "")
+	logger.LogDataChange("Group member removed", *changeInfo)
+
+	wscutils.SendSuccessResponse(c, wscutils.NewSuccessResponse(nil))
+}
+
+// HandleListUserGroupsRequest lists the groups of a user by name
+func HandleListUserGroupsRequest(c *gin.Context, s *service.Service) {
+	var listReq ListUserGroupsRequest
+	if err := wscutils.BindJSON(c, &listReq); err != nil {
+		return
+	}
+
+	logger := requestLogger(c, s).WithModule("UserService").WithInstanceId(fmt.Sprintf("%d", listReq.UserID))
+	logger.Info().LogActivity("ListUserGroups request received", nil)
+
+	queries := s.Database.(*sqlc.Queries)
+	tenant := requestTenant(c)
+
+	// Validate request data
+	validationErrors := wscutils.WscValidate(listReq, groupValidationVals)
+	if len(validationErrors) > 0 {
+		wscutils.SendErrorResponse(c, wscutils.NewResponse("error", nil, validationErrors))
+		return
+	}
+
+	// An unknown user is reported rather than listed as having no groups
+	if !userExists(c, queries, logger, tenant, listReq.UserID) {
+		return
+	}
+
+	groups, err := queries.ListUserGroups(c.Request.Context(), sqlc.ListUserGroupsParams{
+		TenantID: tenant,
+		UserID:   listReq.UserID,
+	})
+	if err != nil {
+		logger.Error(fmt.Errorf("error listing user groups: %w", err)).LogActivity("Database error", nil)
+		internalError := wscutils.BuildErrorMessage(MsgIDInternalError, ErrCodeInternal, "", "")
+		wscutils.SendErrorResponse(c, wscutils.NewResponse("error", nil, []wscutils.ErrorMessage{internalError}))
+		return
+	}
+
+	response := make([]GroupResponse, 0, len(groups))
+	for _, group := range groups {
+		response = append(response, groupToResponse(group))
+	}
+	wscutils.SendSuccessResponse(c, wscutils.NewSuccessResponse(response))
+}
+
+// HandleListGroupMembersRequest lists the members of a group a page at a
+// time, in user ID order. Paging by the last user ID seen keeps pages stable
+// while members are added and removed.
+func HandleListGroupMembersRequest(c *gin.Context, s *service.Service) {
+	var listReq ListGroupMembersRequest
+	if err := wscutils.BindJSON(c, &listReq); err != nil {
+		return
+	}
+
+	logger := requestLogger(c, s).WithModule("UserService").WithInstanceId(fmt.Sprintf("%d", listReq.GroupID))
+	logger.Info().LogActivity("ListGroupMembers request received", map[string]any{"after_id": listReq.AfterID})
+
+	queries := s.Database.(*sqlc.Queries)
+	tenant := requestTenant(c)
+
+	// Validate request data
+	validationErrors := wscutils.WscValidate(listReq, groupValidationVals)
+	if len(validationErrors) > 0 {
+		wscutils.SendErrorResponse(c, wscutils.NewResponse("error", nil, validationErrors))
+		return
+	}
+	pageSize := listReq.PageSize
+	if pageSize == 0 {
+		pageSize = DefaultMembersPageSize
+	}
+
+	if _, ok := getGroup(c, queries, logger, tenant, listReq.GroupID, "group_id"); !ok {
+		return
+	}
+
+	// Fetch one extra row to tell whether there is another page
+	members, err := queries.ListGroupMembers(c.Request.Context(), sqlc.ListGroupMembersParams{
+		TenantID: tenant,
+		GroupID:  listReq.GroupID,
+		AfterID:  listReq.AfterID,
+		PageSize: pageSize + 1,
+	})
+	if err != nil {
+		logger.Error(fmt.Errorf("error listing group members: %w", err)).LogActivity("Database error", nil)
+		internalError := wscutils.BuildErrorMessage(MsgIDInternalError, ErrCodeInternal, "", "")
+		wscutils.SendErrorResponse(c, wscutils.NewResponse("error", nil, []wscutils.ErrorMessage{internalError}))
+		return
+	}
+
+	response := GroupMembersResponse{Members: make([]GroupMemberResponse, 0, len(members))}
+	if len(members) > int(pageSize) {
+		members = members[:pageSize]
+		response.NextAfterID = &members[pageSize-1].ID
+	}
+	for _, member := range members {
+		response.Members = append(response.Members, groupMemberToResponse(member))
+	}
+	wscutils.SendSuccessResponse(c, wscutils.NewSuccessResponse(response))
+}
+
+// userExists reports whether the tenant has a user with the given ID. When
+// it has not, or the lookup fails, it sends the error response.
+func userExists(c *gin.Context, queries *sqlc.Queries, logger *logharbour.Logger, tenant string, id int32) bool {
+	_, err := queries.GetUserByID(pg.WithPrimary(c.Request.Context()), sqlc.GetUserByIDParams{
+		TenantID: tenant,
+		ID:       id,
+	})
+	if err == pgx.ErrNoRows {
+		logger.Info().LogActivity("User not found", map[string]any{"id": id})
+		notFoundError := wscutils.BuildErrorMessage(MsgIDNotFound, ErrCodeNotFound, "user_id", fmt.Sprintf("%d", id))
+		wscutils.SendErrorResponse(c, wscutils.NewResponse("error", nil, []wscutils.ErrorMessage{notFoundError}))
+		return false
+	}
+	if err != nil {
+		logger.Error(fmt.Errorf("error fetching user: %w", err)).LogActivity("Database error", nil)
+		internalError := wscutils.BuildErrorMessage(MsgIDInternalError, ErrCodeInternal, "", "")
+		wscutils.SendErrorResponse(c, wscutils.NewResponse("error", nil, []wscutils.ErrorMessage{internalError}))
+		return false
+	}
+	return true
+}
@@ -0,0 +1,261 @@
+package usersvc
+
+import (
+	"fmt"
+
+	"github.com/gin-gonic/gin"
+	"github.com/go-playground/validator/v10"
+	"github.com/jackc/pgx/v5"
+	"github.com/remiges-tech/alya/service"
+	"github.com/remiges-tech/alya/wscutils"
+	"github.com/remiges-tech/logharbour/logharbour"
+	"github.com/synapsewave/remiges-demo/pg"
+	"github.com/synapsewave/remiges-demo/pg/sqlc-gen"
+)
+
+// HandleCreateGroupRequest creates a group in the request's tenant. Group
+// names are unique per tenant.
+func HandleCreateGroupRequest(c *gin.Context, s *service.Service) {
+	var createGroupReq CreateGroupRequest
+	if err := wscutils.BindJSON(c, &createGroupReq); err != nil {
+		return
+	}
+
+	logger := requestLogger(c, s).WithModule("UserService")
+	logger.Info().LogActivity("CreateGroup request received", map[string]any{"name": createGroupReq.Name})
+
+	queries := s.Database.(*sqlc.Queries)
+	tenant := requestTenant(c)
+
+	// Validate request data
+	validationErrors := wscutils.WscValidate(createGroupReq, groupValidationVals)
+	if len(validationErrors) > 0 {
+		wscutils.SendErrorResponse(c, wscutils.NewResponse("error", nil, validationErrors))
+		return
+	}
+
+	// Check that the name is free
+	exists, err := queries.CheckGroupNameExists(c.Request.Context(), sqlc.CheckGroupNameExistsParams{
+		TenantID: tenant,
+		Name:     createGroupReq.Name,
+	})
+	if err != nil {
+		logger.Error(fmt.Errorf("error checking group name: %w", err)).LogActivity("Database error", nil)
+		internalError := wscutils.BuildErrorMessage(MsgIDInternalError, ErrCodeInternal, "", "")
+		wscutils.SendErrorResponse(c, wscutils.NewResponse("error", nil, []wscutils.ErrorMessage{internalError}))
+		return
+	}
+	if exists {
+		alreadyExistsError := wscutils.BuildErrorMessage(MsgIDAlreadyExists, ErrCodeAlreadyExists, "name")
+		wscutils.SendErrorResponse(c, wscutils.NewResponse("error", nil, []wscutils.ErrorMessage{alreadyExistsError}))
+		return
+	}
+
+	// Create group
+	group, err := queries.CreateGroup(c.Request.Context(), sqlc.CreateGroupParams{
+		TenantID: tenant,
+		Name:     createGroupReq.Name,
+	})
+	if uniqueViolation(err) != "" {
+		// Another request took the name after the check above
+		alreadyExistsError := wscutils.BuildErrorMessage(MsgIDAlreadyExists, ErrCodeAlreadyExists, "name")
+		wscutils.SendErrorResponse(c, wscutils.NewResponse("error", nil, []wscutils.ErrorMessage{alreadyExistsError}))
+		return
+	}
+	if err != nil {
+		logger.Error(fmt.Errorf("error creating group: %w", err)).LogActivity("Database error", nil)
+		internalError := wscutils.BuildErrorMessage(MsgIDInternalError, ErrCodeInternal, "", "")
+		wscutils.SendErrorResponse(c, wscutils.NewResponse("error", nil, []wscutils.ErrorMessage{internalError}))
+		return
+	}
+
+	logger = logger.WithInstanceId(fmt.Sprintf("%d", group.ID))
+	changeInfo := logharbour.NewChangeInfo("Group", "Create")
+	changeInfo.AddChange("name", "", group.Name)
+	logger.LogDataChange("Group created", *changeInfo)
+
+	wscutils.SendSuccessResponse(c, wscutils.NewSuccessResponse(groupToResponse(group)))
+}
+
+// HandleRenameGroupRequest changes the name of a group
+func HandleRenameGroupRequest(c *gin.Context, s *service.Service) {
+	var renameGroupReq RenameGroupRequest
+	if err := wscutils.BindJSON(c, &renameGroupReq); err != nil {
+		return
+	}
+
+	logger := requestLogger(c, s).WithModule("UserService").WithInstanceId(fmt.Sprintf("%d", renameGroupReq.ID))
+	logger.Info().LogActivity("RenameGroup request received", nil)
+
+	queries := s.Database.(*sqlc.Queries)
+	tenant := requestTenant(c)
+
+	// Validate request data
+	validationErrors := wscutils.WscValidate(renameGroupReq, groupValidationVals)
+	if len(validationErrors) > 0 {
+		wscutils.SendErrorResponse(c, wscutils.NewResponse("error", nil, validationErrors))
+		return
+	}
+
+	// Get the current name for the changelog
+	currentGroup, ok := getGroup(c, queries, logger, tenant, renameGroupReq.ID, "id")
+	if !ok {
+		return
+	}
+	if currentGroup.Name == renameGroupReq.Name {
+		wscutils.SendSuccessResponse(c, wscutils.NewSuccessResponse(groupToResponse(currentGroup)))
+		return
+	}
+
+	// Check that the new name is free
+	exists, err := queries.CheckGroupNameExistsForRename(c.Request.Context(), sqlc.CheckGroupNameExistsForRenameParams{
+		TenantID: tenant,
+		Name:     renameGroupReq.Name,
+		ID:       renameGroupReq.ID,
+	})
+	if err != nil {
+		logger.Error(fmt.Errorf("error checking group name: %w", err)).LogActivity("Database error", nil)
+		internalError := wscutils.BuildErrorMessage(MsgIDInternalError, ErrCodeInternal, "", "")
+		wscutils.SendErrorResponse(c, wscutils.NewResponse("error", nil, []wscutils.ErrorMessage{internalError}))
+		return
+	}
+	if exists {
+		alreadyExistsError := wscutils.BuildErrorMessage(MsgIDAlreadyExists, ErrCodeAlreadyExists, "name")
+		wscutils.SendErrorResponse(c, wscutils.NewResponse("error", nil, []wscutils.ErrorMessage{alreadyExistsError}))
+		return
+	}
+
+	// Rename group
+	group, err := queries.RenameGroup(c.Request.Context(), sqlc.RenameGroupParams{
+		TenantID: tenant,
+		ID:       renameGroupReq.ID,
+		Name:     renameGroupReq.Name,
+	})
+	if uniqueViolation(err) != "" {
+		// Another request took the name after the check above
+		alreadyExistsError := wscutils.BuildErrorMessage(MsgIDAlreadyExists, ErrCodeAlreadyExists, "name")
+		wscutils.SendErrorResponse(c, wscutils.NewResponse("error", nil, []wscutils.ErrorMessage{alreadyExistsError}))
+		return
+	}
+	if err != nil {
+		logger.Error(fmt.Errorf("error renaming group: %w", err)).LogActivity("Database error", nil)
+		internalError := wscutils.BuildErrorMessage(MsgIDInternalError, ErrCodeInternal, "", "")
+		wscutils.SendErrorResponse(c, wscutils.NewResponse("error", nil, []wscutils.ErrorMessage{internalError}))
+		return
+	}
+
+	changeInfo := logharbour.NewChangeInfo("Group", "Update")
+	changeInfo.AddChange("name", currentGroup.Name, group.Name)
+	logger.LogDataChange("Group renamed", *changeInfo)
+
+	wscutils.SendSuccessResponse(c, wscutils.NewSuccessResponse(groupToResponse(group)))
+}
+
+// HandleDeleteGroupRequest deletes a group together with its memberships.
+// The users themselves are not affected.
+func HandleDeleteGroupRequest(c *gin.Context, s *service.Service) {
+	var deleteGroupReq DeleteGroupRequest
+	if err := wscutils.BindJSON(c, &deleteGroupReq); err != nil {
+		return
+	}
+
+	logger := requestLogger(c, s).WithModule("UserService").WithInstanceId(fmt.Sprintf("%d", deleteGroupReq.ID))
+	logger.Info().LogActivity("DeleteGroup request received", nil)
+
+	queries := s.Database.(*sqlc.Queries)
+	tenant := requestTenant(c)
+
+	// Validate request data
+	validationErrors := wscutils.WscValidate(deleteGroupReq, groupValidationVals)
+	if len(validationErrors) > 0 {
+		wscutils.SendErrorResponse(c, wscutils.NewResponse("error", nil, validationErrors))
+		return
+	}
+
+	// Get the group and its member count for the logs
+	currentGroup, ok := getGroup(c, queries, logger, tenant, deleteGroupReq.ID, "id")
+	if !ok {
+		return
+	}
+	memberCount, err := queries.CountGroupMembers(pg.WithPrimary(c.Request.Context()), sqlc.CountGroupMembersParams{
+		TenantID: tenant,
+		GroupID:  deleteGroupReq.ID,
+	})
+	if err != nil {
+		logger.Error(fmt.Errorf("error counting group members: %w", err)).LogActivity("Database error", nil)
+		internalError := wscutils.BuildErrorMessage(MsgIDInternalError, ErrCodeInternal, "", "")
+		wscutils.SendErrorResponse(c, wscutils.NewResponse("error", nil, []wscutils.ErrorMessage{internalError}))
+		return
+	}
+
+	// Delete group; memberships go with it
+	deleted, err := queries.DeleteGroup(c.Request.Context(), sqlc.DeleteGroupParams{
+		TenantID: tenant,
+		ID:       deleteGroupReq.ID,
+	})
+	if err != nil {
+		logger.Error(fmt.Errorf("error deleting group: %w", err)).LogActivity("Database error", nil)
+		internalError := wscutils.BuildErrorMessage(MsgIDInternalError, ErrCodeInternal, "", "")
+		wscutils.SendErrorResponse(c, wscutils.NewResponse("error", nil, []wscutils.ErrorMessage{internalError}))
+		return
+	}
+	if deleted == 0 {
+		// Deleted by a concurrent request
+		notFoundError := wscutils.BuildErrorMessage(MsgIDNotFound, ErrCodeNotFound, "id", fmt.Sprintf("%d", deleteGroupReq.ID))
+		wscutils.SendErrorResponse(c, wscutils.NewResponse("error", nil, []wscutils.ErrorMessage{notFoundError}))
+		return
+	}
+
+	changeInfo := logharbour.NewChangeInfo("Group", "Delete")
+	changeInfo.AddChange("name", currentGroup.Name, "")
+	logger.LogDataChange("Group deleted", *changeInfo)
+
+	logger.Info().LogActivity("Group deleted", map[string]any{
+		"name":            currentGroup.Name,
+		"members_removed": memberCount,
+	})
+
+	wscutils.SendSuccessResponse(c, wscutils.NewSuccessResponse(nil))
+}
+
+// getGroup fetches a group of the tenant from the primary, since the lookup
+// guards a write. When there is no such group, or the lookup fails, it sends
+// the error response, reporting a missing group against field, and returns
+// false.
+func getGroup(c *gin.Context, queries *sqlc.Queries, logger *logharbour.Logger, tenant string, id int32, field string) (sqlc.Group, bool) {
+	group, err := queries.GetGroupByID(pg.WithPrimary(c.Request.Context()), sqlc.GetGroupByIDParams{
+		TenantID: tenant,
+		ID:       id,
+	})
+	if err == pgx.ErrNoRows {
+		logger.Info().LogActivity("Group not found", map[string]any{"id": id})
+		notFoundError := wscutils.BuildErrorMessage(MsgIDNotFound, ErrCodeNotFound, field, fmt.Sprintf("%d", id))
+		wscutils.SendErrorResponse(c, wscutils.NewResponse("error", nil, []wscutils.ErrorMessage{notFoundError}))
+		return group, false
+	}
+	if err != nil {
+		logger.Error(fmt.Errorf("error fetching group: %w", err)).LogActivity("Database error", nil)
+		internalError := wscutils.BuildErrorMessage(MsgIDInternalError, ErrCodeInternal, "", "")
+		wscutils.SendErrorResponse(c, wscutils.NewResponse("error", nil, []wscutils.ErrorMessage{internalError}))
+		return group, false
+	}
+	return group, true
+}
+
+// groupValidationVals returns the vals of a validation error in a group
+// request: for min and max, the current length or value and the bounds
+func groupValidationVals(err validator.FieldError) []string {
+	switch err.Tag() {
+	case "min", "max":
+		switch err.Field() {
+		case "Name":
+			return []string{fmt.Sprintf("%d", len(err.Value().(string))), "1", fmt.Sprintf("%d", MaxGroupNameLength)}
+		case "PageSize":
+			return []string{fmt.Sprintf("%v", err.Value()), "1", fmt.Sprintf("%d", MaxMembersPageSize)}
+		default:
+			return []string{fmt.Sprintf("%v", err.Value()), err.Param()}
+		}
+	default:
+		return []string{}
+	}
+}
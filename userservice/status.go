@@ -0,0 +1,112 @@
+package usersvc
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgtype"
+	"github.com/remiges-tech/logharbour/logharbour"
+	"github.com/remiges-tech/rigel"
+	"github.com/synapsewave/remiges-demo/pg/sqlc-gen"
+)
+
+// User statuses
+const (
+	StatusPending     = "pending"     // Created, not yet activated
+	StatusActive      = "active"      // Normal use
+	StatusSuspended   = "suspended"   // Barred by an administrator, usually for a time
+	StatusLocked      = "locked"      // Barred for security reasons, usually for a time
+	StatusDeactivated = "deactivated" // Closed
+)
+
+// initialStatusKey is the Rigel key with the status of new users, pending
+// or active
+const initialStatusKey = "status.initial"
+
+// statusTransitions lists the statuses each status may change to. Suspending
+// a suspended user or locking a locked one sets a new end time.
+var statusTransitions = map[string][]string{
+	StatusPending:     {StatusActive, StatusDeactivated},
+	StatusActive:      {StatusSuspended, StatusLocked, StatusDeactivated},
+	StatusSuspended:   {StatusActive, StatusSuspended, StatusLocked, StatusDeactivated},
+	StatusLocked:      {StatusActive, StatusLocked, StatusDeactivated},
+	StatusDeactivated: {StatusActive},
+}
+
+// statusExpiry gives, for the statuses that may be set until a given time,
+// the status the user moves to when that time comes
+var statusExpiry = map[string]string{
+	StatusSuspended: StatusActive,
+	StatusLocked:    StatusActive,
+}
+
+// scheduledStatusReason is the reason recorded for scheduled changes
+const scheduledStatusReason = "scheduled"
+
+func canTransition(from, to string) bool {
+	return slices.Contains(statusTransitions[from], to)
+}
+
+// loadInitialStatus reads the status of new users of the tenant, which
+// defaults to active
+func loadInitialStatus(ctx context.Context, cfg tenantConfig) (string, error) {
+	value, err := cfg.Get(ctx, initialStatusKey)
+	var notFound *rigel.KeyNotFoundError
+	if errors.As(err, &notFound) || (err == nil && value == "") {
+		return StatusActive, nil
+	}
+	if err != nil {
+		return "", fmt.Errorf("failed to get %s: %w", initialStatusKey, err)
+	}
+	if value != StatusPending && value != StatusActive {
+		return "", fmt.Errorf("invalid %s %q: must be %s or %s", initialStatusKey, value, StatusPending, StatusActive)
+	}
+	return value, nil
+}
+
+// statusFields are the columns a status change writes, rendered the way
+// change logs record them
+type statusFields struct {
+	status          string
+	reason          string
+	scheduledStatus string
+	scheduledAt     string
+}
+
+func userStatusFields(user sqlc.User) statusFields {
+	return statusFields{
+		status:          user.Status,
+		reason:          user.StatusReason.String,
+		scheduledStatus: user.ScheduledStatus.String,
+		scheduledAt:     formatStatusTime(user.ScheduledAt),
+	}
+}
+
+// addStatusChanges adds the fields that differ between two states of a user
+// to a change log
+func addStatusChanges(changeInfo *logharbour.ChangeInfo, before, after statusFields) {
+	for _, field := range []struct {
+		name     string
+		old, new string
+	}{
+		{"status", before.status, after.status},
+		{"status_reason", before.reason, after.reason},
+		{"scheduled_status", before.scheduledStatus, after.scheduledStatus},
+		{"scheduled_at", before.scheduledAt, after.scheduledAt},
+	} {
+		if field.old != field.new {
+			changeInfo.AddChange(field.name, field.old, field.new)
+		}
+	}
+}
+
+// formatStatusTime renders a status timestamp in UTC, or NULL as ""
+func formatStatusTime(t pgtype.Timestamptz) string {
+	if !t.Valid {
+		return ""
+	}
+	return t.Time.UTC().Format(time.RFC3339Nano)
+}
@@ -0,0 +1,90 @@
+package usersvc
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/remiges-tech/logharbour/logharbour"
+)
+
+func TestCanTransition(t *testing.T) {
+	statuses := []string{StatusPending, StatusActive, StatusSuspended, StatusLocked, StatusDeactivated}
+	// Every allowed change; all other pairs are denied
+	allowed := map[[2]string]bool{
+		{StatusPending, StatusActive}:      true,
+		{StatusPending, StatusDeactivated}: true,
+
+		{StatusActive, StatusSuspended}:   true,
+		{StatusActive, StatusLocked}:      true,
+		{StatusActive, StatusDeactivated}: true,
+
+		{StatusSuspended, StatusActive}: true,
+		// Suspending again sets a new end time
+		{StatusSuspended, StatusSuspended}:   true,
+		{StatusSuspended, StatusLocked}:      true,
+		{StatusSuspended, StatusDeactivated}: true,
+
+		{StatusLocked, StatusActive}: true,
+		// Locking again sets a new end time
+		{StatusLocked, StatusLocked}:      true,
+		{StatusLocked, StatusDeactivated}: true,
+
+		// Reactivation is the only way out of deactivated
+		{StatusDeactivated, StatusActive}: true,
+	}
+
+	for _, from := range statuses {
+		for _, to := range statuses {
+			want := allowed[[2]string{from, to}]
+			if got := canTransition(from, to); got != want {
+				t.Errorf("canTransition(%s, %s) = %v, want %v", from, to, got, want)
+			}
+		}
+	}
+	if canTransition("", StatusActive) || canTransition(StatusActive, "") || canTransition("archived", StatusActive) {
+		t.Error("transition allowed for an unknown status")
+	}
+}
+
+func TestAddStatusChanges(t *testing.T) {
+	suspended := statusFields{
+		status:          StatusSuspended,
+		reason:          "chargeback",
+		scheduledStatus: StatusActive,
+		scheduledAt:     "2026-10-20T00:00:00Z",
+	}
+
+	for _, tc := range []struct {
+		name          string
+		before, after statusFields
+		want          []logharbour.ChangeDetail
+	}{
+		{"unchanged", suspended, suspended, nil},
+		{"suspension extended", suspended, statusFields{StatusSuspended, "chargeback", StatusActive, "2026-10-27T00:00:00Z"}, []logharbour.ChangeDetail{
+			{Field: "scheduled_at", OldValue: "2026-10-20T00:00:00Z", NewValue: "2026-10-27T00:00:00Z"},
+		}},
+		{"suspended", statusFields{status: StatusActive}, suspended, []logharbour.ChangeDetail{
+			{Field: "status", OldValue: StatusActive, NewValue: StatusSuspended},
+			{Field: "status_reason", OldValue: "", NewValue: "chargeback"},
+			{Field: "scheduled_status", OldValue: "", NewValue: StatusActive},
+			{Field: "scheduled_at", OldValue: "", NewValue: "2026-10-20T00:00:00Z"},
+		}},
+		{"expired", suspended, statusFields{status: StatusActive, reason: scheduledStatusReason}, []logharbour.ChangeDetail{
+			{Field: "status", OldValue: StatusSuspended, NewValue: StatusActive},
+			{Field: "status_reason", OldValue: "chargeback", NewValue: scheduledStatusReason},
+			{Field: "scheduled_status", OldValue: StatusActive, NewValue: ""},
+			{Field: "scheduled_at", OldValue: "2026-10-20T00:00:00Z", NewValue: ""},
+		}},
+		{"reason only", statusFields{status: StatusLocked, reason: "brute force"}, statusFields{status: StatusLocked, reason: "password reset"}, []logharbour.ChangeDetail{
+			{Field: "status_reason", OldValue: "brute force", NewValue: "password reset"},
+		}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			changeInfo := logharbour.NewChangeInfo("User", "Update")
+			addStatusChanges(changeInfo, tc.before, tc.after)
+			if len(changeInfo.Changes)+len(tc.want) > 0 && !reflect.DeepEqual(changeInfo.Changes, tc.want) {
+				t.Errorf("changes = %+v\nwant %+v", changeInfo.Changes, tc.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,89 @@
+package usersvc
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgtype"
+	"github.com/remiges-tech/logharbour/logharbour"
+	"github.com/synapsewave/remiges-demo/pg"
+	"github.com/synapsewave/remiges-demo/pg/sqlc-gen"
+)
+
+// statusWorkerBatch is how many due status changes one run applies at most
+const statusWorkerBatch = 500
+
+// RunStatusWorker applies scheduled status changes, such as the end of a
+// suspension, every interval until ctx is done. Every instance of the
+// service may run it: each change is applied by exactly one of them.
+func RunStatusWorker(ctx context.Context, queries *sqlc.Queries, loggers *TenantLoggers, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		applyDueStatusChanges(ctx, queries, loggers)
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// applyDueStatusChanges applies the changes that are due, oldest first.
+// Each is made as the user's tenant and logged to the tenant's logger.
+func applyDueStatusChanges(ctx context.Context, queries *sqlc.Queries, loggers *TenantLoggers) {
+	logger := loggers.For("").WithModule("UserService")
+	due, err := queries.ListDueStatusChanges(pg.WithTenant(ctx, pg.AllTenants), statusWorkerBatch)
+	if err != nil {
+		logger.Error(fmt.Errorf("error listing due status changes: %w", err)).LogActivity("Database error", nil)
+		return
+	}
+
+	for _, change := range due {
+		logger := loggers.For(change.TenantID).WithModule("UserService").WithInstanceId(fmt.Sprintf("%d", change.ID))
+		if !canTransition(change.Status, change.ScheduledStatus.String) {
+			logger.Warn().LogActivity("Scheduled status change not allowed, skipped", map[string]any{
+				"from": change.Status,
+				"to":   change.ScheduledStatus.String,
+			})
+			continue
+		}
+
+		// Matching the status and time read above skips changes that were
+		// applied or replaced in the meantime
+		applied, err := queries.ApplyScheduledStatus(pg.WithTenant(ctx, change.TenantID), sqlc.ApplyScheduledStatusParams{
+			TenantID:     change.TenantID,
+			ID:           change.ID,
+			Status:       change.Status,
+			ScheduledAt:  change.ScheduledAt,
+			StatusReason: pgtype.Text{String: scheduledStatusReason, Valid: true},
+		})
+		if err != nil {
+			logger.Error(fmt.Errorf("error applying scheduled status: %w", err)).LogActivity("Database error", nil)
+			continue
+		}
+		if applied == 0 {
+			continue
+		}
+
+		before := statusFields{
+			status:          change.Status,
+			reason:          change.StatusReason.String,
+			scheduledStatus: change.ScheduledStatus.String,
+			scheduledAt:     formatStatusTime(change.ScheduledAt),
+		}
+		after := statusFields{
+			status: change.ScheduledStatus.String,
+			reason: scheduledStatusReason,
+		}
+		changeInfo := logharbour.NewChangeInfo("User", "Update")
+		addStatusChanges(changeInfo, before, after)
+		logger.LogDataChange("User status changed", *changeInfo)
+
+		logger.Info().LogActivity("Scheduled status change applied", map[string]any{
+			"from": change.Status,
+			"to":   change.ScheduledStatus.String,
+		})
+	}
+}
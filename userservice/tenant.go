@@ -0,0 +1,298 @@
+package usersvc
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	lru "github.com/hashicorp/golang-lru/v2"
+	"github.com/remiges-tech/alya/service"
+	"github.com/remiges-tech/alya/wscutils"
+	"github.com/remiges-tech/logharbour/logharbour"
+	"github.com/remiges-tech/rigel"
+	"github.com/synapsewave/remiges-demo/pg"
+)
+
+// Rigel keys for tenant resolution; all are optional
+const (
+	tenantHeaderKey         = "tenant.header"           // request header naming the tenant
+	tenantHeaderTrustedKey  = "tenant.header.trusted"   // true to accept the header without a token
+	tenantClaimKey          = "tenant.claim"            // bearer token claim naming the tenant
+	tenantTokenSecretKey    = "tenant.token.secret"     // HMAC secret of HS256/384/512 tokens
+	tenantTokenPublicKeyKey = "tenant.token.public_key" // PEM RSA key of RS256/384/512 tokens
+	tenantDefaultKey        = "tenant.default"          // tenant of requests that name none
+	tenantAllowedKey        = "tenant.allowed"          // comma-separated; empty allows any valid ID
+
+	defaultTenantHeader = "X-Tenant-ID"
+	defaultTenantClaim  = "tenant_id"
+)
+
+// maxCachedTenants bounds the Rigel clients and loggers kept per tenant
+const maxCachedTenants = 1024
+
+// Keys of the request's tenant and tenant logger in the gin context
+const (
+	tenantContextKey = "usersvc.tenant"
+	loggerContextKey = "usersvc.logger"
+)
+
+// tenantIDPattern matches valid tenant IDs. They also name the tenant's Rigel
+// override config, so they are kept short and path-safe.
+var tenantIDPattern = regexp.MustCompile(`^[a-z0-9][a-z0-9_-]{0,62}$`)
+
+// tenantOptionsRefresh is how often TenantResolver rereads its options
+const tenantOptionsRefresh = 30 * time.Second
+
+// TenantResolver works out the tenant of each request from the tenant claim
+// of its bearer token, else the tenant header, else tenant.default. The claim
+// only counts once the token's signature has been verified with
+// tenant.token.secret or tenant.token.public_key; without either, tokens are
+// ignored. The header alone is only accepted when tenant.header.trusted is
+// set, since any client can send it. A request whose token and header name
+// different tenants is rejected.
+//
+// The options are read from Rigel when the resolver is created and then by
+// Refresh in the background, so requests never wait on Rigel.
+type TenantResolver struct {
+	rigel   *rigel.Rigel
+	loggers *TenantLoggers
+	opts    atomic.Pointer[tenantOptions]
+}
+
+// NewTenantResolver loads the tenant options, failing if they are invalid
+func NewTenantResolver(ctx context.Context, rigelClient *rigel.Rigel, loggers *TenantLoggers) (*TenantResolver, error) {
+	t := &TenantResolver{rigel: rigelClient, loggers: loggers}
+	opts, err := loadTenantOptions(ctx, rigelClient)
+	if err != nil {
+		return nil, err
+	}
+	t.opts.Store(&opts)
+	return t, nil
+}
+
+// Refresh rereads the options every tenantOptionsRefresh until ctx is done.
+// When a read fails, or the new options are invalid, the previous ones stay
+// in use.
+func (t *TenantResolver) Refresh(ctx context.Context) {
+	ticker := time.NewTicker(tenantOptionsRefresh)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		opts, err := loadTenantOptions(ctx, t.rigel)
+		if err != nil {
+			t.loggers.For("").WithModule("UserService").Error(err).LogActivity("Tenant options not refreshed", nil)
+			continue
+		}
+		t.opts.Store(&opts)
+	}
+}
+
+// Middleware resolves the tenant of each request. The tenant is stored in
+// the request context, where the pg package applies it to row-level
+// security, and in the gin context for requestTenant, requestLogger and
+// configFor.
+func (t *TenantResolver) Middleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		tenant, errCode := t.opts.Load().resolve(c.Request)
+		if errCode != "" {
+			t.loggers.For("").WithModule("UserService").Warn().LogActivity("Tenant not resolved", map[string]any{
+				"path":    c.Request.URL.Path,
+				"errcode": errCode,
+			})
+			tenantError := wscutils.BuildErrorMessage(MsgIDInvalidTenant, errCode, "tenant")
+			c.AbortWithStatusJSON(http.StatusBadRequest, wscutils.NewResponse("error", nil, []wscutils.ErrorMessage{tenantError}))
+			return
+		}
+
+		c.Set(tenantContextKey, tenant)
+		c.Set(loggerContextKey, t.loggers.For(tenant))
+		c.Request = c.Request.WithContext(pg.WithTenant(c.Request.Context(), tenant))
+		c.Next()
+	}
+}
+
+type tenantOptions struct {
+	header        string
+	headerTrusted bool
+	claim         string
+	keys          *tokenKeys // nil when no key is configured
+	fallback      string
+	allowed       []string
+}
+
+func loadTenantOptions(ctx context.Context, rigelClient *rigel.Rigel) (tenantOptions, error) {
+	opts := tenantOptions{header: defaultTenantHeader, claim: defaultTenantClaim}
+	var allowed, trusted, secret, publicKey string
+	for key, dst := range map[string]*string{
+		tenantHeaderKey:         &opts.header,
+		tenantHeaderTrustedKey:  &trusted,
+		tenantClaimKey:          &opts.claim,
+		tenantTokenSecretKey:    &secret,
+		tenantTokenPublicKeyKey: &publicKey,
+		tenantDefaultKey:        &opts.fallback,
+		tenantAllowedKey:        &allowed,
+	} {
+		value, err := rigelClient.Get(ctx, key)
+		var notFound *rigel.KeyNotFoundError
+		if errors.As(err, &notFound) {
+			continue
+		}
+		if err != nil {
+			return opts, fmt.Errorf("failed to get %s: %w", key, err)
+		}
+		if value != "" {
+			*dst = value
+		}
+	}
+	for _, tenant := range strings.Split(allowed, ",") {
+		if tenant = strings.TrimSpace(tenant); tenant != "" {
+			opts.allowed = append(opts.allowed, tenant)
+		}
+	}
+	if trusted != "" {
+		var err error
+		if opts.headerTrusted, err = strconv.ParseBool(trusted); err != nil {
+			return opts, fmt.Errorf("invalid %s %q: %w", tenantHeaderTrustedKey, trusted, err)
+		}
+	}
+	if secret != "" || publicKey != "" {
+		opts.keys = &tokenKeys{}
+		if secret != "" {
+			opts.keys.secret = []byte(secret)
+		}
+		if publicKey != "" {
+			key, err := parsePublicKey(publicKey)
+			if err != nil {
+				return opts, fmt.Errorf("invalid %s: %w", tenantTokenPublicKeyKey, err)
+			}
+			opts.keys.publicKey = key
+		}
+	}
+	return opts, nil
+}
+
+// resolve returns the request's tenant, or the error code to reject it with
+func (o tenantOptions) resolve(r *http.Request) (tenant string, errCode string) {
+	fromHeader := strings.TrimSpace(r.Header.Get(o.header))
+	fromToken, err := o.tokenClaim(r.Header.Get("Authorization"), time.Now())
+	switch {
+	case err != nil:
+		return "", ErrCodeInvalidToken
+	case fromToken != "" && fromHeader != "" && fromToken != fromHeader:
+		return "", ErrCodeTenantMismatch
+	case fromToken != "":
+		tenant = fromToken
+	case fromHeader != "" && !o.headerTrusted:
+		return "", ErrCodeUntrustedHeader
+	case fromHeader != "":
+		tenant = fromHeader
+	default:
+		tenant = o.fallback
+	}
+
+	switch {
+	case tenant == "":
+		return "", ErrCodeRequired
+	case !tenantIDPattern.MatchString(tenant):
+		return "", ErrCodeInvalidFormat
+	case len(o.allowed) > 0 && !slices.Contains(o.allowed, tenant):
+		return "", ErrCodeUnknownTenant
+	}
+	return tenant, ""
+}
+
+// tokenClaim reads the tenant claim from a bearer JWT once its signature has
+// been verified. It returns "" when there is no token, no configured key or
+// no such claim, and an error for a token that fails verification.
+func (o tenantOptions) tokenClaim(authorization string, now time.Time) (string, error) {
+	token, ok := strings.CutPrefix(authorization, "Bearer ")
+	if !ok || o.keys == nil {
+		return "", nil
+	}
+	claims, err := o.keys.claims(token, now)
+	if err != nil {
+		return "", err
+	}
+	value, _ := claims[o.claim].(string)
+	return value, nil
+}
+
+// requestTenant returns the tenant ResolveTenant found for the request
+func requestTenant(c *gin.Context) string {
+	return c.GetString(tenantContextKey)
+}
+
+// requestLogger returns the logger of the request's tenant, whose entries
+// carry the tenant, or the service logger outside ResolveTenant
+func requestLogger(c *gin.Context, s *service.Service) *logharbour.Logger {
+	if logger, ok := c.Get(loggerContextKey); ok {
+		return logger.(*logharbour.Logger)
+	}
+	return s.LogHarbour
+}
+
+// tenantRigels caches a Rigel client per tenant override config. It is
+// bounded since, without tenant.allowed, any valid tenant ID resolves.
+var tenantRigels = mustLRU[string, *rigel.Rigel](maxCachedTenants)
+
+// mustLRU creates an LRU cache, which only fails for a size below 1
+func mustLRU[K comparable, V any](size int) *lru.Cache[K, V] {
+	cache, err := lru.New[K, V](size)
+	if err != nil {
+		panic(err)
+	}
+	return cache
+}
+
+// tenantConfig reads Rigel keys for one tenant. A value set in the tenant's
+// override config, named <config>-<tenant> and sharing the service's schema,
+// wins over the shared config. An empty override counts as unset.
+type tenantConfig struct {
+	shared   *rigel.Rigel
+	override *rigel.Rigel
+}
+
+// configFor returns the configuration of the request's tenant
+func configFor(c *gin.Context, s *service.Service) tenantConfig {
+	cfg := tenantConfig{shared: s.RigelConfig}
+	tenant := requestTenant(c)
+	if tenant == "" {
+		return cfg
+	}
+	override, ok := tenantRigels.Get(tenant)
+	if !ok {
+		shared := s.RigelConfig
+		override = rigel.New(shared.Storage, shared.App, shared.Module, shared.Version, shared.Config+"-"+tenant)
+		// A client another request cached meanwhile is just replaced
+		tenantRigels.Add(tenant, override)
+	}
+	cfg.override = override
+	return cfg
+}
+
+// Get returns the tenant's value for key, falling back to the shared config
+func (t tenantConfig) Get(ctx context.Context, key string) (string, error) {
+	if t.override != nil {
+		value, err := t.override.Get(ctx, key)
+		var notFound *rigel.KeyNotFoundError
+		if err != nil && !errors.As(err, &notFound) {
+			return "", err
+		}
+		if value != "" {
+			return value, nil
+		}
+	}
+	return t.shared.Get(ctx, key)
+}
@@ -0,0 +1,92 @@
+package usersvc
+
+import (
+	"encoding/json"
+	"io"
+
+	"github.com/gin-gonic/gin"
+	lru "github.com/hashicorp/golang-lru/v2"
+	"github.com/remiges-tech/alya/router"
+	"github.com/remiges-tech/logharbour/logharbour"
+)
+
+// TenantLoggers hands out one LogHarbour logger per tenant. Each writes
+// through the shared writer and adds a "tenant" field to every entry, so
+// activity, change and request logs can be filtered by tenant. Loggers of
+// the most recent maxCachedTenants tenants are kept.
+type TenantLoggers struct {
+	lctx    *logharbour.LoggerContext
+	app     string
+	writer  io.Writer
+	shared  *logharbour.Logger
+	loggers *lru.Cache[string, *logharbour.Logger]
+}
+
+// NewTenantLoggers creates loggers for app that write to writer
+func NewTenantLoggers(lctx *logharbour.LoggerContext, app string, writer io.Writer) *TenantLoggers {
+	return &TenantLoggers{
+		lctx:    lctx,
+		app:     app,
+		writer:  writer,
+		shared:  logharbour.NewLogger(lctx, app, writer),
+		loggers: mustLRU[string, *logharbour.Logger](maxCachedTenants),
+	}
+}
+
+// For returns the logger of tenant, or an untagged logger for ""
+func (t *TenantLoggers) For(tenant string) *logharbour.Logger {
+	if tenant == "" {
+		return t.shared
+	}
+	if logger, ok := t.loggers.Get(tenant); ok {
+		return logger
+	}
+	logger := logharbour.NewLogger(t.lctx, t.app, newTenantWriter(tenant, t.writer))
+	t.loggers.Add(tenant, logger)
+	return logger
+}
+
+// LogRequests logs every HTTP request like alya's router.LogRequest, with the
+// logger of the tenant ResolveTenant finds further down the chain
+func LogRequests(loggers *TenantLoggers) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		router.LogRequest(tenantRequestLogger{c: c, loggers: loggers})(c)
+	}
+}
+
+// tenantRequestLogger picks the logger once the request has been handled
+type tenantRequestLogger struct {
+	c       *gin.Context
+	loggers *TenantLoggers
+}
+
+func (l tenantRequestLogger) Log(info router.RequestInfo) {
+	router.NewLogHarbourAdapter(l.loggers.For(requestTenant(l.c))).Log(info)
+}
+
+// tenantWriter inserts a "tenant" field at the start of each JSON entry.
+// LogHarbour writes one entry per Write call.
+type tenantWriter struct {
+	field []byte // `"tenant":"<id>",`
+	w     io.Writer
+}
+
+func newTenantWriter(tenant string, w io.Writer) *tenantWriter {
+	value, _ := json.Marshal(tenant)
+	field := append([]byte(`"tenant":`), value...)
+	return &tenantWriter{field: append(field, ','), w: w}
+}
+
+func (t *tenantWriter) Write(p []byte) (int, error) {
+	if len(p) < 2 || p[0] != '{' || p[1] == '}' {
+		return t.w.Write(p)
+	}
+	entry := make([]byte, 0, len(p)+len(t.field))
+	entry = append(entry, '{')
+	entry = append(entry, t.field...)
+	entry = append(entry, p[1:]...)
+	if _, err := t.w.Write(entry); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
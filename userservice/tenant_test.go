@@ -0,0 +1,161 @@
+package usersvc
+
+import (
+	"crypto"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+var testSecret = []byte("tenant-test-secret")
+
+// signedToken builds a JWT with claims, signed by sign over its header and
+// payload
+func signedToken(t *testing.T, alg string, claims map[string]any, sign func(signed string) []byte) string {
+	t.Helper()
+	header, _ := json.Marshal(map[string]string{"alg": alg, "typ": "JWT"})
+	payload, err := json.Marshal(claims)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signed := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(payload)
+	return signed + "." + base64.RawURLEncoding.EncodeToString(sign(signed))
+}
+
+func hs256(secret []byte) func(string) []byte {
+	return func(signed string) []byte {
+		mac := hmac.New(sha256.New, secret)
+		mac.Write([]byte(signed))
+		return mac.Sum(nil)
+	}
+}
+
+func rs256(t *testing.T, key *rsa.PrivateKey) func(string) []byte {
+	return func(signed string) []byte {
+		digest := sha256.Sum256([]byte(signed))
+		signature, err := rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, digest[:])
+		if err != nil {
+			t.Fatal(err)
+		}
+		return signature
+	}
+}
+
+func TestTokenClaimsVerification(t *testing.T) {
+	now := time.Date(2026, 10, 18, 12, 0, 0, 0, time.UTC)
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	otherKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keys := tokenKeys{secret: testSecret, publicKey: &rsaKey.PublicKey}
+	claims := map[string]any{"tenant_id": "acme", "exp": now.Add(time.Hour).Unix()}
+
+	for _, tc := range []struct {
+		name  string
+		token string
+		valid bool
+	}{
+		{"HS256", signedToken(t, "HS256", claims, hs256(testSecret)), true},
+		{"RS256", signedToken(t, "RS256", claims, rs256(t, rsaKey)), true},
+		{"wrong secret", signedToken(t, "HS256", claims, hs256([]byte("guess"))), false},
+		{"wrong RSA key", signedToken(t, "RS256", claims, rs256(t, otherKey)), false},
+		{"unsigned", signedToken(t, "none", claims, func(string) []byte { return nil }), false},
+		// An RS256 verifier must not accept the public key as an HMAC secret
+		{"algorithm confusion", signedToken(t, "HS256", claims, hs256(x509.MarshalPKCS1PublicKey(&rsaKey.PublicKey))), false},
+		{"expired", signedToken(t, "HS256", map[string]any{"tenant_id": "acme", "exp": now.Unix()}, hs256(testSecret)), false},
+		{"not valid yet", signedToken(t, "HS256", map[string]any{"tenant_id": "acme", "nbf": now.Add(time.Minute).Unix()}, hs256(testSecret)), false},
+		{"not a JWT", "acme", false},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			got, err := keys.claims(tc.token, now)
+			if !tc.valid {
+				if !errors.Is(err, errInvalidToken) {
+					t.Errorf("err = %v, want errInvalidToken", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got["tenant_id"] != "acme" {
+				t.Errorf("claims = %v", got)
+			}
+		})
+	}
+
+	// Without a secret, HS256 tokens are not accepted at all
+	if _, err := (tokenKeys{publicKey: &rsaKey.PublicKey}).claims(signedToken(t, "HS256", claims, hs256(nil)), now); !errors.Is(err, errInvalidToken) {
+		t.Errorf("HS256 token accepted without a secret: %v", err)
+	}
+
+	der, err := x509.MarshalPKIXPublicKey(&rsaKey.PublicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	parsed, err := parsePublicKey(string(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der})))
+	if err != nil || !parsed.Equal(&rsaKey.PublicKey) {
+		t.Errorf("parsePublicKey = %v, %v", parsed, err)
+	}
+	if _, err := parsePublicKey("not a key"); err == nil {
+		t.Error("parsePublicKey accepted text without a PEM block")
+	}
+}
+
+func TestResolveTenant(t *testing.T) {
+	token := func(tenant string) string {
+		return "Bearer " + signedToken(t, "HS256", map[string]any{"tenant_id": tenant}, hs256(testSecret))
+	}
+	forged := "Bearer " + signedToken(t, "HS256", map[string]any{"tenant_id": "globex"}, hs256([]byte("guess")))
+	verifying := tenantOptions{header: defaultTenantHeader, claim: defaultTenantClaim, keys: &tokenKeys{secret: testSecret}, fallback: "default"}
+	trusting := tenantOptions{header: defaultTenantHeader, headerTrusted: true, claim: defaultTenantClaim, fallback: "default"}
+	allowing := verifying
+	allowing.allowed = []string{"acme"}
+
+	for _, tc := range []struct {
+		name          string
+		opts          tenantOptions
+		authorization string
+		header        string
+		want          string
+		wantErrCode   string
+	}{
+		{"verified token", verifying, token("acme"), "", "acme", ""},
+		{"token and matching header", verifying, token("acme"), "acme", "acme", ""},
+		{"token and other header", verifying, token("acme"), "globex", "", ErrCodeTenantMismatch},
+		{"forged token", verifying, forged, "", "", ErrCodeInvalidToken},
+		{"untrusted header", verifying, "", "globex", "", ErrCodeUntrustedHeader},
+		{"trusted header", trusting, "", "globex", "globex", ""},
+		{"token without a configured key", trusting, forged, "", "default", ""},
+		{"default", verifying, "", "", "default", ""},
+		{"not allowed", allowing, token("initech"), "", "", ErrCodeUnknownTenant},
+		{"malformed", trusting, "", "Acme Corp", "", ErrCodeInvalidFormat},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			r := httptest.NewRequest("POST", "/user_get", strings.NewReader("{}"))
+			if tc.authorization != "" {
+				r.Header.Set("Authorization", tc.authorization)
+			}
+			if tc.header != "" {
+				r.Header.Set(defaultTenantHeader, tc.header)
+			}
+			tenant, errCode := tc.opts.resolve(r)
+			if tenant != tc.want || errCode != tc.wantErrCode {
+				t.Errorf("resolve = %q, %q, want %q, %q", tenant, errCode, tc.want, tc.wantErrCode)
+			}
+		})
+	}
+}
@@ -0,0 +1,114 @@
+package usersvc
+
+import (
+	"crypto"
+	"crypto/hmac"
+	"crypto/rsa"
+	_ "crypto/sha256" // registers SHA256 for tokenHashes
+	_ "crypto/sha512" // registers SHA384 and SHA512
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// tokenKeys verifies bearer JWTs. HS256, HS384 and HS512 tokens are checked
+// against secret, RS256, RS384 and RS512 ones against publicKey. Any other
+// algorithm, "none" included, is rejected.
+type tokenKeys struct {
+	secret    []byte
+	publicKey *rsa.PublicKey
+}
+
+var errInvalidToken = errors.New("invalid token")
+
+// tokenHashes maps the algorithms in JWT headers to their hash
+var tokenHashes = map[string]crypto.Hash{
+	"HS256": crypto.SHA256, "HS384": crypto.SHA384, "HS512": crypto.SHA512,
+	"RS256": crypto.SHA256, "RS384": crypto.SHA384, "RS512": crypto.SHA512,
+}
+
+// claims verifies the signature of token and that it is valid at now, and
+// returns its claims
+func (k tokenKeys) claims(token string, now time.Time) (map[string]any, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, fmt.Errorf("%w: not a JWT", errInvalidToken)
+	}
+	var header struct {
+		Alg string `json:"alg"`
+	}
+	if err := decodeTokenPart(parts[0], &header); err != nil {
+		return nil, err
+	}
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, fmt.Errorf("%w: bad signature encoding", errInvalidToken)
+	}
+
+	hash, ok := tokenHashes[header.Alg]
+	if !ok {
+		return nil, fmt.Errorf("%w: unsupported algorithm %q", errInvalidToken, header.Alg)
+	}
+	signed := parts[0] + "." + parts[1]
+	switch {
+	case strings.HasPrefix(header.Alg, "HS") && k.secret != nil:
+		mac := hmac.New(hash.New, k.secret)
+		mac.Write([]byte(signed))
+		if !hmac.Equal(mac.Sum(nil), signature) {
+			return nil, fmt.Errorf("%w: signature mismatch", errInvalidToken)
+		}
+	case strings.HasPrefix(header.Alg, "RS") && k.publicKey != nil:
+		digest := hash.New()
+		digest.Write([]byte(signed))
+		if err := rsa.VerifyPKCS1v15(k.publicKey, hash, digest.Sum(nil), signature); err != nil {
+			return nil, fmt.Errorf("%w: signature mismatch", errInvalidToken)
+		}
+	default:
+		return nil, fmt.Errorf("%w: no key configured for %s", errInvalidToken, header.Alg)
+	}
+
+	var claims map[string]any
+	if err := decodeTokenPart(parts[1], &claims); err != nil {
+		return nil, err
+	}
+	if exp, ok := claims["exp"].(float64); ok && !now.Before(time.Unix(int64(exp), 0)) {
+		return nil, fmt.Errorf("%w: expired", errInvalidToken)
+	}
+	if nbf, ok := claims["nbf"].(float64); ok && now.Before(time.Unix(int64(nbf), 0)) {
+		return nil, fmt.Errorf("%w: not valid yet", errInvalidToken)
+	}
+	return claims, nil
+}
+
+func decodeTokenPart(part string, v any) error {
+	data, err := base64.RawURLEncoding.DecodeString(part)
+	if err != nil {
+		return fmt.Errorf("%w: bad encoding", errInvalidToken)
+	}
+	if err := json.Unmarshal(data, v); err != nil {
+		return fmt.Errorf("%w: bad JSON", errInvalidToken)
+	}
+	return nil
+}
+
+// parsePublicKey reads a PEM "PUBLIC KEY" block holding an RSA key
+func parsePublicKey(pemText string) (*rsa.PublicKey, error) {
+	block, _ := pem.Decode([]byte(pemText))
+	if block == nil || block.Type != "PUBLIC KEY" {
+		return nil, errors.New("no PEM PUBLIC KEY block")
+	}
+	key, err := x509.ParsePKIXPublicKey(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+	rsaKey, ok := key.(*rsa.PublicKey)
+	if !ok {
+		return nil, fmt.Errorf("%T is not an RSA key", key)
+	}
+	return rsaKey, nil
+}
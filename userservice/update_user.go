@@ -7,6 +7,7 @@ import (
 	"github.com/go-playground/validator/v10"
 	"github.com/jackc/pgx/v5"
 	"github.com/jackc/pgx/v5/pgtype"
+	"github.com/synapsewave/remiges-demo/pg"
 	"github.com/synapsewave/remiges-demo/pg/sqlc-gen"
 	"github.com/remiges-tech/alya/service"
 	"github.com/remiges-tech/alya/wscutils"
@@ -26,14 +27,19 @@ func HandleUpdateUserRequest(c *gin.Context, s *service.Service) {
 	}
 
 	// Create logger with module and instance information
-	logger := s.LogHarbour.WithModule("UserService").WithInstanceId(fmt.Sprintf("%d", updateUserReq.ID))
+	logger := requestLogger(c, s).WithModule("UserService").WithInstanceId(fmt.Sprintf("%d", updateUserReq.ID))
 	logger.Info().LogActivity("UpdateUser request received", nil)
 
 	// Get queries object
 	queries := s.Database.(*sqlc.Queries)
+	tenant := requestTenant(c)
 
-	// Check if user exists and get current values for changelog
-	currentUser, err := queries.GetUserByID(c.Request.Context(), updateUserReq.ID)
+	// Check if user exists and get current values for changelog. Read from
+	// the primary: a lagging replica would give a stale changelog "before".
+	currentUser, err := queries.GetUserByID(pg.WithPrimary(c.Request.Context()), sqlc.GetUserByIDParams{
+		TenantID: tenant,
+		ID:       updateUserReq.ID,
+	})
 	if err != nil {
 		if err == pgx.ErrNoRows {
 			logger.Info().LogActivity("User not found", map[string]any{"id": updateUserReq.ID})
@@ -48,23 +54,24 @@ func HandleUpdateUserRequest(c *gin.Context, s *service.Service) {
 	}
 
 	// Check if at least one field is being updated
-	if updateUserReq.Name == nil && updateUserReq.Email == nil && updateUserReq.PhoneNumber == nil {
+	if updateUserReq.Name == nil && updateUserReq.Email == nil && updateUserReq.PhoneNumber == nil && len(updateUserReq.Attributes) == 0 {
 		logger.Info().LogActivity("No fields to update", nil)
 		noUpdatesError := wscutils.BuildErrorMessage(MsgIDNoFieldsToUpdate, ErrCodeNoFields, "", "")
 		wscutils.SendErrorResponse(c, wscutils.NewResponse("error", nil, []wscutils.ErrorMessage{noUpdatesError}))
 		return
 	}
 
-	// Get validation constraints from Rigel
-	minNameLength, err := s.RigelConfig.Get(c.Request.Context(), "validation.name.minLength")
+	// Get validation constraints from Rigel, with the tenant's overrides
+	cfg := configFor(c, s)
+	minNameLength, err := cfg.Get(c.Request.Context(), "validation.name.minLength")
 	if err != nil {
 		minNameLength = "2"
 	}
-	maxNameLength, err := s.RigelConfig.Get(c.Request.Context(), "validation.name.maxLength")
+	maxNameLength, err := cfg.Get(c.Request.Context(), "validation.name.maxLength")
 	if err != nil {
 		maxNameLength = "50"
 	}
-	maxEmailLength, err := s.RigelConfig.Get(c.Request.Context(), "validation.email.maxLength")
+	maxEmailLength, err := cfg.Get(c.Request.Context(), "validation.email.maxLength")
 	if err != nil {
 		maxEmailLength = "100"
 	}
@@ -116,6 +123,16 @@ func HandleUpdateUserRequest(c *gin.Context, s *service.Service) {
 		}
 	})
 
+	// Validate custom attributes against the attribute schema from Rigel
+	attributeSchema, err := loadAttributeSchema(c.Request.Context(), cfg)
+	if err != nil {
+		logger.Error(err).LogActivity("Configuration error", nil)
+		internalError := wscutils.BuildErrorMessage(MsgIDInternalError, ErrCodeInternal, "", "")
+		wscutils.SendErrorResponse(c, wscutils.NewResponse("error", nil, []wscutils.ErrorMessage{internalError}))
+		return
+	}
+	validationErrors = append(validationErrors, attributeSchema.validateUpdate(updateUserReq.Attributes)...)
+
 	if len(validationErrors) > 0 {
 		wscutils.SendErrorResponse(c, wscutils.NewResponse("error", nil, validationErrors))
 		return
@@ -132,8 +149,9 @@ func HandleUpdateUserRequest(c *gin.Context, s *service.Service) {
 
 		// Check if email already exists for another user
 		exists, err := queries.CheckEmailExistsForUpdate(c.Request.Context(), sqlc.CheckEmailExistsForUpdateParams{
-			Email: *updateUserReq.Email,
-			ID:    updateUserReq.ID,
+			TenantID: tenant,
+			Email:    *updateUserReq.Email,
+			ID:       updateUserReq.ID,
 		})
 		if err != nil {
 			logger.Error(fmt.Errorf("error checking email existence: %w", err)).LogActivity("Database error", nil)
@@ -150,7 +168,8 @@ func HandleUpdateUserRequest(c *gin.Context, s *service.Service) {
 
 	// Prepare update parameters
 	updateParams := sqlc.UpdateUserParams{
-		ID: updateUserReq.ID,
+		ID:       updateUserReq.ID,
+		TenantID: tenant,
 	}
 	
 	if updateUserReq.Name != nil {
@@ -162,6 +181,9 @@ func HandleUpdateUserRequest(c *gin.Context, s *service.Service) {
 	if updateUserReq.PhoneNumber != nil {
 		updateParams.PhoneNumber = pgtype.Text{String: *updateUserReq.PhoneNumber, Valid: true}
 	}
+	// Attributes are merged in the database so concurrent updates of
+	// different keys do not overwrite each other
+	updateParams.SetAttributes, updateParams.UnsetAttributes = splitAttributePatch(updateUserReq.Attributes)
 
 	// Update user
 	_, err = queries.UpdateUser(c.Request.Context(), updateParams)
@@ -196,6 +218,16 @@ func HandleUpdateUserRequest(c *gin.Context, s *service.Service) {
 		}
 	}
 	
+	// Track attribute changes, one entry per key
+	currentAttributes := decodeAttributes(currentUser.Attributes)
+	for _, key := range sortedAttributeKeys(updateUserReq.Attributes) {
+		oldValue := attributeValue(currentAttributes[key])
+		newValue := attributeValue(updateUserReq.Attributes[key])
+		if oldValue != newValue {
+			changeInfo.AddChange(attributeField(key), oldValue, newValue)
+		}
+	}
+
 	// Log the data change if there were actual changes
 	if len(changeInfo.Changes) > 0 {
 		logger.LogDataChange("User updated", *changeInfo)
@@ -204,9 +236,10 @@ func HandleUpdateUserRequest(c *gin.Context, s *service.Service) {
 	// Log the update activity
 	logger.Info().LogActivity("User updated", map[string]any{
 		"updated_fields": map[string]any{
-			"name_changed":  updateUserReq.Name != nil,
-			"email_changed": updateUserReq.Email != nil,
-			"phone_changed": updateUserReq.PhoneNumber != nil,
+			"name_changed":       updateUserReq.Name != nil,
+			"email_changed":      updateUserReq.Email != nil,
+			"phone_changed":      updateUserReq.PhoneNumber != nil,
+			"attributes_changed": len(updateUserReq.Attributes) > 0,
 		},
 	})
 
@@ -3,8 +3,15 @@ package usersvc
 // This package implements a user service with handlers split across multiple files:
 // - common.go: Shared constants, types, and helper functions
 // - create_user.go: Handler for creating new users
-// - get_user.go: Handler for retrieving users by ID
+// - get_user.go: Handler for retrieving users by ID or username
 // - update_user.go: Handler for updating existing users
+// - change_username.go: Handler for renaming users, reserving their old usernames
+// - change_user_status.go: Handler for moving users between statuses
+// - status.go, status_worker.go: Status transition rules and the worker applying scheduled changes
+// - groups.go: Handlers for creating, renaming and deleting groups
+// - group_members.go: Handlers for group membership and listing members and groups
+// - attributes.go: Custom attribute schema and validation
+// - tenant.go, tenant_log.go: Tenant resolution, per-tenant config and loggers
 //
 // The handlers demonstrate:
 // - Alya framework patterns for request/response handling